@@ -0,0 +1,177 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+// A RequestPriority represents the priority of a request
+// for the admission control. When the server is overloaded, the
+// requests with the lowest priority are shed first.
+type RequestPriority int
+
+// Various values of RequestPriority.
+const (
+	// RequestPriorityBulk is the priority of the requests that can
+	// be shed first, like the ones coming from batch clients. They can
+	// only use half of the concurrency limit.
+	RequestPriorityBulk RequestPriority = iota
+
+	// RequestPriorityNormal is the default priority. The requests
+	// can use 80% of the concurrency limit.
+	RequestPriorityNormal
+
+	// RequestPriorityCritical is the priority of the requests that
+	// must be protected, like internal or system traffic. They can
+	// use the whole concurrency limit.
+	RequestPriorityCritical
+)
+
+// requestPriorityShares contains the share of the
+// concurrency limit each priority is allowed to use.
+var requestPriorityShares = map[RequestPriority]float64{
+	RequestPriorityBulk:     0.5,
+	RequestPriorityNormal:   0.8,
+	RequestPriorityCritical: 1.0,
+}
+
+func (p RequestPriority) String() string {
+
+	switch p {
+	case RequestPriorityBulk:
+		return "bulk"
+	case RequestPriorityNormal:
+		return "normal"
+	case RequestPriorityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// A RequestPriorityFunc returns the priority of the given request.
+// The request is not authenticated yet when the function is called,
+// but it holds the token and the client certificates the
+// claims of the client can be derived from.
+type RequestPriorityFunc func(*elemental.Request) RequestPriority
+
+// An admissionController limits the number of requests processed
+// concurrently. The limit is adapted using AIMD: it is increased by one
+// when a request is processed faster than the target latency while the
+// server is busy, and multiplied by the backoff ratio otherwise.
+type admissionController struct {
+	cfg            admissionControlConfig
+	metricsManager MetricsManager
+	limit          float64
+	inflight       int
+	lock           sync.Mutex
+}
+
+// newAdmissionController returns a new admissionController.
+func newAdmissionController(cfg admissionControlConfig, metricsManager MetricsManager) *admissionController {
+
+	a := &admissionController{
+		cfg:            cfg,
+		metricsManager: metricsManager,
+		limit:          float64(cfg.initialLimit),
+	}
+
+	a.reportLimits()
+
+	return a
+}
+
+// priority returns the priority of the given request.
+func (a *admissionController) priority(request *elemental.Request) RequestPriority {
+
+	if a.cfg.priorityFunc == nil {
+		return RequestPriorityNormal
+	}
+
+	return a.cfg.priorityFunc(request)
+}
+
+// acquire tries to admit a request with the given priority. If the
+// request is admitted, it returns a function to call once the request
+// is processed. Otherwise, it returns false and the request must be shed.
+func (a *admissionController) acquire(priority RequestPriority) (func(), bool) {
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if float64(a.inflight) >= a.limitFor(priority) {
+		if m, ok := a.metricsManager.(AdmissionMetricsManager); ok {
+			m.RegisterShedRequest(priority)
+		}
+		return nil, false
+	}
+
+	a.inflight++
+	start := time.Now()
+
+	return func() { a.release(time.Since(start)) }, true
+}
+
+// release releases a request that took the given latency
+// to be processed and adapts the limit accordingly.
+func (a *admissionController) release(latency time.Duration) {
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	busy := float64(a.inflight)*2 >= a.limit
+	a.inflight--
+
+	switch {
+	case latency > a.cfg.targetLatency:
+		a.limit = math.Max(float64(a.cfg.minLimit), a.limit*a.cfg.backoffRatio)
+	case busy:
+		a.limit = math.Min(float64(a.cfg.maxLimit), a.limit+1)
+	default:
+		return
+	}
+
+	a.reportLimits()
+}
+
+// limitFor returns the number of concurrent requests
+// the given priority can use. It must be called
+// while holding the lock.
+func (a *admissionController) limitFor(priority RequestPriority) float64 {
+
+	share, ok := requestPriorityShares[priority]
+	if !ok {
+		share = requestPriorityShares[RequestPriorityNormal]
+	}
+
+	return math.Max(1, math.Floor(a.limit*share))
+}
+
+// reportLimits reports the current limits to the metrics
+// manager, if it supports it. It must be called while
+// holding the lock.
+func (a *admissionController) reportLimits() {
+
+	m, ok := a.metricsManager.(AdmissionMetricsManager)
+	if !ok {
+		return
+	}
+
+	for priority := range requestPriorityShares {
+		m.SetAdmissionLimit(priority, int(a.limitFor(priority)))
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"fmt"
+	"time"
+)
+
+// An AdmissionControlOption represents an option for the admission control.
+type AdmissionControlOption func(*admissionControlConfig)
+
+type admissionControlConfig struct {
+	targetLatency time.Duration
+	initialLimit  int
+	minLimit      int
+	maxLimit      int
+	backoffRatio  float64
+	retryAfter    time.Duration
+	priorityFunc  RequestPriorityFunc
+}
+
+func newAdmissionControlConfig(targetLatency time.Duration) admissionControlConfig {
+	return admissionControlConfig{
+		targetLatency: targetLatency,
+		initialLimit:  100,
+		minLimit:      10,
+		maxLimit:      1000,
+		backoffRatio:  0.9,
+		retryAfter:    time.Second,
+	}
+}
+
+// AdmissionControlOptLimits sets the initial, minimum and maximum
+// number of requests processed concurrently. The defaults are
+// respectively 100, 10 and 1000.
+func AdmissionControlOptLimits(initial int, min int, max int) AdmissionControlOption {
+
+	if min <= 0 || min > initial || initial > max {
+		panic(fmt.Sprintf("invalid admission limits %d, %d, %d: they must be positive and min <= initial <= max", initial, min, max))
+	}
+
+	return func(c *admissionControlConfig) {
+		c.initialLimit = initial
+		c.minLimit = min
+		c.maxLimit = max
+	}
+}
+
+// AdmissionControlOptBackoffRatio sets the ratio the limit is multiplied
+// by when a request takes longer than the target latency. The default is 0.9.
+func AdmissionControlOptBackoffRatio(ratio float64) AdmissionControlOption {
+
+	if ratio <= 0 || ratio >= 1 {
+		panic(fmt.Sprintf("invalid admission backoff ratio %f: it must be between 0 and 1 excluded", ratio))
+	}
+
+	return func(c *admissionControlConfig) {
+		c.backoffRatio = ratio
+	}
+}
+
+// AdmissionControlOptRetryAfter sets the duration sent to the clients in
+// the Retry-After header when their requests are shed. The default is 1s.
+func AdmissionControlOptRetryAfter(retryAfter time.Duration) AdmissionControlOption {
+
+	if retryAfter < time.Second {
+		panic(fmt.Sprintf("invalid admission retry after %s: it must be at least 1s", retryAfter))
+	}
+
+	return func(c *admissionControlConfig) {
+		c.retryAfter = retryAfter
+	}
+}
+
+// AdmissionControlOptPriorityFunc sets the function used to compute the
+// priority of the requests. By default, all requests have the priority
+// RequestPriorityNormal.
+func AdmissionControlOptPriorityFunc(f RequestPriorityFunc) AdmissionControlOption {
+	return func(c *admissionControlConfig) {
+		c.priorityFunc = f
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-zoo/bone"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+type mockAdmissionMetricsManager struct {
+	mockMetricsManager
+	limits map[RequestPriority]int
+	shed   []RequestPriority
+}
+
+func (m *mockAdmissionMetricsManager) SetAdmissionLimit(priority RequestPriority, limit int) {
+	if m.limits == nil {
+		m.limits = map[RequestPriority]int{}
+	}
+	m.limits[priority] = limit
+}
+
+func (m *mockAdmissionMetricsManager) RegisterShedRequest(priority RequestPriority) {
+	m.shed = append(m.shed, priority)
+}
+
+func TestAdmission_RequestPriority(t *testing.T) {
+
+	Convey("Calling String on the priorities should work", t, func() {
+		So(RequestPriorityBulk.String(), ShouldEqual, "bulk")
+		So(RequestPriorityNormal.String(), ShouldEqual, "normal")
+		So(RequestPriorityCritical.String(), ShouldEqual, "critical")
+		So(RequestPriority(42).String(), ShouldEqual, "unknown")
+	})
+}
+
+func TestAdmission_AdmissionController(t *testing.T) {
+
+	Convey("Given I have an admission controller", t, func() {
+
+		cfg := newAdmissionControlConfig(time.Second)
+		cfg.initialLimit = 10
+		cfg.minLimit = 2
+		cfg.maxLimit = 12
+
+		mm := &mockAdmissionMetricsManager{}
+		a := newAdmissionController(cfg, mm)
+
+		Convey("Then the initial limits should be reported", func() {
+			So(mm.limits, ShouldResemble, map[RequestPriority]int{
+				RequestPriorityBulk:     5,
+				RequestPriorityNormal:   8,
+				RequestPriorityCritical: 10,
+			})
+		})
+
+		Convey("When I acquire as many bulk requests as possible", func() {
+
+			var releases []func()
+			for i := 0; i < 5; i++ {
+				release, ok := a.acquire(RequestPriorityBulk)
+				So(ok, ShouldBeTrue)
+				releases = append(releases, release)
+			}
+
+			Convey("Then the next bulk request should be shed", func() {
+				_, ok := a.acquire(RequestPriorityBulk)
+				So(ok, ShouldBeFalse)
+				So(mm.shed, ShouldResemble, []RequestPriority{RequestPriorityBulk})
+			})
+
+			Convey("Then the next normal and critical requests should be admitted", func() {
+				_, ok := a.acquire(RequestPriorityNormal)
+				So(ok, ShouldBeTrue)
+				_, ok = a.acquire(RequestPriorityCritical)
+				So(ok, ShouldBeTrue)
+				So(mm.shed, ShouldBeEmpty)
+			})
+
+			Convey("When I release the requests quickly", func() {
+
+				for _, release := range releases {
+					release()
+				}
+
+				Convey("Then the limit should have been increased only while the server was busy", func() {
+					So(a.inflight, ShouldEqual, 0)
+					So(a.limit, ShouldEqual, 11.0)
+					So(mm.limits[RequestPriorityCritical], ShouldEqual, 11)
+				})
+			})
+		})
+
+		Convey("When requests are slower than the target latency", func() {
+
+			for i := 0; i < 20; i++ {
+				_, ok := a.acquire(RequestPriorityCritical)
+				So(ok, ShouldBeTrue)
+				a.release(2 * time.Second)
+			}
+
+			Convey("Then the limit should have been decreased down to the minimum", func() {
+				So(a.limit, ShouldEqual, 2.0)
+				So(mm.limits, ShouldResemble, map[RequestPriority]int{
+					RequestPriorityBulk:     1,
+					RequestPriorityNormal:   1,
+					RequestPriorityCritical: 2,
+				})
+			})
+		})
+
+		Convey("When I use a priority function", func() {
+
+			a.cfg.priorityFunc = func(r *elemental.Request) RequestPriority {
+				if r.Identity == testmodel.ListIdentity {
+					return RequestPriorityBulk
+				}
+				return RequestPriorityCritical
+			}
+
+			Convey("Then the priorities should be correct", func() {
+				So(a.priority(&elemental.Request{Identity: testmodel.ListIdentity}), ShouldEqual, RequestPriorityBulk)
+				So(a.priority(&elemental.Request{Identity: testmodel.TaskIdentity}), ShouldEqual, RequestPriorityCritical)
+			})
+		})
+
+		Convey("When I don't use a priority function", func() {
+
+			Convey("Then the priority should be normal", func() {
+				So(a.priority(&elemental.Request{Identity: testmodel.ListIdentity}), ShouldEqual, RequestPriorityNormal)
+			})
+		})
+	})
+}
+
+func TestAdmission_Handler(t *testing.T) {
+
+	Convey("Given I have a rest server with admission control", t, func() {
+
+		cfg := config{}
+		cfg.model.modelManagers = map[int]elemental.ModelManager{0: testmodel.Manager()}
+		acfg := newAdmissionControlConfig(time.Second)
+		acfg.initialLimit = 1
+		acfg.minLimit = 1
+		acfg.retryAfter = 3 * time.Second
+		cfg.admissionControl = &acfg
+
+		c := newRestServer(cfg, bone.New(), nil, nil, nil)
+		h := c.makeHandler(handleRetrieveMany)
+
+		Convey("When the server is at its limit", func() {
+
+			release, ok := c.admission.acquire(RequestPriorityCritical)
+			So(ok, ShouldBeTrue)
+			defer release()
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "http://toto.com/lists", nil)
+			h(w, r)
+
+			Convey("Then the request should be shed", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+				So(w.Result().Header.Get("Retry-After"), ShouldEqual, "3")
+			})
+		})
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// auditFileSink is an AuditSink writing JSON lines to a file,
+// rotating it when it reaches a maximum size.
+type auditFileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	lock       sync.Mutex
+}
+
+// NewAuditFileSink returns an AuditSink that writes the records as JSON
+// lines to the file at the given path.
+//
+// When writing a record would make the file bigger than maxSize bytes,
+// the file is rotated: it is renamed to path.1, the previous path.1 is
+// renamed to path.2 and so on, keeping at most maxBackups files. If maxSize
+// is 0, the file is never rotated.
+func NewAuditFileSink(path string, maxSize int64, maxBackups int) (AuditSink, error) {
+
+	s := &auditFileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *auditFileSink) Write(record *AuditRecord) error {
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record: %s", err)
+	}
+
+	data = append(data, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err = s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to write audit record: %s", err)
+	}
+
+	return nil
+}
+
+func (s *auditFileSink) Close() error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *auditFileSink) open() error {
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit file: %s", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() // nolint: errcheck
+		return fmt.Errorf("unable to stat audit file: %s", err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+
+	return nil
+}
+
+// rotate renames the current files and opens a new one. The current
+// file is only closed once the new one is opened so the sink can
+// still be written to if the rotation fails.
+func (s *auditFileSink) rotate() error {
+
+	if s.maxBackups > 0 {
+
+		for i := s.maxBackups - 1; i > 0; i-- {
+			from := fmt.Sprintf("%s.%d", s.path, i)
+			if _, err := os.Stat(from); err != nil {
+				continue
+			}
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil {
+				return fmt.Errorf("unable to rotate audit file: %s", err)
+			}
+		}
+
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("unable to rotate audit file: %s", err)
+		}
+
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("unable to rotate audit file: %s", err)
+	}
+
+	previous := s.file
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if err := previous.Close(); err != nil {
+		return fmt.Errorf("unable to close audit file: %s", err)
+	}
+
+	return nil
+}
+
+// auditPubSubSink is an AuditSink publishing the records
+// in a topic of a PubSubClient.
+type auditPubSubSink struct {
+	client PubSubClient
+	topic  string
+}
+
+// NewAuditPubSubSink returns an AuditSink that publishes
+// the records in the given topic of the given PubSubClient.
+func NewAuditPubSubSink(client PubSubClient, topic string) AuditSink {
+
+	return &auditPubSubSink{
+		client: client,
+		topic:  topic,
+	}
+}
+
+func (s *auditPubSubSink) Write(record *AuditRecord) error {
+
+	publication := NewPublication(s.topic)
+	if err := publication.Encode(record); err != nil {
+		return fmt.Errorf("unable to encode audit record: %s", err)
+	}
+
+	return s.client.Publish(publication)
+}
+
+func (s *auditPubSubSink) Close() error {
+	return nil
+}
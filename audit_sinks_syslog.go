@@ -0,0 +1,59 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package bahamut
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// auditSyslogSink is an AuditSink writing the records to syslog.
+type auditSyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewAuditSyslogSink returns an AuditSink that writes the records as
+// JSON to syslog. Network and raddr are passed to syslog.Dial: if network
+// is empty, it connects to the local syslog server.
+func NewAuditSyslogSink(network string, raddr string, priority syslog.Priority, tag string) (AuditSink, error) {
+
+	w, err := syslog.Dial(network, raddr, priority, tag)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to syslog: %s", err)
+	}
+
+	return &auditSyslogSink{
+		writer: w,
+	}, nil
+}
+
+func (s *auditSyslogSink) Write(record *AuditRecord) error {
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record: %s", err)
+	}
+
+	if _, err = s.writer.Write(data); err != nil {
+		return fmt.Errorf("unable to write audit record: %s", err)
+	}
+
+	return nil
+}
+
+func (s *auditSyslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+)
+
+func readAuditFile(path string) []*AuditRecord {
+
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close() // nolint: errcheck
+
+	var records []*AuditRecord
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			panic(err)
+		}
+		records = append(records, r)
+	}
+
+	return records
+}
+
+func TestAuditSinks_File(t *testing.T) {
+
+	Convey("Given I have a file sink", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		path := filepath.Join(dir, "audit.log")
+
+		s, err := NewAuditFileSink(path, 0, 0)
+		So(err, ShouldBeNil)
+
+		Convey("When I write some records", func() {
+
+			So(s.Write(&AuditRecord{RequestID: "1", Operation: elemental.OperationCreate}), ShouldBeNil)
+			So(s.Write(&AuditRecord{RequestID: "2", Operation: elemental.OperationDelete}), ShouldBeNil)
+			So(s.Close(), ShouldBeNil)
+
+			Convey("Then the file should contain the records as json lines", func() {
+				records := readAuditFile(path)
+				So(len(records), ShouldEqual, 2)
+				So(records[0].RequestID, ShouldEqual, "1")
+				So(records[1].Operation, ShouldEqual, elemental.OperationDelete)
+			})
+		})
+	})
+
+	Convey("Given I have a file sink with rotation", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		path := filepath.Join(dir, "audit.log")
+
+		data, _ := json.Marshal(&AuditRecord{RequestID: "x"})
+		lineSize := int64(len(data) + 1)
+
+		s, err := NewAuditFileSink(path, lineSize*2, 2)
+		So(err, ShouldBeNil)
+
+		Convey("When I write more records than the file can hold", func() {
+
+			for _, id := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+				So(s.Write(&AuditRecord{RequestID: id}), ShouldBeNil)
+			}
+			So(s.Close(), ShouldBeNil)
+
+			Convey("Then the files should have been rotated", func() {
+
+				current := readAuditFile(path)
+				So(len(current), ShouldEqual, 1)
+				So(current[0].RequestID, ShouldEqual, "g")
+
+				backup1 := readAuditFile(path + ".1")
+				So(len(backup1), ShouldEqual, 2)
+				So(backup1[0].RequestID, ShouldEqual, "e")
+
+				backup2 := readAuditFile(path + ".2")
+				So(len(backup2), ShouldEqual, 2)
+				So(backup2[0].RequestID, ShouldEqual, "c")
+
+				_, err := os.Stat(path + ".3")
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I have a file sink with a rotation that fails", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		path := filepath.Join(dir, "audit.log")
+
+		data, _ := json.Marshal(&AuditRecord{RequestID: "x"})
+		lineSize := int64(len(data) + 1)
+
+		s, err := NewAuditFileSink(path, lineSize, 1)
+		So(err, ShouldBeNil)
+
+		// The file cannot be renamed over a directory.
+		So(os.Mkdir(path+".1", 0700), ShouldBeNil)
+
+		Convey("When I write more records than the file can hold", func() {
+
+			So(s.Write(&AuditRecord{RequestID: "a"}), ShouldBeNil)
+			errRotate := s.Write(&AuditRecord{RequestID: "b"})
+
+			So(os.Remove(path+".1"), ShouldBeNil)
+			errWrite := s.Write(&AuditRecord{RequestID: "c"})
+			So(s.Close(), ShouldBeNil)
+
+			Convey("Then the failed rotation should return an error", func() {
+				So(errRotate, ShouldNotBeNil)
+			})
+
+			Convey("Then the sink should still be usable", func() {
+				So(errWrite, ShouldBeNil)
+
+				current := readAuditFile(path)
+				So(len(current), ShouldEqual, 1)
+				So(current[0].RequestID, ShouldEqual, "c")
+
+				backup1 := readAuditFile(path + ".1")
+				So(len(backup1), ShouldEqual, 1)
+				So(backup1[0].RequestID, ShouldEqual, "a")
+			})
+		})
+	})
+
+	Convey("Given I have a file sink on an invalid path", t, func() {
+
+		_, err := NewAuditFileSink("/not/a/real/dir/audit.log", 0, 0)
+
+		Convey("Then err should not be nil", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAuditSinks_PubSub(t *testing.T) {
+
+	Convey("Given I have a pubsub sink", t, func() {
+
+		ps := &mockPubSubServer{}
+		s := NewAuditPubSubSink(ps, "audit")
+
+		Convey("When I write a record", func() {
+
+			err := s.Write(&AuditRecord{RequestID: "1"})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+				So(s.Close(), ShouldBeNil)
+			})
+
+			Convey("Then the record should have been published", func() {
+				So(len(ps.publications), ShouldEqual, 1)
+				So(ps.publications[0].Topic, ShouldEqual, "audit")
+
+				r := &AuditRecord{}
+				So(ps.publications[0].Decode(r), ShouldBeNil)
+				So(r.RequestID, ShouldEqual, "1")
+			})
+		})
+	})
+}
@@ -0,0 +1,396 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// AuditRedactedValue is the value used in place
+// of the secret attributes in the audit records.
+const AuditRedactedValue = "[redacted]"
+
+// An AuditOutcome represents the outcome of an audited request.
+type AuditOutcome string
+
+// Various values for AuditOutcome.
+const (
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
+)
+
+// An AuditRecord is a structured record of an audited request.
+type AuditRecord struct {
+	Timestamp      time.Time              `json:"timestamp"`
+	RequestID      string                 `json:"requestID"`
+	TraceID        string                 `json:"traceID,omitempty"`
+	Claims         []string               `json:"claims,omitempty"`
+	ClientIP       string                 `json:"clientIP,omitempty"`
+	Namespace      string                 `json:"namespace,omitempty"`
+	Identity       string                 `json:"identity"`
+	Operation      elemental.Operation    `json:"operation"`
+	ObjectID       string                 `json:"objectID,omitempty"`
+	ParentIdentity string                 `json:"parentIdentity,omitempty"`
+	ParentID       string                 `json:"parentID,omitempty"`
+	Changes        map[string]interface{} `json:"changes,omitempty"`
+	Outcome        AuditOutcome           `json:"outcome"`
+	StatusCode     int                    `json:"statusCode"`
+	Error          string                 `json:"error,omitempty"`
+	Latency        time.Duration          `json:"latency"`
+}
+
+// An AuditSink writes audit records somewhere.
+type AuditSink interface {
+
+	// Write writes the given record.
+	Write(*AuditRecord) error
+
+	// Close releases the resources used by the sink.
+	Close() error
+}
+
+// auditerCloser is implemented by the Auditers
+// that must be closed when the server stops.
+type auditerCloser interface {
+	Close(context.Context) error
+}
+
+// An AuditQueuePolicy defines what an AuditTrail
+// does when its queue is full.
+type AuditQueuePolicy int
+
+// Various values for AuditQueuePolicy.
+const (
+	// AuditQueuePolicyBlock blocks the request until
+	// there is room in the queue. No record is lost, unless
+	// the AuditTrail is closed while the request is blocked.
+	AuditQueuePolicyBlock AuditQueuePolicy = iota
+
+	// AuditQueuePolicyDropNewest drops the new record.
+	AuditQueuePolicyDropNewest
+
+	// AuditQueuePolicyDropOldest drops the oldest queued
+	// record to make room for the new one.
+	AuditQueuePolicyDropOldest
+)
+
+// An AuditTrail is an Auditer that builds structured AuditRecords
+// and writes them asynchronously to a list of AuditSinks.
+//
+// The record is built synchronously when Audit is called, then it
+// is queued and written by a background goroutine. When the queue is
+// full, the configured AuditQueuePolicy is applied.
+//
+// If an AuditTrail is used with OptAuditer, it will be closed when
+// the server stops, after the rest server is stopped.
+type AuditTrail struct {
+	sinks     []AuditSink
+	queue     chan *AuditRecord
+	policy    AuditQueuePolicy
+	dropped   int64
+	done      chan struct{}
+	stop      chan struct{}
+	closing   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	lock      sync.RWMutex
+	closed    bool
+}
+
+// NewAuditTrail returns a new started *AuditTrail writing to the given sinks.
+func NewAuditTrail(sinks []AuditSink, options ...AuditTrailOption) *AuditTrail {
+
+	cfg := newAuditTrailConfig()
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	a := &AuditTrail{
+		sinks:   sinks,
+		queue:   make(chan *AuditRecord, cfg.queueSize),
+		policy:  cfg.policy,
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+// Audit implements the Auditer interface.
+func (a *AuditTrail) Audit(ctx Context, err error) {
+
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if a.closed {
+		return
+	}
+
+	a.enqueue(NewAuditRecord(ctx, err))
+}
+
+// Dropped returns the number of records that have been
+// dropped because the queue was full, or because the
+// AuditTrail was closed while they were waiting for room.
+func (a *AuditTrail) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Close stops accepting new records, waits for the queued records
+// to be written until the given context expires, and closes the sinks.
+// If the context expires first, the remaining records are discarded,
+// but the sinks are only closed once the record being written, if any,
+// has been written.
+func (a *AuditTrail) Close(ctx context.Context) error {
+
+	a.closeOnce.Do(func() {
+
+		// We first release the requests blocked
+		// on a full queue, as they hold the lock.
+		close(a.closing)
+
+		a.lock.Lock()
+		a.closed = true
+		close(a.queue)
+		a.lock.Unlock()
+
+		select {
+		case <-a.done:
+		case <-ctx.Done():
+			close(a.stop)
+			<-a.done
+			a.closeErr = fmt.Errorf("audit trail: %d record(s) not written", len(a.queue))
+		}
+
+		for _, s := range a.sinks {
+			if err := s.Close(); err != nil {
+				zap.L().Error("Unable to close audit sink", zap.Error(err))
+			}
+		}
+	})
+
+	return a.closeErr
+}
+
+func (a *AuditTrail) enqueue(record *AuditRecord) {
+
+	switch a.policy {
+
+	case AuditQueuePolicyDropNewest:
+		select {
+		case a.queue <- record:
+		default:
+			atomic.AddInt64(&a.dropped, 1)
+		}
+
+	case AuditQueuePolicyDropOldest:
+		for {
+			select {
+			case a.queue <- record:
+				return
+			default:
+			}
+
+			select {
+			case <-a.queue:
+				atomic.AddInt64(&a.dropped, 1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case a.queue <- record:
+		case <-a.closing:
+			atomic.AddInt64(&a.dropped, 1)
+		}
+	}
+}
+
+func (a *AuditTrail) run() {
+
+	defer close(a.done)
+
+	for {
+
+		// We check the stop signal first so no more
+		// record is written once Close gave up.
+		select {
+		case <-a.stop:
+			return
+		default:
+		}
+
+		select {
+
+		case record, ok := <-a.queue:
+			if !ok {
+				return
+			}
+
+			for _, s := range a.sinks {
+				if err := s.Write(record); err != nil {
+					zap.L().Error("Unable to write audit record", zap.Error(err))
+				}
+			}
+
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// NewAuditRecord builds an AuditRecord from the given
+// Context and the error returned by the request, if any.
+//
+// For the update and patch operations, the record contains the
+// attributes sent by the client. The secret attributes are
+// replaced by AuditRedactedValue.
+func NewAuditRecord(ctx Context, err error) *AuditRecord {
+
+	req := ctx.Request()
+
+	record := &AuditRecord{
+		Timestamp:      time.Now(),
+		RequestID:      ctx.Identifier(),
+		Claims:         ctx.Claims(),
+		ClientIP:       req.ClientIP,
+		Namespace:      req.Namespace,
+		Identity:       req.Identity.Name,
+		Operation:      req.Operation,
+		ObjectID:       req.ObjectID,
+		ParentIdentity: req.ParentIdentity.Name,
+		ParentID:       req.ParentID,
+		Outcome:        AuditOutcomeSuccess,
+		StatusCode:     ctx.StatusCode(),
+	}
+
+	if span := opentracing.SpanFromContext(ctx.Context()); span != nil {
+		record.TraceID = extractSpanID(span)
+	}
+
+	if bctx, ok := ctx.(*bcontext); ok && !bctx.startTime.IsZero() {
+		record.Latency = time.Since(bctx.startTime)
+	}
+
+	if err != nil {
+		record.Outcome = AuditOutcomeFailure
+		outError := processError(ctx.Context(), err)
+		record.StatusCode = outError.Code()
+		record.Error = outError.Error()
+	} else if record.StatusCode == 0 {
+		record.StatusCode = http.StatusOK
+		if req.Operation == elemental.OperationInfo {
+			record.StatusCode = http.StatusNoContent
+		}
+	}
+
+	switch req.Operation {
+	case elemental.OperationUpdate, elemental.OperationPatch:
+		record.Changes = auditChanges(req, ctx.InputData())
+	}
+
+	return record
+}
+
+// auditChanges returns the attributes of the given input that
+// have been sent by the client, with the secret attributes redacted.
+func auditChanges(req *elemental.Request, input interface{}) map[string]interface{} {
+
+	if input == nil {
+		return nil
+	}
+
+	attrs, err := redactedAttributes(input)
+	if err != nil {
+		zap.L().Debug("Unable to compute audit changes", zap.Error(err))
+		return nil
+	}
+
+	if len(req.Data) == 0 {
+		return attrs
+	}
+
+	sent := map[string]interface{}{}
+	if err := elemental.Decode(req.ContentType, req.Data, &sent); err != nil {
+		return attrs
+	}
+
+	for k := range attrs {
+		if _, ok := sent[k]; !ok {
+			delete(attrs, k)
+		}
+	}
+
+	return attrs
+}
+
+// redactedAttributes returns the attributes of the given object
+// as a map, with the secret attributes set to AuditRedactedValue.
+//
+// To find the secret attributes, it works on a copy of the
+// object that is passed to elemental.ResetSecretAttributesValues.
+func redactedAttributes(obj interface{}) (map[string]interface{}, error) {
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]interface{}{}
+	if err = json.Unmarshal(data, &attrs); err != nil {
+		return nil, err
+	}
+
+	t := reflect.TypeOf(obj)
+	if t.Kind() != reflect.Ptr {
+		return attrs, nil
+	}
+
+	scrubbedObj := reflect.New(t.Elem()).Interface()
+	if err = json.Unmarshal(data, scrubbedObj); err != nil {
+		return nil, err
+	}
+
+	elemental.ResetSecretAttributesValues(scrubbedObj)
+
+	if data, err = json.Marshal(scrubbedObj); err != nil {
+		return nil, err
+	}
+
+	scrubbed := map[string]interface{}{}
+	if err = json.Unmarshal(data, &scrubbed); err != nil {
+		return nil, err
+	}
+
+	for k, v := range attrs {
+		if sv, ok := scrubbed[k]; !ok || !reflect.DeepEqual(sv, v) {
+			attrs[k] = AuditRedactedValue
+		}
+	}
+
+	return attrs, nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import "fmt"
+
+// An AuditTrailOption represents an option for the AuditTrail.
+type AuditTrailOption func(*auditTrailConfig)
+
+type auditTrailConfig struct {
+	queueSize int
+	policy    AuditQueuePolicy
+}
+
+func newAuditTrailConfig() auditTrailConfig {
+	return auditTrailConfig{
+		queueSize: 1000,
+		policy:    AuditQueuePolicyBlock,
+	}
+}
+
+// AuditTrailOptQueueSize sets the maximum number of records
+// waiting to be written. The default is 1000.
+func AuditTrailOptQueueSize(size int) AuditTrailOption {
+
+	if size <= 0 {
+		panic(fmt.Sprintf("invalid audit queue size %d: it must be positive", size))
+	}
+
+	return func(c *auditTrailConfig) {
+		c.queueSize = size
+	}
+}
+
+// AuditTrailOptQueuePolicy sets the policy to apply when the
+// queue is full. The default is AuditQueuePolicyBlock.
+func AuditTrailOptQueuePolicy(policy AuditQueuePolicy) AuditTrailOption {
+	return func(c *auditTrailConfig) {
+		c.policy = policy
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+type mockAuditSink struct {
+	records           []*AuditRecord
+	block             chan struct{}
+	closed            bool
+	writtenAfterClose bool
+	err               error
+	lock              sync.Mutex
+}
+
+func (s *mockAuditSink) Write(record *AuditRecord) error {
+
+	if s.block != nil {
+		<-s.block
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		s.writtenAfterClose = true
+	}
+
+	s.records = append(s.records, record)
+
+	return s.err
+}
+
+func (s *mockAuditSink) Close() error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+
+	return nil
+}
+
+func (s *mockAuditSink) Records() []*AuditRecord {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]*AuditRecord{}, s.records...)
+}
+
+func TestAuditTrail_NewAuditRecord(t *testing.T) {
+
+	Convey("Given I have a successful create request", t, func() {
+
+		req := elemental.NewRequest()
+		req.Identity = testmodel.ListIdentity
+		req.Operation = elemental.OperationCreate
+		req.ClientIP = "10.0.0.1"
+		req.Namespace = "/ns"
+
+		ctx := newContext(context.Background(), req)
+		ctx.SetClaims([]string{"@auth:realm=test"})
+		ctx.SetInputData(&testmodel.List{Name: "l1"})
+
+		Convey("When I create an audit record", func() {
+
+			record := NewAuditRecord(ctx, nil)
+
+			Convey("Then the record should be correct", func() {
+				So(record.RequestID, ShouldEqual, ctx.Identifier())
+				So(record.Claims, ShouldResemble, []string{"@auth:realm=test"})
+				So(record.ClientIP, ShouldEqual, "10.0.0.1")
+				So(record.Namespace, ShouldEqual, "/ns")
+				So(record.Identity, ShouldEqual, "list")
+				So(record.Operation, ShouldEqual, elemental.OperationCreate)
+				So(record.Outcome, ShouldEqual, AuditOutcomeSuccess)
+				So(record.StatusCode, ShouldEqual, http.StatusOK)
+				So(record.Error, ShouldBeEmpty)
+				So(record.Changes, ShouldBeNil)
+				So(record.Latency, ShouldBeGreaterThan, 0)
+			})
+		})
+	})
+
+	Convey("Given I have a failed retrieve request", t, func() {
+
+		req := elemental.NewRequest()
+		req.Identity = testmodel.ListIdentity
+		req.Operation = elemental.OperationRetrieve
+		req.ObjectID = "xyz"
+
+		ctx := newContext(context.Background(), req)
+
+		Convey("When I create an audit record", func() {
+
+			record := NewAuditRecord(ctx, elemental.NewError("Forbidden", "nope", "test", http.StatusForbidden))
+
+			Convey("Then the record should be correct", func() {
+				So(record.ObjectID, ShouldEqual, "xyz")
+				So(record.Outcome, ShouldEqual, AuditOutcomeFailure)
+				So(record.StatusCode, ShouldEqual, http.StatusForbidden)
+				So(record.Error, ShouldContainSubstring, "nope")
+			})
+		})
+	})
+
+	Convey("Given I have an update request", t, func() {
+
+		req := elemental.NewRequest()
+		req.Identity = testmodel.ListIdentity
+		req.Operation = elemental.OperationUpdate
+		req.ContentType = elemental.EncodingTypeJSON
+		req.Data = []byte(`{"name":"new"}`)
+
+		ctx := newContext(context.Background(), req)
+		ctx.SetInputData(&testmodel.List{Name: "new", Description: "not sent"})
+
+		Convey("When I create an audit record", func() {
+
+			record := NewAuditRecord(ctx, nil)
+
+			Convey("Then the changes should only contain the sent attributes", func() {
+				So(record.Changes, ShouldResemble, map[string]interface{}{"name": "new"})
+			})
+		})
+	})
+}
+
+func TestAuditTrail_Audit(t *testing.T) {
+
+	Convey("Given I have an audit trail", t, func() {
+
+		sink1 := &mockAuditSink{}
+		sink2 := &mockAuditSink{err: errors.New("boom")}
+
+		a := NewAuditTrail([]AuditSink{sink1, sink2})
+
+		req := elemental.NewRequest()
+		req.Identity = testmodel.ListIdentity
+		req.Operation = elemental.OperationDelete
+
+		Convey("When I audit some requests and close it", func() {
+
+			a.Audit(newContext(context.Background(), req), nil)
+			a.Audit(newContext(context.Background(), req), errors.New("oops"))
+
+			err := a.Close(context.Background())
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then all sinks should have received the records", func() {
+				So(len(sink1.Records()), ShouldEqual, 2)
+				So(len(sink2.Records()), ShouldEqual, 2)
+				So(sink1.Records()[1].Outcome, ShouldEqual, AuditOutcomeFailure)
+			})
+
+			Convey("Then the sinks should be closed", func() {
+				So(sink1.closed, ShouldBeTrue)
+				So(sink2.closed, ShouldBeTrue)
+			})
+
+			Convey("When I audit a request after close", func() {
+
+				a.Audit(newContext(context.Background(), req), nil)
+
+				Convey("Then it should be ignored", func() {
+					So(len(sink1.Records()), ShouldEqual, 2)
+				})
+			})
+		})
+	})
+
+	Convey("Given I have an audit trail with a blocked sink and the drop newest policy", t, func() {
+
+		sink := &mockAuditSink{block: make(chan struct{})}
+
+		a := NewAuditTrail(
+			[]AuditSink{sink},
+			AuditTrailOptQueueSize(1),
+			AuditTrailOptQueuePolicy(AuditQueuePolicyDropNewest),
+		)
+
+		req := elemental.NewRequest()
+
+		Convey("When I audit more requests than the queue can hold", func() {
+
+			for i := 0; i < 5; i++ {
+				a.Audit(newContext(context.Background(), req), nil)
+			}
+
+			Convey("Then some records should have been dropped", func() {
+				So(a.Dropped(), ShouldBeGreaterThanOrEqualTo, 3)
+			})
+
+			Convey("When I close it with an expired context", func() {
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					close(sink.block)
+				}()
+
+				err := a.Close(ctx)
+
+				Convey("Then err should not be nil", func() {
+					So(err, ShouldNotBeNil)
+				})
+
+				Convey("Then the sink should have been closed after the last write", func() {
+					sink.lock.Lock()
+					defer sink.lock.Unlock()
+					So(sink.closed, ShouldBeTrue)
+					So(sink.writtenAfterClose, ShouldBeFalse)
+				})
+			})
+		})
+	})
+
+	Convey("Given I have an audit trail with a blocked sink and the block policy", t, func() {
+
+		sink := &mockAuditSink{block: make(chan struct{})}
+		defer close(sink.block)
+
+		a := NewAuditTrail(
+			[]AuditSink{sink},
+			AuditTrailOptQueueSize(1),
+			AuditTrailOptQueuePolicy(AuditQueuePolicyBlock),
+		)
+
+		req := elemental.NewRequest()
+
+		Convey("When a request is blocked on the full queue and I close it with an expiring context", func() {
+
+			// The first record is being written and the second fills the queue.
+			a.Audit(newContext(context.Background(), req), nil)
+			a.Audit(newContext(context.Background(), req), nil)
+
+			audited := make(chan struct{})
+			go func() {
+				a.Audit(newContext(context.Background(), req), nil)
+				close(audited)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			closed := make(chan error)
+			go func() {
+				closed <- a.Close(ctx)
+			}()
+
+			Convey("Then the blocked request should be released", func() {
+
+				select {
+				case <-audited:
+				case <-time.After(time.Second):
+					So("request should have been released", ShouldBeEmpty)
+				}
+
+				So(a.Dropped(), ShouldEqual, 1)
+			})
+
+			Convey("Then close should return once the context expired", func() {
+
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					sink.block <- struct{}{}
+				}()
+
+				select {
+				case err := <-closed:
+					So(err, ShouldNotBeNil)
+				case <-time.After(time.Second):
+					So("close should have returned", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+
+	Convey("Given I have an audit trail with a blocked sink and the drop oldest policy", t, func() {
+
+		sink := &mockAuditSink{block: make(chan struct{})}
+
+		a := NewAuditTrail(
+			[]AuditSink{sink},
+			AuditTrailOptQueueSize(1),
+			AuditTrailOptQueuePolicy(AuditQueuePolicyDropOldest),
+		)
+
+		req := elemental.NewRequest()
+
+		Convey("When I audit more requests than the queue can hold", func() {
+
+			var ids []string
+			for i := 0; i < 5; i++ {
+				ctx := newContext(context.Background(), req)
+				ids = append(ids, ctx.Identifier())
+				a.Audit(ctx, nil)
+			}
+
+			close(sink.block)
+			_ = a.Close(context.Background())
+
+			Convey("Then the last record should have been kept", func() {
+				records := sink.Records()
+				So(a.Dropped(), ShouldBeGreaterThanOrEqualTo, 3)
+				So(records[len(records)-1].RequestID, ShouldEqual, ids[4])
+			})
+		})
+	})
+}
@@ -13,11 +13,15 @@ package bahamut
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/go-zoo/bone"
 	"go.aporeto.io/elemental"
@@ -77,11 +81,16 @@ type server struct {
 	multiplexer          *bone.Mux
 	processors           map[string]Processor
 	customRoutesHandlers map[string]http.HandlerFunc
+	routesRevision       uint64
 	cfg                  config
 	restServer           *restServer
 	pushServer           *pushServer
 	healthServer         *healthServer
 	profilingServer      *profilingServer
+	lock                 sync.RWMutex
+	stopOnce             sync.Once
+	stopErr              error
+	stopped              chan struct{}
 }
 
 // New returns a new bahamut Server configured with
@@ -101,6 +110,18 @@ func New(options ...Option) Server {
 		zap.L().Warn("Push server is enabled but neither dispatching or publishing is. Use bahamut.OptPushPublishHandler() and/or bahamut.OptPushDispatchHandler()")
 	}
 
+	if c.restServer.http3ListenAddress != "" && c.tls.policy != nil && c.tls.policy.MaxVersion != 0 && c.tls.policy.MaxVersion < tls.VersionTLS13 {
+		panic("http3 requires TLS 1.3 but the TLS policy does not allow it")
+	}
+
+	if c.tls.reloader != nil {
+		for _, lc := range c.restServer.listeners {
+			if lc.tlsEnabled() {
+				panic(fmt.Sprintf("rest server listener %s/%s cannot use TLS when TLS reloading is enabled", lc.network, lc.address))
+			}
+		}
+	}
+
 	if (c.restServer.enabled || c.pushServer.enabled) && len(c.model.modelManagers) == 0 {
 		zap.L().Warn("No elemental.ModelManager is defined. Use bahamut.OptModel()")
 	}
@@ -115,12 +136,17 @@ func NewServer(cfg config) Server {
 		cfg.model.unmarshallers = map[elemental.Identity]CustomUmarshaller{}
 	}
 
+	if cfg.responseCache == nil {
+		cfg.responseCache = newConfiguredResponseCache(cfg)
+	}
+
 	mux := bone.New()
 	srv := &server{
 		multiplexer:          mux,
 		processors:           make(map[string]Processor),
 		customRoutesHandlers: make(map[string]http.HandlerFunc),
 		cfg:                  cfg,
+		stopped:              make(chan struct{}),
 	}
 
 	if cfg.restServer.enabled {
@@ -129,10 +155,15 @@ func NewServer(cfg config) Server {
 
 	if cfg.pushServer.enabled {
 		srv.pushServer = newPushServer(cfg, mux, srv.ProcessorForIdentity)
+		srv.pushServer.pusher = srv.Push
+		if srv.restServer != nil {
+			srv.pushServer.admission = srv.restServer.admission
+		}
 	}
 
 	if cfg.healthServer.enabled {
 		srv.healthServer = newHealthServer(cfg)
+		srv.healthServer.pushServer = srv.pushServer
 	}
 
 	if cfg.profilingServer.enabled {
@@ -144,22 +175,30 @@ func NewServer(cfg config) Server {
 
 func (b *server) RegisterProcessor(processor Processor, identity elemental.Identity) error {
 
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	if _, ok := b.processors[identity.Name]; ok {
 		return fmt.Errorf("identity %s already has a registered processor", identity)
 	}
 
 	b.processors[identity.Name] = processor
+	b.routesRevision++
 
 	return nil
 }
 
 func (b *server) UnregisterProcessor(identity elemental.Identity) error {
 
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	if _, ok := b.processors[identity.Name]; !ok {
 		return fmt.Errorf("no registered processor for identity %s", identity)
 	}
 
 	delete(b.processors, identity.Name)
+	b.routesRevision++
 
 	return nil
 }
@@ -177,42 +216,73 @@ func (b *server) RegisterCustomRouteHandler(path string, handler http.HandlerFun
 		)
 	}
 
+	b.lock.Lock()
+
 	if _, ok := b.customRoutesHandlers[path]; ok {
+		b.lock.Unlock()
 		return fmt.Errorf("path %s has a registered handler already", path)
 	}
 
 	b.customRoutesHandlers[path] = handler
 
+	b.lock.Unlock()
+
+	b.restServer.installCustomRoutes()
+
 	return nil
 }
 
 func (b *server) UnregisterCustomRouteHandler(path string) error {
 
+	b.lock.Lock()
+
 	if _, ok := b.customRoutesHandlers[path]; !ok {
+		b.lock.Unlock()
 		return fmt.Errorf("path %s has no existing handler", path)
 	}
 
 	delete(b.customRoutesHandlers, path)
 
+	b.lock.Unlock()
+
+	if b.restServer != nil {
+		b.restServer.installCustomRoutes()
+	}
+
 	return nil
 }
 
 func (b *server) ProcessorForIdentity(identity elemental.Identity) (Processor, error) {
 
-	if _, ok := b.processors[identity.Name]; !ok {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	p, ok := b.processors[identity.Name]
+	if !ok {
 		return nil, fmt.Errorf("no registered processor for identity %s", identity)
 	}
 
-	return b.processors[identity.Name], nil
+	return p, nil
 }
 
 func (b *server) CustomHandlers() map[string]http.HandlerFunc {
 
-	return b.customRoutesHandlers
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	handlers := make(map[string]http.HandlerFunc, len(b.customRoutesHandlers))
+	for k, v := range b.customRoutesHandlers {
+		handlers[k] = v
+	}
+
+	return handlers
 }
 
 func (b *server) ProcessorsCount() int {
 
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
 	return len(b.processors)
 }
 
@@ -225,11 +295,64 @@ func (b *server) Push(events ...*elemental.Event) {
 	b.pushServer.pushEvents(events...)
 }
 
+func (b *server) PushTo(target PushTarget, events ...*elemental.Event) error {
+
+	if b.pushServer == nil {
+		return nil
+	}
+
+	return b.pushServer.pushTo(target, events...)
+}
+
+func (b *server) PushSessions(claims ...string) []PushSessionInfo {
+
+	if b.pushServer == nil {
+		return nil
+	}
+
+	return b.pushServer.sessionsInfo(claims)
+}
+
+func (b *server) PushSession(id string) (PushSessionInfo, bool) {
+
+	if b.pushServer == nil {
+		return PushSessionInfo{}, false
+	}
+
+	return b.pushServer.sessionInfo(id)
+}
+
+func (b *server) ClosePushSession(id string, reason string) bool {
+
+	if b.pushServer == nil {
+		return false
+	}
+
+	return b.pushServer.closeSession(id, reason)
+}
+
+func (b *server) ClosePushSessionsWithClaims(reason string, claims ...string) int {
+
+	if b.pushServer == nil {
+		return 0
+	}
+
+	return b.pushServer.closeSessionsWithClaims(claims, reason)
+}
+
 func (b *server) RoutesInfo() map[int][]RouteInfo {
 
 	return buildVersionedRoutes(b.cfg.model.modelManagers, b.ProcessorForIdentity)
 }
 
+func (b *server) RoutesRevision() uint64 {
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.routesRevision
+}
+
 func (b *server) VersionsInfo() map[string]interface{} {
 
 	return b.cfg.meta.version
@@ -250,12 +373,15 @@ func (b *server) PushEndpoint() string {
 
 func (b *server) Run(ctx context.Context) {
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	if b.profilingServer != nil {
 		go b.profilingServer.start(ctx)
 	}
 
 	if b.restServer != nil {
-		go b.restServer.start(ctx, b.RoutesInfo())
+		go b.restServer.start(ctx, b.RoutesInfo)
 	}
 
 	if b.pushServer != nil {
@@ -272,7 +398,26 @@ func (b *server) Run(ctx context.Context) {
 		}
 	}
 
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+		if err := b.Stop(context.Background()); err != nil {
+			zap.L().Error("Unable to gracefully stop bahamut server", zap.Error(err))
+		}
+	case <-b.stopped:
+	}
+}
+
+func (b *server) Stop(ctx context.Context) error {
+
+	b.stopOnce.Do(func() {
+		b.stopErr = b.stop(ctx)
+		close(b.stopped)
+	})
+
+	return b.stopErr
+}
+
+func (b *server) stop(ctx context.Context) error {
 
 	if hook := b.cfg.hooks.preStop; hook != nil {
 		if err := hook(b); err != nil {
@@ -280,23 +425,55 @@ func (b *server) Run(ctx context.Context) {
 		}
 	}
 
-	// Stop the health server first so we become unhealthy.
+	// Enter the drain phase: we become unhealthy
+	// and we reject the new requests.
 	if b.healthServer != nil {
-		<-b.healthServer.stop().Done()
+		b.healthServer.drain()
 	}
 
+	if b.restServer != nil {
+		b.restServer.drain()
+	}
+
+	var errs []string
+
 	// Stop the push server to disconnect everybody.
 	if b.pushServer != nil {
-		b.pushServer.stop()
+		if err := b.pushServer.stop(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
 	}
 
 	// Stop the restserver and wait for current requests to complete.
 	if b.restServer != nil {
-		<-b.restServer.stop().Done()
+		if err := b.restServer.stop(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// Flush the audit records of the completed requests.
+	if a, ok := b.cfg.security.auditer.(auditerCloser); ok {
+		auditCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := a.Close(auditCtx); err != nil {
+			errs = append(errs, err.Error())
+		}
+		cancel()
+	}
+
+	if b.healthServer != nil {
+		if err := b.healthServer.stop(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
 	}
 
 	// Stop the profiling server.
 	if b.profilingServer != nil {
 		b.profilingServer.stop()
 	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to drain server: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
 }
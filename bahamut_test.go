@@ -12,8 +12,14 @@
 package bahamut
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
@@ -66,6 +72,56 @@ func TestBahamut_New(t *testing.T) {
 			So(logs[0].Message, ShouldEqual, "No elemental.ModelManager is defined. Use bahamut.OptModel()")
 		})
 	})
+
+	Convey("Given I create a bahamut with http3 and a TLS policy without TLS 1.3", t, func() {
+
+		policy, _ := NewTLSPolicy(TLSProfileFIPS)
+
+		Convey("Then it should panic", func() {
+			So(
+				func() { New(OptRestServer(":123"), OptHTTP3(":123"), OptTLSPolicy(policy)) },
+				ShouldPanicWith,
+				"http3 requires TLS 1.3 but the TLS policy does not allow it",
+			)
+		})
+
+		Convey("Then it should not panic if the policy allows TLS 1.3", func() {
+			policy, _ := NewTLSPolicy(TLSProfileIntermediate)
+			So(func() { New(OptRestServer(":123"), OptHTTP3(":123"), OptTLSPolicy(policy)) }, ShouldNotPanic)
+		})
+	})
+
+	Convey("Given I create a bahamut with TLS reloading and a TLS listener", t, func() {
+
+		loader := func() (*TLSMaterial, error) { return &TLSMaterial{}, nil }
+
+		Convey("Then it should panic", func() {
+			So(
+				func() {
+					New(
+						OptRestServer(":123"),
+						OptTLSReloader(loader, time.Minute, 0),
+						OptRestServerListener("tcp", ":124", ListenerOptTLS([]tls.Certificate{{}}, nil)),
+					)
+				},
+				ShouldPanicWith,
+				"rest server listener tcp/:124 cannot use TLS when TLS reloading is enabled",
+			)
+		})
+
+		Convey("Then it should not panic if the listener does not use TLS", func() {
+			So(
+				func() {
+					New(
+						OptRestServer(":123"),
+						OptTLSReloader(loader, time.Minute, 0),
+						OptRestServerListener("tcp", ":124"),
+					)
+				},
+				ShouldNotPanic,
+			)
+		})
+	})
 }
 
 func TestBahamut_NewBahamut(t *testing.T) {
@@ -269,6 +325,19 @@ func TestBahamut_ProcessorRegistration(t *testing.T) {
 			Convey("Then the number of registered processors should be 1", func() {
 				So(b.ProcessorsCount(), ShouldEqual, 1)
 			})
+
+			Convey("Then the routes revision should have changed", func() {
+				So(b.(RoutesRevisioner).RoutesRevision(), ShouldEqual, 1)
+			})
+
+			Convey("When I unregister it", func() {
+
+				_ = b.UnregisterProcessor(ident)
+
+				Convey("Then the routes revision should have changed again", func() {
+					So(b.(RoutesRevisioner).RoutesRevision(), ShouldEqual, 2)
+				})
+			})
 		})
 
 		Convey("When I register it twie", func() {
@@ -394,6 +463,84 @@ func TestBahamut_RegisterCustomRouteHandlers(t *testing.T) {
 	})
 }
 
+func TestBahamut_RuntimeRegistration(t *testing.T) {
+
+	Convey("Given I have a bahamut server with installed routes", t, func() {
+
+		cfg := config{}
+		cfg.restServer.enabled = true
+		cfg.restServer.apiPrefix = "/api"
+		cfg.restServer.customRoutePrefix = "/custom"
+		cfg.model.modelManagers = map[int]elemental.ModelManager{0: testmodel.Manager(), 1: testmodel.Manager()}
+
+		b := NewServer(cfg).(*server)
+		b.restServer.installRoutes(b.RoutesInfo)
+
+		serve := func(url string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			b.multiplexer.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+			return w
+		}
+
+		Convey("When I register a custom route handler", func() {
+
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+			So(b.RegisterCustomRouteHandler("/saml", h), ShouldBeNil)
+
+			Convey("Then the route should be served", func() {
+				So(serve("/custom/saml").Code, ShouldEqual, http.StatusTeapot)
+			})
+
+			Convey("Then an unknown custom route should not be found", func() {
+				So(serve("/custom/nope").Code, ShouldEqual, http.StatusNotFound)
+			})
+
+			Convey("When I unregister it", func() {
+
+				So(b.UnregisterCustomRouteHandler("/saml"), ShouldBeNil)
+
+				Convey("Then the route should not be served anymore", func() {
+					So(serve("/custom/saml").Code, ShouldEqual, http.StatusNotFound)
+				})
+			})
+		})
+
+		Convey("When I register a processor", func() {
+
+			So(serve("/_meta/routes").Body.String(), ShouldNotContainSubstring, `"identity":"lists"`)
+
+			So(b.RegisterProcessor(&mockEmptyProcessor{}, testmodel.ListIdentity), ShouldBeNil)
+
+			Convey("Then the meta routes should contain it", func() {
+				So(serve("/_meta/routes").Body.String(), ShouldContainSubstring, `"identity":"lists"`)
+			})
+		})
+
+		Convey("When I register and unregister processors concurrently", func() {
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					_ = b.RegisterProcessor(&mockEmptyProcessor{}, testmodel.ListIdentity)
+					_ = b.UnregisterProcessor(testmodel.ListIdentity)
+				}()
+				go func() {
+					defer wg.Done()
+					_, _ = b.ProcessorForIdentity(testmodel.ListIdentity)
+					_ = b.RoutesInfo()
+				}()
+			}
+			wg.Wait()
+
+			Convey("Then the processor should be unregistered", func() {
+				So(b.ProcessorsCount(), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
 func TestBahamyt_RegistorProcessorOrDie(t *testing.T) {
 
 	Convey("Given I have no bahamut server ", t, func() {
@@ -435,3 +582,53 @@ func TestBahamyt_RegistorProcessorOrDie(t *testing.T) {
 		})
 	})
 }
+
+func TestBahamut_Stop(t *testing.T) {
+
+	Convey("Given I have a running bahamut server", t, func() {
+
+		var preStopCalled int
+		sink := &mockAuditSink{}
+		b := New(
+			OptHealthServer(fmt.Sprintf("127.0.0.1:%d", freePort()), nil),
+			OptPreStopHook(func(Server) error { preStopCalled++; return nil }),
+			OptAuditer(NewAuditTrail([]AuditSink{sink})),
+		)
+
+		out := make(chan struct{})
+		go func() {
+			b.Run(context.Background())
+			close(out)
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		Convey("When I call Stop twice", func() {
+
+			err1 := b.Stop(context.Background())
+			err2 := b.Stop(context.Background())
+
+			Convey("Then errs should be nil", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+			})
+
+			Convey("Then the preStop hook should have been called once", func() {
+				So(preStopCalled, ShouldEqual, 1)
+			})
+
+			Convey("Then the audit trail should have been closed", func() {
+				So(sink.closed, ShouldBeTrue)
+			})
+
+			Convey("Then Run should return", func() {
+				var returned bool
+				select {
+				case <-out:
+					returned = true
+				case <-time.After(3 * time.Second):
+				}
+				So(returned, ShouldBeTrue)
+			})
+		})
+	})
+}
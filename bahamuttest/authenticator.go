@@ -0,0 +1,58 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"strings"
+
+	"go.aporeto.io/bahamut"
+)
+
+// ClaimsHeader is the header used by the RequestBuilder
+// to pass the claims to the ClaimsAuthenticator.
+const ClaimsHeader = "X-Bahamuttest-Claims"
+
+// A ClaimsAuthenticator is a bahamut.RequestAuthenticator and a
+// bahamut.SessionAuthenticator that sets the claims found in the
+// ClaimsHeader header of the request or the session.
+//
+// It always returns bahamut.AuthActionContinue, so it can be placed
+// before the authenticators of the application in the chain.
+type ClaimsAuthenticator struct{}
+
+// NewClaimsAuthenticator returns a new *ClaimsAuthenticator.
+func NewClaimsAuthenticator() *ClaimsAuthenticator {
+	return &ClaimsAuthenticator{}
+}
+
+// AuthenticateRequest implements the bahamut.RequestAuthenticator interface.
+func (a *ClaimsAuthenticator) AuthenticateRequest(ctx bahamut.Context) (bahamut.AuthAction, error) {
+
+	if claims := ctx.Request().Headers[ClaimsHeader]; len(claims) > 0 {
+		ctx.SetClaims(claims)
+	}
+
+	return bahamut.AuthActionContinue, nil
+}
+
+// AuthenticateSession implements the bahamut.SessionAuthenticator interface.
+//
+// As bahamut.Session only gives access to the first value of a header,
+// the claims must be passed as a single comma separated value.
+func (a *ClaimsAuthenticator) AuthenticateSession(session bahamut.Session) (bahamut.AuthAction, error) {
+
+	if claims := session.Header(ClaimsHeader); claims != "" {
+		session.SetClaims(strings.Split(claims, ","))
+	}
+
+	return bahamut.AuthActionContinue, nil
+}
@@ -0,0 +1,23 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bahamuttest provides utilities to test bahamut processors
+// without running a server on the network.
+//
+// NewServer returns an in-memory server that runs the exact same
+// dispatching and response pipeline as a real bahamut server, over an
+// in-memory transport. Requests can be issued with claims using the
+// RequestBuilder returned by Server.Request, and the pushed events and
+// audit calls are recorded by the server Recorder.
+//
+// The package also provides a fake PushSession and the ShouldDispatch
+// function to test the decisions of a bahamut.PushDispatchHandler.
+package bahamuttest // import "go.aporeto.io/bahamut/bahamuttest"
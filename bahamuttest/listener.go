@@ -0,0 +1,87 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+var errListenerClosed = errors.New("listener closed")
+
+type memAddr struct{}
+
+func (memAddr) Network() string { return "memory" }
+func (memAddr) String() string  { return "bahamuttest" }
+
+// A memListener is an in-memory net.Listener. Connections are
+// created using DialContext and returned by Accept.
+type memListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMemListener() *memListener {
+
+	return &memListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *memListener) Close() error {
+
+	l.closeOnce.Do(func() { close(l.closed) })
+
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr{}
+}
+
+// DialContext returns a new connection to the listener. It blocks
+// until the connection is accepted, the listener is closed or
+// the given context is canceled.
+func (l *memListener) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+
+	server, client := net.Pipe()
+
+	var err error
+
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		err = errListenerClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	server.Close() // nolint: errcheck
+	client.Close() // nolint: errcheck
+
+	return nil, err
+}
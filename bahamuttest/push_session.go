@@ -0,0 +1,257 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+// A PushSession is a fake bahamut.PushSession. It can be used
+// to test the decisions of a bahamut.PushDispatchHandler
+// using ShouldDispatch.
+//
+// The events sent using DirectPush are recorded and can be
+// retrieved using DirectPushedEvents.
+type PushSession struct {
+	id                 string
+	ctx                context.Context
+	parameters         url.Values
+	headers            http.Header
+	cookies            []*http.Cookie
+	pushConfig         *elemental.PushConfig
+	claims             []string
+	tags               []string
+	metadata           interface{}
+	clientIP           string
+	tlsConnectionState *tls.ConnectionState
+	directPushed       []*elemental.Event
+	lock               sync.RWMutex
+}
+
+// NewPushSession returns a new *PushSession bound to the given context.
+func NewPushSession(ctx context.Context) *PushSession {
+
+	return &PushSession{
+		id:         uuid.Must(uuid.NewV4()).String(),
+		ctx:        ctx,
+		parameters: url.Values{},
+		headers:    http.Header{},
+	}
+}
+
+// WithParameter sets the parameter with the given key.
+func (s *PushSession) WithParameter(key string, value string) *PushSession {
+	s.parameters.Set(key, value)
+	return s
+}
+
+// WithHeader sets the header with the given key.
+func (s *PushSession) WithHeader(key string, value string) *PushSession {
+	s.headers.Set(key, value)
+	return s
+}
+
+// WithCookie adds the given cookie.
+func (s *PushSession) WithCookie(cookie *http.Cookie) *PushSession {
+	s.cookies = append(s.cookies, cookie)
+	return s
+}
+
+// WithPushConfig sets the push config of the session.
+func (s *PushSession) WithPushConfig(pushConfig *elemental.PushConfig) *PushSession {
+	s.pushConfig = pushConfig
+	return s
+}
+
+// WithClaims sets the claims of the session.
+func (s *PushSession) WithClaims(claims ...string) *PushSession {
+	s.SetClaims(claims)
+	return s
+}
+
+// WithTags sets the tags of the session.
+func (s *PushSession) WithTags(tags ...string) *PushSession {
+	s.SetTags(tags)
+	return s
+}
+
+// WithClientIP sets the client IP of the session.
+func (s *PushSession) WithClientIP(ip string) *PushSession {
+	s.clientIP = ip
+	return s
+}
+
+// WithTLSConnectionState sets the TLS connection state of the session.
+func (s *PushSession) WithTLSConnectionState(state *tls.ConnectionState) *PushSession {
+	s.tlsConnectionState = state
+	return s
+}
+
+// Identifier implements the bahamut.Session interface.
+func (s *PushSession) Identifier() string { return s.id }
+
+// Context implements the bahamut.Session interface.
+func (s *PushSession) Context() context.Context { return s.ctx }
+
+// Parameter implements the bahamut.Session interface.
+func (s *PushSession) Parameter(key string) string { return s.parameters.Get(key) }
+
+// Header implements the bahamut.Session interface.
+func (s *PushSession) Header(key string) string { return s.headers.Get(key) }
+
+// PushConfig implements the bahamut.Session interface.
+func (s *PushSession) PushConfig() *elemental.PushConfig { return s.pushConfig }
+
+// Token implements the bahamut.Session interface.
+func (s *PushSession) Token() string { return s.Parameter("token") }
+
+// ClientIP implements the bahamut.Session interface.
+func (s *PushSession) ClientIP() string { return s.clientIP }
+
+// TLSConnectionState implements the bahamut.Session interface.
+func (s *PushSession) TLSConnectionState() *tls.ConnectionState { return s.tlsConnectionState }
+
+// Cookie implements the bahamut.Session interface.
+func (s *PushSession) Cookie(name string) (*http.Cookie, error) {
+
+	for _, cookie := range s.cookies {
+		if cookie.Name == name {
+			return cookie, nil
+		}
+	}
+
+	return nil, http.ErrNoCookie
+}
+
+// SetClaims implements the bahamut.Session interface.
+func (s *PushSession) SetClaims(claims []string) {
+
+	s.lock.Lock()
+	s.claims = append([]string{}, claims...)
+	s.lock.Unlock()
+}
+
+// Claims implements the bahamut.Session interface.
+func (s *PushSession) Claims() []string {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]string{}, s.claims...)
+}
+
+// ClaimsMap implements the bahamut.Session interface.
+func (s *PushSession) ClaimsMap() map[string]string {
+
+	claimsMap := map[string]string{}
+
+	for _, claim := range s.Claims() {
+		if parts := strings.SplitN(claim, "=", 2); len(parts) == 2 {
+			claimsMap[parts[0]] = parts[1]
+		}
+	}
+
+	return claimsMap
+}
+
+// SetTags implements the bahamut.TaggableSession interface.
+func (s *PushSession) SetTags(tags []string) {
+
+	s.lock.Lock()
+	s.tags = append([]string{}, tags...)
+	s.lock.Unlock()
+}
+
+// Tags implements the bahamut.TaggableSession interface.
+func (s *PushSession) Tags() []string {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]string{}, s.tags...)
+}
+
+// Metadata implements the bahamut.Session interface.
+func (s *PushSession) Metadata() interface{} {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.metadata
+}
+
+// SetMetadata implements the bahamut.Session interface.
+func (s *PushSession) SetMetadata(metadata interface{}) {
+
+	s.lock.Lock()
+	s.metadata = metadata
+	s.lock.Unlock()
+}
+
+// DirectPush implements the bahamut.PushSession interface.
+func (s *PushSession) DirectPush(events ...*elemental.Event) {
+
+	s.lock.Lock()
+	s.directPushed = append(s.directPushed, events...)
+	s.lock.Unlock()
+}
+
+// DirectPushedEvents returns the events sent using DirectPush.
+func (s *PushSession) DirectPushedEvents() []*elemental.Event {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]*elemental.Event{}, s.directPushed...)
+}
+
+// ShouldDispatch returns true if the given event would be dispatched
+// to the given session by a push server using the given handler.
+//
+// It applies the push config of the session as the push server does,
+// taking the related identities into account, then calls
+// SummarizeEvent and ShouldDispatch on the handler.
+func ShouldDispatch(handler bahamut.PushDispatchHandler, session bahamut.PushSession, event *elemental.Event) (bool, error) {
+
+	if f := session.PushConfig(); f != nil {
+
+		identities := append([]string{event.Identity}, handler.RelatedEventIdentities(event.Identity)...)
+
+		var ok bool
+		for _, identity := range identities {
+			if !f.IsFilteredOut(identity, event.Type) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	summary, err := handler.SummarizeEvent(event)
+	if err != nil {
+		return false, err
+	}
+
+	return handler.ShouldDispatch(session, event, summary)
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+type testDispatchHandler struct {
+	summarizeErr error
+}
+
+func (h *testDispatchHandler) OnPushSessionInit(bahamut.PushSession) (bool, error) { return true, nil }
+func (h *testDispatchHandler) OnPushSessionStart(bahamut.PushSession)              {}
+func (h *testDispatchHandler) OnPushSessionStop(bahamut.PushSession)               {}
+func (h *testDispatchHandler) RelatedEventIdentities(identity string) []string {
+	if identity == "task" {
+		return []string{"list"}
+	}
+	return nil
+}
+
+func (h *testDispatchHandler) SummarizeEvent(event *elemental.Event) (interface{}, error) {
+	return event.Identity, h.summarizeErr
+}
+
+func (h *testDispatchHandler) ShouldDispatch(session bahamut.PushSession, event *elemental.Event, summary interface{}) (bool, error) {
+	return session.ClaimsMap()["@auth:realm"] == "test" && summary.(string) == event.Identity, nil
+}
+
+func TestPushSession(t *testing.T) {
+
+	Convey("Given I have a push session", t, func() {
+
+		s := NewPushSession(context.Background()).
+			WithParameter("token", "secret").
+			WithHeader("X-Hello", "world").
+			WithClaims("@auth:realm=test", "@auth:user=bob").
+			WithTags("beta").
+			WithClientIP("10.0.0.1")
+
+		s.SetMetadata("meta")
+
+		Convey("Then it should implement bahamut.PushSession", func() {
+			So(s, ShouldImplement, (*bahamut.PushSession)(nil))
+			So(s, ShouldImplement, (*bahamut.TaggableSession)(nil))
+		})
+
+		Convey("Then the session should be correct", func() {
+			So(s.Identifier(), ShouldNotBeEmpty)
+			So(s.Token(), ShouldEqual, "secret")
+			So(s.Header("X-Hello"), ShouldEqual, "world")
+			So(s.ClientIP(), ShouldEqual, "10.0.0.1")
+			So(s.Metadata(), ShouldEqual, "meta")
+			So(s.Claims(), ShouldResemble, []string{"@auth:realm=test", "@auth:user=bob"})
+			So(s.ClaimsMap(), ShouldResemble, map[string]string{"@auth:realm": "test", "@auth:user": "bob"})
+			So(s.Tags(), ShouldResemble, []string{"beta"})
+		})
+
+		Convey("When I direct push an event", func() {
+
+			event := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+			s.DirectPush(event)
+
+			Convey("Then the event should be recorded", func() {
+				So(s.DirectPushedEvents(), ShouldResemble, []*elemental.Event{event})
+			})
+		})
+	})
+}
+
+func TestShouldDispatch(t *testing.T) {
+
+	Convey("Given I have a dispatch handler and a push session", t, func() {
+
+		h := &testDispatchHandler{}
+		s := NewPushSession(context.Background()).WithClaims("@auth:realm=test")
+
+		listEvent := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+		taskEvent := elemental.NewEvent(elemental.EventCreate, testmodel.NewTask())
+
+		Convey("When I check an event with no push config", func() {
+
+			ok, err := ShouldDispatch(h, s, listEvent)
+
+			Convey("Then the event should be dispatched", func() {
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I check an event filtered out by the push config", func() {
+
+			pc := elemental.NewPushConfig()
+			pc.FilterIdentity("user")
+			s.WithPushConfig(pc)
+
+			ok, err := ShouldDispatch(h, s, listEvent)
+
+			Convey("Then the event should not be dispatched", func() {
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I check an event allowed by a related identity", func() {
+
+			pc := elemental.NewPushConfig()
+			pc.FilterIdentity("list")
+			s.WithPushConfig(pc)
+
+			ok, err := ShouldDispatch(h, s, taskEvent)
+
+			Convey("Then the event should be dispatched", func() {
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I check an event for a session with other claims", func() {
+
+			s.WithClaims("@auth:realm=other")
+
+			ok, err := ShouldDispatch(h, s, listEvent)
+
+			Convey("Then the event should not be dispatched", func() {
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the summary fails", func() {
+
+			h.summarizeErr = errors.New("boom")
+
+			ok, err := ShouldDispatch(h, s, listEvent)
+
+			Convey("Then err should be returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "boom")
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
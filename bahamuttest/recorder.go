@@ -0,0 +1,128 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"context"
+	"sync"
+
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+// An AuditRecord contains the information
+// recorded for a call to bahamut.Auditer.Audit.
+type AuditRecord struct {
+	Context bahamut.Context
+	Error   error
+}
+
+// A Recorder is a bahamut.PubSubClient and a bahamut.Auditer
+// that records the publications and the audit calls. Publications
+// are forwarded to an in-memory bahamut.PubSubClient so the
+// push sessions of the server still receive the events.
+//
+// It is safe to use a Recorder from multiple goroutines.
+type Recorder struct {
+	pubsub       bahamut.PubSubClient
+	publications []*bahamut.Publication
+	audits       []AuditRecord
+	lock         sync.RWMutex
+}
+
+// NewRecorder returns a new *Recorder.
+func NewRecorder() *Recorder {
+
+	return &Recorder{
+		pubsub: bahamut.NewLocalPubSubClient(),
+	}
+}
+
+// Publish implements the bahamut.PubSubClient interface.
+func (r *Recorder) Publish(publication *bahamut.Publication, opts ...bahamut.PubSubOptPublish) error {
+
+	r.lock.Lock()
+	r.publications = append(r.publications, publication.Duplicate())
+	r.lock.Unlock()
+
+	return r.pubsub.Publish(publication, opts...)
+}
+
+// Subscribe implements the bahamut.PubSubClient interface.
+func (r *Recorder) Subscribe(pubs chan *bahamut.Publication, errors chan error, topic string, opts ...bahamut.PubSubOptSubscribe) func() {
+	return r.pubsub.Subscribe(pubs, errors, topic, opts...)
+}
+
+// Connect implements the bahamut.PubSubClient interface.
+func (r *Recorder) Connect(ctx context.Context) error {
+	return r.pubsub.Connect(ctx)
+}
+
+// Disconnect implements the bahamut.PubSubClient interface.
+func (r *Recorder) Disconnect() error {
+	return r.pubsub.Disconnect()
+}
+
+// Audit implements the bahamut.Auditer interface.
+func (r *Recorder) Audit(ctx bahamut.Context, err error) {
+
+	r.lock.Lock()
+	r.audits = append(r.audits, AuditRecord{Context: ctx, Error: err})
+	r.lock.Unlock()
+}
+
+// Publications returns a copy of the recorded publications.
+func (r *Recorder) Publications() []*bahamut.Publication {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return append([]*bahamut.Publication{}, r.publications...)
+}
+
+// Events returns the events contained in the recorded publications.
+// Publications that do not contain an event are ignored.
+func (r *Recorder) Events() []*elemental.Event {
+
+	publications := r.Publications()
+	events := make([]*elemental.Event, 0, len(publications))
+
+	for _, p := range publications {
+
+		event := &elemental.Event{}
+		if err := p.Decode(event); err != nil {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// Audits returns a copy of the recorded audit calls.
+func (r *Recorder) Audits() []AuditRecord {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return append([]AuditRecord{}, r.audits...)
+}
+
+// Reset forgets all the recorded publications and audit calls.
+func (r *Recorder) Reset() {
+
+	r.lock.Lock()
+	r.publications = nil
+	r.audits = nil
+	r.lock.Unlock()
+}
@@ -0,0 +1,217 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"go.aporeto.io/elemental"
+)
+
+var operationMethods = map[elemental.Operation]string{
+	elemental.OperationRetrieveMany: http.MethodGet,
+	elemental.OperationRetrieve:     http.MethodGet,
+	elemental.OperationCreate:       http.MethodPost,
+	elemental.OperationUpdate:       http.MethodPut,
+	elemental.OperationDelete:       http.MethodDelete,
+	elemental.OperationPatch:        http.MethodPatch,
+	elemental.OperationInfo:         http.MethodHead,
+}
+
+// A RequestBuilder builds and sends an elemental request
+// to a Server. It is created using Server.Request.
+type RequestBuilder struct {
+	server     *Server
+	ctx        context.Context
+	operation  elemental.Operation
+	identity   elemental.Identity
+	id         string
+	parent     elemental.Identity
+	parentID   string
+	version    int
+	prefix     string
+	data       interface{}
+	parameters url.Values
+	headers    http.Header
+	encoding   elemental.EncodingType
+}
+
+// WithContext sets the context.Context of the request.
+func (b *RequestBuilder) WithContext(ctx context.Context) *RequestBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// WithID sets the ID of the object targeted by the request.
+func (b *RequestBuilder) WithID(id string) *RequestBuilder {
+	b.id = id
+	return b
+}
+
+// WithParent sets the parent of the request.
+func (b *RequestBuilder) WithParent(identity elemental.Identity, id string) *RequestBuilder {
+	b.parent = identity
+	b.parentID = id
+	return b
+}
+
+// WithVersion sets the version of the api to use.
+func (b *RequestBuilder) WithVersion(version int) *RequestBuilder {
+	b.version = version
+	return b
+}
+
+// WithPathPrefix sets the api path prefix to use. This must be set
+// if the server has been configured with bahamut.OptEnableAPIPathPrefix.
+func (b *RequestBuilder) WithPathPrefix(prefix string) *RequestBuilder {
+	b.prefix = prefix
+	return b
+}
+
+// WithData sets the data of the request. It will be
+// encoded using the encoding of the request.
+func (b *RequestBuilder) WithData(data interface{}) *RequestBuilder {
+	b.data = data
+	return b
+}
+
+// WithParameter adds the given values to the parameter with the given key.
+func (b *RequestBuilder) WithParameter(key string, values ...string) *RequestBuilder {
+
+	for _, v := range values {
+		b.parameters.Add(key, v)
+	}
+
+	return b
+}
+
+// WithHeader adds the given value to the header with the given key.
+func (b *RequestBuilder) WithHeader(key string, value string) *RequestBuilder {
+	b.headers.Add(key, value)
+	return b
+}
+
+// WithClaims sets the claims of the request. The claims will be set
+// in the bahamut.Context by the ClaimsAuthenticator of the server.
+func (b *RequestBuilder) WithClaims(claims ...string) *RequestBuilder {
+	b.headers[ClaimsHeader] = append([]string{}, claims...)
+	return b
+}
+
+// WithEncoding sets the encoding of the request and
+// of the response. The default is elemental.EncodingTypeJSON.
+func (b *RequestBuilder) WithEncoding(encoding elemental.EncodingType) *RequestBuilder {
+	b.encoding = encoding
+	return b
+}
+
+// Do sends the request and returns the response.
+func (b *RequestBuilder) Do() (*Response, error) {
+
+	method, ok := operationMethods[b.operation]
+	if !ok {
+		return nil, fmt.Errorf("unsupported operation '%s'", b.operation)
+	}
+
+	var body []byte
+	if b.data != nil {
+		var err error
+		if body, err = elemental.Encode(b.encoding, b.data); err != nil {
+			return nil, fmt.Errorf("unable to encode request data: %s", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, "http://bahamuttest"+b.path()+"?"+b.parameters.Encode(), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %s", err)
+	}
+
+	req = req.WithContext(b.ctx)
+
+	for k, v := range b.headers {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", string(b.encoding))
+	req.Header.Set("Accept", string(b.encoding))
+
+	resp, err := b.server.Client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send request: %s", err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response body: %s", err)
+	}
+
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       data,
+		encoding:   b.encoding,
+	}, nil
+}
+
+func (b *RequestBuilder) path() string {
+
+	p := []string{"/", b.prefix}
+
+	if b.version > 0 {
+		p = append(p, "v", strconv.Itoa(b.version))
+	}
+
+	if b.parentID != "" && !b.parent.IsEmpty() {
+		p = append(p, b.parent.Category, b.parentID)
+	}
+
+	p = append(p, b.identity.Category)
+
+	if b.id != "" {
+		p = append(p, b.id)
+	}
+
+	return path.Join(p...)
+}
+
+// A Response is the response to a request sent by a RequestBuilder.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	encoding elemental.EncodingType
+}
+
+// Decode decodes the body of the response into the given destination.
+func (r *Response) Decode(dest interface{}) error {
+	return elemental.Decode(r.encoding, r.Body, dest)
+}
+
+// Errors decodes the body of the response as a list of elemental.Error.
+func (r *Response) Errors() ([]elemental.Error, error) {
+
+	errs := []elemental.Error{}
+	if err := r.Decode(&errs); err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+)
+
+// PushTopic is the topic used by the Server to publish the events.
+const PushTopic = "bahamuttest"
+
+// A Server is an in-memory bahamut.Server. It runs the exact same
+// pipeline as a real bahamut server, but listens on an in-memory
+// transport instead of the network.
+//
+// By default, the server uses the Recorder as bahamut.PubSubClient
+// for the push server and as bahamut.Auditer, and a ClaimsAuthenticator
+// as request and session authenticator. If you override them with your
+// own options, the corresponding features of the Server will not work.
+type Server struct {
+	bahamut.Server
+
+	// Recorder records the events pushed and the audit
+	// calls made by the server.
+	Recorder *Recorder
+
+	listener *memListener
+	client   *http.Client
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewServer returns a new started *Server configured with the given
+// bahamut options. You must call Close when you are done with it.
+func NewServer(options ...bahamut.Option) *Server {
+
+	recorder := NewRecorder()
+	listener := newMemListener()
+	authenticator := NewClaimsAuthenticator()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if err := recorder.Connect(ctx); err != nil {
+		panic(err)
+	}
+
+	opts := append(
+		[]bahamut.Option{
+			bahamut.OptRestServer(listener.Addr().String()),
+			bahamut.OptCustomListener(listener),
+			bahamut.OptPushServer(recorder, PushTopic),
+			bahamut.OptAuditer(recorder),
+			bahamut.OptAuthenticators(
+				[]bahamut.RequestAuthenticator{authenticator},
+				[]bahamut.SessionAuthenticator{authenticator},
+			),
+		},
+		options...,
+	)
+
+	s := &Server{
+		Server:   bahamut.New(opts...),
+		Recorder: recorder,
+		listener: listener,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: listener.DialContext,
+			},
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		s.Server.Run(ctx)
+		close(s.done)
+	}()
+
+	return s
+}
+
+// Client returns a *http.Client that sends
+// the requests to the server, whatever their host.
+func (s *Server) Client() *http.Client {
+	return s.client
+}
+
+// DialContext returns a new connection to the server.
+// It can be used to build custom clients.
+func (s *Server) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return s.listener.DialContext(ctx, network, address)
+}
+
+// Request returns a new *RequestBuilder to send the
+// given operation on the given identity to the server.
+func (s *Server) Request(operation elemental.Operation, identity elemental.Identity) *RequestBuilder {
+
+	return &RequestBuilder{
+		server:     s,
+		ctx:        context.Background(),
+		operation:  operation,
+		identity:   identity,
+		parameters: url.Values{},
+		headers:    http.Header{},
+		encoding:   elemental.EncodingTypeJSON,
+	}
+}
+
+// Close stops the server and waits for it to be stopped.
+func (s *Server) Close() {
+
+	s.cancel()
+	<-s.done
+
+	s.client.CloseIdleConnections()
+
+	s.listener.Close()      // nolint: errcheck
+	s.Recorder.Disconnect() // nolint: errcheck
+}
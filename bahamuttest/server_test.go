@@ -0,0 +1,208 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamuttest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+type testProcessor struct {
+	claims map[string]string
+	lock   sync.Mutex
+}
+
+func (p *testProcessor) ProcessCreate(ctx bahamut.Context) error {
+
+	p.lock.Lock()
+	p.claims = ctx.ClaimsMap()
+	p.lock.Unlock()
+
+	list := ctx.InputData().(*testmodel.List)
+	list.ID = "xyz"
+
+	ctx.SetOutputData(list)
+	ctx.EnqueueEvents(elemental.NewEvent(elemental.EventCreate, list))
+
+	return nil
+}
+
+func (p *testProcessor) ProcessRetrieve(ctx bahamut.Context) error {
+
+	return elemental.NewError("Not Found", "no such list", "test", http.StatusNotFound)
+}
+
+func TestServer(t *testing.T) {
+
+	Convey("Given I have a server with a processor", t, func() {
+
+		s := NewServer(
+			bahamut.OptModel(map[int]elemental.ModelManager{0: testmodel.Manager()}),
+		)
+		defer s.Close()
+
+		p := &testProcessor{}
+		So(s.RegisterProcessor(p, testmodel.ListIdentity), ShouldBeNil)
+
+		Convey("When I create a list with claims", func() {
+
+			resp, err := s.Request(elemental.OperationCreate, testmodel.ListIdentity).
+				WithClaims("@auth:realm=test", "@auth:user=bob").
+				WithData(&testmodel.List{Name: "l1"}).
+				Do()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the response should be correct", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				out := testmodel.NewList()
+				So(resp.Decode(out), ShouldBeNil)
+				So(out.ID, ShouldEqual, "xyz")
+				So(out.Name, ShouldEqual, "l1")
+			})
+
+			Convey("Then the processor should have received the claims", func() {
+				p.lock.Lock()
+				defer p.lock.Unlock()
+				So(p.claims, ShouldResemble, map[string]string{
+					"@auth:realm": "test",
+					"@auth:user":  "bob",
+				})
+			})
+
+			Convey("Then the event should have been recorded", func() {
+				events := s.Recorder.Events()
+				So(len(events), ShouldEqual, 1)
+				So(events[0].Type, ShouldEqual, elemental.EventCreate)
+				So(events[0].Identity, ShouldEqual, "list")
+			})
+
+			Convey("Then the audit should have been recorded", func() {
+				audits := s.Recorder.Audits()
+				So(len(audits), ShouldEqual, 1)
+				So(audits[0].Error, ShouldBeNil)
+				So(audits[0].Context.Request().Operation, ShouldEqual, elemental.OperationCreate)
+			})
+
+			Convey("When I reset the recorder", func() {
+
+				s.Recorder.Reset()
+
+				Convey("Then nothing should be recorded", func() {
+					So(len(s.Recorder.Publications()), ShouldEqual, 0)
+					So(len(s.Recorder.Audits()), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When I create an invalid list", func() {
+
+			resp, err := s.Request(elemental.OperationCreate, testmodel.ListIdentity).
+				WithData(&testmodel.List{}).
+				Do()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the response should be a validation error", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+
+				errs, err := resp.Errors()
+				So(err, ShouldBeNil)
+				So(len(errs), ShouldEqual, 1)
+			})
+
+			Convey("Then no event should have been recorded", func() {
+				So(len(s.Recorder.Events()), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I retrieve a list using msgpack", func() {
+
+			resp, err := s.Request(elemental.OperationRetrieve, testmodel.ListIdentity).
+				WithID("xyz").
+				WithEncoding(elemental.EncodingTypeMSGPACK).
+				Do()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the response should be the processor error", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+				errs, err := resp.Errors()
+				So(err, ShouldBeNil)
+				So(len(errs), ShouldEqual, 1)
+				So(errs[0].Description, ShouldEqual, "no such list")
+			})
+		})
+
+		Convey("When I delete a list", func() {
+
+			resp, err := s.Request(elemental.OperationDelete, testmodel.ListIdentity).
+				WithID("xyz").
+				WithContext(context.Background()).
+				Do()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the response should be not implemented", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNotImplemented)
+			})
+		})
+	})
+}
+
+func TestRequestBuilder_path(t *testing.T) {
+
+	Convey("Given I have a server", t, func() {
+
+		s := &Server{}
+
+		Convey("When I build the path of a simple request", func() {
+
+			p := s.Request(elemental.OperationRetrieveMany, testmodel.ListIdentity).path()
+
+			Convey("Then the path should be correct", func() {
+				So(p, ShouldEqual, "/lists")
+			})
+		})
+
+		Convey("When I build the path of a full request", func() {
+
+			p := s.Request(elemental.OperationRetrieve, testmodel.TaskIdentity).
+				WithPathPrefix("/api").
+				WithVersion(1).
+				WithParent(testmodel.ListIdentity, "xyz").
+				WithID("abc").
+				path()
+
+			Convey("Then the path should be correct", func() {
+				So(p, ShouldEqual, "/api/v/1/lists/xyz/tasks/abc")
+			})
+		})
+	})
+}
@@ -0,0 +1,432 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"text/template"
+)
+
+type templateData struct {
+	Package     string
+	ModelImport string
+	ModelAlias  string
+	Models      []model
+}
+
+var templateFuncs = template.FuncMap{
+	// listType returns the type to use for a list of the given model.
+	"listType": func(alias string, m model) string {
+		if m.ListTypeName != "" {
+			return alias + "." + m.ListTypeName
+		}
+		return "[]*" + alias + "." + m.TypeName
+	},
+}
+
+// generate renders the given template with the given
+// data and returns the formatted Go source.
+func generate(tmpl *template.Template, data templateData) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("unable to execute template '%s': %s", tmpl.Name(), err)
+	}
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("unable to format generated code for '%s': %s", tmpl.Name(), err)
+	}
+
+	return out, nil
+}
+
+var processorsTemplate = template.Must(template.New("processors").Funcs(templateFuncs).Parse(
+	`// Code generated by bahamut-gen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	{{ .ModelAlias }} "{{ .ModelImport }}"
+)
+
+func makeInvalidInputDataError(data interface{}, expected string) error {
+	return elemental.NewError(
+		"Bad Request",
+		fmt.Sprintf("Invalid input data: expected %s, got %T", expected, data),
+		"bahamut",
+		http.StatusBadRequest,
+	)
+}
+
+func makeNotImplementedError(request *elemental.Request) error {
+	return elemental.NewError(
+		"Not implemented",
+		fmt.Sprintf("No handler for operation %s on %s", request.Operation, request.Identity.Name),
+		"bahamut",
+		http.StatusNotImplemented,
+	)
+}
+{{ $alias := .ModelAlias }}
+{{- range .Models }}
+{{- $t := .TypeName }}
+{{- $list := listType $alias . }}
+
+// {{ $t }}RetrieveManyProcessor is the interface a processor must implement
+// in order to be able to manage OperationRetrieveMany on {{ $alias }}.{{ $t }}.
+type {{ $t }}RetrieveManyProcessor interface {
+	Process{{ $t }}RetrieveMany(bahamut.Context) ({{ $list }}, error)
+}
+
+// {{ $t }}RetrieveProcessor is the interface a processor must implement
+// in order to be able to manage OperationRetrieve on {{ $alias }}.{{ $t }}.
+type {{ $t }}RetrieveProcessor interface {
+	Process{{ $t }}Retrieve(bahamut.Context) (*{{ $alias }}.{{ $t }}, error)
+}
+
+// {{ $t }}CreateProcessor is the interface a processor must implement
+// in order to be able to manage OperationCreate on {{ $alias }}.{{ $t }}.
+type {{ $t }}CreateProcessor interface {
+	Process{{ $t }}Create(bahamut.Context, *{{ $alias }}.{{ $t }}) (*{{ $alias }}.{{ $t }}, error)
+}
+
+// {{ $t }}UpdateProcessor is the interface a processor must implement
+// in order to be able to manage OperationUpdate on {{ $alias }}.{{ $t }}.
+type {{ $t }}UpdateProcessor interface {
+	Process{{ $t }}Update(bahamut.Context, *{{ $alias }}.{{ $t }}) (*{{ $alias }}.{{ $t }}, error)
+}
+
+// {{ $t }}DeleteProcessor is the interface a processor must implement
+// in order to be able to manage OperationDelete on {{ $alias }}.{{ $t }}.
+type {{ $t }}DeleteProcessor interface {
+	Process{{ $t }}Delete(bahamut.Context) (*{{ $alias }}.{{ $t }}, error)
+}
+{{- if .SparseTypeName }}
+
+// {{ $t }}PatchProcessor is the interface a processor must implement
+// in order to be able to manage OperationPatch on {{ $alias }}.{{ $t }}.
+type {{ $t }}PatchProcessor interface {
+	Process{{ $t }}Patch(bahamut.Context, *{{ $alias }}.{{ .SparseTypeName }}) (*{{ $alias }}.{{ .SparseTypeName }}, error)
+}
+{{- end }}
+
+// {{ $t }}InfoProcessor is the interface a processor must implement
+// in order to be able to manage OperationInfo on {{ $alias }}.{{ $t }}.
+type {{ $t }}InfoProcessor interface {
+	Process{{ $t }}Info(bahamut.Context) error
+}
+
+// A {{ $t }}ProcessorAdapter adapts a processor implementing
+// some of the typed {{ $t }} processor interfaces so it can be
+// registered with bahamut.Server.RegisterProcessor.
+type {{ $t }}ProcessorAdapter struct {
+	processor interface{}
+}
+
+// New{{ $t }}ProcessorAdapter returns a new *{{ $t }}ProcessorAdapter
+// for the given processor.
+func New{{ $t }}ProcessorAdapter(processor interface{}) *{{ $t }}ProcessorAdapter {
+	return &{{ $t }}ProcessorAdapter{
+		processor: processor,
+	}
+}
+
+// Register{{ $t }}Processor registers the given typed processor
+// for {{ $alias }}.{{ .IdentityVar }}. It returns an error if the processor
+// does not implement any of the typed {{ $t }} processor interfaces.
+func Register{{ $t }}Processor(server bahamut.Server, processor interface{}) error {
+
+	a := New{{ $t }}ProcessorAdapter(processor)
+
+	var implemented bool
+	for _, op := range []elemental.Operation{
+		elemental.OperationRetrieveMany,
+		elemental.OperationRetrieve,
+		elemental.OperationCreate,
+		elemental.OperationUpdate,
+		elemental.OperationDelete,
+		elemental.OperationPatch,
+		elemental.OperationInfo,
+	} {
+		if a.ImplementsOperation(op) {
+			implemented = true
+			break
+		}
+	}
+
+	if !implemented {
+		return fmt.Errorf("processor %T does not implement any {{ $t }} processor interface", processor)
+	}
+
+	return server.RegisterProcessor(a, {{ $alias }}.{{ .IdentityVar }})
+}
+
+// ImplementsOperation implements the bahamut.ProcessorAdapter interface.
+func (a *{{ $t }}ProcessorAdapter) ImplementsOperation(operation elemental.Operation) (ok bool) {
+
+	switch operation {
+	case elemental.OperationRetrieveMany:
+		_, ok = a.processor.({{ $t }}RetrieveManyProcessor)
+	case elemental.OperationRetrieve:
+		_, ok = a.processor.({{ $t }}RetrieveProcessor)
+	case elemental.OperationCreate:
+		_, ok = a.processor.({{ $t }}CreateProcessor)
+	case elemental.OperationUpdate:
+		_, ok = a.processor.({{ $t }}UpdateProcessor)
+	case elemental.OperationDelete:
+		_, ok = a.processor.({{ $t }}DeleteProcessor)
+	{{- if .SparseTypeName }}
+	case elemental.OperationPatch:
+		_, ok = a.processor.({{ $t }}PatchProcessor)
+	{{- end }}
+	case elemental.OperationInfo:
+		_, ok = a.processor.({{ $t }}InfoProcessor)
+	}
+
+	return ok
+}
+
+// ProcessRetrieveMany implements the bahamut.RetrieveManyProcessor interface.
+func (a *{{ $t }}ProcessorAdapter) ProcessRetrieveMany(ctx bahamut.Context) error {
+
+	p, ok := a.processor.({{ $t }}RetrieveManyProcessor)
+	if !ok {
+		return makeNotImplementedError(ctx.Request())
+	}
+
+	out, err := p.Process{{ $t }}RetrieveMany(ctx)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		ctx.SetOutputData(out)
+	}
+
+	return nil
+}
+
+// ProcessRetrieve implements the bahamut.RetrieveProcessor interface.
+func (a *{{ $t }}ProcessorAdapter) ProcessRetrieve(ctx bahamut.Context) error {
+
+	p, ok := a.processor.({{ $t }}RetrieveProcessor)
+	if !ok {
+		return makeNotImplementedError(ctx.Request())
+	}
+
+	out, err := p.Process{{ $t }}Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		ctx.SetOutputData(out)
+	}
+
+	return nil
+}
+
+// ProcessCreate implements the bahamut.CreateProcessor interface.
+func (a *{{ $t }}ProcessorAdapter) ProcessCreate(ctx bahamut.Context) error {
+
+	p, ok := a.processor.({{ $t }}CreateProcessor)
+	if !ok {
+		return makeNotImplementedError(ctx.Request())
+	}
+
+	in, ok := ctx.InputData().(*{{ $alias }}.{{ $t }})
+	if !ok {
+		return makeInvalidInputDataError(ctx.InputData(), "*{{ $alias }}.{{ $t }}")
+	}
+
+	out, err := p.Process{{ $t }}Create(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		ctx.SetOutputData(out)
+	}
+
+	return nil
+}
+
+// ProcessUpdate implements the bahamut.UpdateProcessor interface.
+func (a *{{ $t }}ProcessorAdapter) ProcessUpdate(ctx bahamut.Context) error {
+
+	p, ok := a.processor.({{ $t }}UpdateProcessor)
+	if !ok {
+		return makeNotImplementedError(ctx.Request())
+	}
+
+	in, ok := ctx.InputData().(*{{ $alias }}.{{ $t }})
+	if !ok {
+		return makeInvalidInputDataError(ctx.InputData(), "*{{ $alias }}.{{ $t }}")
+	}
+
+	out, err := p.Process{{ $t }}Update(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		ctx.SetOutputData(out)
+	}
+
+	return nil
+}
+
+// ProcessDelete implements the bahamut.DeleteProcessor interface.
+func (a *{{ $t }}ProcessorAdapter) ProcessDelete(ctx bahamut.Context) error {
+
+	p, ok := a.processor.({{ $t }}DeleteProcessor)
+	if !ok {
+		return makeNotImplementedError(ctx.Request())
+	}
+
+	out, err := p.Process{{ $t }}Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		ctx.SetOutputData(out)
+	}
+
+	return nil
+}
+
+// ProcessPatch implements the bahamut.PatchProcessor interface.
+func (a *{{ $t }}ProcessorAdapter) ProcessPatch(ctx bahamut.Context) error {
+{{- if .SparseTypeName }}
+
+	p, ok := a.processor.({{ $t }}PatchProcessor)
+	if !ok {
+		return makeNotImplementedError(ctx.Request())
+	}
+
+	in, ok := ctx.InputData().(*{{ $alias }}.{{ .SparseTypeName }})
+	if !ok {
+		return makeInvalidInputDataError(ctx.InputData(), "*{{ $alias }}.{{ .SparseTypeName }}")
+	}
+
+	out, err := p.Process{{ $t }}Patch(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		ctx.SetOutputData(out)
+	}
+
+	return nil
+{{- else }}
+
+	return makeNotImplementedError(ctx.Request())
+{{- end }}
+}
+
+// ProcessInfo implements the bahamut.InfoProcessor interface.
+func (a *{{ $t }}ProcessorAdapter) ProcessInfo(ctx bahamut.Context) error {
+
+	p, ok := a.processor.({{ $t }}InfoProcessor)
+	if !ok {
+		return makeNotImplementedError(ctx.Request())
+	}
+
+	return p.Process{{ $t }}Info(ctx)
+}
+{{- end }}
+`))
+
+var testingTemplate = template.Must(template.New("testing").Funcs(templateFuncs).Parse(
+	`// Code generated by bahamut-gen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"context"
+
+	"go.aporeto.io/bahamut"
+	"go.aporeto.io/elemental"
+	{{ .ModelAlias }} "{{ .ModelImport }}"
+)
+
+func newTestContext(ctx context.Context, identity elemental.Identity, operation elemental.Operation, input interface{}) bahamut.Context {
+
+	req := elemental.NewRequest()
+	req.Identity = identity
+	req.Operation = operation
+
+	bctx := bahamut.NewContext(ctx, req)
+	if input != nil {
+		bctx.SetInputData(input)
+	}
+
+	return bctx
+}
+{{ $alias := .ModelAlias }}
+{{- range .Models }}
+{{- $t := .TypeName }}
+{{- $list := listType $alias . }}
+
+// New{{ $t }}Context returns a bahamut.Context for the given operation
+// on {{ $alias }}.{{ $t }} with no input data. It can be used to test
+// the typed {{ $t }} processors.
+func New{{ $t }}Context(ctx context.Context, operation elemental.Operation) bahamut.Context {
+	return newTestContext(ctx, {{ $alias }}.{{ .IdentityVar }}, operation, nil)
+}
+
+// New{{ $t }}CreateContext returns a bahamut.Context for
+// OperationCreate on the given {{ $alias }}.{{ $t }}.
+func New{{ $t }}CreateContext(ctx context.Context, input *{{ $alias }}.{{ $t }}) bahamut.Context {
+	return newTestContext(ctx, {{ $alias }}.{{ .IdentityVar }}, elemental.OperationCreate, input)
+}
+
+// New{{ $t }}UpdateContext returns a bahamut.Context for
+// OperationUpdate on the given {{ $alias }}.{{ $t }}.
+func New{{ $t }}UpdateContext(ctx context.Context, input *{{ $alias }}.{{ $t }}) bahamut.Context {
+	return newTestContext(ctx, {{ $alias }}.{{ .IdentityVar }}, elemental.OperationUpdate, input)
+}
+{{- if .SparseTypeName }}
+
+// New{{ $t }}PatchContext returns a bahamut.Context for
+// OperationPatch on the given {{ $alias }}.{{ .SparseTypeName }}.
+func New{{ $t }}PatchContext(ctx context.Context, input *{{ $alias }}.{{ .SparseTypeName }}) bahamut.Context {
+	return newTestContext(ctx, {{ $alias }}.{{ .IdentityVar }}, elemental.OperationPatch, input)
+}
+{{- end }}
+
+// {{ $t }}OutputData returns the output data of the given
+// bahamut.Context as a *{{ $alias }}.{{ $t }}. It returns nil
+// if the output data is not a *{{ $alias }}.{{ $t }}.
+func {{ $t }}OutputData(ctx bahamut.Context) *{{ $alias }}.{{ $t }} {
+	out, _ := ctx.OutputData().(*{{ $alias }}.{{ $t }})
+	return out
+}
+
+// {{ $t }}ListOutputData returns the output data of the given
+// bahamut.Context as a {{ $list }}. It returns nil
+// if the output data is not a {{ $list }}.
+func {{ $t }}ListOutputData(ctx bahamut.Context) {{ $list }} {
+	out, _ := ctx.OutputData().({{ $list }})
+	return out
+}
+{{- end }}
+`))
@@ -0,0 +1,230 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testModelSource = `package testmodel
+
+import "go.aporeto.io/elemental"
+
+var RootIdentity = elemental.Identity{
+	Name:     "root",
+	Category: "root",
+}
+
+var ListIdentity = elemental.Identity{
+	Name:     "list",
+	Category: "lists",
+}
+
+type ListsList []*List
+
+type List struct {
+	ID string
+}
+
+type SparseList struct {
+	ID *string
+}
+
+var TaskIdentity = elemental.Identity{
+	Name:     "task",
+	Category: "tasks",
+}
+
+type Task struct {
+	ID string
+}
+
+var NotAnIdentity = "hello"
+`
+
+func writeTestModel(dir string) {
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "models.go"), []byte(testModelSource), 0644); err != nil {
+		panic(err)
+	}
+}
+
+func TestParser_parseModels(t *testing.T) {
+
+	Convey("Given I have a model package", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut-gen")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		writeTestModel(dir)
+
+		Convey("When I parse all models", func() {
+
+			pkg, models, err := parseModels(dir, nil)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then pkg should be correct", func() {
+				So(pkg, ShouldEqual, "testmodel")
+			})
+
+			Convey("Then models should be correct", func() {
+				So(models, ShouldResemble, []model{
+					{
+						TypeName:       "List",
+						SparseTypeName: "SparseList",
+						ListTypeName:   "ListsList",
+						IdentityVar:    "ListIdentity",
+						Name:           "list",
+						Category:       "lists",
+					},
+					{
+						TypeName:    "Task",
+						IdentityVar: "TaskIdentity",
+						Name:        "task",
+						Category:    "tasks",
+					},
+				})
+			})
+		})
+
+		Convey("When I parse filtered models", func() {
+
+			_, models, err := parseModels(dir, []string{"task"})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then models should be correct", func() {
+				So(len(models), ShouldEqual, 1)
+				So(models[0].TypeName, ShouldEqual, "Task")
+			})
+		})
+	})
+
+	Convey("Given I have a directory with no go package", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut-gen")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		Convey("When I parse the models", func() {
+
+			_, _, err := parseModels(dir, nil)
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "expected exactly one package in '"+dir+"', found 0")
+			})
+		})
+	})
+}
+
+func TestGenerator_run(t *testing.T) {
+
+	Convey("Given I have a model package", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut-gen")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		modelDir := filepath.Join(dir, "models")
+		outputDir := filepath.Join(dir, "processors")
+
+		if err = os.Mkdir(modelDir, 0755); err != nil {
+			panic(err)
+		}
+
+		writeTestModel(modelDir)
+
+		Convey("When I run the generator", func() {
+
+			err := run(modelDir, "example.com/testmodel", "processors", outputDir, "")
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the generated files should be valid", func() {
+
+				fset := token.NewFileSet()
+
+				f, err := parser.ParseFile(fset, filepath.Join(outputDir, "processors.go"), nil, 0)
+				So(err, ShouldBeNil)
+				So(f.Name.Name, ShouldEqual, "processors")
+
+				decls := map[string]struct{}{}
+				for name := range f.Scope.Objects {
+					decls[name] = struct{}{}
+				}
+
+				So(decls, ShouldContainKey, "ListCreateProcessor")
+				So(decls, ShouldContainKey, "ListPatchProcessor")
+				So(decls, ShouldContainKey, "ListProcessorAdapter")
+				So(decls, ShouldContainKey, "RegisterListProcessor")
+				So(decls, ShouldContainKey, "TaskRetrieveManyProcessor")
+				So(decls, ShouldNotContainKey, "TaskPatchProcessor")
+				So(decls, ShouldNotContainKey, "RootProcessorAdapter")
+
+				f, err = parser.ParseFile(fset, filepath.Join(outputDir, "processors_testing.go"), nil, 0)
+				So(err, ShouldBeNil)
+
+				decls = map[string]struct{}{}
+				for name := range f.Scope.Objects {
+					decls[name] = struct{}{}
+				}
+
+				So(decls, ShouldContainKey, "NewListPatchContext")
+				So(decls, ShouldContainKey, "ListListOutputData")
+				So(decls, ShouldContainKey, "TaskOutputData")
+				So(decls, ShouldNotContainKey, "NewTaskPatchContext")
+			})
+		})
+
+		Convey("When I run the generator with a missing identity", func() {
+
+			err := run(modelDir, "example.com/testmodel", "processors", outputDir, "nope")
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "no model found in '"+modelDir+"'")
+			})
+		})
+
+		Convey("When I run the generator with no model import", func() {
+
+			err := run(modelDir, "", "processors", outputDir, "")
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "-model-import is required")
+			})
+		})
+	})
+}
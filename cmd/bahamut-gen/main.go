@@ -0,0 +1,104 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command bahamut-gen generates typed processor interfaces and adapters
+// for an elemental model package.
+//
+// For each model, it generates one interface per operation (for instance
+// ListCreateProcessor with ProcessListCreate(bahamut.Context, *models.List) (*models.List, error)),
+// an adapter implementing the generic bahamut processor interfaces and
+// bahamut.ProcessorAdapter, a RegisterXProcessor function, and typed
+// helpers to build contexts and read output data in tests.
+//
+// Usage:
+//
+//	bahamut-gen -model-dir ./models -model-import example.com/api/models -package processors -output ./processors
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+func main() {
+
+	modelDir := flag.String("model-dir", "", "Path to the directory containing the elemental model package.")
+	modelImport := flag.String("model-import", "", "Import path of the elemental model package.")
+	pkg := flag.String("package", "processors", "Name of the package of the generated files.")
+	output := flag.String("output", ".", "Directory where to write the generated files.")
+	identities := flag.String("identities", "", "Comma separated list of identity names to generate. All identities if empty.")
+	flag.Parse()
+
+	if err := run(*modelDir, *modelImport, *pkg, *output, *identities); err != nil {
+		fmt.Fprintf(os.Stderr, "bahamut-gen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(modelDir string, modelImport string, pkg string, output string, identities string) error {
+
+	if modelDir == "" {
+		return fmt.Errorf("-model-dir is required")
+	}
+
+	if modelImport == "" {
+		return fmt.Errorf("-model-import is required")
+	}
+
+	var names []string
+	for _, n := range strings.Split(identities, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+
+	alias, models, err := parseModels(modelDir, names)
+	if err != nil {
+		return err
+	}
+
+	if len(models) == 0 {
+		return fmt.Errorf("no model found in '%s'", modelDir)
+	}
+
+	data := templateData{
+		Package:     pkg,
+		ModelImport: modelImport,
+		ModelAlias:  alias,
+		Models:      models,
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return fmt.Errorf("unable to create output directory: %s", err)
+	}
+
+	for file, tmpl := range map[string]*template.Template{
+		"processors.go":         processorsTemplate,
+		"processors_testing.go": testingTemplate,
+	} {
+
+		out, err := generate(tmpl, data)
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(output, file), out, 0644); err != nil {
+			return fmt.Errorf("unable to write '%s': %s", file, err)
+		}
+	}
+
+	return nil
+}
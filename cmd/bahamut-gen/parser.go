@@ -0,0 +1,218 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A model describes an elemental model found in the model package.
+type model struct {
+	// TypeName is the name of the Go struct of the model (ie List).
+	TypeName string
+
+	// SparseTypeName is the name of the sparse version
+	// of the model (ie SparseList). It is empty if there is none.
+	SparseTypeName string
+
+	// ListTypeName is the name of the slice type of
+	// the model (ie ListsList). It is empty if there is none.
+	ListTypeName string
+
+	// IdentityVar is the name of the variable holding
+	// the elemental.Identity of the model (ie ListIdentity).
+	IdentityVar string
+
+	// Name is the name of the identity.
+	Name string
+
+	// Category is the category of the identity.
+	Category string
+}
+
+// parseModels parses the Go package in the given directory and
+// returns the elemental models it contains, sorted by type name.
+// If names is not empty, only the models whose identity names are
+// listed will be returned.
+func parseModels(dir string, names []string) (string, []model, error) {
+
+	fset := token.NewFileSet()
+
+	pkgs, err := parser.ParseDir(
+		fset,
+		dir,
+		func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") },
+		0,
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to parse model package: %s", err)
+	}
+
+	if len(pkgs) != 1 {
+		return "", nil, fmt.Errorf("expected exactly one package in '%s', found %d", dir, len(pkgs))
+	}
+
+	var pkgName string
+	var pkg *ast.Package
+	for name, p := range pkgs {
+		pkgName, pkg = name, p
+	}
+
+	structs := map[string]struct{}{}
+	lists := map[string]string{}
+	var identities []model
+
+	for _, file := range pkg.Files {
+
+		for _, decl := range file.Decls {
+
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+
+			for _, spec := range gd.Specs {
+
+				switch s := spec.(type) {
+
+				case *ast.TypeSpec:
+
+					switch t := s.Type.(type) {
+					case *ast.StructType:
+						structs[s.Name.Name] = struct{}{}
+					case *ast.ArrayType:
+						if star, ok := t.Elt.(*ast.StarExpr); ok && t.Len == nil {
+							if ident, ok := star.X.(*ast.Ident); ok {
+								lists[ident.Name] = s.Name.Name
+							}
+						}
+					}
+
+				case *ast.ValueSpec:
+
+					if m, ok := identityFromValueSpec(s); ok {
+						identities = append(identities, m)
+					}
+				}
+			}
+		}
+	}
+
+	filter := map[string]struct{}{}
+	for _, n := range names {
+		filter[n] = struct{}{}
+	}
+
+	var models []model
+
+	for _, m := range identities {
+
+		if _, ok := structs[m.TypeName]; !ok {
+			continue
+		}
+
+		if len(filter) > 0 {
+			if _, ok := filter[m.Name]; !ok {
+				continue
+			}
+		}
+
+		if _, ok := structs["Sparse"+m.TypeName]; ok {
+			m.SparseTypeName = "Sparse" + m.TypeName
+		}
+
+		m.ListTypeName = lists[m.TypeName]
+
+		models = append(models, m)
+	}
+
+	sort.Slice(models, func(i int, j int) bool { return models[i].TypeName < models[j].TypeName })
+
+	return pkgName, models, nil
+}
+
+// identityFromValueSpec returns the model described by the given value
+// spec if it declares an elemental.Identity variable named <Type>Identity.
+func identityFromValueSpec(s *ast.ValueSpec) (model, bool) {
+
+	if len(s.Names) != 1 || len(s.Values) != 1 {
+		return model{}, false
+	}
+
+	varName := s.Names[0].Name
+	if !strings.HasSuffix(varName, "Identity") || varName == "Identity" {
+		return model{}, false
+	}
+
+	lit, ok := s.Values[0].(*ast.CompositeLit)
+	if !ok {
+		return model{}, false
+	}
+
+	sel, ok := lit.Type.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Identity" {
+		return model{}, false
+	}
+
+	if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "elemental" {
+		return model{}, false
+	}
+
+	m := model{
+		IdentityVar: varName,
+		TypeName:    strings.TrimSuffix(varName, "Identity"),
+	}
+
+	for _, elt := range lit.Elts {
+
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		value, ok := kv.Value.(*ast.BasicLit)
+		if !ok || value.Kind != token.STRING {
+			continue
+		}
+
+		v, err := strconv.Unquote(value.Value)
+		if err != nil {
+			continue
+		}
+
+		switch key.Name {
+		case "Name":
+			m.Name = v
+		case "Category":
+			m.Category = v
+		}
+	}
+
+	// The root identity has no processor.
+	if m.Name == "" || m.Category == "" || m.Name == "root" {
+		return model{}, false
+	}
+
+	return m, true
+}
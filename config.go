@@ -63,21 +63,41 @@ type config struct {
 		enabled               bool
 		customRootHandlerFunc http.HandlerFunc
 		customListener        net.Listener
+		listeners             []listenerConfig
 		maxConnection         int
 		httpLogger            *log.Logger
 		customRoutePrefix     string
 		apiPrefix             string
+		h2cEnabled            bool
+		http3ListenAddress    string
 	}
 
 	pushServer struct {
-		service         PubSubClient
-		topic           string
-		endpoint        string
-		dispatchHandler PushDispatchHandler
-		publishHandler  PushPublishHandler
-		enabled         bool
-		publishEnabled  bool
-		dispatchEnabled bool
+		service              PubSubClient
+		topic                string
+		endpoint             string
+		dispatchHandler      PushDispatchHandler
+		publishHandler       PushPublishHandler
+		enabled              bool
+		publishEnabled       bool
+		dispatchEnabled      bool
+		dispatchWorkers      int
+		dispatchQueueSize    int
+		reauthEnabled        bool
+		reauthInterval       time.Duration
+		messageHandler       PushMessageHandler
+		apiEnabled           bool
+		compressionEnabled   bool
+		compressionLevel     int
+		compressionThreshold int
+		allowedOrigins       []string
+		maxSessions          int
+		maxSessionsPerClaim  int
+		sessionsClaimKey     string
+		handshakeLimiter     *rate.Limiter
+		batchingEnabled      bool
+		batchMaxWindow       time.Duration
+		batchMaxSize         int
 	}
 
 	healthServer struct {
@@ -89,6 +109,9 @@ type config struct {
 		enabled        bool
 		customStats    map[string]HealthStatFunc
 		metricsManager MetricsManager
+
+		pushSessionsEnabled    bool
+		pushSessionsAuthorizer func(*http.Request) bool
 	}
 
 	profilingServer struct {
@@ -103,6 +126,8 @@ type config struct {
 		serverCertificatesRetrieverFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 		disableSessionTicket            bool
 		nextProtos                      []string
+		reloader                        *tlsReloader
+		policy                          *TLSPolicy
 	}
 
 	security struct {
@@ -118,6 +143,31 @@ type config struct {
 		apiRateLimiters map[elemental.Identity]apiRateLimit
 	}
 
+	admissionControl *admissionControlConfig
+
+	responseCaching struct {
+		ttls       map[elemental.Identity]time.Duration
+		scopeFunc  ResponseCacheScopeFunc
+		maxEntries int
+	}
+
+	// responseCache is built by NewServer from responseCaching.
+	responseCache *responseCache
+
+	requestTimeouts struct {
+		defaultTimeout   time.Duration
+		identityTimeouts map[elemental.Identity]map[elemental.Operation]time.Duration
+		clientEnabled    bool
+		clientMaxTimeout time.Duration
+	}
+
+	limits struct {
+		maxRequestBodySize         int64
+		identityMaxRequestBodySize map[elemental.Identity]int64
+		maxRetrieveManyItems       int
+		maxPushMessageSize         int64
+	}
+
 	model struct {
 		modelManagers              map[int]elemental.ModelManager
 		readOnly                   bool
@@ -144,4 +194,12 @@ type config struct {
 		postStart func(Server) error
 		preStop   func(Server) error
 	}
+
+	shutdown struct {
+		restTimeout     time.Duration
+		pushTimeout     time.Duration
+		healthTimeout   time.Duration
+		pushGracePeriod time.Duration
+		retryAfter      time.Duration
+	}
 }
@@ -15,6 +15,7 @@ import (
 	"context"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"go.aporeto.io/elemental"
@@ -40,6 +41,9 @@ type bcontext struct {
 	responseWriter        ResponseWriter
 	statusCode            int
 	disableOutputDataPush bool
+	startTime             time.Time
+	cacheGeneration       uint64
+	cacheEntry            *responseCacheEntry
 }
 
 // NewContext creates a new *Context.
@@ -61,6 +65,7 @@ func newContext(ctx context.Context, request *elemental.Request) *bcontext {
 		id:           uuid.Must(uuid.NewV4()).String(),
 		messagesLock: &sync.Mutex{},
 		request:      request,
+		startTime:    time.Now(),
 	}
 }
 
@@ -215,6 +220,7 @@ func (c *bcontext) Duplicate() Context {
 	c2.outputCookies = append(c2.outputCookies, c.outputCookies...)
 	c2.responseWriter = c.responseWriter
 	c2.disableOutputDataPush = c.disableOutputDataPush
+	c2.startTime = c.startTime
 
 	for k, v := range c.claimsMap {
 		c2.claimsMap[k] = v
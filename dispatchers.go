@@ -36,6 +36,17 @@ func notImplementedErr(request *elemental.Request) error {
 	)
 }
 
+// implementsOperation returns false if the given processor
+// is a ProcessorAdapter that does not support the operation.
+func implementsOperation(proc Processor, operation elemental.Operation) bool {
+
+	if a, ok := proc.(ProcessorAdapter); ok {
+		return a.ImplementsOperation(operation)
+	}
+
+	return true
+}
+
 func dispatchRetrieveManyOperation(
 	ctx *bcontext,
 	processorFinder processorFinderFunc,
@@ -43,6 +54,7 @@ func dispatchRetrieveManyOperation(
 	authorizers []Authorizer,
 	pusher eventPusherFunc,
 	auditer Auditer,
+	cache *responseCache,
 ) (err error) {
 
 	if err = CheckAuthentication(authenticators, ctx); err != nil {
@@ -55,9 +67,16 @@ func dispatchRetrieveManyOperation(
 		return err
 	}
 
+	if entry := cache.get(ctx); entry != nil {
+		ctx.cacheEntry = entry
+		ctx.responseWriter = entry.writer(ctx.request)
+		audit(auditer, ctx, nil)
+		return nil
+	}
+
 	proc, _ := processorFinder(ctx.request.Identity)
 
-	if _, ok := proc.(RetrieveManyProcessor); !ok {
+	if _, ok := proc.(RetrieveManyProcessor); !ok || !implementsOperation(proc, elemental.OperationRetrieveMany) {
 		err = notImplementedErr(ctx.request)
 		audit(auditer, ctx, err)
 		return err
@@ -84,6 +103,7 @@ func dispatchRetrieveOperation(
 	authorizers []Authorizer,
 	pusher eventPusherFunc,
 	auditer Auditer,
+	cache *responseCache,
 ) (err error) {
 
 	if err = CheckAuthentication(authenticators, ctx); err != nil {
@@ -96,9 +116,16 @@ func dispatchRetrieveOperation(
 		return err
 	}
 
+	if entry := cache.get(ctx); entry != nil {
+		ctx.cacheEntry = entry
+		ctx.responseWriter = entry.writer(ctx.request)
+		audit(auditer, ctx, nil)
+		return nil
+	}
+
 	proc, _ := processorFinder(ctx.request.Identity)
 
-	if _, ok := proc.(RetrieveProcessor); !ok {
+	if _, ok := proc.(RetrieveProcessor); !ok || !implementsOperation(proc, elemental.OperationRetrieve) {
 		err = notImplementedErr(ctx.request)
 		audit(auditer, ctx, err)
 		return err
@@ -149,7 +176,7 @@ func dispatchCreateOperation(
 
 	proc, _ := processorFinder(ctx.request.Identity)
 
-	if _, ok := proc.(CreateProcessor); !ok {
+	if _, ok := proc.(CreateProcessor); !ok || !implementsOperation(proc, elemental.OperationCreate) {
 		err = notImplementedErr(ctx.request)
 		audit(auditer, ctx, err)
 		return err
@@ -229,7 +256,7 @@ func dispatchUpdateOperation(
 
 	proc, _ := processorFinder(ctx.request.Identity)
 
-	if _, ok := proc.(UpdateProcessor); !ok {
+	if _, ok := proc.(UpdateProcessor); !ok || !implementsOperation(proc, elemental.OperationUpdate) {
 		err = notImplementedErr(ctx.request)
 		audit(auditer, ctx, err)
 		return err
@@ -307,7 +334,7 @@ func dispatchDeleteOperation(
 
 	proc, _ := processorFinder(ctx.request.Identity)
 
-	if _, ok := proc.(DeleteProcessor); !ok {
+	if _, ok := proc.(DeleteProcessor); !ok || !implementsOperation(proc, elemental.OperationDelete) {
 		err = notImplementedErr(ctx.request)
 		audit(auditer, ctx, err)
 		return err
@@ -364,13 +391,13 @@ func dispatchPatchOperation(
 	proc, _ := processorFinder(ctx.request.Identity)
 
 	if identifiableRetriever != nil {
-		if _, ok := proc.(UpdateProcessor); !ok {
+		if _, ok := proc.(UpdateProcessor); !ok || !implementsOperation(proc, elemental.OperationUpdate) {
 			err = notImplementedErr(ctx.request)
 			audit(auditer, ctx, err)
 			return err
 		}
 	} else {
-		if _, ok := proc.(PatchProcessor); !ok {
+		if _, ok := proc.(PatchProcessor); !ok || !implementsOperation(proc, elemental.OperationPatch) {
 			err = notImplementedErr(ctx.request)
 			audit(auditer, ctx, err)
 			return err
@@ -391,6 +418,11 @@ func dispatchPatchOperation(
 		}
 	}
 
+	if err = validateSparse(modelManager, ctx.request.Identity, sparse); err != nil {
+		audit(auditer, ctx, err)
+		return err
+	}
+
 	if identifiableRetriever != nil {
 		identifiable, err := identifiableRetriever(ctx.Request())
 		if err != nil {
@@ -400,11 +432,20 @@ func dispatchPatchOperation(
 
 		patchable, ok := identifiable.(elemental.Patchable)
 		if !ok {
+			err = elemental.NewError("Bad Request", "Idenfiable is not patchable", "bahamut", http.StatusBadRequest)
 			audit(auditer, ctx, err)
-			return elemental.NewError("Bad Request", "Idenfiable is not patchable", "bahamut", http.StatusBadRequest)
+			return err
 		}
 
 		patchable.Patch(sparse.(elemental.SparseIdentifiable))
+
+		if v, ok := patchable.(elemental.Validatable); ok {
+			if err = v.Validate(); err != nil {
+				audit(auditer, ctx, err)
+				return err
+			}
+		}
+
 		ctx.inputData = patchable
 
 		if err = proc.(UpdateProcessor).ProcessUpdate(ctx); err != nil {
@@ -453,7 +494,7 @@ func dispatchInfoOperation(
 
 	proc, _ := processorFinder(ctx.request.Identity)
 
-	if _, ok := proc.(InfoProcessor); !ok {
+	if _, ok := proc.(InfoProcessor); !ok || !implementsOperation(proc, elemental.OperationInfo) {
 		err = notImplementedErr(ctx.request)
 		audit(auditer, ctx, err)
 		return err
@@ -16,7 +16,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
@@ -40,7 +42,7 @@ func TestDispatchers_dispatchRetrieveManyOperation(t *testing.T) {
 		pusher := &mockPusher{}
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, pusher.Push, auditer)
+		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, pusher.Push, auditer, nil)
 
 		expectedNbCalls := 1
 
@@ -65,7 +67,7 @@ func TestDispatchers_dispatchRetrieveManyOperation(t *testing.T) {
 		auditer := &mockAuditer{}
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, nil, auditer)
+		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, nil, auditer, nil)
 
 		expectedError := "error 400 (bahamut-test): Error: Bad request."
 		expectedNbCalls := 1
@@ -91,7 +93,7 @@ func TestDispatchers_dispatchRetrieveManyOperation(t *testing.T) {
 		expectedNbCalls := 1
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, nil, auditer)
+		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, nil, auditer, nil)
 
 		Convey("Then I should get a bahamut error and no context", func() {
 			So(err.Error(), ShouldEqual, expectedError)
@@ -99,6 +101,46 @@ func TestDispatchers_dispatchRetrieveManyOperation(t *testing.T) {
 		})
 	})
 
+	Convey("Given I have a processor adapter that does not implement OperationRetrieveMany", t, func() {
+		request := elemental.NewRequest()
+		request.Operation = elemental.OperationRetrieveMany
+		request.Identity = elemental.MakeIdentity("Fake", "Test")
+
+		processorFinder := func(identity elemental.Identity) (Processor, error) {
+			return &mockAdapterProcessor{operations: []elemental.Operation{elemental.OperationCreate}}, nil
+		}
+
+		auditer := &mockAuditer{}
+
+		expectedError := "error 501 (bahamut): Not implemented: No handler for operation retrieve-many on Fake"
+		expectedNbCalls := 1
+
+		ctx := newContext(context.Background(), request)
+		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, nil, auditer, nil)
+
+		Convey("Then I should get a bahamut error and no context", func() {
+			So(err.Error(), ShouldEqual, expectedError)
+			So(auditer.GetCallCount(), ShouldEqual, expectedNbCalls)
+		})
+	})
+
+	Convey("Given I have a processor adapter that implements OperationRetrieveMany", t, func() {
+		request := elemental.NewRequest()
+		request.Operation = elemental.OperationRetrieveMany
+		request.Identity = elemental.MakeIdentity("Fake", "Test")
+
+		processorFinder := func(identity elemental.Identity) (Processor, error) {
+			return &mockAdapterProcessor{operations: []elemental.Operation{elemental.OperationRetrieveMany}}, nil
+		}
+
+		ctx := newContext(context.Background(), request)
+		err := dispatchRetrieveManyOperation(ctx, processorFinder, nil, nil, nil, nil, nil)
+
+		Convey("Then I should get no error", func() {
+			So(err, ShouldBeNil)
+		})
+	})
+
 	Convey("Given I have a processor that handle ProcessRetrieveMany function and an authenticator that is not authenticated", t, func() {
 		request := elemental.NewRequest()
 
@@ -122,7 +164,7 @@ func TestDispatchers_dispatchRetrieveManyOperation(t *testing.T) {
 		expectedNbCalls := 1
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveManyOperation(ctx, processorFinder, authenticators, nil, nil, auditer)
+		err := dispatchRetrieveManyOperation(ctx, processorFinder, authenticators, nil, nil, auditer, nil)
 
 		Convey("Then I should get a bahamut error and no context", func() {
 			So(err.Error(), ShouldEqual, expectedError)
@@ -162,7 +204,7 @@ func TestDispatchers_dispatchRetrieveManyOperation(t *testing.T) {
 		expectedNbCalls := 1
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveManyOperation(ctx, processorFinder, authenticators, authorizers, nil, auditer)
+		err := dispatchRetrieveManyOperation(ctx, processorFinder, authenticators, authorizers, nil, auditer, nil)
 
 		Convey("Then I should get a bahamut error and no context", func() {
 			So(err.Error(), ShouldEqual, expectedError)
@@ -188,7 +230,7 @@ func TestDispatchers_dispatchRetrieveOperation(t *testing.T) {
 		pusher := &mockPusher{}
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveOperation(ctx, processorFinder, nil, nil, pusher.Push, auditer)
+		err := dispatchRetrieveOperation(ctx, processorFinder, nil, nil, pusher.Push, auditer, nil)
 
 		expectedNbCalls := 1
 
@@ -201,6 +243,43 @@ func TestDispatchers_dispatchRetrieveOperation(t *testing.T) {
 		})
 	})
 
+	Convey("Given I have a cached response", t, func() {
+		request := elemental.NewRequest()
+		request.Identity = testmodel.ListIdentity
+		request.Operation = elemental.OperationRetrieve
+
+		var called bool
+		processorFinder := func(identity elemental.Identity) (Processor, error) {
+			called = true
+			return &mockProcessor{}, nil
+		}
+
+		cache := newResponseCache()
+		cache.ttls[testmodel.ListIdentity] = time.Minute
+
+		response := elemental.NewResponse(request)
+		response.StatusCode = http.StatusOK
+		response.Data = []byte(`{"name":"cached"}`)
+
+		auditer := &mockAuditer{}
+
+		ctx := newContext(context.Background(), request)
+		cache.set(ctx, response)
+
+		err := dispatchRetrieveOperation(ctx, processorFinder, nil, nil, nil, auditer, cache)
+
+		Convey("Then the processor should not be called and the cached response should be written", func() {
+			So(err, ShouldBeNil)
+			So(called, ShouldBeFalse)
+			So(auditer.GetCallCount(), ShouldEqual, 1)
+			So(ctx.responseWriter, ShouldNotBeNil)
+
+			w := httptest.NewRecorder()
+			So(ctx.responseWriter(w), ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, `{"name":"cached"}`)
+		})
+	})
+
 	Convey("Given I have a processor that handle ProcessRetrieve function with error", t, func() {
 		request := elemental.NewRequest()
 
@@ -213,7 +292,7 @@ func TestDispatchers_dispatchRetrieveOperation(t *testing.T) {
 		auditer := &mockAuditer{}
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveOperation(ctx, processorFinder, nil, nil, nil, auditer)
+		err := dispatchRetrieveOperation(ctx, processorFinder, nil, nil, nil, auditer, nil)
 
 		expectedError := "error 400 (bahamut-test): Error: Bad request."
 		expectedNbCalls := 1
@@ -239,7 +318,7 @@ func TestDispatchers_dispatchRetrieveOperation(t *testing.T) {
 		expectedNbCalls := 1
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveOperation(ctx, processorFinder, nil, nil, nil, auditer)
+		err := dispatchRetrieveOperation(ctx, processorFinder, nil, nil, nil, auditer, nil)
 
 		Convey("Then I should get a bahamut error and no context", func() {
 			So(err.Error(), ShouldEqual, expectedError)
@@ -270,7 +349,7 @@ func TestDispatchers_dispatchRetrieveOperation(t *testing.T) {
 		expectedNbCalls := 1
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveOperation(ctx, processorFinder, authenticators, nil, nil, auditer)
+		err := dispatchRetrieveOperation(ctx, processorFinder, authenticators, nil, nil, auditer, nil)
 
 		Convey("Then I should get a bahamut error and no context", func() {
 			So(err.Error(), ShouldEqual, expectedError)
@@ -310,7 +389,7 @@ func TestDispatchers_dispatchRetrieveOperation(t *testing.T) {
 		expectedNbCalls := 1
 
 		ctx := newContext(context.Background(), request)
-		err := dispatchRetrieveOperation(ctx, processorFinder, authenticators, authorizers, nil, auditer)
+		err := dispatchRetrieveOperation(ctx, processorFinder, authenticators, authorizers, nil, auditer, nil)
 
 		Convey("Then I should get a bahamut error and no context", func() {
 			So(err.Error(), ShouldEqual, expectedError)
@@ -1639,6 +1718,54 @@ func TestDispatchers_dispatchPatchOperation(t *testing.T) {
 		})
 	})
 
+	Convey("Given I have a processor that handle ProcessPatch function and a patch with an invalid attribute", t, func() {
+		request := elemental.NewRequest()
+		request.Identity = testmodel.ListIdentity
+		request.Data = []byte(`{"ID": "1234", "name": ""}`)
+
+		processorFinder := func(identity elemental.Identity) (Processor, error) {
+			return &mockProcessor{}, nil
+		}
+
+		auditer := &mockAuditer{}
+
+		ctx := newContext(context.Background(), request)
+		err := dispatchPatchOperation(ctx, processorFinder, testmodel.Manager(), nil, nil, nil, nil, auditer, false, nil, nil)
+
+		Convey("Then I should get a validation error and the processor should not be called", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(elemental.Errors).Code(), ShouldEqual, http.StatusUnprocessableEntity)
+			So(ctx.inputData, ShouldBeNil)
+			So(auditer.GetCallCount(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given I have a processor that handle ProcessPatch function and uses an elementalRetriever returning an object invalid after patch", t, func() {
+		request := elemental.NewRequest()
+		request.Identity = testmodel.ListIdentity
+		request.Data = []byte(`{"description": "hello"}`)
+
+		processorFinder := func(identity elemental.Identity) (Processor, error) {
+			return &mockProcessor{}, nil
+		}
+
+		retriever := func(req *elemental.Request) (elemental.Identifiable, error) {
+			return &testmodel.List{ID: "a"}, nil
+		}
+
+		auditer := &mockAuditer{}
+
+		ctx := newContext(context.Background(), request)
+		err := dispatchPatchOperation(ctx, processorFinder, testmodel.Manager(), nil, nil, nil, nil, auditer, false, nil, retriever)
+
+		Convey("Then I should get a validation error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(elemental.Errors).Code(), ShouldEqual, http.StatusUnprocessableEntity)
+			So(ctx.inputData, ShouldBeNil)
+			So(auditer.GetCallCount(), ShouldEqual, 1)
+		})
+	})
+
 	Convey("Given I have a processor that handle ProcessPatch function and uses an elementalRetriever that fails", t, func() {
 		request := elemental.NewRequest()
 		request.Identity = testmodel.ListIdentity
@@ -60,6 +60,21 @@ func New(listenAddr string, upstreamer Upstreamer, options ...Option) (Gateway,
 		o(cfg)
 	}
 
+	if cfg.tlsPolicy != nil {
+
+		if cfg.serverTLSConfig != nil {
+			cfg.serverTLSConfig = cfg.serverTLSConfig.Clone()
+			cfg.tlsPolicy.Apply(cfg.serverTLSConfig)
+		}
+
+		if cfg.upstreamTLSConfig != nil {
+			cfg.upstreamTLSConfig = cfg.upstreamTLSConfig.Clone()
+		} else {
+			cfg.upstreamTLSConfig = &tls.Config{}
+		}
+		cfg.tlsPolicy.Apply(cfg.upstreamTLSConfig)
+	}
+
 	var listener net.Listener
 
 	rootListener, err := (&tcplisten.Config{
@@ -89,6 +89,7 @@ type gwconfig struct {
 	upstreamTLSHandshakeTimeout time.Duration
 	upstreamTLSConfig           *tls.Config
 	serverTLSConfig             *tls.Config
+	tlsPolicy                   *bahamut.TLSPolicy
 	corsOrigin                  string
 }
 
@@ -274,6 +275,17 @@ func OptionUpstreamTLSConfig(tlsConfig *tls.Config) Option {
 	}
 }
 
+// OptionTLSPolicy sets the TLS policy to use for both the
+// front end server and the upstream servers. It overrides the
+// versions, cipher suites, curves and ALPN protocols set in
+// the tls.Config given to OptionServerTLSConfig and
+// OptionUpstreamTLSConfig.
+func OptionTLSPolicy(policy bahamut.TLSPolicy) Option {
+	return func(cfg *gwconfig) {
+		cfg.tlsPolicy = &policy
+	}
+}
+
 // OptionAllowedCORSOrigin sets allowed CORS origin.
 // If set to empty, or "*", the gateway will mirror
 // whatever is set in the upcoming request Origin header.
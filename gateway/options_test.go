@@ -132,6 +132,12 @@ func Test_Options(t *testing.T) {
 		So(c.serverTLSConfig, ShouldEqual, tlscfg)
 	})
 
+	Convey("Calling OptionTLSPolicy should work", t, func() {
+		policy, _ := bahamut.NewTLSPolicy(bahamut.TLSProfileModern)
+		OptionTLSPolicy(policy)(c)
+		So(*c.tlsPolicy, ShouldResemble, policy)
+	})
+
 	Convey("Calling OptionAllowedCORSOrigin should work", t, func() {
 		OptionAllowedCORSOrigin("dog")(c)
 		So(c.corsOrigin, ShouldEqual, "dog")
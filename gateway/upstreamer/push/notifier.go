@@ -40,11 +40,20 @@ func (w *Notifier) MakeStartHook(ctx context.Context, frequency time.Duration) f
 			return err
 		}
 
+		routesRev := func() uint64 { return 0 }
+		if r, ok := server.(bahamut.RoutesRevisioner); ok {
+			routesRev = r.RoutesRevision
+		}
+
+		rev := routesRev()
+		routes := server.RoutesInfo()
+
 		sp := ping{
 			Name:         w.serviceName,
 			Status:       serviceStatusHello,
 			Endpoint:     w.endpoint,
-			Routes:       server.RoutesInfo(),
+			Routes:       routes,
+			RoutesHash:   routesHash(routes),
 			Versions:     server.VersionsInfo(),
 			PushEndpoint: server.PushEndpoint(),
 		}
@@ -80,6 +89,14 @@ func (w *Notifier) MakeStartHook(ctx context.Context, frequency time.Duration) f
 
 					sp.Load = pct / cores
 
+					// Processors can be registered at any time
+					// so we send the latest routes when they change.
+					if r := routesRev(); r != rev {
+						rev = r
+						sp.Routes = server.RoutesInfo()
+						sp.RoutesHash = routesHash(sp.Routes)
+					}
+
 					if err := pub.Encode(sp); err != nil {
 						zap.L().Error("Unable to encode service ping", zap.Error(err))
 						continue
@@ -18,6 +18,7 @@ type ping struct {
 	PushEndpoint string
 	Status       serviceStatus
 	Routes       map[int][]bahamut.RouteInfo
+	RoutesHash   string
 	Versions     map[string]interface{}
 	Load         float64
 }
@@ -18,10 +18,11 @@ type endpointInfo struct {
 type servicesConfig map[string]*service
 
 type service struct {
-	name      string
-	routes    map[int][]bahamut.RouteInfo
-	versions  map[string]interface{}
-	endpoints map[string]*endpointInfo
+	name       string
+	routes     map[int][]bahamut.RouteInfo
+	routesHash string
+	versions   map[string]interface{}
+	endpoints  map[string]*endpointInfo
 }
 
 // newService returns a new proxy info from the given string.
@@ -1,8 +1,14 @@
 package push
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
 	"regexp"
 	"strings"
+
+	"go.aporeto.io/bahamut"
 )
 
 var vregexp = regexp.MustCompile(`^/v/\d+`)
@@ -44,6 +50,19 @@ func pick(randomizer Randomizer, len int) (int, int) {
 	return idxs[0], idxs[1]
 }
 
+// routesHash returns a hash of the content of the given routes.
+func routesHash(routes map[int][]bahamut.RouteInfo) string {
+
+	data, err := json.Marshal(routes)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
 func handleAddServicePing(services servicesConfig, sp ping) bool {
 
 	if sp.Status == serviceStatusGoodbye {
@@ -62,11 +81,28 @@ func handleAddServicePing(services servicesConfig, sp ping) bool {
 	defer srv.pokeEndpoint(sp.Endpoint, sp.Load)
 
 	if srv.hasEndpoint(sp.Endpoint) {
-		return false
+
+		// We only update the info, so the apis are resynced,
+		// if the service reports new versions or new routes.
+		// As all the endpoints of a service share the same info,
+		// we compare the hash of their routes, which does not
+		// depend on the instance that sent them.
+		if sp.RoutesHash == srv.routesHash && reflect.DeepEqual(srv.versions, sp.Versions) {
+			return false
+		}
+
+		if sp.Routes != nil {
+			srv.routes = sp.Routes
+		}
+		srv.routesHash = sp.RoutesHash
+		srv.versions = sp.Versions
+
+		return true
 	}
 
 	// We update the info to the latest.
 	srv.routes = sp.Routes
+	srv.routesHash = sp.RoutesHash
 	srv.versions = sp.Versions
 
 	// We register the new endpoint.
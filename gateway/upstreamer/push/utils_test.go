@@ -323,6 +323,80 @@ func TestHandleServicePings(t *testing.T) {
 		})
 	})
 
+	Convey("When I send a hello ping with new routes from an known service/endpoint", t, func() {
+
+		routes1 := map[int][]bahamut.RouteInfo{1: {{Identity: "a", URL: "/a", Verbs: []string{"GET"}}}}
+		routes2 := map[int][]bahamut.RouteInfo{1: {{Identity: "a", URL: "/a", Verbs: []string{"GET"}}, {Identity: "b", URL: "/b", Verbs: []string{"GET"}}}}
+		versions := map[string]interface{}{"version": "1.0.0"}
+
+		scfg := servicesConfig{
+			"srv1": &service{
+				name:       "srv1",
+				routes:     routes1,
+				routesHash: routesHash(routes1),
+				versions:   versions,
+				endpoints: map[string]*endpointInfo{
+					"1.1.1.1:1": {
+						address:  "1.1.1.1:1",
+						lastSeen: time.Now(),
+						lastLoad: 0.1,
+					},
+				},
+			},
+		}
+
+		Convey("Then the routes should have been updated if their hash changed", func() {
+
+			handled := handleAddServicePing(scfg, ping{
+				Name:       "srv1",
+				Endpoint:   "1.1.1.1:1",
+				Status:     serviceStatusHello,
+				Routes:     routes2,
+				RoutesHash: routesHash(routes2),
+				Versions:   versions,
+			})
+
+			So(handled, ShouldBeTrue)
+			So(scfg["srv1"].routes, ShouldResemble, routes2)
+			So(scfg["srv1"].routesHash, ShouldEqual, routesHash(routes2))
+		})
+
+		Convey("Then the versions and routes should have been updated if the versions changed", func() {
+
+			versions2 := map[string]interface{}{"version": "1.1.0"}
+
+			handled := handleAddServicePing(scfg, ping{
+				Name:       "srv1",
+				Endpoint:   "1.1.1.1:1",
+				Status:     serviceStatusHello,
+				Routes:     routes2,
+				RoutesHash: routesHash(routes1),
+				Versions:   versions2,
+			})
+
+			So(handled, ShouldBeTrue)
+			So(scfg["srv1"].routes, ShouldResemble, routes2)
+			So(scfg["srv1"].versions, ShouldResemble, versions2)
+		})
+
+		Convey("Then the same routes and versions sent by another endpoint should not be handled", func() {
+
+			scfg["srv1"].registerEndpoint("2.2.2.2:2", 0.1)
+
+			handled := handleAddServicePing(scfg, ping{
+				Name:       "srv1",
+				Endpoint:   "2.2.2.2:2",
+				Status:     serviceStatusHello,
+				Routes:     routes1,
+				RoutesHash: routesHash(routes1),
+				Versions:   map[string]interface{}{"version": "1.0.0"},
+			})
+
+			So(handled, ShouldBeFalse)
+			So(scfg["srv1"].routes, ShouldResemble, routes1)
+		})
+	})
+
 	Convey("When I send a goodbye ping from an instance of an unknown service", t, func() {
 
 		now := time.Now()
@@ -691,3 +765,19 @@ func TestPick(t *testing.T) {
 		})
 	})
 }
+
+func Test_routesHash(t *testing.T) {
+
+	Convey("Given I have some routes", t, func() {
+
+		routes1 := map[int][]bahamut.RouteInfo{1: {{Identity: "a", URL: "/a", Verbs: []string{"GET"}}}}
+		routes2 := map[int][]bahamut.RouteInfo{1: {{Identity: "a", URL: "/a", Verbs: []string{"GET"}}}}
+		routes3 := map[int][]bahamut.RouteInfo{1: {{Identity: "a", URL: "/a", Verbs: []string{"GET", "POST"}}}}
+
+		Convey("Then the hash should only depend on their content", func() {
+			So(routesHash(routes1), ShouldNotBeEmpty)
+			So(routesHash(routes1), ShouldEqual, routesHash(routes2))
+			So(routesHash(routes1), ShouldNotEqual, routesHash(routes3))
+		})
+	})
+}
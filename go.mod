@@ -1,6 +1,6 @@
 module go.aporeto.io/bahamut
 
-go 1.13
+go 1.24
 
 require (
 	go.aporeto.io/elemental v1.100.1-0.20200617155434-2d1f67120246
@@ -24,13 +24,19 @@ require (
 	github.com/opentracing/opentracing-go v1.1.0
 	github.com/prometheus/client_golang v1.5.0
 	github.com/prometheus/procfs v0.0.10 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	github.com/quic-go/quic-go v0.59.1
 	github.com/shirou/gopsutil v2.20.2+incompatible
 	github.com/sirupsen/logrus v1.4.2
 	github.com/smartystreets/goconvey v1.6.4
 	github.com/valyala/tcplisten v0.0.0-20161114210144-ceec8f93295a
 	github.com/vulcand/oxy v1.0.0
 	go.uber.org/zap v1.14.0
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/net v0.43.0
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 	launchpad.net/gocheck v0.0.0-20140225173054-000000000087 // indirect
 )
@@ -15,7 +15,10 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
 	"runtime/debug"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/log"
@@ -146,13 +149,173 @@ func runDispatcher(ctx *bcontext, r *elemental.Response, d func() error, disable
 		}
 	}()
 
-	if err := d(); err != nil {
+	err := d()
+
+	// If the deadline of the request is exceeded, the processor
+	// took too long, even if it did not return an error. If the client
+	// went away, the context would be canceled instead and
+	// makeErrorResponse would ignore it.
+	if ctx.ctx.Err() == context.DeadlineExceeded {
+		err = ErrRequestTimeout
+	}
+
+	if err != nil {
 		return makeErrorResponse(ctx.ctx, r, err, marshallers)
 	}
 
 	return makeResponse(ctx, r, marshallers)
 }
 
+// checkMaxRetrieveManyItems returns an error if the output data of the
+// given context contains more items than allowed by the configuration.
+func checkMaxRetrieveManyItems(ctx *bcontext, cfg config) error {
+
+	max := cfg.limits.maxRetrieveManyItems
+	if max == 0 || ctx.outputData == nil {
+		return nil
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(ctx.outputData))
+	if v.Kind() != reflect.Slice || v.Len() <= max {
+		return nil
+	}
+
+	registerLimitViolation(cfg.healthServer.metricsManager, LimitRetrieveManyItems)
+
+	return elemental.NewError(
+		"Bad Request",
+		fmt.Sprintf("The response contains %d items, which is more than the maximum of %d. Use pagination to retrieve them", v.Len(), max),
+		"bahamut",
+		http.StatusBadRequest,
+	)
+}
+
+// cacheResponse stores the given response in the response cache if
+// it can be cached, and makes the context write it with the
+// appropriate Cache-Control header.
+func cacheResponse(ctx *bcontext, cfg config, response *elemental.Response) {
+
+	// The response comes from the cache or is written by the processor.
+	if ctx.responseWriter != nil {
+		return
+	}
+
+	if entry := cfg.responseCache.set(ctx, response); entry != nil {
+		ctx.responseWriter = entry.writer(ctx.request)
+	}
+}
+
+// admitRequest runs the checks shared by all the transports before
+// the given request is handled: the size of its body, the rate
+// limiting, the validation of its parameters given as query and the
+// admission control. If the request is admitted, it returns the
+// context to handle it with, bound to its deadline, and a function
+// to call once it is handled. Otherwise, it returns the error to
+// send to the client.
+func admitRequest(ctx context.Context, cfg config, admission *admissionController, request *elemental.Request, query url.Values) (context.Context, func(), error) {
+
+	if limit := requestBodyLimit(cfg, request.Identity); limit > 0 && int64(len(request.Data)) > limit {
+		registerLimitViolation(cfg.healthServer.metricsManager, LimitRequestBodySize)
+		return ctx, nil, ErrTooLarge
+	}
+
+	// Global rate limiting
+	if cfg.rateLimiting.rateLimiter != nil && !cfg.rateLimiting.rateLimiter.Allow() {
+		return ctx, nil, ErrRateLimit
+	}
+
+	// Per api rate limiting
+	if rlm, ok := cfg.rateLimiting.apiRateLimiters[request.Identity]; ok {
+		if (rlm.condition == nil || rlm.condition(request)) && !rlm.limiter.Allow() {
+			return ctx, nil, ErrRateLimit
+		}
+	}
+
+	// Query parameters validation
+	if err := validateParameters(relationshipInfo(cfg.model.modelManagers[request.Version], request), query, request.Parameters); err != nil {
+		return ctx, nil, err
+	}
+
+	timeout, err := requestTimeout(cfg, request)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	// Admission control
+	release := func() {}
+	if admission != nil {
+		var ok bool
+		if release, ok = admission.acquire(admission.priority(request)); !ok {
+			return ctx, nil, ErrOverloaded
+		}
+	}
+
+	if timeout <= 0 {
+		return ctx, release, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() { cancel(); release() }, nil
+}
+
+// requestBodyLimit returns the maximum size of the body
+// of the requests targeting the given identity.
+func requestBodyLimit(cfg config, identity elemental.Identity) int64 {
+
+	if limit, ok := cfg.limits.identityMaxRequestBodySize[identity]; ok {
+		return limit
+	}
+
+	return cfg.limits.maxRequestBodySize
+}
+
+// requestTimeout returns the maximum duration of the processing of
+// the given request. It is the timeout configured for the identity and
+// operation of the request, or the default one, eventually shortened
+// by the timeout requested by the client. 0 means no timeout.
+func requestTimeout(cfg config, request *elemental.Request) (time.Duration, error) {
+
+	timeout := cfg.requestTimeouts.defaultTimeout
+
+	if ops, ok := cfg.requestTimeouts.identityTimeouts[request.Identity]; ok {
+		if t, ok := ops[request.Operation]; ok {
+			timeout = t
+		} else if t, ok := ops[""]; ok {
+			timeout = t
+		}
+	}
+
+	if !cfg.requestTimeouts.clientEnabled {
+		return timeout, nil
+	}
+
+	v := request.Headers.Get(RequestTimeoutHeader)
+	if v == "" {
+		return timeout, nil
+	}
+
+	clientTimeout, err := time.ParseDuration(v)
+	if err != nil || clientTimeout <= 0 {
+		return 0, elemental.NewError(
+			"Bad Request",
+			fmt.Sprintf("Invalid %s header '%s': it must be a positive duration like 10s", RequestTimeoutHeader, v),
+			"bahamut",
+			http.StatusBadRequest,
+		)
+	}
+
+	if max := cfg.requestTimeouts.clientMaxTimeout; max > 0 && clientTimeout > max {
+		clientTimeout = max
+	}
+
+	if timeout == 0 || clientTimeout < timeout {
+		return clientTimeout, nil
+	}
+
+	return timeout, nil
+}
+
 func handleRetrieveMany(ctx *bcontext, cfg config, processorFinder processorFinderFunc, pusherFunc eventPusherFunc) (response *elemental.Response) {
 
 	response = elemental.NewResponse(ctx.request)
@@ -176,22 +339,30 @@ func handleRetrieveMany(ctx *bcontext, cfg config, processorFinder processorFind
 		)
 	}
 
-	return runDispatcher(
+	response = runDispatcher(
 		ctx,
 		response,
 		func() error {
-			return dispatchRetrieveManyOperation(
+			if err := dispatchRetrieveManyOperation(
 				ctx,
 				processorFinder,
 				cfg.security.requestAuthenticators,
 				cfg.security.authorizers,
 				pusherFunc,
 				cfg.security.auditer,
-			)
+				cfg.responseCache,
+			); err != nil {
+				return err
+			}
+			return checkMaxRetrieveManyItems(ctx, cfg)
 		},
 		cfg.general.panicRecoveryDisabled,
 		cfg.model.marshallers,
 	)
+
+	cacheResponse(ctx, cfg, response)
+
+	return response
 }
 
 func handleRetrieve(ctx *bcontext, cfg config, processorFinder processorFinderFunc, pusherFunc eventPusherFunc) (response *elemental.Response) {
@@ -216,7 +387,7 @@ func handleRetrieve(ctx *bcontext, cfg config, processorFinder processorFinderFu
 		)
 	}
 
-	return runDispatcher(
+	response = runDispatcher(
 		ctx,
 		response,
 		func() error {
@@ -227,11 +398,16 @@ func handleRetrieve(ctx *bcontext, cfg config, processorFinder processorFinderFu
 				cfg.security.authorizers,
 				pusherFunc,
 				cfg.security.auditer,
+				cfg.responseCache,
 			)
 		},
 		cfg.general.panicRecoveryDisabled,
 		cfg.model.marshallers,
 	)
+
+	cacheResponse(ctx, cfg, response)
+
+	return response
 }
 
 func handleCreate(ctx *bcontext, cfg config, processorFinder processorFinderFunc, pusherFunc eventPusherFunc) (response *elemental.Response) {
@@ -22,6 +22,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
 	testmodel "go.aporeto.io/elemental/test/model"
+	"golang.org/x/time/rate"
 )
 
 func TestHandlers_makeResponse(t *testing.T) {
@@ -495,6 +496,29 @@ func TestHandlers_runDispatcher(t *testing.T) {
 		})
 	})
 
+	Convey("When I call runDispatcher and it returns after the deadline", t, func() {
+
+		gctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		ctx := newContext(context.Background(), elemental.NewRequest())
+		ctx.request = elemental.NewRequest()
+		ctx.ctx = gctx
+
+		response := elemental.NewResponse(elemental.NewRequest())
+
+		d := func() error {
+			<-gctx.Done()
+			return nil
+		}
+
+		r := runDispatcher(ctx, response, d, true, nil)
+
+		Convey("Then the code should be 504", func() {
+			So(r.StatusCode, ShouldEqual, http.StatusGatewayTimeout)
+		})
+	})
+
 	Convey("When I call runDispatcher and it panics with recovery", t, func() {
 
 		calledCounter := &counter{}
@@ -576,6 +600,212 @@ func TestHandlers_runDispatcher(t *testing.T) {
 	})
 }
 
+func TestHandlers_admitRequest(t *testing.T) {
+
+	Convey("Given I have a config and a request", t, func() {
+
+		cfg := config{}
+		cfg.model.modelManagers = map[int]elemental.ModelManager{0: testmodel.Manager(), 1: testmodel.Manager()}
+
+		request := elemental.NewRequest()
+		request.Identity = testmodel.ListIdentity
+		request.Operation = elemental.OperationCreate
+		request.Headers = http.Header{}
+
+		Convey("When I admit a request with no limits", func() {
+
+			ctx, done, err := admitRequest(context.Background(), cfg, nil, request, nil)
+
+			Convey("Then it should be admitted with no deadline", func() {
+				So(err, ShouldBeNil)
+				So(done, ShouldNotBeNil)
+				_, ok := ctx.Deadline()
+				So(ok, ShouldBeFalse)
+				done()
+			})
+		})
+
+		Convey("When I admit a request with a timeout", func() {
+
+			cfg.requestTimeouts.defaultTimeout = time.Minute
+
+			ctx, done, err := admitRequest(context.Background(), cfg, nil, request, nil)
+
+			Convey("Then it should be admitted with a deadline that is released when done", func() {
+				So(err, ShouldBeNil)
+				_, ok := ctx.Deadline()
+				So(ok, ShouldBeTrue)
+				done()
+				So(ctx.Err(), ShouldEqual, context.Canceled)
+			})
+		})
+
+		Convey("When I admit a request whose body is too large", func() {
+
+			cfg.limits.identityMaxRequestBodySize = map[elemental.Identity]int64{testmodel.ListIdentity: 2}
+			request.Data = []byte(`{"name":"a"}`)
+
+			_, _, err := admitRequest(context.Background(), cfg, nil, request, nil)
+
+			Convey("Then it should be rejected", func() {
+				So(err, ShouldResemble, ErrTooLarge)
+			})
+		})
+
+		Convey("When I admit a request while the global rate limit is reached", func() {
+
+			cfg.rateLimiting.rateLimiter = rate.NewLimiter(rate.Limit(1), 1)
+			cfg.rateLimiting.rateLimiter.Allow()
+
+			_, _, err := admitRequest(context.Background(), cfg, nil, request, nil)
+
+			Convey("Then it should be rejected", func() {
+				So(err, ShouldResemble, ErrRateLimit)
+			})
+		})
+
+		Convey("When I admit a request while the rate limit of its api is reached", func() {
+
+			cfg.rateLimiting.apiRateLimiters = map[elemental.Identity]apiRateLimit{
+				testmodel.ListIdentity: {limiter: rate.NewLimiter(rate.Limit(1), 1)},
+			}
+			cfg.rateLimiting.apiRateLimiters[testmodel.ListIdentity].limiter.Allow()
+
+			_, _, err := admitRequest(context.Background(), cfg, nil, request, nil)
+
+			Convey("Then it should be rejected", func() {
+				So(err, ShouldResemble, ErrRateLimit)
+			})
+		})
+
+		Convey("When I admit a request while the server is overloaded", func() {
+
+			admission := newAdmissionController(newAdmissionControlConfig(time.Second), nil)
+			admission.inflight = 1000
+
+			_, _, err := admitRequest(context.Background(), cfg, admission, request, nil)
+
+			Convey("Then it should be rejected", func() {
+				So(err, ShouldResemble, ErrOverloaded)
+			})
+		})
+	})
+}
+
+func TestHandlers_requestTimeout(t *testing.T) {
+
+	Convey("Given I have a config with some timeouts", t, func() {
+
+		cfg := config{}
+		cfg.requestTimeouts.defaultTimeout = 10 * time.Second
+		cfg.requestTimeouts.identityTimeouts = map[elemental.Identity]map[elemental.Operation]time.Duration{
+			testmodel.ListIdentity: {
+				"":                              20 * time.Second,
+				elemental.OperationRetrieveMany: 30 * time.Second,
+			},
+		}
+
+		makeRequest := func(identity elemental.Identity, operation elemental.Operation, header string) *elemental.Request {
+			request := elemental.NewRequest()
+			request.Identity = identity
+			request.Operation = operation
+			request.Headers = http.Header{}
+			if header != "" {
+				request.Headers.Set(RequestTimeoutHeader, header)
+			}
+			return request
+		}
+
+		Convey("When I get the timeout of a request on an identity with no specific timeout", func() {
+
+			timeout, err := requestTimeout(cfg, makeRequest(testmodel.TaskIdentity, elemental.OperationCreate, ""))
+
+			Convey("Then it should be the default one", func() {
+				So(err, ShouldBeNil)
+				So(timeout, ShouldEqual, 10*time.Second)
+			})
+		})
+
+		Convey("When I get the timeout of a request on an identity with a timeout", func() {
+
+			timeout, err := requestTimeout(cfg, makeRequest(testmodel.ListIdentity, elemental.OperationCreate, ""))
+
+			Convey("Then it should be the one of the identity", func() {
+				So(err, ShouldBeNil)
+				So(timeout, ShouldEqual, 20*time.Second)
+			})
+		})
+
+		Convey("When I get the timeout of a request on an identity with an operation timeout", func() {
+
+			timeout, err := requestTimeout(cfg, makeRequest(testmodel.ListIdentity, elemental.OperationRetrieveMany, ""))
+
+			Convey("Then it should be the one of the operation", func() {
+				So(err, ShouldBeNil)
+				So(timeout, ShouldEqual, 30*time.Second)
+			})
+		})
+
+		Convey("When I get the timeout of a request with a header while client timeouts are disabled", func() {
+
+			timeout, err := requestTimeout(cfg, makeRequest(testmodel.TaskIdentity, elemental.OperationCreate, "1s"))
+
+			Convey("Then the header should be ignored", func() {
+				So(err, ShouldBeNil)
+				So(timeout, ShouldEqual, 10*time.Second)
+			})
+		})
+
+		Convey("When client timeouts are enabled", func() {
+
+			cfg.requestTimeouts.clientEnabled = true
+			cfg.requestTimeouts.clientMaxTimeout = 5 * time.Second
+
+			Convey("When I get the timeout of a request with a shorter header", func() {
+
+				timeout, err := requestTimeout(cfg, makeRequest(testmodel.TaskIdentity, elemental.OperationCreate, "1s"))
+
+				Convey("Then it should be the one of the client", func() {
+					So(err, ShouldBeNil)
+					So(timeout, ShouldEqual, time.Second)
+				})
+			})
+
+			Convey("When I get the timeout of a request with a header over the max", func() {
+
+				timeout, err := requestTimeout(cfg, makeRequest(testmodel.TaskIdentity, elemental.OperationCreate, "1m"))
+
+				Convey("Then it should be capped", func() {
+					So(err, ShouldBeNil)
+					So(timeout, ShouldEqual, 5*time.Second)
+				})
+			})
+
+			Convey("When I get the timeout of a request with a header longer than the server timeout", func() {
+
+				cfg.requestTimeouts.clientMaxTimeout = 0
+				timeout, err := requestTimeout(cfg, makeRequest(testmodel.TaskIdentity, elemental.OperationCreate, "1m"))
+
+				Convey("Then it should be the one of the server", func() {
+					So(err, ShouldBeNil)
+					So(timeout, ShouldEqual, 10*time.Second)
+				})
+			})
+
+			Convey("When I get the timeout of a request with an invalid header", func() {
+
+				timeout, err := requestTimeout(cfg, makeRequest(testmodel.TaskIdentity, elemental.OperationCreate, "nope"))
+
+				Convey("Then it should fail", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "error 400 (bahamut): Bad Request: Invalid X-Request-Timeout header 'nope': it must be a positive duration like 10s")
+					So(timeout, ShouldEqual, 0)
+				})
+			})
+		})
+	})
+}
+
 func TestHandlers_handleRetrieveMany(t *testing.T) {
 
 	Convey("Given I have a config", t, func() {
@@ -638,6 +868,64 @@ func TestHandlers_handleRetrieveMany(t *testing.T) {
 	})
 }
 
+func TestHandlers_checkMaxRetrieveManyItems(t *testing.T) {
+
+	Convey("Given I have a config with a max retrieve many items", t, func() {
+
+		metricsManager := &mockLimitsMetricsManager{}
+
+		cfg := config{}
+		cfg.limits.maxRetrieveManyItems = 2
+		cfg.healthServer.metricsManager = metricsManager
+
+		ctx := newContext(context.Background(), elemental.NewRequest())
+
+		Convey("When the output data contains less items than the limit", func() {
+
+			ctx.outputData = testmodel.ListsList{testmodel.NewList(), testmodel.NewList()}
+
+			Convey("Then err should be nil", func() {
+				So(checkMaxRetrieveManyItems(ctx, cfg), ShouldBeNil)
+			})
+		})
+
+		Convey("When the output data is not a list", func() {
+
+			ctx.outputData = testmodel.NewList()
+
+			Convey("Then err should be nil", func() {
+				So(checkMaxRetrieveManyItems(ctx, cfg), ShouldBeNil)
+			})
+		})
+
+		Convey("When the output data contains more items than the limit", func() {
+
+			ctx.outputData = testmodel.ListsList{testmodel.NewList(), testmodel.NewList(), testmodel.NewList()}
+
+			err := checkMaxRetrieveManyItems(ctx, cfg)
+
+			Convey("Then err should be correct", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "error 400 (bahamut): Bad Request: The response contains 3 items, which is more than the maximum of 2. Use pagination to retrieve them")
+			})
+
+			Convey("Then the violation should be registered", func() {
+				So(metricsManager.violations, ShouldResemble, []string{LimitRetrieveManyItems})
+			})
+		})
+
+		Convey("When there is no limit", func() {
+
+			cfg.limits.maxRetrieveManyItems = 0
+			ctx.outputData = testmodel.ListsList{testmodel.NewList(), testmodel.NewList(), testmodel.NewList()}
+
+			Convey("Then err should be nil", func() {
+				So(checkMaxRetrieveManyItems(ctx, cfg), ShouldBeNil)
+			})
+		})
+	})
+}
+
 func TestHandlers_handleRetrieve(t *testing.T) {
 
 	Convey("Given I have a config", t, func() {
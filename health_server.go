@@ -13,8 +13,10 @@ package bahamut
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -22,8 +24,10 @@ import (
 
 // an healthServer is the structure serving the health check endpoint.
 type healthServer struct {
-	cfg    config
-	server *http.Server
+	cfg        config
+	server     *http.Server
+	pushServer *pushServer
+	draining   int32
 }
 
 // newHealthServer returns a new healthServer.
@@ -41,6 +45,12 @@ func newHealthServer(cfg config) *healthServer {
 
 func (s *healthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	if s.cfg.healthServer.pushSessionsEnabled &&
+		(r.URL.Path == pushSessionsAdminPath || strings.HasPrefix(r.URL.Path, pushSessionsAdminPath+"/")) {
+		s.servePushSessions(w, r)
+		return
+	}
+
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
@@ -50,6 +60,11 @@ func (s *healthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	case "/":
 
+		if atomic.LoadInt32(&s.draining) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
 		if s.cfg.healthServer.healthHandler == nil {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -105,18 +120,28 @@ func (s *healthServer) start(ctx context.Context) {
 	<-ctx.Done()
 }
 
-func (s *healthServer) stop() context.Context {
+// drain makes the health check report the
+// server as unavailable.
+func (s *healthServer) drain() {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	atomic.StoreInt32(&s.draining, 1)
+}
 
-	go func() {
-		defer cancel()
-		if err := s.server.Shutdown(ctx); err != nil {
-			zap.L().Error("Could not gracefully stop health server", zap.Error(err))
-		} else {
-			zap.L().Debug("Health server stopped")
-		}
-	}()
+func (s *healthServer) stop(ctx context.Context) error {
+
+	timeout := s.cfg.shutdown.healthTimeout
+	if timeout == 0 {
+		timeout = 1 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("health server: %s", err)
+	}
+
+	zap.L().Debug("Health server stopped")
 
-	return ctx
+	return nil
 }
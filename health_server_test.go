@@ -72,6 +72,30 @@ func TestHealthServer(t *testing.T) {
 		})
 	})
 
+	Convey("Given I have a draining health server and I get /", t, func() {
+
+		port := freePort()
+		cfg := config{}
+		cfg.healthServer.listenAddress = fmt.Sprintf("127.0.0.1:%d", port)
+
+		hs := newHealthServer(cfg)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		go hs.start(ctx)
+		time.Sleep(1 * time.Second)
+
+		hs.drain()
+
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d", port))
+
+		Convey("Result should be correct", func() {
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+
 	Convey("Given I have a health server with no custom handlers and I get /metrics", t, func() {
 
 		port := freePort()
@@ -152,7 +176,7 @@ func TestHealthServer(t *testing.T) {
 		go hs.start(ctx)
 		time.Sleep(1 * time.Second)
 
-		<-hs.stop().Done()
+		So(hs.stop(context.Background()), ShouldBeNil)
 
 		resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/something", port), "", nil)
 
@@ -184,7 +208,7 @@ func TestHealthServerWithCustomHandler(t *testing.T) {
 		defer cancel()
 
 		go hs.start(ctx)
-		defer hs.stop()
+		defer hs.stop(context.Background()) // nolint: errcheck
 
 		time.Sleep(1 * time.Second)
 
@@ -51,6 +51,8 @@ const (
 type Server interface {
 
 	// RegisterProcessor registers a new Processor for a particular Identity.
+	// It is safe to call while the server is running, and the processor
+	// will be used for the next requests.
 	RegisterProcessor(Processor, elemental.Identity) error
 
 	// UnregisterProcessor unregisters a registered Processor for a particular identity.
@@ -64,18 +66,45 @@ type Server interface {
 
 	// RegisterCustomRouteHandler registers a generic HTTP handler for a given
 	// path. Users are responsible for all processing in this path.
+	// It is safe to call while the server is running, and the route
+	// will be served for the next requests.
 	RegisterCustomRouteHandler(path string, handler http.HandlerFunc) error
 
 	// UnregisterCustomRouteHandler unregisters a generic HTTP handler for a path.
 	UnregisterCustomRouteHandler(path string) error
 
-	// CustomHandlers returns a map of all the custom handlers.
+	// CustomHandlers returns a copy of the map of all the custom handlers.
 	CustomHandlers() map[string]http.HandlerFunc
 
 	// Push pushes the given events to all active sessions.
 	// It will use the PubSubClient configured in the pushConfig.
 	Push(...*elemental.Event)
 
+	// PushTo pushes the given events to the push sessions selected by
+	// the given target, on all the instances sharing the push topic.
+	// The events bypass the PushDispatchHandler, but the push config of
+	// the sessions is honored.
+	PushTo(PushTarget, ...*elemental.Event) error
+
+	// PushSessions returns information about the connected push sessions
+	// having all the given claims, ordered by connection time.
+	PushSessions(claims ...string) []PushSessionInfo
+
+	// PushSession returns information about the push session with the
+	// given ID. It returns false if there is no such session.
+	PushSession(id string) (PushSessionInfo, bool)
+
+	// ClosePushSession closes the push session with the given ID. If
+	// the client handles error events, it receives the given reason
+	// before the socket is closed. It returns false if there is no
+	// such session.
+	ClosePushSession(id string, reason string) bool
+
+	// ClosePushSessionsWithClaims closes all the push sessions having
+	// all the given claims, for instance when a token is revoked, and
+	// returns how many were closed. It does nothing if no claim is given.
+	ClosePushSessionsWithClaims(reason string, claims ...string) int
+
 	// RoutesInfo returns the routing information of the server.
 	RoutesInfo() map[int][]RouteInfo
 
@@ -88,8 +117,26 @@ type Server interface {
 	PushEndpoint() string
 
 	// Run runs the server using the given context.Context.
-	// You can stop the server by canceling the context.
+	// You can stop the server by canceling the context, or
+	// by calling Stop.
 	Run(context.Context)
+
+	// Stop gracefully stops the server. The health check starts to
+	// report the server as unavailable and new requests are rejected
+	// while the in-flight ones are completing and the push sessions
+	// are closed. It returns an error describing any component that
+	// did not drain in time or before the given context is done.
+	// Calling Stop more than once has no effect.
+	Stop(context.Context) error
+}
+
+// A RoutesRevisioner is a Server that can tell
+// when its routes change.
+type RoutesRevisioner interface {
+
+	// RoutesRevision returns a number that changes every
+	// time a processor is registered or unregistered.
+	RoutesRevision() uint64
 }
 
 // A ResponseWriter is a function you can use in
@@ -200,6 +247,14 @@ type Context interface {
 // Processor is the interface for a Processor Unit
 type Processor interface{}
 
+// A ProcessorAdapter is a Processor that implements all the
+// operation interfaces but only supports some of them, like the typed
+// adapters generated by bahamut-gen. The dispatchers consider an operation
+// as not implemented if ImplementsOperation returns false for it.
+type ProcessorAdapter interface {
+	ImplementsOperation(elemental.Operation) bool
+}
+
 // RetrieveManyProcessor is the interface a processor must implement
 // in order to be able to manage OperationRetrieveMany.
 type RetrieveManyProcessor interface {
@@ -295,6 +350,28 @@ type PushDispatchHandler interface {
 	SummarizeEvent(event *elemental.Event) (interface{}, error)
 }
 
+// A PushRoutingHandler is a PushDispatchHandler that can also declare
+// routing keys for the push sessions, like their namespace or their
+// tenant. The push server indexes the sessions by their keys, so an
+// event whose summary implements RoutableEventSummary is only evaluated
+// against the sessions sharing one of its keys, and against the sessions
+// without any key.
+type PushRoutingHandler interface {
+
+	// SessionRoutingKeys is called once when a push session starts
+	// and returns its routing keys. A session with no key is
+	// considered for every event.
+	SessionRoutingKeys(PushSession) []string
+}
+
+// A RoutableEventSummary is an event summary returned by
+// PushDispatchHandler.SummarizeEvent that declares the
+// routing keys of the event. If it returns no key, the event is
+// considered for every session.
+type RoutableEventSummary interface {
+	RoutingKeys() []string
+}
+
 // PushPublishHandler is the interface that must be implemented in order to
 // to be used as the Bahamut Push Publish handler.
 type PushPublishHandler interface {
@@ -0,0 +1,77 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// A ListenerOption represents an option for an additional
+// listener of the rest server.
+type ListenerOption func(*listenerConfig)
+
+type listenerConfig struct {
+	network                         string
+	address                         string
+	customListener                  net.Listener
+	maxConnection                   int
+	serverCertificates              []tls.Certificate
+	serverCertificatesRetrieverFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	clientCAPool                    *x509.CertPool
+	authType                        tls.ClientAuthType
+}
+
+func (c listenerConfig) tlsEnabled() bool {
+	return c.serverCertificates != nil || c.serverCertificatesRetrieverFunc != nil
+}
+
+// ListenerOptTLS configures the TLS certificates to use for the listener.
+//
+// If you set certRetriever, the value of certs will be ignored.
+// If this option is not set, the listener will serve plain HTTP, even
+// if TLS has been configured for the main listener using OptTLS.
+// This option cannot be used together with OptTLSReloader.
+func ListenerOptTLS(certs []tls.Certificate, certRetriever func(*tls.ClientHelloInfo) (*tls.Certificate, error)) ListenerOption {
+	return func(c *listenerConfig) {
+		c.serverCertificates = certs
+		c.serverCertificatesRetrieverFunc = certRetriever
+	}
+}
+
+// ListenerOptMTLS configures the tls client authentication mechanism
+// for the listener. This option has no effect if ListenerOptTLS is not set.
+func ListenerOptMTLS(caPool *x509.CertPool, authType tls.ClientAuthType) ListenerOption {
+	return func(c *listenerConfig) {
+		c.clientCAPool = caPool
+		c.authType = authType
+	}
+}
+
+// ListenerOptMaxConnection sets the maximum number of concurrent
+// connections the listener will accept. 0, which is the default, means
+// no limit.
+func ListenerOptMaxConnection(n int) ListenerOption {
+	return func(c *listenerConfig) {
+		c.maxConnection = n
+	}
+}
+
+// ListenerOptCustomListener sets a custom net.Listener to use.
+// When set, the network and address given to OptRestServerListener
+// are ignored.
+func ListenerOptCustomListener(listener net.Listener) ListenerOption {
+	return func(c *listenerConfig) {
+		c.customListener = listener
+	}
+}
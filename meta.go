@@ -25,6 +25,9 @@ type RouteInfo struct {
 	URL      string   `msgpack:"url" json:"url"`
 	Verbs    []string `msgpack:"verbs,omitempty" json:"verbs,omitempty"`
 	Private  bool     `msgpack:"private,omitempty" json:"private,omitempty"`
+
+	// Parameters contains the query parameters accepted by the route, per verb.
+	Parameters map[string][]RouteParameter `msgpack:"parameters,omitempty" json:"parameters,omitempty"`
 }
 
 func (r RouteInfo) String() string {
@@ -32,25 +35,39 @@ func (r RouteInfo) String() string {
 }
 
 type routeBuilder struct {
-	verbs    map[string]struct{}
-	private  bool
-	identity elemental.Identity
+	verbs      map[string]struct{}
+	parameters map[string][]RouteParameter
+	private    bool
+	identity   elemental.Identity
 }
 
 func buildVersionedRoutes(modelManagers map[int]elemental.ModelManager, processorFinder processorFinderFunc) map[int][]RouteInfo {
 
-	addRoute := func(routes map[string]routeBuilder, identity elemental.Identity, url string, verb string, private bool) {
+	addRoute := func(routes map[string]routeBuilder, identity elemental.Identity, url string, verb string, private bool, parameters []RouteParameter) {
 
 		rb, ok := routes[url]
 		if !ok {
 			rb = routeBuilder{
-				verbs:    map[string]struct{}{},
-				private:  private,
-				identity: identity,
+				verbs:      map[string]struct{}{},
+				parameters: map[string][]RouteParameter{},
+				private:    private,
+				identity:   identity,
 			}
 			routes[url] = rb
 		}
 		rb.verbs[verb] = struct{}{}
+
+		if len(parameters) > 0 {
+			rb.parameters[verb] = parameters
+		}
+	}
+
+	allInfos := func(infos map[string]*elemental.RelationshipInfo) []*elemental.RelationshipInfo {
+		out := make([]*elemental.RelationshipInfo, 0, len(infos))
+		for _, info := range infos {
+			out = append(out, info)
+		}
+		return out
 	}
 
 	versionedRoutes := map[int][]RouteInfo{}
@@ -69,36 +86,36 @@ func buildVersionedRoutes(modelManagers map[int]elemental.ModelManager, processo
 			}
 
 			if len(relationship.Create) > 0 {
-				addRoute(routes, identity, fmt.Sprintf("/%s", identity.Category), "POST", identity.Private)
+				addRoute(routes, identity, fmt.Sprintf("/%s", identity.Category), "POST", identity.Private, routeParameters(relationship.Create["root"]))
 			}
 
 			if len(relationship.Retrieve) > 0 {
-				addRoute(routes, identity, fmt.Sprintf("/%s/:id", identity.Category), "GET", identity.Private)
+				addRoute(routes, identity, fmt.Sprintf("/%s/:id", identity.Category), "GET", identity.Private, routeParameters(allInfos(relationship.Retrieve)...))
 			}
 
 			if len(relationship.Delete) > 0 {
-				addRoute(routes, identity, fmt.Sprintf("/%s/:id", identity.Category), "DELETE", identity.Private)
+				addRoute(routes, identity, fmt.Sprintf("/%s/:id", identity.Category), "DELETE", identity.Private, routeParameters(allInfos(relationship.Delete)...))
 			}
 
 			if len(relationship.Update) > 0 {
-				addRoute(routes, identity, fmt.Sprintf("/%s/:id", identity.Category), "PUT", identity.Private)
+				addRoute(routes, identity, fmt.Sprintf("/%s/:id", identity.Category), "PUT", identity.Private, routeParameters(allInfos(relationship.Update)...))
 			}
 
-			for parent := range relationship.RetrieveMany {
+			for parent, info := range relationship.RetrieveMany {
 
 				if parent == "root" {
-					addRoute(routes, identity, fmt.Sprintf("/%s", identity.Category), "GET", identity.Private)
+					addRoute(routes, identity, fmt.Sprintf("/%s", identity.Category), "GET", identity.Private, routeParameters(info))
 				} else {
-					addRoute(routes, identity, fmt.Sprintf("/%s/:id/%s", modelManager.IdentityFromName(parent).Category, identity.Category), "GET", identity.Private)
+					addRoute(routes, identity, fmt.Sprintf("/%s/:id/%s", modelManager.IdentityFromName(parent).Category, identity.Category), "GET", identity.Private, routeParameters(info))
 				}
 			}
 
-			for parent := range relationship.Create {
+			for parent, info := range relationship.Create {
 
 				if parent == "root" {
-					addRoute(routes, identity, fmt.Sprintf("/%s", identity.Category), "POST", identity.Private)
+					addRoute(routes, identity, fmt.Sprintf("/%s", identity.Category), "POST", identity.Private, routeParameters(info))
 				} else {
-					addRoute(routes, identity, fmt.Sprintf("/%s/:id/%s", modelManager.IdentityFromName(parent).Category, identity.Category), "POST", identity.Private)
+					addRoute(routes, identity, fmt.Sprintf("/%s/:id/%s", modelManager.IdentityFromName(parent).Category, identity.Category), "POST", identity.Private, routeParameters(info))
 				}
 			}
 		}
@@ -111,13 +128,19 @@ func buildVersionedRoutes(modelManagers map[int]elemental.ModelManager, processo
 			}
 			sort.Strings(flatVerbs)
 
+			var parameters map[string][]RouteParameter
+			if len(rb.parameters) > 0 {
+				parameters = rb.parameters
+			}
+
 			versionedRoutes[version] = append(
 				versionedRoutes[version],
 				RouteInfo{
-					URL:      url,
-					Verbs:    flatVerbs,
-					Private:  rb.private,
-					Identity: rb.identity.Category,
+					URL:        url,
+					Verbs:      flatVerbs,
+					Private:    rb.private,
+					Identity:   rb.identity.Category,
+					Parameters: parameters,
 				},
 			)
 		}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
+	"go.aporeto.io/elemental"
 )
 
 // FinishMeasurementFunc is the kind of functinon returned by MetricsManager.MeasureRequest().
@@ -30,3 +31,58 @@ type MetricsManager interface {
 	UnregisterTCPConnection()
 	Write(w http.ResponseWriter, r *http.Request)
 }
+
+// A TLSMetricsManager is a MetricsManager that can also
+// record TLS material reloads and certificate expiration.
+type TLSMetricsManager interface {
+	RegisterTLSReload(success bool)
+	SetTLSCertificateExpiration(time.Time)
+}
+
+// An AdmissionMetricsManager is a MetricsManager that can
+// also record the state of the admission control.
+type AdmissionMetricsManager interface {
+	SetAdmissionLimit(priority RequestPriority, limit int)
+	RegisterShedRequest(priority RequestPriority)
+}
+
+// A PushMetricsManager is a MetricsManager that can
+// also record the state of the push dispatch workers.
+type PushMetricsManager interface {
+	SetPushDispatchQueueDepth(topic string, depth int)
+	ObservePushDispatchDuration(topic string, d time.Duration)
+	RegisterDroppedPublication(topic string)
+	RegisterDroppedDispatch(topic string)
+}
+
+// A PushCompressionMetricsManager is a MetricsManager that can
+// also record the compression of the messages sent to the push
+// sessions.
+type PushCompressionMetricsManager interface {
+	ObservePushCompression(encoding elemental.EncodingType, uncompressed int, compressed int, d time.Duration)
+}
+
+// Various values for the limit of LimitsMetricsManager.RegisterLimitViolation.
+const (
+	LimitRequestBodySize      = "request_body_size"
+	LimitRetrieveManyItems    = "retrieve_many_items"
+	LimitPushMessageSize      = "push_message_size"
+	LimitPushSessions         = "push_sessions"
+	LimitPushSessionsPerClaim = "push_sessions_per_claim"
+	LimitPushHandshakeRate    = "push_handshake_rate"
+)
+
+// A LimitsMetricsManager is a MetricsManager that can
+// also record the violations of the configured limits.
+type LimitsMetricsManager interface {
+	RegisterLimitViolation(limit string)
+}
+
+// registerLimitViolation records a limit violation in
+// the given MetricsManager, if it supports it.
+func registerLimitViolation(manager MetricsManager, limit string) {
+
+	if m, ok := manager.(LimitsMetricsManager); ok {
+		m.RegisterLimitViolation(limit)
+	}
+}
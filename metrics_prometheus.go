@@ -21,6 +21,7 @@ import (
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.aporeto.io/elemental"
 )
 
 var vregexp = regexp.MustCompile(`^/v/\d+`)
@@ -47,6 +48,17 @@ type prometheusMetricsManager struct {
 	tcpConnCurrentMetric prometheus.Gauge
 	wsConnTotalMetric    prometheus.Counter
 	wsConnCurrentMetric  prometheus.Gauge
+	tlsReloadMetric      *prometheus.CounterVec
+	tlsExpirationMetric  prometheus.Gauge
+	limitViolationMetric *prometheus.CounterVec
+	admissionLimitMetric *prometheus.GaugeVec
+	shedRequestMetric    *prometheus.CounterVec
+	pushQueueMetric      *prometheus.GaugeVec
+	pushDurationMetric   *prometheus.SummaryVec
+	pushDroppedMetric    *prometheus.CounterVec
+	pushShardDropMetric  *prometheus.CounterVec
+	compressRatioMetric  *prometheus.SummaryVec
+	compressTimeMetric   *prometheus.SummaryVec
 
 	handler http.Handler
 }
@@ -106,6 +118,85 @@ func newPrometheusMetricsManager(registerer prometheus.Registerer) MetricsManage
 			},
 			[]string{"trace", "method", "url", "code"},
 		),
+		tlsReloadMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tls_reloads_total",
+				Help: "The total number of TLS material reloads.",
+			},
+			[]string{"result"},
+		),
+		tlsExpirationMetric: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "tls_certificate_expiration_timestamp_seconds",
+				Help: "The expiration time of the server certificate closest to expire.",
+			},
+		),
+		limitViolationMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "limit_violations_total",
+				Help: "The total number of requests or messages rejected because they exceeded a limit.",
+			},
+			[]string{"limit"},
+		),
+		admissionLimitMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "admission_limit",
+				Help: "The current number of requests that can be processed concurrently per priority.",
+			},
+			[]string{"priority"},
+		),
+		shedRequestMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "admission_shed_requests_total",
+				Help: "The total number of requests shed by the admission control.",
+			},
+			[]string{"priority"},
+		),
+		pushQueueMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "push_dispatch_queue_depth",
+				Help: "The current number of publications waiting to be dispatched.",
+			},
+			[]string{"topic"},
+		),
+		pushDurationMetric: prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       "push_dispatch_duration_seconds",
+				Help:       "The average duration between the reception of a publication and the end of its dispatch.",
+				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			},
+			[]string{"topic"},
+		),
+		pushDroppedMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "push_dropped_publications_total",
+				Help: "The total number of publications dropped because the dispatch queue was full.",
+			},
+			[]string{"topic"},
+		),
+		pushShardDropMetric: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "push_dropped_dispatches_total",
+				Help: "The total number of events not dispatched to a shard of sessions because its worker queue was full.",
+			},
+			[]string{"topic"},
+		),
+		compressRatioMetric: prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       "push_compression_ratio",
+				Help:       "The average ratio between the compressed and the uncompressed size of the push messages.",
+				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			},
+			[]string{"encoding"},
+		),
+		compressTimeMetric: prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       "push_compression_duration_seconds",
+				Help:       "The average duration of the compression and the write of the push messages.",
+				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			},
+			[]string{"encoding"},
+		),
 	}
 
 	registerer.MustRegister(mc.tcpConnCurrentMetric)
@@ -115,6 +206,17 @@ func newPrometheusMetricsManager(registerer prometheus.Registerer) MetricsManage
 	registerer.MustRegister(mc.wsConnTotalMetric)
 	registerer.MustRegister(mc.wsConnCurrentMetric)
 	registerer.MustRegister(mc.errorMetric)
+	registerer.MustRegister(mc.tlsReloadMetric)
+	registerer.MustRegister(mc.tlsExpirationMetric)
+	registerer.MustRegister(mc.limitViolationMetric)
+	registerer.MustRegister(mc.admissionLimitMetric)
+	registerer.MustRegister(mc.shedRequestMetric)
+	registerer.MustRegister(mc.pushQueueMetric)
+	registerer.MustRegister(mc.pushDurationMetric)
+	registerer.MustRegister(mc.pushDroppedMetric)
+	registerer.MustRegister(mc.pushShardDropMetric)
+	registerer.MustRegister(mc.compressRatioMetric)
+	registerer.MustRegister(mc.compressTimeMetric)
 
 	return mc
 }
@@ -176,6 +278,57 @@ func (c *prometheusMetricsManager) UnregisterTCPConnection() {
 	c.tcpConnCurrentMetric.Dec()
 }
 
+func (c *prometheusMetricsManager) RegisterTLSReload(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	c.tlsReloadMetric.With(prometheus.Labels{"result": result}).Inc()
+}
+
+func (c *prometheusMetricsManager) SetTLSCertificateExpiration(t time.Time) {
+	c.tlsExpirationMetric.Set(float64(t.Unix()))
+}
+
+func (c *prometheusMetricsManager) RegisterLimitViolation(limit string) {
+	c.limitViolationMetric.With(prometheus.Labels{"limit": limit}).Inc()
+}
+
+func (c *prometheusMetricsManager) SetAdmissionLimit(priority RequestPriority, limit int) {
+	c.admissionLimitMetric.With(prometheus.Labels{"priority": priority.String()}).Set(float64(limit))
+}
+
+func (c *prometheusMetricsManager) RegisterShedRequest(priority RequestPriority) {
+	c.shedRequestMetric.With(prometheus.Labels{"priority": priority.String()}).Inc()
+}
+
+func (c *prometheusMetricsManager) SetPushDispatchQueueDepth(topic string, depth int) {
+	c.pushQueueMetric.With(prometheus.Labels{"topic": topic}).Set(float64(depth))
+}
+
+func (c *prometheusMetricsManager) ObservePushDispatchDuration(topic string, d time.Duration) {
+	c.pushDurationMetric.With(prometheus.Labels{"topic": topic}).Observe(d.Seconds())
+}
+
+func (c *prometheusMetricsManager) RegisterDroppedPublication(topic string) {
+	c.pushDroppedMetric.With(prometheus.Labels{"topic": topic}).Inc()
+}
+
+func (c *prometheusMetricsManager) RegisterDroppedDispatch(topic string) {
+	c.pushShardDropMetric.With(prometheus.Labels{"topic": topic}).Inc()
+}
+
+func (c *prometheusMetricsManager) ObservePushCompression(encoding elemental.EncodingType, uncompressed int, compressed int, d time.Duration) {
+
+	if uncompressed <= 0 {
+		return
+	}
+
+	labels := prometheus.Labels{"encoding": string(encoding)}
+	c.compressRatioMetric.With(labels).Observe(float64(compressed) / float64(uncompressed))
+	c.compressTimeMetric.With(labels).Observe(d.Seconds())
+}
+
 func (c *prometheusMetricsManager) Write(w http.ResponseWriter, r *http.Request) {
 	c.handler.ServeHTTP(w, r)
 }
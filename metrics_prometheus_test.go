@@ -13,9 +13,11 @@ package bahamut
 
 import (
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
 )
 
 func Test_sanitizeURL(t *testing.T) {
@@ -195,3 +197,107 @@ func TestRegisterTCPConnection(t *testing.T) {
 		})
 	})
 }
+
+func TestRegisterLimitViolation(t *testing.T) {
+
+	Convey("Given I have a PrometheusMetricsManager", t, func() {
+
+		r := prometheus.NewRegistry()
+		pmm := newPrometheusMetricsManager(r).(*prometheusMetricsManager)
+
+		Convey("When I call RegisterLimitViolation", func() {
+
+			pmm.RegisterLimitViolation(LimitRequestBodySize)
+			pmm.RegisterLimitViolation(LimitRequestBodySize)
+
+			data, _ := r.Gather()
+
+			Convey("Then the violation should be counted", func() {
+				So(data[0].GetName(), ShouldEqual, "http_ws_connections_current")
+				So(data[2].GetName(), ShouldEqual, "limit_violations_total")
+				So(data[2].GetMetric()[0].String(), ShouldEqual, `label:<name:"limit" value:"request_body_size" > counter:<value:2 > `)
+			})
+		})
+	})
+}
+
+func TestAdmissionMetrics(t *testing.T) {
+
+	Convey("Given I have a PrometheusMetricsManager", t, func() {
+
+		r := prometheus.NewRegistry()
+		pmm := newPrometheusMetricsManager(r).(*prometheusMetricsManager)
+
+		Convey("When I call SetAdmissionLimit and RegisterShedRequest", func() {
+
+			pmm.SetAdmissionLimit(RequestPriorityBulk, 50)
+			pmm.RegisterShedRequest(RequestPriorityBulk)
+
+			data, _ := r.Gather()
+
+			Convey("Then the metrics should be correct", func() {
+				So(data[0].GetName(), ShouldEqual, "admission_limit")
+				So(data[0].GetMetric()[0].String(), ShouldEqual, `label:<name:"priority" value:"bulk" > gauge:<value:50 > `)
+				So(data[1].GetName(), ShouldEqual, "admission_shed_requests_total")
+				So(data[1].GetMetric()[0].String(), ShouldEqual, `label:<name:"priority" value:"bulk" > counter:<value:1 > `)
+			})
+		})
+	})
+}
+
+func TestPushMetrics(t *testing.T) {
+
+	Convey("Given I have a PrometheusMetricsManager", t, func() {
+
+		r := prometheus.NewRegistry()
+		pmm := newPrometheusMetricsManager(r).(*prometheusMetricsManager)
+
+		Convey("When I call the push dispatch metrics methods", func() {
+
+			pmm.ObservePushDispatchDuration("topic", time.Second)
+			pmm.SetPushDispatchQueueDepth("topic", 12)
+			pmm.RegisterDroppedPublication("topic")
+			pmm.RegisterDroppedDispatch("topic")
+
+			data, _ := r.Gather()
+
+			Convey("Then the metrics should be correct", func() {
+				So(data[2].GetName(), ShouldEqual, "push_dispatch_duration_seconds")
+				So(data[2].GetMetric()[0].GetSummary().GetSampleCount(), ShouldEqual, 1)
+				So(data[2].GetMetric()[0].GetSummary().GetSampleSum(), ShouldEqual, 1.0)
+				So(data[3].GetName(), ShouldEqual, "push_dispatch_queue_depth")
+				So(data[3].GetMetric()[0].String(), ShouldEqual, `label:<name:"topic" value:"topic" > gauge:<value:12 > `)
+				So(data[4].GetName(), ShouldEqual, "push_dropped_dispatches_total")
+				So(data[4].GetMetric()[0].String(), ShouldEqual, `label:<name:"topic" value:"topic" > counter:<value:1 > `)
+				So(data[5].GetName(), ShouldEqual, "push_dropped_publications_total")
+				So(data[5].GetMetric()[0].String(), ShouldEqual, `label:<name:"topic" value:"topic" > counter:<value:1 > `)
+			})
+		})
+	})
+}
+
+func TestPushCompressionMetrics(t *testing.T) {
+
+	Convey("Given I have a PrometheusMetricsManager", t, func() {
+
+		r := prometheus.NewRegistry()
+		pmm := newPrometheusMetricsManager(r).(*prometheusMetricsManager)
+
+		Convey("When I call ObservePushCompression", func() {
+
+			pmm.ObservePushCompression(elemental.EncodingTypeJSON, 100, 25, time.Second)
+			pmm.ObservePushCompression(elemental.EncodingTypeJSON, 0, 0, time.Second)
+
+			data, _ := r.Gather()
+
+			Convey("Then the metrics should be correct", func() {
+				So(data[2].GetName(), ShouldEqual, "push_compression_duration_seconds")
+				So(data[2].GetMetric()[0].GetSummary().GetSampleCount(), ShouldEqual, 1)
+				So(data[2].GetMetric()[0].GetSummary().GetSampleSum(), ShouldEqual, 1.0)
+				So(data[3].GetName(), ShouldEqual, "push_compression_ratio")
+				So(data[3].GetMetric()[0].GetSummary().GetSampleCount(), ShouldEqual, 1)
+				So(data[3].GetMetric()[0].GetSummary().GetSampleSum(), ShouldEqual, 0.25)
+			})
+		})
+	})
+}
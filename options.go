@@ -55,6 +55,35 @@ func OptCustomListener(listener net.Listener) Option {
 	}
 }
 
+// OptRestServerListener adds an additional listener to the rest server.
+//
+// Network can be either tcp, tcp4, tcp6 or unix. Address is the listening
+// address, or the path of the socket for unix. All listeners share the same
+// routes and are shut down together. Each listener can have its own TLS
+// configuration and maximum number of connections using ListenerOptions.
+// This option has no effect if OptRestServer is not set.
+func OptRestServerListener(network string, address string, options ...ListenerOption) Option {
+
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		panic(fmt.Sprintf("invalid network '%s' for rest server listener", network))
+	}
+
+	lc := listenerConfig{
+		network: network,
+		address: address,
+	}
+
+	for _, opt := range options {
+		opt(&lc)
+	}
+
+	return func(c *config) {
+		c.restServer.listeners = append(c.restServer.listeners, lc)
+	}
+}
+
 // OptMaxConnection sets the maximum number of concurrent
 // connection to the server. 0, which is the default, means
 // no limit.
@@ -64,6 +93,32 @@ func OptMaxConnection(n int) Option {
 	}
 }
 
+// OptH2C enables HTTP/2 cleartext (h2c) support for the rest server.
+//
+// This allows clients to use HTTP/2 multiplexing without TLS, either
+// through prior knowledge or the HTTP/1.1 Upgrade mechanism. This is mostly
+// useful for internal service to service communication behind a mesh.
+// This option has no effect if TLS is configured.
+func OptH2C() Option {
+	return func(c *config) {
+		c.restServer.h2cEnabled = true
+	}
+}
+
+// OptHTTP3 enables an additional HTTP/3 (QUIC) listener for the rest server
+// on the given UDP address.
+//
+// The HTTP/3 server uses the same routes, TLS configuration and graceful
+// shutdown path as the main server. The main server will advertise it
+// to the clients using the Alt-Svc header. This option has no effect if
+// TLS is not configured. As QUIC requires TLS 1.3, New will panic if the
+// policy set by OptTLSPolicy does not allow it, like TLSProfileFIPS.
+func OptHTTP3(listen string) Option {
+	return func(c *config) {
+		c.restServer.http3ListenAddress = listen
+	}
+}
+
 // OptTimeouts configures the timeouts of the server.
 func OptTimeouts(read, write, idle time.Duration) Option {
 	return func(c *config) {
@@ -182,6 +237,220 @@ func OptPushDispatchHandler(dispatchHandler PushDispatchHandler) Option {
 	}
 }
 
+// OptPushDispatchWorkers configures the pool of workers
+// dispatching the push events to the sessions.
+//
+// Workers is the number of workers evaluating the sessions in
+// parallel. Each session is always handled by the same worker, so
+// it receives the events in the order they have been published.
+// QueueSize is the number of publications that can wait to be
+// dispatched. When the queue is full, the new publications are dropped.
+// By default, there is one worker per CPU and the queue size is 24000.
+//
+// Each worker also has its own bounded queue of events. When it is full,
+// the event is dropped for all the sessions handled by this worker, so a
+// slow worker does not hold back the others. Such an event is counted in
+// the dropped events of these sessions, as reported by
+// OptHealthPushSessions, and by PushMetricsManager.RegisterDroppedDispatch.
+func OptPushDispatchWorkers(workers int, queueSize int) Option {
+
+	if workers <= 0 {
+		panic("push dispatch workers must be greater than 0")
+	}
+
+	if queueSize <= 0 {
+		panic("push dispatch queue size must be greater than 0")
+	}
+
+	return func(c *config) {
+		c.pushServer.dispatchWorkers = workers
+		c.pushServer.dispatchQueueSize = queueSize
+	}
+}
+
+// OptPushSessionReauthentication enables the periodic re-authentication
+// of the push sessions.
+//
+// The session authenticators are run again on every push session at
+// the given interval, and at the expiration of the session set by a
+// SessionAuthenticator through ExpirableSession, whichever comes
+// first. If interval is 0, the sessions are only re-authenticated
+// at their expiration. Clients can also send a message like
+// {"token": "..."} to refresh their token, which re-authenticates the
+// session immediately. When the re-authentication fails, the session
+// is closed with the code PushSessionCloseUnauthorized, after an error
+// event is sent if the client handles error events.
+func OptPushSessionReauthentication(interval time.Duration) Option {
+
+	if interval < 0 {
+		panic("reauthentication interval must not be negative")
+	}
+
+	return func(c *config) {
+		c.pushServer.reauthEnabled = true
+		c.pushServer.reauthInterval = interval
+	}
+}
+
+// OptPushMessageHandler sets the handler of the messages sent by the
+// clients over the push websocket.
+//
+// Clients can send typed messages like {"type": "message", "requestID":
+// "1", "data": {...}}. The messages of type PushMessageTypeAck and
+// PushMessageTypeMessage are given to the handler, and the response is
+// sent back with the same request ID. Without handler, these messages
+// are answered with an error event.
+func OptPushMessageHandler(handler PushMessageHandler) Option {
+	return func(c *config) {
+		c.pushServer.messageHandler = handler
+	}
+}
+
+// OptPushSessionAPI allows the clients to run elemental requests
+// over their push websocket.
+//
+// Clients send messages of type PushMessageTypeRequest containing an
+// elemental.Request. The requests are run by the same handlers as the
+// rest server, with authorization, read only mode, validation, audit
+// and tracing, but they are not authenticated again: they get the
+// claims of the push session. The response is sent back in a message
+// of type PushMessageTypeResponse containing a PushAPIResponse with
+// the same request ID, alongside the push events.
+func OptPushSessionAPI() Option {
+	return func(c *config) {
+		c.pushServer.apiEnabled = true
+	}
+}
+
+// OptPushCompression enables the permessage-deflate compression
+// of the push websockets.
+//
+// The compression is used with the clients offering the extension, at
+// the given level, from flate.HuffmanOnly (-2) to flate.BestCompression
+// (9). The messages smaller than the given threshold, in bytes, are not
+// compressed. Clients can disable the compression of their session by
+// passing the query parameter disableCompression.
+//
+// The context takeover cannot be configured: the underlying websocket
+// library does not support it, so the server always negotiates
+// server_no_context_takeover and client_no_context_takeover, whatever
+// the clients offer. Every message is then compressed on its own,
+// which gives a lower ratio than a shared window on small messages.
+//
+// If the metrics manager implements PushCompressionMetricsManager, the
+// compression ratio and the duration of the compressed writes are
+// recorded.
+func OptPushCompression(level int, threshold int) Option {
+
+	if level < -2 || level > 9 {
+		panic("push compression level must be between -2 and 9")
+	}
+
+	if threshold < 0 {
+		panic("push compression threshold must not be negative")
+	}
+
+	return func(c *config) {
+		c.pushServer.compressionEnabled = true
+		c.pushServer.compressionLevel = level
+		c.pushServer.compressionThreshold = threshold
+	}
+}
+
+// OptPushAllowedOrigins sets the origins allowed to
+// open a push websocket.
+//
+// An origin can be *, to allow any origin, or contain a wildcard
+// subdomain like https://*.example.com. If not set, the origin set
+// by OptCORSOrigin is used, and if it is not set either, only the
+// requests from the same origin are allowed. Requests with no
+// origin are always allowed, as they are not coming from a browser.
+func OptPushAllowedOrigins(origins ...string) Option {
+	return func(c *config) {
+		c.pushServer.allowedOrigins = origins
+	}
+}
+
+// OptPushMaxSessions sets the maximum number of
+// concurrent push sessions. 0 means no limit.
+func OptPushMaxSessions(max int) Option {
+
+	if max < 0 {
+		panic("max push sessions must not be negative")
+	}
+
+	return func(c *config) {
+		c.pushServer.maxSessions = max
+	}
+}
+
+// OptPushMaxSessionsPerClaim sets the maximum number of concurrent
+// push sessions having the same value for the claim with the given
+// key, like @auth:subject. The sessions without this claim are not
+// limited.
+func OptPushMaxSessionsPerClaim(key string, max int) Option {
+
+	if key == "" {
+		panic("push sessions claim key must not be empty")
+	}
+
+	if max <= 0 {
+		panic("max push sessions per claim must be greater than 0")
+	}
+
+	return func(c *config) {
+		c.pushServer.sessionsClaimKey = key
+		c.pushServer.maxSessionsPerClaim = max
+	}
+}
+
+// OptPushHandshakeRateLimiting configures the rate limiting
+// of the push websocket handshakes.
+func OptPushHandshakeRateLimiting(limit float64, burst int) Option {
+
+	if limit <= 0 {
+		panic("push handshake rate limit must be greater than 0")
+	}
+
+	if burst <= 0 {
+		panic("push handshake rate limit burst must be greater than 0")
+	}
+
+	return func(c *config) {
+		c.pushServer.handshakeLimiter = rate.NewLimiter(rate.Limit(limit), burst)
+	}
+}
+
+// OptPushBatching allows the push sessions to receive their
+// events in batches.
+//
+// Batching is opt-in for every session: the client enables it by setting
+// the batchWindow parameter of its push config to a duration, like 50ms,
+// and can set batchSize to limit the number of events in a batch. The
+// events are then held until the window expires or the batch is full,
+// and sent as a single message containing an array of events, encoded
+// like the session. If the client also sets the coalesce parameter to
+// true, only the latest update event of an object is kept in a batch.
+// The window and the size requested by the clients are capped by the
+// given maxWindow and maxSize. Sessions not requesting it keep
+// receiving the events one by one.
+func OptPushBatching(maxWindow time.Duration, maxSize int) Option {
+
+	if maxWindow <= 0 {
+		panic("push batching window must be greater than 0")
+	}
+
+	if maxSize <= 0 {
+		panic("push batch size must be greater than 0")
+	}
+
+	return func(c *config) {
+		c.pushServer.batchingEnabled = true
+		c.pushServer.batchMaxWindow = maxWindow
+		c.pushServer.batchMaxSize = maxSize
+	}
+}
+
 // OptPushPublishHandler configures the push publisher.
 //
 // PublishHandler defines the handler that will be used to
@@ -247,6 +516,31 @@ func OptHealthCustomStats(handlers map[string]HealthStatFunc) Option {
 	}
 }
 
+// OptHealthPushSessions enables the push sessions admin endpoint
+// on the health server.
+//
+// The endpoint /_push/sessions allows to list the connected push
+// sessions, filtered by claims with the claim query parameter and
+// paginated with the page and pagesize query parameters (at most 1000
+// sessions per page), to get a
+// session with /_push/sessions/<id>, and to close sessions with the
+// DELETE method, either by ID or by claims, with an optional reason.
+// The authorizer is called for every request and a 403 is returned
+// if it returns false. As the endpoint exposes the claims of the
+// sessions and allows to close them, the authorizer is mandatory.
+//
+// The health server and the push server must be enabled or this
+// option will have no effect.
+func OptHealthPushSessions(authorizer func(*http.Request) bool) Option {
+	if authorizer == nil {
+		panic("push sessions authorizer must not be nil")
+	}
+	return func(c *config) {
+		c.healthServer.pushSessionsEnabled = true
+		c.healthServer.pushSessionsAuthorizer = authorizer
+	}
+}
+
 // OptHealthServerTimeouts configures the health server timeouts.
 func OptHealthServerTimeouts(read, write, idle time.Duration) Option {
 	return func(c *config) {
@@ -279,6 +573,48 @@ func OptTLS(certs []tls.Certificate, certRetriever func(*tls.ClientHelloInfo) (*
 	}
 }
 
+// OptTLSReloader enables hot reloading of the server certificates and
+// client CA pool of the rest server.
+//
+// Loader is called every interval to retrieve the current TLS material.
+// You can use NewTLSFilesLoader to reload them from disk, or provide
+// your own function. New connections use the latest material while
+// current ones are not affected. If the certificate closest to expire
+// will do so within expiryWarning, a warning will be logged at every
+// reload. If a MetricsManager implementing TLSMetricsManager is
+// configured, reloads and expiration will be reported.
+//
+// When set, the certificates passed to OptTLS and the client CA pool passed
+// to OptMTLS are ignored, but the client authentication type is still used.
+// The additional listeners added with OptRestServerListener cannot use
+// TLS when this option is set and New will panic if they do.
+func OptTLSReloader(loader TLSMaterialLoader, interval time.Duration, expiryWarning time.Duration) Option {
+
+	if loader == nil {
+		panic("loader must not be nil")
+	}
+
+	if interval <= 0 {
+		panic("interval must be positive")
+	}
+
+	return func(c *config) {
+		c.tls.reloader = newTLSReloader(loader, interval, expiryWarning)
+	}
+}
+
+// OptTLSPolicy sets the TLS policy to use for the rest server.
+//
+// The policy defines the allowed TLS versions, cipher suites, curves and
+// ALPN protocols. You can get a predefined one using NewTLSPolicy. If
+// not set, the policy for TLSProfileDefault will be used.
+// The policy also applies to the additional listeners using TLS.
+func OptTLSPolicy(policy TLSPolicy) Option {
+	return func(c *config) {
+		c.tls.policy = &policy
+	}
+}
+
 // OptTLSNextProtos configures server TLS next protocols.
 //
 // You can use it to set it to []string{'h2'} for instance to
@@ -340,8 +676,10 @@ func OptAuthorizers(authorizers []Authorizer) Option {
 
 // OptAuditer configures the auditor to use to audit the requests.
 //
-// The Audit() method will be run in a go routine so there is no
-// need to deal with it in your implementation.
+// The Audit() method is called synchronously by the request pipeline,
+// so it must return quickly. If it needs to perform slow operations, it
+// must do them asynchronously. The AuditTrail returned by NewAuditTrail
+// does this for you.
 func OptAuditer(auditer Auditer) Option {
 	return func(c *config) {
 		c.security.auditer = auditer
@@ -372,6 +710,199 @@ func OptAPIRateLimiting(identity elemental.Identity, limit float64, burst int, c
 	}
 }
 
+// OptAdmissionControl enables the admission control of the rest server.
+// The number of requests processed concurrently is adapted according to
+// their latency compared to the given target latency. When the server is
+// overloaded, requests are shed with a 503 error and a Retry-After header,
+// starting with the ones with the lowest priority.
+func OptAdmissionControl(targetLatency time.Duration, options ...AdmissionControlOption) Option {
+
+	if targetLatency <= 0 {
+		panic("admission target latency must be positive")
+	}
+
+	cfg := newAdmissionControlConfig(targetLatency)
+	for _, o := range options {
+		o(&cfg)
+	}
+
+	return func(c *config) {
+		c.admissionControl = &cfg
+	}
+}
+
+// OptResponseCache enables the caching of the responses of the Retrieve
+// and RetrieveMany operations on the given identity for the given ttl.
+// The responses are cached per scope, which is by default the claims of
+// the client (see OptResponseCacheScopeFunc). The cached responses of an
+// identity are invalidated when the server pushes or receives create,
+// update or delete events for it, which requires the push server to be
+// enabled.
+func OptResponseCache(identity elemental.Identity, ttl time.Duration) Option {
+
+	if ttl <= 0 {
+		panic("response cache ttl must be positive")
+	}
+
+	return func(c *config) {
+		if c.responseCaching.ttls == nil {
+			c.responseCaching.ttls = map[elemental.Identity]time.Duration{}
+		}
+		c.responseCaching.ttls[identity] = ttl
+	}
+}
+
+// OptResponseCacheScopeFunc sets the function used to compute the scope
+// of the cached responses from the claims of the client. This option has
+// no effect if OptResponseCache is not set.
+func OptResponseCacheScopeFunc(f ResponseCacheScopeFunc) Option {
+
+	if f == nil {
+		panic("response cache scope func must not be nil")
+	}
+
+	return func(c *config) {
+		c.responseCaching.scopeFunc = f
+	}
+}
+
+// OptResponseCacheMaxEntries sets the maximum number of cached
+// responses. The default is 10000. This option has no effect if
+// OptResponseCache is not set.
+func OptResponseCacheMaxEntries(max int) Option {
+
+	if max <= 0 {
+		panic("response cache max entries must be positive")
+	}
+
+	return func(c *config) {
+		c.responseCaching.maxEntries = max
+	}
+}
+
+// OptRequestTimeout sets the default maximum duration of the processing
+// of a request. When it is reached, the context.Context of the
+// bahamut.Context is canceled and the client receives a 504 error once
+// the processor returns, even if it returned no error. 0, which is the
+// default, means no timeout.
+func OptRequestTimeout(timeout time.Duration) Option {
+
+	if timeout < 0 {
+		panic("request timeout must not be negative")
+	}
+
+	return func(c *config) {
+		c.requestTimeouts.defaultTimeout = timeout
+	}
+}
+
+// OptIdentityRequestTimeout sets the maximum duration of the processing
+// of the requests on the given identity for the given operations. If no
+// operation is given, the timeout applies to all the operations on
+// the identity. It overrides the value set by OptRequestTimeout.
+func OptIdentityRequestTimeout(identity elemental.Identity, timeout time.Duration, operations ...elemental.Operation) Option {
+
+	if timeout < 0 {
+		panic("request timeout must not be negative")
+	}
+
+	if len(operations) == 0 {
+		operations = []elemental.Operation{""}
+	}
+
+	return func(c *config) {
+		if c.requestTimeouts.identityTimeouts == nil {
+			c.requestTimeouts.identityTimeouts = map[elemental.Identity]map[elemental.Operation]time.Duration{}
+		}
+
+		if c.requestTimeouts.identityTimeouts[identity] == nil {
+			c.requestTimeouts.identityTimeouts[identity] = map[elemental.Operation]time.Duration{}
+		}
+
+		for _, op := range operations {
+			c.requestTimeouts.identityTimeouts[identity][op] = timeout
+		}
+	}
+}
+
+// OptClientRequestTimeout allows the clients to set the timeout of their
+// requests using the RequestTimeoutHeader header, as a duration like "5s".
+// The timeout requested by the client is capped by max, and cannot be
+// longer than the timeout configured for the request. If max is 0, only
+// the timeout configured for the request caps it.
+func OptClientRequestTimeout(max time.Duration) Option {
+
+	if max < 0 {
+		panic("max client request timeout must not be negative")
+	}
+
+	return func(c *config) {
+		c.requestTimeouts.clientEnabled = true
+		c.requestTimeouts.clientMaxTimeout = max
+	}
+}
+
+// OptMaxRequestBodySize sets the maximum size in bytes of the body
+// of the requests. Requests with a bigger body are rejected with
+// a 413 error. 0, which is the default, means no limit.
+func OptMaxRequestBodySize(size int64) Option {
+
+	if size < 0 {
+		panic("max request body size must not be negative")
+	}
+
+	return func(c *config) {
+		c.limits.maxRequestBodySize = size
+	}
+}
+
+// OptIdentityMaxRequestBodySize sets the maximum size in bytes of the
+// body of the requests on the given identity. It overrides the value
+// set by OptMaxRequestBodySize for this identity. 0 means no limit.
+func OptIdentityMaxRequestBodySize(identity elemental.Identity, size int64) Option {
+
+	if size < 0 {
+		panic("max request body size must not be negative")
+	}
+
+	return func(c *config) {
+		if c.limits.identityMaxRequestBodySize == nil {
+			c.limits.identityMaxRequestBodySize = map[elemental.Identity]int64{}
+		}
+
+		c.limits.identityMaxRequestBodySize[identity] = size
+	}
+}
+
+// OptMaxRetrieveManyItems sets the maximum number of objects
+// a processor can return for a RetrieveMany operation. If a processor
+// returns more objects, the request fails with a 400 error asking the
+// client to use pagination. 0, which is the default, means no limit.
+func OptMaxRetrieveManyItems(n int) Option {
+
+	if n < 0 {
+		panic("max retrieve many items must not be negative")
+	}
+
+	return func(c *config) {
+		c.limits.maxRetrieveManyItems = n
+	}
+}
+
+// OptMaxPushMessageSize sets the maximum size in bytes of the messages
+// a push session can receive from its client. If a client sends a bigger
+// message, the session is closed. 0, which is the default, means no limit.
+func OptMaxPushMessageSize(size int64) Option {
+
+	if size < 0 {
+		panic("max push message size must not be negative")
+	}
+
+	return func(c *config) {
+		c.limits.maxPushMessageSize = size
+	}
+}
+
 // OptModel configures the elemental Model for the server.
 //
 // modelManagers is a map of version to elemental.ModelManager.
@@ -471,6 +1002,36 @@ func OptPreStopHook(hook func(Server) error) Option {
 	}
 }
 
+// OptShutdownTimeouts configures the maximum time each component
+// has to drain when the server is stopped.
+//
+// Rest is the time given to the in-flight requests to complete, push is
+// the time given to the push sessions to disconnect once they have been
+// asked to, and health is the time given to the health server to stop.
+// A value of 0 keeps the default, which are respectively 60s, 10s and 1s.
+func OptShutdownTimeouts(rest time.Duration, push time.Duration, health time.Duration) Option {
+	return func(c *config) {
+		c.shutdown.restTimeout = rest
+		c.shutdown.pushTimeout = push
+		c.shutdown.healthTimeout = health
+	}
+}
+
+// OptDrain configures the drain phase of the server.
+//
+// When the server is stopped, the health endpoint starts to report
+// the server as unavailable and new requests receive a 503 with
+// a Retry-After header set to retryAfter, while the in-flight requests
+// are completing. The push sessions are left open for pushGracePeriod,
+// then closed with a going-away code. Default is to close them right away
+// and to ask the clients to retry after 5s.
+func OptDrain(pushGracePeriod time.Duration, retryAfter time.Duration) Option {
+	return func(c *config) {
+		c.shutdown.pushGracePeriod = pushGracePeriod
+		c.shutdown.retryAfter = retryAfter
+	}
+}
+
 // OptTraceCleaner registers a trace cleaner that will be called to
 // let a chance to clean up various sensitive information before
 // sending the trace to the OpenTracing server.
@@ -48,11 +48,49 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.restServer.customListener, ShouldEqual, listener)
 	})
 
+	Convey("Calling OptRestServerListener should work", t, func() {
+		listener := &net.UnixListener{}
+		_, clientcapool, servercerts := loadFixtureCertificates()
+		OptRestServerListener(
+			"tcp6",
+			"[::1]:443",
+			ListenerOptTLS(servercerts, nil),
+			ListenerOptMTLS(clientcapool, tls.RequireAndVerifyClientCert),
+			ListenerOptMaxConnection(2),
+		)(&c)
+		OptRestServerListener("unix", "/tmp/sock", ListenerOptCustomListener(listener))(&c)
+		So(len(c.restServer.listeners), ShouldEqual, 2)
+		So(c.restServer.listeners[0].network, ShouldEqual, "tcp6")
+		So(c.restServer.listeners[0].address, ShouldEqual, "[::1]:443")
+		So(c.restServer.listeners[0].serverCertificates, ShouldResemble, servercerts)
+		So(c.restServer.listeners[0].clientCAPool, ShouldEqual, clientcapool)
+		So(c.restServer.listeners[0].authType, ShouldEqual, tls.RequireAndVerifyClientCert)
+		So(c.restServer.listeners[0].maxConnection, ShouldEqual, 2)
+		So(c.restServer.listeners[0].tlsEnabled(), ShouldBeTrue)
+		So(c.restServer.listeners[1].network, ShouldEqual, "unix")
+		So(c.restServer.listeners[1].customListener, ShouldEqual, listener)
+		So(c.restServer.listeners[1].tlsEnabled(), ShouldBeFalse)
+	})
+
+	Convey("Calling OptRestServerListener with an invalid network should panic", t, func() {
+		So(func() { OptRestServerListener("udp", "1.2.3.4:123") }, ShouldPanicWith, "invalid network 'udp' for rest server listener")
+	})
+
 	Convey("Calling OptMaxConnection should work", t, func() {
 		OptMaxConnection(3)(&c)
 		So(c.restServer.maxConnection, ShouldEqual, 3)
 	})
 
+	Convey("Calling OptH2C should work", t, func() {
+		OptH2C()(&c)
+		So(c.restServer.h2cEnabled, ShouldEqual, true)
+	})
+
+	Convey("Calling OptHTTP3 should work", t, func() {
+		OptHTTP3("1.2.3.4:443")(&c)
+		So(c.restServer.http3ListenAddress, ShouldEqual, "1.2.3.4:443")
+	})
+
 	Convey("Calling OptTimeouts should work", t, func() {
 		OptTimeouts(1*time.Second, 2*time.Second, 3*time.Second)(&c)
 		So(c.restServer.readTimeout, ShouldEqual, 1*time.Second)
@@ -105,6 +143,109 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.pushServer.publishHandler, ShouldEqual, h)
 	})
 
+	Convey("Calling OptPushDispatchWorkers should work", t, func() {
+		OptPushDispatchWorkers(4, 100)(&c)
+		So(c.pushServer.dispatchWorkers, ShouldEqual, 4)
+		So(c.pushServer.dispatchQueueSize, ShouldEqual, 100)
+	})
+
+	Convey("Calling OptPushDispatchWorkers with invalid values should panic", t, func() {
+		So(func() { OptPushDispatchWorkers(0, 100) }, ShouldPanicWith, "push dispatch workers must be greater than 0")
+		So(func() { OptPushDispatchWorkers(1, 0) }, ShouldPanicWith, "push dispatch queue size must be greater than 0")
+	})
+
+	Convey("Calling OptHealthPushSessions should work", t, func() {
+		OptHealthPushSessions(func(*http.Request) bool { return true })(&c)
+		So(c.healthServer.pushSessionsEnabled, ShouldBeTrue)
+		So(c.healthServer.pushSessionsAuthorizer, ShouldNotBeNil)
+		So(func() { OptHealthPushSessions(nil) }, ShouldPanicWith, "push sessions authorizer must not be nil")
+	})
+
+	Convey("Calling OptPushSessionReauthentication should work", t, func() {
+		OptPushSessionReauthentication(time.Minute)(&c)
+		So(c.pushServer.reauthEnabled, ShouldBeTrue)
+		So(c.pushServer.reauthInterval, ShouldEqual, time.Minute)
+	})
+
+	Convey("Calling OptPushSessionReauthentication with a negative interval should panic", t, func() {
+		So(func() { OptPushSessionReauthentication(-time.Minute) }, ShouldPanicWith, "reauthentication interval must not be negative")
+	})
+
+	Convey("Calling OptPushMessageHandler should work", t, func() {
+		h := &mockPushMessageHandler{}
+		OptPushMessageHandler(h)(&c)
+		So(c.pushServer.messageHandler, ShouldEqual, h)
+	})
+
+	Convey("Calling OptPushSessionAPI should work", t, func() {
+		OptPushSessionAPI()(&c)
+		So(c.pushServer.apiEnabled, ShouldBeTrue)
+	})
+
+	Convey("Calling OptPushCompression should work", t, func() {
+		OptPushCompression(5, 1024)(&c)
+		So(c.pushServer.compressionEnabled, ShouldBeTrue)
+		So(c.pushServer.compressionLevel, ShouldEqual, 5)
+		So(c.pushServer.compressionThreshold, ShouldEqual, 1024)
+	})
+
+	Convey("Calling OptPushCompression with an invalid level should panic", t, func() {
+		So(func() { OptPushCompression(10, 0) }, ShouldPanicWith, "push compression level must be between -2 and 9")
+	})
+
+	Convey("Calling OptPushCompression with a negative threshold should panic", t, func() {
+		So(func() { OptPushCompression(1, -1) }, ShouldPanicWith, "push compression threshold must not be negative")
+	})
+
+	Convey("Calling OptPushAllowedOrigins should work", t, func() {
+		OptPushAllowedOrigins("https://*.example.com", "https://example.com")(&c)
+		So(c.pushServer.allowedOrigins, ShouldResemble, []string{"https://*.example.com", "https://example.com"})
+	})
+
+	Convey("Calling OptPushMaxSessions should work", t, func() {
+		OptPushMaxSessions(10)(&c)
+		So(c.pushServer.maxSessions, ShouldEqual, 10)
+	})
+
+	Convey("Calling OptPushMaxSessions with a negative value should panic", t, func() {
+		So(func() { OptPushMaxSessions(-1) }, ShouldPanicWith, "max push sessions must not be negative")
+	})
+
+	Convey("Calling OptPushMaxSessionsPerClaim should work", t, func() {
+		OptPushMaxSessionsPerClaim("@auth:subject", 2)(&c)
+		So(c.pushServer.sessionsClaimKey, ShouldEqual, "@auth:subject")
+		So(c.pushServer.maxSessionsPerClaim, ShouldEqual, 2)
+	})
+
+	Convey("Calling OptPushMaxSessionsPerClaim with invalid values should panic", t, func() {
+		So(func() { OptPushMaxSessionsPerClaim("", 1) }, ShouldPanicWith, "push sessions claim key must not be empty")
+		So(func() { OptPushMaxSessionsPerClaim("@auth:subject", 0) }, ShouldPanicWith, "max push sessions per claim must be greater than 0")
+	})
+
+	Convey("Calling OptPushHandshakeRateLimiting should work", t, func() {
+		OptPushHandshakeRateLimiting(10, 20)(&c)
+		So(c.pushServer.handshakeLimiter, ShouldNotBeNil)
+		So(c.pushServer.handshakeLimiter.Limit(), ShouldEqual, rate.Limit(10))
+		So(c.pushServer.handshakeLimiter.Burst(), ShouldEqual, 20)
+	})
+
+	Convey("Calling OptPushHandshakeRateLimiting with invalid values should panic", t, func() {
+		So(func() { OptPushHandshakeRateLimiting(0, 20) }, ShouldPanicWith, "push handshake rate limit must be greater than 0")
+		So(func() { OptPushHandshakeRateLimiting(10, 0) }, ShouldPanicWith, "push handshake rate limit burst must be greater than 0")
+	})
+
+	Convey("Calling OptPushBatching should work", t, func() {
+		OptPushBatching(100*time.Millisecond, 50)(&c)
+		So(c.pushServer.batchingEnabled, ShouldBeTrue)
+		So(c.pushServer.batchMaxWindow, ShouldEqual, 100*time.Millisecond)
+		So(c.pushServer.batchMaxSize, ShouldEqual, 50)
+	})
+
+	Convey("Calling OptPushBatching with invalid values should panic", t, func() {
+		So(func() { OptPushBatching(0, 1) }, ShouldPanicWith, "push batching window must be greater than 0")
+		So(func() { OptPushBatching(time.Second, 0) }, ShouldPanicWith, "push batch size must be greater than 0")
+	})
+
 	Convey("Calling OptHealthServer should work", t, func() {
 		h := func() error { return nil }
 		OptHealthServer("1.2.3.4:123", h)(&c)
@@ -168,6 +309,29 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.tls.serverCertificatesRetrieverFunc, ShouldEqual, r)
 	})
 
+	Convey("Calling OptTLSReloader should work", t, func() {
+		loader := func() (*TLSMaterial, error) { return nil, nil }
+		OptTLSReloader(loader, time.Minute, time.Hour)(&c)
+		So(c.tls.reloader, ShouldNotBeNil)
+		So(c.tls.reloader.interval, ShouldEqual, time.Minute)
+		So(c.tls.reloader.expiryWarning, ShouldEqual, time.Hour)
+	})
+
+	Convey("Calling OptTLSReloader with a nil loader should panic", t, func() {
+		So(func() { OptTLSReloader(nil, time.Minute, 0) }, ShouldPanicWith, "loader must not be nil")
+	})
+
+	Convey("Calling OptTLSReloader with an invalid interval should panic", t, func() {
+		loader := func() (*TLSMaterial, error) { return nil, nil }
+		So(func() { OptTLSReloader(loader, 0, 0) }, ShouldPanicWith, "interval must be positive")
+	})
+
+	Convey("Calling OptTLSPolicy should work", t, func() {
+		policy, _ := NewTLSPolicy(TLSProfileFIPS)
+		OptTLSPolicy(policy)(&c)
+		So(*c.tls.policy, ShouldResemble, policy)
+	})
+
 	Convey("Calling OptTLSNextProtos should work", t, func() {
 		OptTLSNextProtos([]string{"h2"})(&c)
 		So(c.tls.nextProtos, ShouldResemble, []string{"h2"})
@@ -222,6 +386,126 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.rateLimiting.apiRateLimiters[ident].condition, ShouldEqual, cond)
 	})
 
+	Convey("Calling OptAdmissionControl should work", t, func() {
+		f := func(*elemental.Request) RequestPriority { return RequestPriorityBulk }
+		OptAdmissionControl(
+			time.Second,
+			AdmissionControlOptLimits(50, 5, 500),
+			AdmissionControlOptBackoffRatio(0.5),
+			AdmissionControlOptRetryAfter(2*time.Second),
+			AdmissionControlOptPriorityFunc(f),
+		)(&c)
+		So(c.admissionControl.targetLatency, ShouldEqual, time.Second)
+		So(c.admissionControl.initialLimit, ShouldEqual, 50)
+		So(c.admissionControl.minLimit, ShouldEqual, 5)
+		So(c.admissionControl.maxLimit, ShouldEqual, 500)
+		So(c.admissionControl.backoffRatio, ShouldEqual, 0.5)
+		So(c.admissionControl.retryAfter, ShouldEqual, 2*time.Second)
+		So(c.admissionControl.priorityFunc, ShouldEqual, f)
+	})
+
+	Convey("Calling OptAdmissionControl with invalid values should panic", t, func() {
+		So(func() { OptAdmissionControl(0) }, ShouldPanicWith, "admission target latency must be positive")
+		So(func() { AdmissionControlOptLimits(5, 10, 20) }, ShouldPanicWith, "invalid admission limits 5, 10, 20: they must be positive and min <= initial <= max")
+		So(func() { AdmissionControlOptBackoffRatio(1) }, ShouldPanicWith, "invalid admission backoff ratio 1.000000: it must be between 0 and 1 excluded")
+		So(func() { AdmissionControlOptRetryAfter(time.Millisecond) }, ShouldPanicWith, "invalid admission retry after 1ms: it must be at least 1s")
+	})
+
+	Convey("Calling OptResponseCache should work", t, func() {
+		c := config{}
+		OptResponseCacheScopeFunc(func(Context) string { return "scope" })(&c)
+		OptResponseCacheMaxEntries(42)(&c)
+		OptResponseCache(testmodel.ListIdentity, time.Minute)(&c)
+		OptResponseCache(testmodel.TaskIdentity, time.Second)(&c)
+		So(c.responseCaching.ttls, ShouldResemble, map[elemental.Identity]time.Duration{
+			testmodel.ListIdentity: time.Minute,
+			testmodel.TaskIdentity: time.Second,
+		})
+		So(c.responseCaching.scopeFunc(nil), ShouldEqual, "scope")
+		So(c.responseCaching.maxEntries, ShouldEqual, 42)
+	})
+
+	Convey("Calling the response cache options with invalid values should panic", t, func() {
+		So(func() { OptResponseCache(testmodel.ListIdentity, 0) }, ShouldPanicWith, "response cache ttl must be positive")
+		So(func() { OptResponseCacheScopeFunc(nil) }, ShouldPanicWith, "response cache scope func must not be nil")
+		So(func() { OptResponseCacheMaxEntries(0) }, ShouldPanicWith, "response cache max entries must be positive")
+	})
+
+	Convey("Calling OptRequestTimeout should work", t, func() {
+		OptRequestTimeout(3 * time.Second)(&c)
+		So(c.requestTimeouts.defaultTimeout, ShouldEqual, 3*time.Second)
+	})
+
+	Convey("Calling OptRequestTimeout with a negative timeout should panic", t, func() {
+		So(func() { OptRequestTimeout(-1) }, ShouldPanicWith, "request timeout must not be negative")
+	})
+
+	Convey("Calling OptIdentityRequestTimeout should work", t, func() {
+		OptIdentityRequestTimeout(testmodel.ListIdentity, time.Second)(&c)
+		OptIdentityRequestTimeout(testmodel.ListIdentity, time.Minute, elemental.OperationRetrieveMany, elemental.OperationInfo)(&c)
+		So(c.requestTimeouts.identityTimeouts, ShouldResemble, map[elemental.Identity]map[elemental.Operation]time.Duration{
+			testmodel.ListIdentity: {
+				"":                              time.Second,
+				elemental.OperationRetrieveMany: time.Minute,
+				elemental.OperationInfo:         time.Minute,
+			},
+		})
+	})
+
+	Convey("Calling OptIdentityRequestTimeout with a negative timeout should panic", t, func() {
+		So(func() { OptIdentityRequestTimeout(testmodel.ListIdentity, -1) }, ShouldPanicWith, "request timeout must not be negative")
+	})
+
+	Convey("Calling OptClientRequestTimeout should work", t, func() {
+		OptClientRequestTimeout(10 * time.Second)(&c)
+		So(c.requestTimeouts.clientEnabled, ShouldBeTrue)
+		So(c.requestTimeouts.clientMaxTimeout, ShouldEqual, 10*time.Second)
+	})
+
+	Convey("Calling OptClientRequestTimeout with a negative max should panic", t, func() {
+		So(func() { OptClientRequestTimeout(-1) }, ShouldPanicWith, "max client request timeout must not be negative")
+	})
+
+	Convey("Calling OptMaxRequestBodySize should work", t, func() {
+		OptMaxRequestBodySize(1024)(&c)
+		So(c.limits.maxRequestBodySize, ShouldEqual, 1024)
+	})
+
+	Convey("Calling OptMaxRequestBodySize with a negative size should panic", t, func() {
+		So(func() { OptMaxRequestBodySize(-1) }, ShouldPanicWith, "max request body size must not be negative")
+	})
+
+	Convey("Calling OptIdentityMaxRequestBodySize should work", t, func() {
+		OptIdentityMaxRequestBodySize(testmodel.ListIdentity, 2048)(&c)
+		OptIdentityMaxRequestBodySize(testmodel.TaskIdentity, 0)(&c)
+		So(c.limits.identityMaxRequestBodySize, ShouldResemble, map[elemental.Identity]int64{
+			testmodel.ListIdentity: 2048,
+			testmodel.TaskIdentity: 0,
+		})
+	})
+
+	Convey("Calling OptIdentityMaxRequestBodySize with a negative size should panic", t, func() {
+		So(func() { OptIdentityMaxRequestBodySize(testmodel.ListIdentity, -1) }, ShouldPanicWith, "max request body size must not be negative")
+	})
+
+	Convey("Calling OptMaxRetrieveManyItems should work", t, func() {
+		OptMaxRetrieveManyItems(100)(&c)
+		So(c.limits.maxRetrieveManyItems, ShouldEqual, 100)
+	})
+
+	Convey("Calling OptMaxRetrieveManyItems with a negative number should panic", t, func() {
+		So(func() { OptMaxRetrieveManyItems(-1) }, ShouldPanicWith, "max retrieve many items must not be negative")
+	})
+
+	Convey("Calling OptMaxPushMessageSize should work", t, func() {
+		OptMaxPushMessageSize(4096)(&c)
+		So(c.limits.maxPushMessageSize, ShouldEqual, 4096)
+	})
+
+	Convey("Calling OptMaxPushMessageSize with a negative size should panic", t, func() {
+		So(func() { OptMaxPushMessageSize(-1) }, ShouldPanicWith, "max push message size must not be negative")
+	})
+
 	Convey("Calling OptModel should work", t, func() {
 		m := map[int]elemental.ModelManager{0: testmodel.Manager()}
 		OptModel(m)(&c)
@@ -283,6 +567,19 @@ func TestBahamut_Options(t *testing.T) {
 		So(c.hooks.preStop, ShouldEqual, f)
 	})
 
+	Convey("Calling OptShutdownTimeouts should work", t, func() {
+		OptShutdownTimeouts(1*time.Second, 2*time.Second, 3*time.Second)(&c)
+		So(c.shutdown.restTimeout, ShouldEqual, 1*time.Second)
+		So(c.shutdown.pushTimeout, ShouldEqual, 2*time.Second)
+		So(c.shutdown.healthTimeout, ShouldEqual, 3*time.Second)
+	})
+
+	Convey("Calling OptDrain should work", t, func() {
+		OptDrain(4*time.Second, 5*time.Second)(&c)
+		So(c.shutdown.pushGracePeriod, ShouldEqual, 4*time.Second)
+		So(c.shutdown.retryAfter, ShouldEqual, 5*time.Second)
+	})
+
 	Convey("Calling OptTraceCleaner should work", t, func() {
 		f := func(elemental.Identity, []byte) []byte {
 			return nil
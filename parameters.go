@@ -0,0 +1,212 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+// A RouteParameter describes a query parameter accepted by a route.
+type RouteParameter struct {
+	Name           string      `msgpack:"name" json:"name"`
+	Type           string      `msgpack:"type" json:"type"`
+	AllowedChoices []string    `msgpack:"allowedChoices,omitempty" json:"allowedChoices,omitempty"`
+	DefaultValue   interface{} `msgpack:"defaultValue,omitempty" json:"defaultValue,omitempty"`
+	Multiple       bool        `msgpack:"multiple,omitempty" json:"multiple,omitempty"`
+}
+
+// routeParameters returns the RouteParameters
+// defined in the given relationship infos, sorted by name.
+func routeParameters(infos ...*elemental.RelationshipInfo) []RouteParameter {
+
+	var out []RouteParameter
+	seen := map[string]struct{}{}
+
+	for _, info := range infos {
+
+		if info == nil {
+			continue
+		}
+
+		for _, def := range info.Parameters {
+
+			if _, ok := seen[def.Name]; ok {
+				continue
+			}
+			seen[def.Name] = struct{}{}
+
+			out = append(out, RouteParameter{
+				Name:           def.Name,
+				Type:           string(def.Type),
+				AllowedChoices: def.AllowedChoices,
+				DefaultValue:   def.DefaultValue,
+				Multiple:       def.Multiple,
+			})
+		}
+	}
+
+	sort.Slice(out, func(i int, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
+
+// relationshipInfo returns the elemental.RelationshipInfo of the
+// operation of the given request, or nil if there is none.
+func relationshipInfo(modelManager elemental.ModelManager, request *elemental.Request) *elemental.RelationshipInfo {
+
+	if modelManager == nil {
+		return nil
+	}
+
+	relationship, ok := modelManager.Relationships()[request.Identity]
+	if !ok || relationship == nil {
+		return nil
+	}
+
+	var infos map[string]*elemental.RelationshipInfo
+
+	switch request.Operation {
+	case elemental.OperationRetrieveMany:
+		infos = relationship.RetrieveMany
+	case elemental.OperationRetrieve:
+		infos = relationship.Retrieve
+	case elemental.OperationCreate:
+		infos = relationship.Create
+	case elemental.OperationUpdate:
+		infos = relationship.Update
+	case elemental.OperationDelete:
+		infos = relationship.Delete
+	case elemental.OperationPatch:
+		infos = relationship.Patch
+	case elemental.OperationInfo:
+		infos = relationship.Info
+	}
+
+	parent := request.ParentIdentity.Name
+	if parent == "" {
+		parent = "root"
+	}
+
+	if info, ok := infos[parent]; ok {
+		return info
+	}
+
+	return infos["root"]
+}
+
+// validateParameters validates the given query parameters against
+// the parameter definitions and requirements of the given relationship
+// info. It returns an elemental.Errors with a 422 error for every problem,
+// or nil if the parameters are valid.
+func validateParameters(info *elemental.RelationshipInfo, query url.Values, parameters elemental.Parameters) error {
+
+	if info == nil {
+		return nil
+	}
+
+	var errs []error
+
+	for _, def := range info.Parameters {
+
+		values, ok := query[def.Name]
+		if !ok {
+			continue
+		}
+
+		if !def.Multiple && len(values) > 1 {
+			errs = append(errs, makeParameterError(def.Name, fmt.Sprintf("Parameter '%s' must not be given more than once", def.Name)))
+		}
+
+		for _, v := range values {
+			if msg := checkParameterValue(def, v); msg != "" {
+				errs = append(errs, makeParameterError(def.Name, fmt.Sprintf("Invalid value '%s' for parameter '%s': %s", v, def.Name, msg)))
+			}
+		}
+	}
+
+	if err := parameters.Validate(info.RequiredParameters); err != nil {
+		msg := err.Error()
+		if e, ok := err.(elemental.Error); ok {
+			msg = e.Description
+		}
+		errs = append(errs, makeParameterError("", msg))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return elemental.NewErrors(errs...)
+}
+
+// checkParameterValue checks the given value against the given
+// parameter definition. It returns the reason why the value is
+// invalid, or an empty string.
+func checkParameterValue(def elemental.ParameterDefinition, value string) string {
+
+	switch def.Type {
+
+	case elemental.ParameterTypeInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "it must be an integer"
+		}
+
+	case elemental.ParameterTypeFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "it must be a float"
+		}
+
+	case elemental.ParameterTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "it must be a boolean"
+		}
+
+	case elemental.ParameterTypeTime:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "it must be a RFC3339 date"
+		}
+
+	case elemental.ParameterTypeDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return "it must be a duration"
+		}
+
+	case elemental.ParameterTypeEnum:
+		for _, choice := range def.AllowedChoices {
+			if value == choice {
+				return ""
+			}
+		}
+		return "it must be one of " + strings.Join(def.AllowedChoices, ", ")
+	}
+
+	return ""
+}
+
+// makeParameterError returns a 422 error about the given parameter.
+func makeParameterError(name string, description string) elemental.Error {
+
+	err := elemental.NewError("Unprocessable Entity", description, "bahamut", http.StatusUnprocessableEntity)
+	if name != "" {
+		err.Data = map[string]interface{}{"parameter": name}
+	}
+
+	return err
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+func TestParameters_checkParameterValue(t *testing.T) {
+
+	Convey("Given I have some parameter definitions", t, func() {
+
+		tests := []struct {
+			def     elemental.ParameterDefinition
+			valid   []string
+			invalid []string
+		}{
+			{elemental.ParameterDefinition{Type: elemental.ParameterTypeString}, []string{"a", ""}, nil},
+			{elemental.ParameterDefinition{Type: elemental.ParameterTypeInt}, []string{"1", "-2"}, []string{"a", "1.2"}},
+			{elemental.ParameterDefinition{Type: elemental.ParameterTypeFloat}, []string{"1", "1.2"}, []string{"a"}},
+			{elemental.ParameterDefinition{Type: elemental.ParameterTypeBool}, []string{"true", "false"}, []string{"yep"}},
+			{elemental.ParameterDefinition{Type: elemental.ParameterTypeTime}, []string{"2020-01-01T00:00:00Z"}, []string{"yesterday"}},
+			{elemental.ParameterDefinition{Type: elemental.ParameterTypeDuration}, []string{"1h"}, []string{"1 hour"}},
+			{elemental.ParameterDefinition{Type: elemental.ParameterTypeEnum, AllowedChoices: []string{"a", "b"}}, []string{"a", "b"}, []string{"c"}},
+		}
+
+		Convey("Then the values should be checked correctly", func() {
+			for _, tt := range tests {
+				for _, v := range tt.valid {
+					So(checkParameterValue(tt.def, v), ShouldBeEmpty)
+				}
+				for _, v := range tt.invalid {
+					So(checkParameterValue(tt.def, v), ShouldNotBeEmpty)
+				}
+			}
+		})
+	})
+}
+
+func TestParameters_validateParameters(t *testing.T) {
+
+	Convey("Given I have a relationship info", t, func() {
+
+		info := &elemental.RelationshipInfo{
+			Parameters: []elemental.ParameterDefinition{
+				{Name: "limit", Type: elemental.ParameterTypeInt},
+				{Name: "mode", Type: elemental.ParameterTypeEnum, AllowedChoices: []string{"fast", "slow"}},
+				{Name: "tag", Type: elemental.ParameterTypeString, Multiple: true},
+			},
+		}
+
+		Convey("When I validate valid parameters", func() {
+
+			err := validateParameters(info, url.Values{"limit": {"10"}, "mode": {"fast"}, "tag": {"a", "b"}, "other": {"x"}}, elemental.Parameters{})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I validate invalid parameters", func() {
+
+			err := validateParameters(info, url.Values{"limit": {"a", "b"}, "mode": {"medium"}}, elemental.Parameters{})
+
+			Convey("Then I should get all the errors", func() {
+				So(err, ShouldNotBeNil)
+				errs := err.(elemental.Errors)
+				So(len(errs), ShouldEqual, 4)
+				So(errs.Code(), ShouldEqual, http.StatusUnprocessableEntity)
+				So(errs[0].(elemental.Error).Description, ShouldEqual, "Parameter 'limit' must not be given more than once")
+				So(errs[1].(elemental.Error).Description, ShouldEqual, "Invalid value 'a' for parameter 'limit': it must be an integer")
+				So(errs[2].(elemental.Error).Description, ShouldEqual, "Invalid value 'b' for parameter 'limit': it must be an integer")
+				So(errs[3].(elemental.Error).Description, ShouldEqual, "Invalid value 'medium' for parameter 'mode': it must be one of fast, slow")
+				So(errs[3].(elemental.Error).Data, ShouldResemble, map[string]interface{}{"parameter": "mode"})
+			})
+		})
+
+		Convey("When I validate parameters against missing requirements", func() {
+
+			info.RequiredParameters = elemental.NewParametersRequirement([][][]string{{{"mode"}}})
+
+			err := validateParameters(info, url.Values{}, elemental.Parameters{})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(elemental.Errors).Code(), ShouldEqual, http.StatusUnprocessableEntity)
+			})
+		})
+
+		Convey("When I validate parameters without relationship info", func() {
+
+			err := validateParameters(nil, url.Values{"limit": {"a"}}, elemental.Parameters{})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestParameters_relationshipInfo(t *testing.T) {
+
+	Convey("Given I have a model manager", t, func() {
+
+		m := testmodel.Manager()
+
+		Convey("When I get the info of a retrieve many on the root", func() {
+
+			req := elemental.NewRequest()
+			req.Identity = testmodel.ListIdentity
+			req.Operation = elemental.OperationRetrieveMany
+
+			Convey("Then it should be found", func() {
+				So(relationshipInfo(m, req), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I get the info of a retrieve many on a parent", func() {
+
+			req := elemental.NewRequest()
+			req.Identity = testmodel.TaskIdentity
+			req.ParentIdentity = testmodel.ListIdentity
+			req.Operation = elemental.OperationRetrieveMany
+
+			Convey("Then it should be found", func() {
+				So(relationshipInfo(m, req), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I get the info of an unknown identity", func() {
+
+			req := elemental.NewRequest()
+			req.Identity = elemental.MakeIdentity("nope", "nopes")
+			req.Operation = elemental.OperationRetrieveMany
+
+			Convey("Then it should be nil", func() {
+				So(relationshipInfo(m, req), ShouldBeNil)
+				So(relationshipInfo(nil, req), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestParameters_routeParameters(t *testing.T) {
+
+	Convey("Given I have some relationship infos", t, func() {
+
+		info1 := &elemental.RelationshipInfo{
+			Parameters: []elemental.ParameterDefinition{
+				{Name: "mode", Type: elemental.ParameterTypeEnum, AllowedChoices: []string{"fast", "slow"}, DefaultValue: "fast"},
+			},
+		}
+		info2 := &elemental.RelationshipInfo{
+			Parameters: []elemental.ParameterDefinition{
+				{Name: "mode", Type: elemental.ParameterTypeEnum, AllowedChoices: []string{"fast", "slow"}, DefaultValue: "fast"},
+				{Name: "tag", Type: elemental.ParameterTypeString, Multiple: true},
+			},
+		}
+
+		Convey("When I get the route parameters", func() {
+
+			params := routeParameters(info2, nil, info1)
+
+			Convey("Then they should be correct", func() {
+				So(params, ShouldResemble, []RouteParameter{
+					{Name: "mode", Type: "enum", AllowedChoices: []string{"fast", "slow"}, DefaultValue: "fast"},
+					{Name: "tag", Type: "string", Multiple: true},
+				})
+			})
+		})
+	})
+}
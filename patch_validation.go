@@ -0,0 +1,159 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"go.aporeto.io/elemental"
+)
+
+// validateSparse validates the attributes present in the given sparse
+// identifiable. Present attributes that are read only or creation only
+// are rejected, and the present attributes are validated by applying the
+// sparse identifiable on a new identifiable and only keeping the
+// validation errors concerning them. It returns errors of the same
+// shape as the ones returned by elemental.Validatable.
+func validateSparse(modelManager elemental.ModelManager, identity elemental.Identity, sparse elemental.Identifiable) error {
+
+	present, err := presentAttributes(sparse)
+	if err != nil {
+		return elemental.NewError("Bad Request", err.Error(), "bahamut", http.StatusBadRequest)
+	}
+
+	if len(present) == 0 {
+		return nil
+	}
+
+	obj := modelManager.Identifiable(identity)
+	if obj == nil {
+		return nil
+	}
+
+	var errs []error
+
+	if s, ok := obj.(elemental.AttributeSpecifiable); ok {
+
+		specs := s.AttributeSpecifications()
+		names := make([]string, 0, len(specs))
+		for name := range specs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+
+			spec := specs[name]
+
+			if _, ok := present[spec.Name]; !ok || spec.Identifier {
+				continue
+			}
+
+			switch {
+			case spec.ReadOnly:
+				errs = append(errs, makeAttributeError("Read Only Error", fmt.Sprintf("Field %s is read only. You cannot modify its value.", spec.Name), spec.Name))
+			case spec.CreationOnly:
+				errs = append(errs, makeAttributeError("Creation Only Error", fmt.Sprintf("Field %s is a creation only field. You cannot modify its value.", spec.Name), spec.Name))
+			}
+		}
+	}
+
+	p, okPatchable := obj.(elemental.Patchable)
+	sp, okSparse := sparse.(elemental.SparseIdentifiable)
+	v, okValidatable := obj.(elemental.Validatable)
+
+	if okPatchable && okSparse && okValidatable {
+
+		p.Patch(sp)
+
+		for _, e := range flattenErrors(v.Validate()) {
+			if attr := errorAttribute(e); attr != "" {
+				if _, ok := present[attr]; ok {
+					errs = append(errs, e)
+				}
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return elemental.NewErrors(errs...)
+}
+
+// presentAttributes returns the exposed names of the
+// attributes that are set in the given sparse identifiable.
+func presentAttributes(sparse interface{}) (map[string]struct{}, error) {
+
+	data, err := json.Marshal(sparse)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]json.RawMessage{}
+	if err = json.Unmarshal(data, &attrs); err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]struct{}, len(attrs))
+	for k := range attrs {
+		present[k] = struct{}{}
+	}
+
+	return present, nil
+}
+
+// flattenErrors returns the individual errors contained in the given error.
+func flattenErrors(err error) []error {
+
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case elemental.Errors:
+		return []error(e)
+	default:
+		return []error{err}
+	}
+}
+
+// errorAttribute returns the attribute concerned
+// by the given validation error, if any.
+func errorAttribute(err error) string {
+
+	e, ok := err.(elemental.Error)
+	if !ok {
+		return ""
+	}
+
+	switch data := e.Data.(type) {
+	case map[string]interface{}:
+		attr, _ := data["attribute"].(string)
+		return attr
+	case map[string]string:
+		return data["attribute"]
+	}
+
+	return ""
+}
+
+// makeAttributeError returns a validation error about the given attribute.
+func makeAttributeError(title string, description string, attribute string) elemental.Error {
+
+	err := elemental.NewError(title, description, "bahamut", http.StatusUnprocessableEntity)
+	err.Data = map[string]interface{}{"attribute": attribute}
+
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+func TestPatchValidation_validateSparse(t *testing.T) {
+
+	Convey("Given I have a model manager", t, func() {
+
+		m := testmodel.Manager()
+
+		Convey("When I validate a valid sparse object", func() {
+
+			id := "1234"
+			desc := "hello"
+			err := validateSparse(m, testmodel.ListIdentity, &testmodel.SparseList{ID: &id, Description: &desc})
+
+			Convey("Then err should be nil even if absent required attributes are not set", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I validate an empty sparse object", func() {
+
+			err := validateSparse(m, testmodel.ListIdentity, &testmodel.SparseList{})
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I validate a sparse object with an invalid present attribute", func() {
+
+			name := ""
+			err := validateSparse(m, testmodel.ListIdentity, &testmodel.SparseList{Name: &name})
+
+			Convey("Then I should get a validation error on the attribute", func() {
+				So(err, ShouldNotBeNil)
+				errs := err.(elemental.Errors)
+				So(len(errs), ShouldEqual, 1)
+				So(errs.Code(), ShouldEqual, http.StatusUnprocessableEntity)
+				So(errorAttribute(errs[0]), ShouldEqual, "name")
+			})
+		})
+
+		Convey("When I validate a sparse object with read only and creation only attributes", func() {
+
+			ro := "ro"
+			co := "co"
+			err := validateSparse(m, testmodel.ListIdentity, &testmodel.SparseList{ReadOnly: &ro, CreationOnly: &co})
+
+			Convey("Then I should get errors for both", func() {
+				So(err, ShouldNotBeNil)
+				errs := err.(elemental.Errors)
+				So(len(errs), ShouldEqual, 2)
+				So(errs.Code(), ShouldEqual, http.StatusUnprocessableEntity)
+				So(errs[0].(elemental.Error).Description, ShouldEqual, "Field creationOnly is a creation only field. You cannot modify its value.")
+				So(errs[1].(elemental.Error).Description, ShouldEqual, "Field readOnly is read only. You cannot modify its value.")
+			})
+		})
+	})
+}
+
+func TestPatchValidation_presentAttributes(t *testing.T) {
+
+	Convey("Given I have a sparse object", t, func() {
+
+		name := "a"
+		desc := ""
+
+		Convey("When I get its present attributes", func() {
+
+			present, err := presentAttributes(&testmodel.SparseList{Name: &name, Description: &desc})
+
+			Convey("Then they should be correct", func() {
+				So(err, ShouldBeNil)
+				So(present, ShouldResemble, map[string]struct{}{"name": {}, "description": {}})
+			})
+		})
+	})
+}
+
+func TestPatchValidation_errorAttribute(t *testing.T) {
+
+	Convey("Given I have some errors", t, func() {
+
+		e1 := elemental.NewError("a", "b", "c", 422)
+		e1.Data = map[string]interface{}{"attribute": "name"}
+
+		e2 := elemental.NewError("a", "b", "c", 422)
+		e2.Data = map[string]string{"attribute": "description"}
+
+		e3 := elemental.NewError("a", "b", "c", 422)
+
+		Convey("Then errorAttribute should work", func() {
+			So(errorAttribute(e1), ShouldEqual, "name")
+			So(errorAttribute(e2), ShouldEqual, "description")
+			So(errorAttribute(e3), ShouldEqual, "")
+			So(errorAttribute(fmt.Errorf("boom")), ShouldEqual, "")
+		})
+
+		Convey("Then flattenErrors should work", func() {
+			So(flattenErrors(nil), ShouldBeNil)
+			So(flattenErrors(e1), ShouldResemble, []error{e1})
+			So(flattenErrors(elemental.NewErrors(e1, e2)), ShouldResemble, []error{e1, e2})
+		})
+	})
+}
@@ -0,0 +1,286 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// pushSessionsAdminPath is the path of the push
+// sessions admin endpoint on the health server.
+const pushSessionsAdminPath = "/_push/sessions"
+
+// pushSessionsMaxPageSize is the maximum number of
+// sessions returned in a page of the admin endpoint.
+const pushSessionsMaxPageSize = 1000
+
+// A PushSessionInfo contains information about a connected push session.
+type PushSessionInfo struct {
+	ID             string                 `msgpack:"ID" json:"ID"`
+	Claims         []string               `msgpack:"claims" json:"claims"`
+	Tags           []string               `msgpack:"tags,omitempty" json:"tags,omitempty"`
+	ClientIP       string                 `msgpack:"clientIP" json:"clientIP"`
+	Encoding       elemental.EncodingType `msgpack:"encoding" json:"encoding"`
+	PushConfig     *elemental.PushConfig  `msgpack:"pushConfig,omitempty" json:"pushConfig,omitempty"`
+	ConnectedSince time.Time              `msgpack:"connectedSince" json:"connectedSince"`
+	EventsSent     uint64                 `msgpack:"eventsSent" json:"eventsSent"`
+	EventsDropped  uint64                 `msgpack:"eventsDropped" json:"eventsDropped"`
+}
+
+func newPushSessionInfo(session *wsPushSession) PushSessionInfo {
+
+	return PushSessionInfo{
+		ID:             session.Identifier(),
+		Claims:         session.Claims(),
+		Tags:           session.Tags(),
+		ClientIP:       session.ClientIP(),
+		Encoding:       session.encodingWrite,
+		PushConfig:     session.currentPushConfig(),
+		ConnectedSince: session.startTime,
+		EventsSent:     atomic.LoadUint64(&session.eventsSent),
+		EventsDropped:  atomic.LoadUint64(&session.eventsDropped),
+	}
+}
+
+// sessionsInfo returns information about the sessions having all
+// the given claims, ordered by connection time.
+func (n *pushServer) sessionsInfo(claims []string) []PushSessionInfo {
+
+	n.sessionsLock.RLock()
+	out := make([]PushSessionInfo, 0, len(n.sessions))
+	for _, s := range n.sessions {
+		if hasClaims(s, claims) {
+			out = append(out, newPushSessionInfo(s))
+		}
+	}
+	n.sessionsLock.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ConnectedSince.Equal(out[j].ConnectedSince) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].ConnectedSince.Before(out[j].ConnectedSince)
+	})
+
+	return out
+}
+
+// sessionInfo returns information about the session with the given
+// identifier. It returns false if there is no such session.
+func (n *pushServer) sessionInfo(id string) (PushSessionInfo, bool) {
+
+	n.sessionsLock.RLock()
+	s, ok := n.sessions[id]
+	n.sessionsLock.RUnlock()
+
+	if !ok {
+		return PushSessionInfo{}, false
+	}
+
+	return newPushSessionInfo(s), true
+}
+
+// closeSession closes the session with the given identifier.
+// It returns false if there is no such session.
+func (n *pushServer) closeSession(id string, reason string) bool {
+
+	n.sessionsLock.RLock()
+	s, ok := n.sessions[id]
+	n.sessionsLock.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	s.terminate(websocket.ClosePolicyViolation, reason)
+
+	return true
+}
+
+// closeSessionsWithClaims closes all the sessions having all the
+// given claims and returns how many were closed. As a safety, it
+// does nothing if no claim is given.
+func (n *pushServer) closeSessionsWithClaims(claims []string, reason string) int {
+
+	if len(claims) == 0 {
+		return 0
+	}
+
+	n.sessionsLock.RLock()
+	sessions := make([]*wsPushSession, 0, len(n.sessions))
+	for _, s := range n.sessions {
+		if hasClaims(s, claims) {
+			sessions = append(sessions, s)
+		}
+	}
+	n.sessionsLock.RUnlock()
+
+	for _, s := range sessions {
+		s.terminate(websocket.ClosePolicyViolation, reason)
+	}
+
+	return len(sessions)
+}
+
+// hasClaims returns true if the given session has all the given claims.
+func hasClaims(session *wsPushSession, claims []string) bool {
+
+	sessionClaims := session.Claims()
+
+	for _, c := range claims {
+
+		var found bool
+		for _, sc := range sessionClaims {
+			if sc == c {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// servePushSessions serves the push sessions admin endpoint:
+//
+//	GET    /_push/sessions?claim=a=b&page=1&pagesize=100
+//	GET    /_push/sessions/<id>
+//	DELETE /_push/sessions/<id>?reason=...
+//	DELETE /_push/sessions?claim=a=b&reason=...
+func (s *healthServer) servePushSessions(w http.ResponseWriter, r *http.Request) {
+
+	if s.pushServer == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if !s.cfg.healthServer.pushSessionsAuthorizer(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, pushSessionsAdminPath), "/")
+	query := r.URL.Query()
+
+	switch {
+
+	case r.Method == http.MethodGet && id == "":
+
+		sessions := s.pushServer.sessionsInfo(query["claim"])
+
+		page, pageSize, err := parsePagination(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("X-Count-Total", strconv.Itoa(len(sessions)))
+
+		// We check the page before computing its bounds
+		// so they cannot overflow.
+		start, end := len(sessions), len(sessions)
+		if page-1 <= len(sessions)/pageSize {
+			start = (page - 1) * pageSize
+			if end-start > pageSize {
+				end = start + pageSize
+			}
+		}
+
+		writeJSON(w, http.StatusOK, sessions[start:end])
+
+	case r.Method == http.MethodGet:
+
+		info, ok := s.pushServer.sessionInfo(id)
+		if !ok {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, info)
+
+	case r.Method == http.MethodDelete && id == "":
+
+		claims := query["claim"]
+		if len(claims) == 0 {
+			http.Error(w, "At least one claim parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		closed := s.pushServer.closeSessionsWithClaims(claims, query.Get("reason"))
+
+		writeJSON(w, http.StatusOK, map[string]int{"closed": closed})
+
+	case r.Method == http.MethodDelete:
+
+		if !s.pushServer.closeSession(id, query.Get("reason")) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parsePagination returns the page and page size
+// given in the query, with default values of 1 and 100.
+// The page size is capped to pushSessionsMaxPageSize.
+func parsePagination(query url.Values) (page int, pageSize int, err error) {
+
+	page, pageSize = 1, 100
+
+	if v := query.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("Parameter page must be a positive integer")
+		}
+	}
+
+	if v := query.Get("pagesize"); v != "" {
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize < 1 {
+			return 0, 0, fmt.Errorf("Parameter pagesize must be a positive integer")
+		}
+	}
+
+	if pageSize > pushSessionsMaxPageSize {
+		pageSize = pushSessionsMaxPageSize
+	}
+
+	return page, pageSize, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.L().Error("Unable to encode response", zap.Error(err))
+	}
+}
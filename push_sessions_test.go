@@ -0,0 +1,351 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-zoo/bone"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/wsc"
+)
+
+func TestPushSessions_pushServer(t *testing.T) {
+
+	Convey("Given I have a push server with some sessions", t, func() {
+
+		wss := newPushServer(config{}, bone.New(), nil)
+
+		makeSession := func(id string, start time.Time, claims ...string) *wsPushSession {
+			s := newWSPushSession(&http.Request{URL: &url.URL{}, RemoteAddr: "1.2.3.4"}, config{}, nil, elemental.EncodingTypeJSON, elemental.EncodingTypeJSON)
+			s.id = id
+			s.startTime = start
+			s.SetClaims(claims)
+			wss.registerSession(s)
+			return s
+		}
+
+		now := time.Now()
+		s1 := makeSession("s1", now.Add(-time.Minute), "@auth:subject=alice", "@auth:realm=a")
+		s2 := makeSession("s2", now, "@auth:subject=bob", "@auth:realm=a")
+		s3 := makeSession("s3", now.Add(-time.Hour), "@auth:subject=alice", "@auth:realm=b")
+
+		s1.send([]byte("hello"))
+		for i := 0; i < cap(s1.dataCh); i++ {
+			s1.send([]byte("hello"))
+		}
+
+		Convey("When I get the info of all sessions", func() {
+
+			infos := wss.sessionsInfo(nil)
+
+			Convey("Then they should be ordered by connection time", func() {
+				So(len(infos), ShouldEqual, 3)
+				So(infos[0].ID, ShouldEqual, "s3")
+				So(infos[1].ID, ShouldEqual, "s1")
+				So(infos[2].ID, ShouldEqual, "s2")
+				So(infos[1].ClientIP, ShouldEqual, "1.2.3.4")
+				So(infos[1].Encoding, ShouldEqual, elemental.EncodingTypeJSON)
+				So(infos[1].EventsDropped, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I get the info of the sessions with some claims", func() {
+
+			infos := wss.sessionsInfo([]string{"@auth:subject=alice", "@auth:realm=a"})
+
+			Convey("Then only the matching sessions should be returned", func() {
+				So(len(infos), ShouldEqual, 1)
+				So(infos[0].ID, ShouldEqual, "s1")
+				So(infos[0].Claims, ShouldResemble, []string{"@auth:subject=alice", "@auth:realm=a"})
+			})
+		})
+
+		Convey("When I get the info of a session", func() {
+
+			info, ok := wss.sessionInfo("s2")
+			_, notFound := wss.sessionInfo("nope")
+
+			Convey("Then it should be correct", func() {
+				So(ok, ShouldBeTrue)
+				So(info.ID, ShouldEqual, "s2")
+				So(notFound, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I close a session", func() {
+
+			ok := wss.closeSession("s2", "bye")
+			notFound := wss.closeSession("nope", "bye")
+
+			Convey("Then it should be asked to terminate", func() {
+				So(ok, ShouldBeTrue)
+				So(notFound, ShouldBeFalse)
+				t := <-s2.terminateCh
+				So(t.code, ShouldEqual, websocket.ClosePolicyViolation)
+				So(t.err.Description, ShouldEqual, "bye")
+			})
+		})
+
+		Convey("When I close the sessions with some claims", func() {
+
+			closed := wss.closeSessionsWithClaims([]string{"@auth:subject=alice"}, "revoked")
+
+			Convey("Then the matching sessions should be asked to terminate", func() {
+				So(closed, ShouldEqual, 2)
+				So(len(s1.terminateCh), ShouldEqual, 1)
+				So(len(s2.terminateCh), ShouldEqual, 0)
+				So(len(s3.terminateCh), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I close the sessions without claims", func() {
+
+			closed := wss.closeSessionsWithClaims(nil, "oops")
+
+			Convey("Then nothing should be closed", func() {
+				So(closed, ShouldEqual, 0)
+				So(len(s1.terminateCh), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestPushSessions_terminate(t *testing.T) {
+
+	Convey("Given I have a listening session", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		unregistered := make(chan struct{})
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{RawQuery: "enableErrors=true"}}).WithContext(ctx),
+			config{},
+			func(*wsPushSession) { close(unregistered) },
+			elemental.EncodingTypeJSON,
+			elemental.EncodingTypeJSON,
+		)
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+
+		go s.listen()
+
+		Convey("When I terminate it with a reason", func() {
+
+			s.terminate(websocket.ClosePolicyViolation, "revoked")
+
+			var data []byte
+			select {
+			case data = <-conn.LastWrite():
+			case <-time.After(time.Second):
+			}
+
+			Convey("Then the client should receive the reason and the session should stop", func() {
+				var event elemental.Event
+				So(elemental.Decode(elemental.EncodingTypeJSON, data, &event), ShouldBeNil)
+				So(event.Type, ShouldEqual, elemental.EventError)
+
+				var ee elemental.Error
+				So(elemental.Decode(event.Encoding, event.JSONData, &ee), ShouldBeNil)
+				So(ee.Description, ShouldEqual, "revoked")
+
+				select {
+				case <-unregistered:
+				case <-time.After(time.Second):
+					So("session should have been unregistered", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+}
+
+func TestPushSessions_healthServer(t *testing.T) {
+
+	Convey("Given I have a health server with the push sessions admin endpoint", t, func() {
+
+		var authorized = true
+
+		cfg := config{}
+		cfg.healthServer.pushSessionsEnabled = true
+		cfg.healthServer.pushSessionsAuthorizer = func(*http.Request) bool { return authorized }
+
+		wss := newPushServer(cfg, bone.New(), nil)
+		hs := newHealthServer(cfg)
+		hs.pushServer = wss
+
+		for _, id := range []string{"s1", "s2", "s3"} {
+			s := newWSPushSession(&http.Request{URL: &url.URL{}}, cfg, nil, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
+			s.id = id
+			s.SetClaims([]string{"@auth:subject=" + id})
+			wss.registerSession(s)
+		}
+
+		do := func(method string, target string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			hs.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+			return w
+		}
+
+		Convey("When I list the sessions with pagination", func() {
+
+			w := do(http.MethodGet, "/_push/sessions?page=2&pagesize=2")
+
+			var infos []PushSessionInfo
+			_ = json.Unmarshal(w.Body.Bytes(), &infos)
+
+			Convey("Then the response should be correct", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("X-Count-Total"), ShouldEqual, "3")
+				So(len(infos), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I list the sessions with a page far after the last one", func() {
+
+			w := do(http.MethodGet, "/_push/sessions?page=9223372036854775807&pagesize=9223372036854775807")
+
+			var infos []PushSessionInfo
+			_ = json.Unmarshal(w.Body.Bytes(), &infos)
+
+			Convey("Then the page should be empty", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("X-Count-Total"), ShouldEqual, "3")
+				So(len(infos), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I list the sessions with a page size bigger than the maximum", func() {
+
+			w := do(http.MethodGet, "/_push/sessions?page=1&pagesize=9223372036854775807")
+
+			var infos []PushSessionInfo
+			_ = json.Unmarshal(w.Body.Bytes(), &infos)
+
+			Convey("Then all the sessions should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(len(infos), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I list the sessions with a claim", func() {
+
+			w := do(http.MethodGet, "/_push/sessions?claim=@auth:subject=s2")
+
+			var infos []PushSessionInfo
+			_ = json.Unmarshal(w.Body.Bytes(), &infos)
+
+			Convey("Then the response should be correct", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(len(infos), ShouldEqual, 1)
+				So(infos[0].ID, ShouldEqual, "s2")
+			})
+		})
+
+		Convey("When I list the sessions with an invalid page", func() {
+
+			w := do(http.MethodGet, "/_push/sessions?page=0")
+
+			Convey("Then I should get a 400", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Body.String(), ShouldEqual, "Parameter page must be a positive integer\n")
+			})
+		})
+
+		Convey("When I get a session", func() {
+
+			w1 := do(http.MethodGet, "/_push/sessions/s1")
+			w2 := do(http.MethodGet, "/_push/sessions/nope")
+
+			var info PushSessionInfo
+			_ = json.Unmarshal(w1.Body.Bytes(), &info)
+
+			Convey("Then the responses should be correct", func() {
+				So(w1.Code, ShouldEqual, http.StatusOK)
+				So(info.ID, ShouldEqual, "s1")
+				So(w2.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When I delete a session", func() {
+
+			w1 := do(http.MethodDelete, "/_push/sessions/s1?reason=bye")
+			w2 := do(http.MethodDelete, "/_push/sessions/nope")
+
+			Convey("Then the responses should be correct", func() {
+				So(w1.Code, ShouldEqual, http.StatusNoContent)
+				So(w2.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When I delete the sessions with a claim", func() {
+
+			w := do(http.MethodDelete, "/_push/sessions?claim=@auth:subject=s3")
+
+			Convey("Then the response should be correct", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, "{\"closed\":1}\n")
+			})
+		})
+
+		Convey("When I delete the sessions without a claim", func() {
+
+			w := do(http.MethodDelete, "/_push/sessions")
+
+			Convey("Then I should get a 400", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When I call the endpoint with an unsupported method", func() {
+
+			w := do(http.MethodPost, "/_push/sessions")
+
+			Convey("Then I should get a 405", func() {
+				So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			})
+		})
+
+		Convey("When I call the endpoint without being authorized", func() {
+
+			authorized = false
+			w := do(http.MethodGet, "/_push/sessions")
+
+			Convey("Then I should get a 403", func() {
+				So(w.Code, ShouldEqual, http.StatusForbidden)
+			})
+		})
+	})
+
+	Convey("Given I have a health server without the push sessions admin endpoint", t, func() {
+
+		hs := newHealthServer(config{})
+
+		Convey("When I list the sessions", func() {
+
+			w := httptest.NewRecorder()
+			hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_push/sessions", nil))
+
+			Convey("Then I should get a 404", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
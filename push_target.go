@@ -0,0 +1,154 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"fmt"
+
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// targetedPushTopicSuffix is appended to the push topic
+// to get the topic of the targeted pushes.
+const targetedPushTopicSuffix = ".targeted"
+
+// A TaggableSession is a PushSession that can be tagged. A
+// SessionAuthenticator or a PushDispatchHandler can type assert
+// the PushSession it receives to set user defined tags on the
+// session, so it can be targeted with Server.PushTo.
+type TaggableSession interface {
+	PushSession
+
+	// SetTags sets the tags of the session.
+	SetTags([]string)
+
+	// Tags returns the tags of the session.
+	Tags() []string
+}
+
+// A PushTarget selects the push sessions receiving a targeted push.
+// A session is selected if its identifier is one of the SessionIDs,
+// if it has all the Claims, or if it has one of the Tags. An empty
+// PushTarget selects no session.
+type PushTarget struct {
+	SessionIDs []string `msgpack:"sessionIDs,omitempty" json:"sessionIDs,omitempty"`
+	Claims     []string `msgpack:"claims,omitempty" json:"claims,omitempty"`
+	Tags       []string `msgpack:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// IsEmpty returns true if the PushTarget selects no session.
+func (t PushTarget) IsEmpty() bool {
+	return len(t.SessionIDs) == 0 && len(t.Claims) == 0 && len(t.Tags) == 0
+}
+
+// matches returns true if the given session is selected by the target.
+func (t PushTarget) matches(session *wsPushSession) bool {
+
+	for _, id := range t.SessionIDs {
+		if id == session.Identifier() {
+			return true
+		}
+	}
+
+	if len(t.Claims) > 0 && hasClaims(session, t.Claims) {
+		return true
+	}
+
+	if len(t.Tags) > 0 {
+		for _, tag := range session.Tags() {
+			for _, want := range t.Tags {
+				if tag == want {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// A targetedPush is the envelope of a targeted push
+// sent to all the instances through the PubSubClient.
+type targetedPush struct {
+	Target PushTarget         `msgpack:"target" json:"target"`
+	Events []*elemental.Event `msgpack:"events" json:"events"`
+}
+
+// pushTo sends the given events to the sessions selected by the given
+// target. If a PubSubClient is configured, the push is published so
+// all the instances deliver it to their own sessions. Otherwise, it is
+// only delivered to the local sessions.
+func (n *pushServer) pushTo(target PushTarget, events ...*elemental.Event) error {
+
+	if target.IsEmpty() || len(events) == 0 {
+		return nil
+	}
+
+	if n.cfg.pushServer.service == nil {
+		n.dispatchTargetedPush(&targetedPush{Target: target, Events: events})
+		return nil
+	}
+
+	publication := NewPublication(n.cfg.pushServer.topic + targetedPushTopicSuffix)
+	if err := publication.Encode(&targetedPush{Target: target, Events: events}); err != nil {
+		return fmt.Errorf("unable to encode targeted push: %s", err)
+	}
+
+	var err error
+	for i := 0; i < 3; i++ {
+		if err = n.cfg.pushServer.service.Publish(publication); err != nil {
+			zap.L().Warn("Unable to publish targeted push", zap.String("topic", publication.Topic), zap.Error(err))
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unable to publish targeted push: %s", err)
+}
+
+// handleTargetedPublication decodes the targeted push
+// contained in the given publication and dispatches it.
+func (n *pushServer) handleTargetedPublication(publication *Publication) {
+
+	tp := &targetedPush{}
+	if err := publication.Decode(tp); err != nil {
+		zap.L().Error("Unable to decode targeted push", zap.Error(err))
+		return
+	}
+
+	n.dispatchTargetedPush(tp)
+}
+
+// dispatchTargetedPush sends the events of the given targeted
+// push to the local sessions selected by its target.
+func (n *pushServer) dispatchTargetedPush(tp *targetedPush) {
+
+	n.sessionsLock.RLock()
+	sessions := make([]*wsPushSession, 0, len(tp.Target.SessionIDs))
+	for _, s := range n.sessions {
+		if tp.Target.matches(s) {
+			sessions = append(sessions, s)
+		}
+	}
+	n.sessionsLock.RUnlock()
+
+	for _, s := range sessions {
+		// DirectPush converts the events to the encoding of the
+		// session, so each session gets its own copy.
+		events := make([]*elemental.Event, len(tp.Events))
+		for i, event := range tp.Events {
+			events[i] = event.Duplicate()
+		}
+		s.DirectPush(events...)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-zoo/bone"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/wsc"
+)
+
+func TestPushTarget_matches(t *testing.T) {
+
+	Convey("Given I have a session", t, func() {
+
+		s := newWSPushSession(&http.Request{URL: &url.URL{}}, config{}, nil, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
+		s.id = "s1"
+		s.SetClaims([]string{"@auth:subject=bob", "@auth:realm=a"})
+		s.SetTags([]string{"beta", "admin"})
+
+		Convey("Then it should implement TaggableSession", func() {
+			So(s, ShouldImplement, (*TaggableSession)(nil))
+		})
+
+		Convey("Then the targets should match correctly", func() {
+			So(PushTarget{}.IsEmpty(), ShouldBeTrue)
+			So(PushTarget{}.matches(s), ShouldBeFalse)
+			So(PushTarget{SessionIDs: []string{"s0", "s1"}}.matches(s), ShouldBeTrue)
+			So(PushTarget{SessionIDs: []string{"s2"}}.matches(s), ShouldBeFalse)
+			So(PushTarget{Claims: []string{"@auth:subject=bob"}}.matches(s), ShouldBeTrue)
+			So(PushTarget{Claims: []string{"@auth:subject=bob", "@auth:realm=b"}}.matches(s), ShouldBeFalse)
+			So(PushTarget{Tags: []string{"admin"}}.matches(s), ShouldBeTrue)
+			So(PushTarget{Tags: []string{"alpha"}}.matches(s), ShouldBeFalse)
+			So(PushTarget{SessionIDs: []string{"s2"}, Tags: []string{"beta"}}.matches(s), ShouldBeTrue)
+		})
+	})
+}
+
+func TestPushTarget_pushTo(t *testing.T) {
+
+	makeSession := func(ctx context.Context, wss *pushServer, id string, tags ...string) wsc.MockWebsocket {
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{}}).WithContext(ctx),
+			config{},
+			wss.unregisterSession,
+			elemental.EncodingTypeMSGPACK,
+			elemental.EncodingTypeJSON,
+		)
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+		s.id = id
+		s.SetTags(tags)
+		go s.listen()
+		wss.registerSession(s)
+		return conn
+	}
+
+	receive := func(conn wsc.MockWebsocket) []byte {
+		select {
+		case data := <-conn.LastWrite():
+			return data
+		case <-time.After(300 * time.Millisecond):
+			return nil
+		}
+	}
+
+	Convey("Given I have a push server without pubsub and some sessions", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cfg := config{}
+		cfg.pushServer.enabled = true
+
+		wss := newPushServer(cfg, bone.New(), nil)
+
+		conn1 := makeSession(ctx, wss, "s1", "beta")
+		conn2 := makeSession(ctx, wss, "s2")
+
+		Convey("When I push to a tag", func() {
+
+			event := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+			event.Timestamp = time.Now().Add(time.Second)
+
+			err := wss.pushTo(PushTarget{Tags: []string{"beta"}}, event)
+
+			Convey("Then only the tagged session should receive the event", func() {
+				So(err, ShouldBeNil)
+
+				data := receive(conn1)
+				So(data, ShouldNotBeNil)
+
+				var received elemental.Event
+				So(elemental.Decode(elemental.EncodingTypeJSON, data, &received), ShouldBeNil)
+				So(received.Identity, ShouldEqual, testmodel.ListIdentity.Name)
+
+				So(receive(conn2), ShouldBeNil)
+			})
+		})
+
+		Convey("When I push to an empty target", func() {
+
+			event := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+			event.Timestamp = time.Now().Add(time.Second)
+
+			err := wss.pushTo(PushTarget{}, event)
+
+			Convey("Then no session should receive the event", func() {
+				So(err, ShouldBeNil)
+				So(receive(conn1), ShouldBeNil)
+				So(receive(conn2), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a started push server with a pubsub and some sessions", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pubsub := NewLocalPubSubClient()
+		if err := pubsub.Connect(ctx); err != nil {
+			panic(err)
+		}
+
+		cfg := config{}
+		cfg.pushServer.enabled = true
+		cfg.pushServer.service = pubsub
+		cfg.pushServer.topic = "events"
+
+		wss := newPushServer(cfg, bone.New(), nil)
+		go wss.start(ctx)
+		time.Sleep(100 * time.Millisecond)
+
+		conn1 := makeSession(ctx, wss, "s1")
+		conn2 := makeSession(ctx, wss, "s2")
+
+		Convey("When I push to a session ID", func() {
+
+			event := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+			event.Timestamp = time.Now().Add(time.Second)
+
+			err := wss.pushTo(PushTarget{SessionIDs: []string{"s2"}}, event)
+
+			Convey("Then only the targeted session should receive the event through the pubsub", func() {
+				So(err, ShouldBeNil)
+				So(receive(conn2), ShouldNotBeNil)
+				So(receive(conn1), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a push server with a failing pubsub", t, func() {
+
+		cfg := config{}
+		cfg.pushServer.enabled = true
+		cfg.pushServer.service = &mockPubSubServer{PublishErr: fmt.Errorf("boom")}
+		cfg.pushServer.topic = "events"
+
+		wss := newPushServer(cfg, bone.New(), nil)
+
+		Convey("When I push to a session ID", func() {
+
+			err := wss.pushTo(PushTarget{SessionIDs: []string{"s1"}}, elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unable to publish targeted push: boom")
+			})
+		})
+	})
+}
@@ -0,0 +1,302 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+// A ResponseCacheScopeFunc returns the scope of the given authenticated
+// context. Requests can only be served a cached response that was
+// computed for a request with the same scope.
+type ResponseCacheScopeFunc func(Context) string
+
+// claimsScope is the default ResponseCacheScopeFunc.
+// It returns the sorted claims of the context.
+func claimsScope(ctx Context) string {
+
+	claims := ctx.Claims()
+	sort.Strings(claims)
+
+	return strings.Join(claims, "\n")
+}
+
+// A responseCacheEntry is an encoded response stored in the cache.
+type responseCacheEntry struct {
+	identity   string
+	statusCode int
+	data       []byte
+	total      int
+	next       string
+	messages   []string
+	expiration time.Time
+}
+
+// response returns an elemental.Response for the
+// given request from the cached entry.
+func (e *responseCacheEntry) response(request *elemental.Request) *elemental.Response {
+
+	response := elemental.NewResponse(request)
+	response.StatusCode = e.statusCode
+	response.Data = e.data
+	response.Total = e.total
+	response.Next = e.next
+	response.Messages = e.messages
+
+	return response
+}
+
+// writer returns a ResponseWriter writing the cached response
+// along with a Cache-Control header matching its expiration.
+func (e *responseCacheEntry) writer(request *elemental.Request) ResponseWriter {
+
+	return func(w http.ResponseWriter) int {
+
+		maxAge := int(time.Until(e.expiration).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+
+		return writeHTTPResponse(w, e.response(request))
+	}
+}
+
+// A responseCache caches the encoded responses of the
+// Retrieve and RetrieveMany operations on the configured identities.
+//
+// As the responses are invalidated per identity, every identity has a
+// generation bumped on invalidation, so a response computed while its
+// identity was invalidated is not cached.
+type responseCache struct {
+	ttls        map[elemental.Identity]time.Duration
+	scopeFunc   ResponseCacheScopeFunc
+	maxEntries  int
+	entries     map[string]*responseCacheEntry
+	identities  map[string]map[string]struct{}
+	generations map[string]uint64
+	lock        sync.RWMutex
+}
+
+// newResponseCache returns a new responseCache.
+func newResponseCache() *responseCache {
+
+	return &responseCache{
+		ttls:        map[elemental.Identity]time.Duration{},
+		scopeFunc:   claimsScope,
+		maxEntries:  10000,
+		entries:     map[string]*responseCacheEntry{},
+		identities:  map[string]map[string]struct{}{},
+		generations: map[string]uint64{},
+	}
+}
+
+// newConfiguredResponseCache returns the responseCache configured
+// using OptResponseCache, or nil if no identity must be cached.
+func newConfiguredResponseCache(cfg config) *responseCache {
+
+	if len(cfg.responseCaching.ttls) == 0 {
+		return nil
+	}
+
+	c := newResponseCache()
+	c.ttls = cfg.responseCaching.ttls
+
+	if cfg.responseCaching.scopeFunc != nil {
+		c.scopeFunc = cfg.responseCaching.scopeFunc
+	}
+
+	if cfg.responseCaching.maxEntries > 0 {
+		c.maxEntries = cfg.responseCaching.maxEntries
+	}
+
+	return c
+}
+
+// key returns the key of the response of the request of the
+// given context, or false if the response must not be cached.
+func (c *responseCache) key(ctx *bcontext) (string, bool) {
+
+	if c == nil {
+		return "", false
+	}
+
+	req := ctx.request
+
+	if req.Operation != elemental.OperationRetrieve && req.Operation != elemental.OperationRetrieveMany {
+		return "", false
+	}
+
+	if _, ok := c.ttls[req.Identity]; !ok {
+		return "", false
+	}
+
+	params := make([]string, 0, len(req.Parameters))
+	for k, p := range req.Parameters {
+		params = append(params, fmt.Sprintf("%q=%q", k, fmt.Sprint(p.Values()...)))
+	}
+	sort.Strings(params)
+
+	return strings.Join(
+		[]string{
+			strconv.Itoa(req.Version),
+			string(req.Operation),
+			req.Identity.Name,
+			strconv.Quote(req.ObjectID),
+			req.ParentIdentity.Name,
+			strconv.Quote(req.ParentID),
+			strconv.Quote(req.Namespace),
+			strconv.FormatBool(req.Recursive),
+			strconv.Itoa(req.Page),
+			strconv.Itoa(req.PageSize),
+			strconv.Quote(strings.Join(req.Order, ",")),
+			strings.Join(params, "&"),
+			strconv.Quote(req.Headers.Get("X-Fields")),
+			string(req.Accept),
+			strconv.Quote(c.scopeFunc(ctx)),
+		},
+		"|",
+	), true
+}
+
+// get returns the cached entry for the request of the given
+// context, or nil if there is no valid one. It records in the
+// context the current generation of the requested identity, so
+// set can discard the response if it has been invalidated since.
+func (c *responseCache) get(ctx *bcontext) *responseCacheEntry {
+
+	key, ok := c.key(ctx)
+	if !ok {
+		return nil
+	}
+
+	c.lock.RLock()
+	entry, ok := c.entries[key]
+	ctx.cacheGeneration = c.generations[ctx.request.Identity.Name]
+	c.lock.RUnlock()
+
+	if !ok || time.Now().After(entry.expiration) {
+		return nil
+	}
+
+	return entry
+}
+
+// set caches the given successful response of the request of
+// the given context and returns the created entry. It returns nil if
+// the response cannot be cached.
+func (c *responseCache) set(ctx *bcontext, response *elemental.Response) *responseCacheEntry {
+
+	if response == nil || response.StatusCode != http.StatusOK || response.Redirect != "" || len(response.Cookies) > 0 {
+		return nil
+	}
+
+	key, ok := c.key(ctx)
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+
+	entry := &responseCacheEntry{
+		identity:   ctx.request.Identity.Name,
+		statusCode: response.StatusCode,
+		data:       response.Data,
+		total:      response.Total,
+		next:       response.Next,
+		messages:   response.Messages,
+		expiration: now.Add(c.ttls[ctx.request.Identity]),
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// The identity has been invalidated while the request was
+	// processed, so the response may be stale already.
+	if c.generations[entry.identity] != ctx.cacheGeneration {
+		return nil
+	}
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+
+		for k, e := range c.entries {
+			if now.After(e.expiration) {
+				c.remove(k, e)
+			}
+		}
+
+		if len(c.entries) >= c.maxEntries {
+			return entry
+		}
+	}
+
+	c.entries[key] = entry
+
+	if c.identities[entry.identity] == nil {
+		c.identities[entry.identity] = map[string]struct{}{}
+	}
+	c.identities[entry.identity][key] = struct{}{}
+
+	return entry
+}
+
+// invalidate removes all the cached responses of the
+// identities modified by the given events.
+func (c *responseCache) invalidate(events ...*elemental.Event) {
+
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, event := range events {
+
+		switch event.Type {
+		case elemental.EventCreate, elemental.EventUpdate, elemental.EventDelete:
+		default:
+			continue
+		}
+
+		c.generations[event.Identity]++
+
+		for k := range c.identities[event.Identity] {
+			c.remove(k, c.entries[k])
+		}
+	}
+}
+
+// remove removes the given entry. It must be
+// called while holding the lock.
+func (c *responseCache) remove(key string, entry *responseCacheEntry) {
+
+	delete(c.entries, key)
+
+	if entry == nil {
+		return
+	}
+
+	delete(c.identities[entry.identity], key)
+	if len(c.identities[entry.identity]) == 0 {
+		delete(c.identities, entry.identity)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+)
+
+func TestResponseCache_newConfiguredResponseCache(t *testing.T) {
+
+	Convey("Given I have no response cache option", t, func() {
+
+		c := config{}
+
+		Convey("Then no response cache should be built", func() {
+			So(newConfiguredResponseCache(c), ShouldBeNil)
+		})
+	})
+
+	Convey("Given I have response cache options in any order", t, func() {
+
+		c := config{}
+		OptResponseCacheMaxEntries(42)(&c)
+		OptResponseCacheScopeFunc(func(Context) string { return "scope" })(&c)
+		OptResponseCache(testmodel.ListIdentity, time.Minute)(&c)
+
+		rc := newConfiguredResponseCache(c)
+
+		Convey("Then the response cache should be configured", func() {
+			So(rc, ShouldNotBeNil)
+			So(rc.ttls, ShouldResemble, map[elemental.Identity]time.Duration{testmodel.ListIdentity: time.Minute})
+			So(rc.scopeFunc(nil), ShouldEqual, "scope")
+			So(rc.maxEntries, ShouldEqual, 42)
+		})
+	})
+
+	Convey("Given I only enable the response cache", t, func() {
+
+		c := config{}
+		OptResponseCache(testmodel.ListIdentity, time.Minute)(&c)
+
+		rc := newConfiguredResponseCache(c)
+
+		Convey("Then the response cache should use the defaults", func() {
+			So(rc.maxEntries, ShouldEqual, 10000)
+			So(rc.scopeFunc, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestResponseCache_key(t *testing.T) {
+
+	Convey("Given I have a response cache and a context", t, func() {
+
+		c := newResponseCache()
+		c.ttls[testmodel.ListIdentity] = time.Minute
+
+		makeCtx := func() *bcontext {
+			req := elemental.NewRequest()
+			req.Identity = testmodel.ListIdentity
+			req.Operation = elemental.OperationRetrieveMany
+			req.Headers = http.Header{}
+			req.Parameters = elemental.Parameters{}
+			ctx := newContext(context.Background(), req)
+			ctx.claims = []string{"b=b", "a=a"}
+			return ctx
+		}
+
+		Convey("When I compute the key of the same requests", func() {
+
+			k1, ok1 := c.key(makeCtx())
+			k2, ok2 := c.key(makeCtx())
+
+			Convey("Then they should be equal", func() {
+				So(ok1, ShouldBeTrue)
+				So(ok2, ShouldBeTrue)
+				So(k1, ShouldEqual, k2)
+			})
+		})
+
+		Convey("When I compute the keys of requests with different claims order", func() {
+
+			ctx := makeCtx()
+			ctx.claims = []string{"a=a", "b=b"}
+
+			k1, _ := c.key(makeCtx())
+			k2, _ := c.key(ctx)
+
+			Convey("Then they should be equal", func() {
+				So(k1, ShouldEqual, k2)
+			})
+		})
+
+		Convey("When I compute the keys of different requests", func() {
+
+			ref, _ := c.key(makeCtx())
+
+			ctx1 := makeCtx()
+			ctx1.claims = []string{"a=a"}
+
+			ctx2 := makeCtx()
+			ctx2.request.Parameters["q"] = elemental.NewParameter(elemental.ParameterTypeString, "name == x")
+
+			ctx3 := makeCtx()
+			ctx3.request.Headers.Set("X-Fields", "name")
+
+			ctx4 := makeCtx()
+			ctx4.request.ParentIdentity = testmodel.UserIdentity
+			ctx4.request.ParentID = "xxx"
+
+			ctx5 := makeCtx()
+			ctx5.request.Operation = elemental.OperationRetrieve
+			ctx5.request.ObjectID = "xxx"
+
+			Convey("Then they should all be different", func() {
+				keys := map[string]struct{}{ref: {}}
+				for _, ctx := range []*bcontext{ctx1, ctx2, ctx3, ctx4, ctx5} {
+					k, ok := c.key(ctx)
+					So(ok, ShouldBeTrue)
+					keys[k] = struct{}{}
+				}
+				So(len(keys), ShouldEqual, 6)
+			})
+		})
+
+		Convey("When I use a custom scope func", func() {
+
+			c.scopeFunc = func(Context) string { return "same" }
+
+			ctx := makeCtx()
+			ctx.claims = []string{"c=c"}
+
+			k1, _ := c.key(makeCtx())
+			k2, _ := c.key(ctx)
+
+			Convey("Then the claims should not matter", func() {
+				So(k1, ShouldEqual, k2)
+			})
+		})
+
+		Convey("When I compute the key of a request on an identity that is not cached", func() {
+
+			ctx := makeCtx()
+			ctx.request.Identity = testmodel.TaskIdentity
+
+			_, ok := c.key(ctx)
+
+			Convey("Then it should not be cacheable", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I compute the key of a request with an operation that is not cached", func() {
+
+			ctx := makeCtx()
+			ctx.request.Operation = elemental.OperationCreate
+
+			_, ok := c.key(ctx)
+
+			Convey("Then it should not be cacheable", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When I compute the key with a nil cache", func() {
+
+			var nc *responseCache
+			_, ok := nc.key(makeCtx())
+
+			Convey("Then it should not be cacheable", func() {
+				So(ok, ShouldBeFalse)
+				So(nc.get(makeCtx()), ShouldBeNil)
+				So(func() { nc.invalidate(elemental.NewEvent(elemental.EventCreate, testmodel.NewList())) }, ShouldNotPanic)
+			})
+		})
+	})
+}
+
+func TestResponseCache_getSetInvalidate(t *testing.T) {
+
+	Convey("Given I have a response cache and a context", t, func() {
+
+		c := newResponseCache()
+		c.ttls[testmodel.ListIdentity] = time.Minute
+
+		req := elemental.NewRequest()
+		req.Identity = testmodel.ListIdentity
+		req.Operation = elemental.OperationRetrieveMany
+		ctx := newContext(context.Background(), req)
+
+		response := elemental.NewResponse(req)
+		response.StatusCode = http.StatusOK
+		response.Data = []byte(`[{"name":"a"}]`)
+		response.Total = 1
+		response.Messages = []string{"hello"}
+
+		Convey("When I get a response that is not cached", func() {
+
+			Convey("Then I should get nothing", func() {
+				So(c.get(ctx), ShouldBeNil)
+			})
+		})
+
+		Convey("When I set a response", func() {
+
+			entry := c.set(ctx, response)
+
+			Convey("Then I should get it back", func() {
+				So(entry, ShouldNotBeNil)
+				So(c.get(ctx), ShouldEqual, entry)
+			})
+
+			Convey("When I write it", func() {
+
+				w := httptest.NewRecorder()
+				code := entry.writer(req)(w)
+
+				Convey("Then the response should be correct", func() {
+					So(code, ShouldEqual, http.StatusOK)
+					So(w.Header().Get("Cache-Control"), ShouldStartWith, "private, max-age=")
+					So(w.Header().Get("X-Count-Total"), ShouldEqual, "1")
+					So(w.Header().Get("X-Messages"), ShouldEqual, "hello")
+					So(w.Body.String(), ShouldEqual, `[{"name":"a"}]`)
+				})
+			})
+
+			Convey("When the entry expires", func() {
+
+				entry.expiration = time.Now().Add(-time.Second)
+
+				Convey("Then I should not get it back", func() {
+					So(c.get(ctx), ShouldBeNil)
+				})
+			})
+
+			Convey("When I invalidate the identity with a create event", func() {
+
+				c.invalidate(elemental.NewEvent(elemental.EventCreate, testmodel.NewList()))
+
+				Convey("Then the entry should be gone", func() {
+					So(c.get(ctx), ShouldBeNil)
+					So(c.entries, ShouldBeEmpty)
+					So(c.identities, ShouldBeEmpty)
+				})
+			})
+
+			Convey("When I invalidate another identity", func() {
+
+				c.invalidate(elemental.NewEvent(elemental.EventCreate, testmodel.NewTask()))
+
+				Convey("Then the entry should still be there", func() {
+					So(c.get(ctx), ShouldEqual, entry)
+				})
+			})
+		})
+
+		Convey("When the identity is invalidated while the response is computed", func() {
+
+			So(c.get(ctx), ShouldBeNil)
+			c.invalidate(elemental.NewEvent(elemental.EventUpdate, testmodel.NewList()))
+
+			Convey("Then the response should not be cached", func() {
+				So(c.set(ctx, response), ShouldBeNil)
+				So(c.entries, ShouldBeEmpty)
+			})
+
+			Convey("When the next request computes the response", func() {
+
+				So(c.get(ctx), ShouldBeNil)
+
+				Convey("Then it should be cached", func() {
+					So(c.set(ctx, response), ShouldNotBeNil)
+					So(len(c.entries), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When I set an error response", func() {
+
+			response.StatusCode = http.StatusForbidden
+
+			Convey("Then it should not be cached", func() {
+				So(c.set(ctx, response), ShouldBeNil)
+				So(c.entries, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the cache is full", func() {
+
+			c.maxEntries = 1
+
+			ctx2 := newContext(context.Background(), req)
+			ctx2.claims = []string{"a=a"}
+			old := c.set(ctx2, response)
+
+			Convey("When I set a new response", func() {
+
+				entry := c.set(ctx, response)
+
+				Convey("Then it should be returned but not cached", func() {
+					So(entry, ShouldNotBeNil)
+					So(c.get(ctx), ShouldBeNil)
+				})
+			})
+
+			Convey("When the existing response is expired and I set a new response", func() {
+
+				old.expiration = time.Now().Add(-time.Second)
+				entry := c.set(ctx, response)
+
+				Convey("Then the expired response should be replaced", func() {
+					So(c.get(ctx), ShouldEqual, entry)
+					So(len(c.entries), ShouldEqual, 1)
+				})
+			})
+		})
+	})
+}
@@ -14,20 +14,30 @@ package bahamut
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/go-zoo/bone"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/valyala/tcplisten"
 	"go.aporeto.io/elemental"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // an restServer is the structure serving the api routes.
@@ -35,49 +45,84 @@ type restServer struct {
 	cfg             config
 	multiplexer     *bone.Mux
 	server          *http.Server
+	http3Server     *http3.Server
+	http3Conn       net.PacketConn
 	processorFinder processorFinderFunc
 	pusher          eventPusherFunc
 	customHandlers  retrieveHandlersFunc
+	customMux       *bone.Mux
+	customMuxLock   sync.RWMutex
+	draining        int32
+	inFlight        int64
+	admission       *admissionController
 }
 
 // newRestServer returns a new apiServer.
 func newRestServer(cfg config, multiplexer *bone.Mux, processorFinder processorFinderFunc, customHandlers retrieveHandlersFunc, pusher eventPusherFunc) *restServer {
 
+	var admission *admissionController
+	if cfg.admissionControl != nil {
+		admission = newAdmissionController(*cfg.admissionControl, cfg.healthServer.metricsManager)
+	}
+
 	return &restServer{
 		cfg:             cfg,
 		multiplexer:     multiplexer,
 		processorFinder: processorFinder,
 		pusher:          pusher,
 		customHandlers:  customHandlers,
+		admission:       admission,
 	}
 }
 
-// createSecureHTTPServer returns the main HTTP Server.
-//
-// It will return an error if any.
-func (a *restServer) createSecureHTTPServer(address string) *http.Server {
+// makeTLSConfig returns the tls.Config to use with the given
+// certificates and client authentication settings.
+func (a *restServer) makeTLSConfig(
+	certs []tls.Certificate,
+	certRetriever func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+	clientCAPool *x509.CertPool,
+	authType tls.ClientAuthType,
+) *tls.Config {
 
 	tlsConfig := &tls.Config{
-		ClientAuth:               a.cfg.tls.authType,
-		ClientCAs:                a.cfg.tls.clientCAPool,
-		MinVersion:               tls.VersionTLS12,
+		ClientAuth:               authType,
+		ClientCAs:                clientCAPool,
 		SessionTicketsDisabled:   a.cfg.tls.disableSessionTicket,
 		PreferServerCipherSuites: true,
 		NextProtos:               a.cfg.tls.nextProtos,
-		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		},
-	}
-
-	if a.cfg.tls.serverCertificatesRetrieverFunc != nil {
-		tlsConfig.GetCertificate = a.cfg.tls.serverCertificatesRetrieverFunc
+	}
+
+	policy := tlsPolicies[TLSProfileDefault]
+	if a.cfg.tls.policy != nil {
+		policy = *a.cfg.tls.policy
+	}
+	policy.Apply(tlsConfig)
+
+	if certRetriever != nil {
+		tlsConfig.GetCertificate = certRetriever
 	} else {
-		tlsConfig.Certificates = a.cfg.tls.serverCertificates
+		tlsConfig.Certificates = certs
+	}
+
+	return tlsConfig
+}
+
+// createSecureHTTPServer returns the main HTTP Server.
+//
+// It will return an error if any.
+func (a *restServer) createSecureHTTPServer(address string) *http.Server {
+
+	tlsConfig := a.makeTLSConfig(
+		a.cfg.tls.serverCertificates,
+		a.cfg.tls.serverCertificatesRetrieverFunc,
+		a.cfg.tls.clientCAPool,
+		a.cfg.tls.authType,
+	)
+
+	if r := a.cfg.tls.reloader; r != nil {
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = r.getCertificate
+		tlsConfig.GetConfigForClient = r.makeGetConfigForClient(tlsConfig)
 	}
 
 	server := &http.Server{
@@ -109,7 +154,7 @@ func (a *restServer) createUnsecureHTTPServer(address string) *http.Server {
 }
 
 // installRoutes installs all the routes declared in the APIServerConfig.
-func (a *restServer) installRoutes(routesInfo map[int][]RouteInfo) {
+func (a *restServer) installRoutes(routesInfo func() map[int][]RouteInfo) {
 
 	a.multiplexer.NotFound(http.HandlerFunc(makeNotFoundHandler()))
 
@@ -129,14 +174,18 @@ func (a *restServer) installRoutes(routesInfo map[int][]RouteInfo) {
 		}))
 	}
 
-	if !a.cfg.meta.disableMetaRoute {
-
-		encodedRoutesInfo, err := json.Marshal(routesInfo)
-		if err != nil {
-			panic(fmt.Sprintf("Unable to build route info: %s", err))
-		}
+	if !a.cfg.meta.disableMetaRoute && routesInfo != nil {
 
+		// The routes are computed for every request as
+		// processors can be registered at any time.
 		a.multiplexer.Get("/_meta/routes", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			encodedRoutesInfo, err := json.Marshal(routesInfo())
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Unable to build route info: %s", err), http.StatusInternalServerError)
+				return
+			}
+
 			setCommonHeader(w, elemental.EncodingTypeJSON)
 			w.WriteHeader(200)
 			_, _ = w.Write(encodedRoutesInfo) // nolint: errcheck
@@ -181,26 +230,117 @@ func (a *restServer) installRoutes(routesInfo map[int][]RouteInfo) {
 	a.multiplexer.Head(path.Join(a.cfg.restServer.apiPrefix, "/v/:version/:category"), a.makeHandler(handleInfo))
 	a.multiplexer.Head(path.Join(a.cfg.restServer.apiPrefix, "/v/:version/:parentcategory/:id/:category"), a.makeHandler(handleInfo))
 
+	// Custom routes are served by a dedicated multiplexer
+	// that is rebuilt every time they change.
+	if a.cfg.restServer.customRoutePrefix != "" {
+		a.installCustomRoutes()
+		a.multiplexer.Handle(path.Join(a.cfg.restServer.customRoutePrefix, "/*"), http.HandlerFunc(a.serveCustomRoute))
+	}
+}
+
+// installCustomRoutes builds a new multiplexer for the current custom
+// routes and uses it for the subsequent requests.
+func (a *restServer) installCustomRoutes() {
+
+	mux := bone.New()
+	mux.NotFound(http.HandlerFunc(makeNotFoundHandler()))
+
 	if a.customHandlers != nil {
 		for customRoute, f := range a.customHandlers() {
-			a.multiplexer.Handle(path.Join(a.cfg.restServer.customRoutePrefix, customRoute), f)
+			mux.Handle(path.Join(a.cfg.restServer.customRoutePrefix, customRoute), f)
+		}
+	}
+
+	a.customMuxLock.Lock()
+	a.customMux = mux
+	a.customMuxLock.Unlock()
+}
+
+func (a *restServer) serveCustomRoute(w http.ResponseWriter, req *http.Request) {
+
+	a.customMuxLock.RLock()
+	mux := a.customMux
+	a.customMuxLock.RUnlock()
+
+	mux.ServeHTTP(w, req)
+}
+
+// createHTTP3Server returns an HTTP/3 server sharing
+// the TLS configuration of the given main server.
+func (a *restServer) createHTTP3Server(address string, mainServer *http.Server) *http3.Server {
+
+	server := &http3.Server{
+		Addr:        address,
+		Handler:     a.makeDrainHandler(a.multiplexer),
+		TLSConfig:   mainServer.TLSConfig,
+		IdleTimeout: a.cfg.restServer.idleTimeout,
+	}
+
+	// QUIC connections don't go through the ConnState hook of the
+	// main server, so we report them when they are established.
+	if metricManager := a.cfg.healthServer.metricsManager; metricManager != nil {
+		server.ConnContext = func(ctx context.Context, conn *quic.Conn) context.Context {
+			metricManager.RegisterTCPConnection()
+			go func() {
+				<-conn.Context().Done()
+				metricManager.UnregisterTCPConnection()
+			}()
+			return ctx
 		}
 	}
+
+	return server
 }
 
-func (a *restServer) start(ctx context.Context, routesInfo map[int][]RouteInfo) {
+// tlsEnabled returns true if the server has been
+// configured to use TLS.
+func (a *restServer) tlsEnabled() bool {
+
+	return a.cfg.tls.serverCertificates != nil ||
+		a.cfg.tls.serverCertificatesRetrieverFunc != nil ||
+		a.cfg.tls.reloader != nil
+}
+
+func (a *restServer) start(ctx context.Context, routesInfo func() map[int][]RouteInfo) {
 
 	a.installRoutes(routesInfo)
 
-	var err error
-	if a.cfg.tls.serverCertificates != nil || a.cfg.tls.serverCertificatesRetrieverFunc != nil {
+	if r := a.cfg.tls.reloader; r != nil {
+
+		r.metricsManager = a.cfg.healthServer.metricsManager
+
+		if err := r.reload(); err != nil {
+			zap.L().Fatal("Unable to load initial TLS material", zap.Error(err))
+		}
+
+		go r.start(ctx)
+	}
+
+	if a.tlsEnabled() {
 		a.server = a.createSecureHTTPServer(a.cfg.restServer.listenAddress)
 	} else {
 		a.server = a.createUnsecureHTTPServer(a.cfg.restServer.listenAddress)
 	}
 
 	// This is just noise.
-	a.server.Handler = a.multiplexer
+	a.server.Handler = a.makeDrainHandler(a.multiplexer)
+
+	if a.cfg.restServer.h2cEnabled && !a.tlsEnabled() {
+		a.server.Handler = h2c.NewHandler(a.server.Handler, &http2.Server{IdleTimeout: a.cfg.restServer.idleTimeout})
+	}
+
+	if a.cfg.restServer.http3ListenAddress != "" && a.tlsEnabled() {
+
+		a.http3Server = a.createHTTP3Server(a.cfg.restServer.http3ListenAddress, a.server)
+
+		// We advertise the HTTP/3 server to the clients
+		// connecting through the main server.
+		handler := a.server.Handler
+		a.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_ = a.http3Server.SetQUICHeaders(w.Header()) // nolint: errcheck
+			handler.ServeHTTP(w, req)
+		})
+	}
 
 	if metricManager := a.cfg.healthServer.metricsManager; metricManager != nil {
 		a.server.ConnState = func(conn net.Conn, state http.ConnState) {
@@ -217,19 +357,16 @@ func (a *restServer) start(ctx context.Context, routesInfo map[int][]RouteInfo)
 
 		listener := a.cfg.restServer.customListener
 		if listener == nil {
-			listener, err = (&tcplisten.Config{
-				ReusePort:   true,
-				DeferAccept: true,
-				FastOpen:    true,
-			}).NewListener("tcp4", a.server.Addr)
-			if err != nil {
+			var err error
+			if listener, err = makeListener(mainListenerNetwork(a.server.Addr), a.server.Addr); err != nil {
 				zap.L().Fatal("Unable to dial", zap.Error(err))
 			}
 		}
 
 		listener = newListener(listener, a.cfg.restServer.maxConnection)
 
-		if a.cfg.tls.serverCertificates != nil || a.cfg.tls.serverCertificatesRetrieverFunc != nil {
+		var err error
+		if a.tlsEnabled() {
 			err = a.server.ServeTLS(listener, "", "")
 		} else {
 			err = a.server.Serve(listener)
@@ -243,25 +380,146 @@ func (a *restServer) start(ctx context.Context, routesInfo map[int][]RouteInfo)
 		}
 	}()
 
+	for _, lc := range a.cfg.restServer.listeners {
+
+		listener := lc.customListener
+		if listener == nil {
+			var err error
+			if listener, err = makeListener(lc.network, lc.address); err != nil {
+				zap.L().Fatal("Unable to dial", zap.String("network", lc.network), zap.String("address", lc.address), zap.Error(err))
+			}
+		}
+
+		listener = newListener(listener, lc.maxConnection)
+
+		if lc.tlsEnabled() {
+			listener = tls.NewListener(
+				listener,
+				a.makeTLSConfig(
+					lc.serverCertificates,
+					lc.serverCertificatesRetrieverFunc,
+					lc.clientCAPool,
+					lc.authType,
+				),
+			)
+		}
+
+		go func(l net.Listener, lc listenerConfig) {
+			if err := a.server.Serve(l); err != nil {
+				if err == http.ErrServerClosed {
+					return
+				}
+				zap.L().Fatal("Unable to start api server listener", zap.String("network", lc.network), zap.String("address", lc.address), zap.Error(err))
+			}
+		}(listener, lc)
+
+		zap.L().Info("API server listener started", zap.String("network", lc.network), zap.String("address", lc.address))
+	}
+
+	if a.http3Server != nil {
+
+		// We create the UDP socket ourselves so it exists before
+		// the server can be shut down.
+		conn, err := net.ListenPacket("udp", a.cfg.restServer.http3ListenAddress)
+		if err != nil {
+			zap.L().Fatal("Unable to listen for http3", zap.String("address", a.cfg.restServer.http3ListenAddress), zap.Error(err))
+		}
+		a.http3Conn = conn
+
+		go func() {
+			if err := a.http3Server.Serve(conn); err != nil {
+				if err == http.ErrServerClosed || ctx.Err() != nil || a.isDraining() {
+					return
+				}
+				zap.L().Fatal("Unable to start http3 api server", zap.Error(err))
+			}
+		}()
+
+		zap.L().Info("HTTP/3 API server started", zap.String("address", a.cfg.restServer.http3ListenAddress))
+	}
+
 	zap.L().Info("API server started", zap.String("address", a.cfg.restServer.listenAddress))
 
 	<-ctx.Done()
 }
 
-func (a *restServer) stop() context.Context {
+// drain makes the server reject the new requests
+// while the in-flight ones are completing.
+func (a *restServer) drain() {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	atomic.StoreInt32(&a.draining, 1)
+}
 
-	go func() {
-		defer cancel()
-		if err := a.server.Shutdown(ctx); err != nil {
-			zap.L().Error("Could not gracefully stop API server", zap.Error(err))
-		} else {
-			zap.L().Debug("API server stopped")
+func (a *restServer) isDraining() bool {
+
+	return atomic.LoadInt32(&a.draining) == 1
+}
+
+// stop drains the server and stops it once the in-flight requests
+// are completed or the shutdown timeout is reached.
+func (a *restServer) stop(ctx context.Context) error {
+
+	timeout := a.cfg.shutdown.restTimeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	a.drain()
+
+	// We keep listening while the in-flight requests
+	// are completing so the new ones get a 503 rather
+	// than a connection error.
+	waitUntil(ctx, func() bool { return atomic.LoadInt64(&a.inFlight) == 0 })
+
+	// The HTTP/3 server sends a GOAWAY to its clients and waits
+	// for their requests to complete, until the deadline.
+	if a.http3Server != nil {
+		if err := a.http3Server.Shutdown(ctx); err != nil {
+			zap.L().Error("Could not gracefully stop HTTP/3 API server", zap.Error(err))
 		}
-	}()
+		if a.http3Conn != nil {
+			_ = a.http3Conn.Close() // nolint: errcheck
+		}
+	}
+
+	if a.server == nil {
+		return nil
+	}
 
-	return ctx
+	if err := a.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("rest server: %d request(s) did not complete: %s", atomic.LoadInt64(&a.inFlight), err)
+	}
+
+	zap.L().Debug("API server stopped")
+
+	return nil
+}
+
+// makeDrainHandler returns a http.Handler that keeps track of
+// the in-flight requests, and rejects the new ones with a 503
+// while the server is draining.
+func (a *restServer) makeDrainHandler(handler http.Handler) http.Handler {
+
+	retryAfter := a.cfg.shutdown.retryAfter
+	if retryAfter == 0 {
+		retryAfter = 5 * time.Second
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		if !a.isDraining() {
+			atomic.AddInt64(&a.inFlight, 1)
+			defer atomic.AddInt64(&a.inFlight, -1)
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeHTTPResponse(w, makeErrorResponse(req.Context(), elemental.NewResponse(elemental.NewRequest()), ErrDraining, nil))
+	})
 }
 
 func (a *restServer) makeHandler(handler handlerFunc) http.HandlerFunc {
@@ -280,8 +538,27 @@ func (a *restServer) makeHandler(handler handlerFunc) http.HandlerFunc {
 			req.URL.Path = strings.TrimPrefix(req.URL.Path, a.cfg.restServer.apiPrefix)
 		}
 
+		if limit := a.maxRequestBodySize(req); limit > 0 {
+
+			if req.ContentLength > limit {
+				registerLimitViolation(a.cfg.healthServer.metricsManager, LimitRequestBodySize)
+				code := writeHTTPResponse(w, makeErrorResponse(req.Context(), elemental.NewResponse(elemental.NewRequest()), ErrTooLarge, nil))
+				if measure != nil {
+					measure(code, nil)
+				}
+				return
+			}
+
+			req.Body = http.MaxBytesReader(w, req.Body, limit)
+		}
+
 		request, err := elemental.NewRequestFromHTTPRequest(req, a.cfg.model.modelManagers[0])
 		if err != nil {
+			// MaxBytesReader does not return a typed error.
+			if strings.Contains(err.Error(), "http: request body too large") {
+				registerLimitViolation(a.cfg.healthServer.metricsManager, LimitRequestBodySize)
+				err = ErrTooLarge
+			}
 			code := writeHTTPResponse(w, makeErrorResponse(req.Context(), elemental.NewResponse(elemental.NewRequest()), err, nil))
 			if measure != nil {
 				measure(code, nil)
@@ -292,31 +569,18 @@ func (a *restServer) makeHandler(handler handlerFunc) http.HandlerFunc {
 		ctx := traceRequest(req.Context(), request, a.cfg.opentracing.tracer, a.cfg.opentracing.excludedIdentities, a.cfg.opentracing.traceCleaner)
 		defer finishTracing(ctx)
 
-		// Global rate limiting
-		if a.cfg.rateLimiting.rateLimiter != nil {
-			if !a.cfg.rateLimiting.rateLimiter.Allow() {
-				code := writeHTTPResponse(w, makeErrorResponse(ctx, elemental.NewResponse(request), ErrRateLimit, nil))
-				if measure != nil {
-					measure(code, opentracing.SpanFromContext(ctx))
-				}
-				return
+		ctx, done, err := admitRequest(ctx, a.cfg, a.admission, request, req.URL.Query())
+		if err != nil {
+			if err == ErrOverloaded {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(a.cfg.admissionControl.retryAfter.Seconds()))))
 			}
-		}
-
-		// Per api rate limiting
-		if a.cfg.rateLimiting.apiRateLimiters != nil {
-			if rlm, ok := a.cfg.rateLimiting.apiRateLimiters[request.Identity]; ok {
-				if rlm.condition == nil || rlm.condition(request) {
-					if !rlm.limiter.Allow() {
-						code := writeHTTPResponse(w, makeErrorResponse(ctx, elemental.NewResponse(request), ErrRateLimit, nil))
-						if measure != nil {
-							measure(code, opentracing.SpanFromContext(ctx))
-						}
-						return
-					}
-				}
+			code := writeHTTPResponse(w, makeErrorResponse(ctx, elemental.NewResponse(request), err, nil))
+			if measure != nil {
+				measure(code, opentracing.SpanFromContext(ctx))
 			}
+			return
 		}
+		defer done()
 
 		bctx := newContext(ctx, request)
 		resp := handler(bctx, a.cfg, a.processorFinder, a.pusher)
@@ -340,3 +604,57 @@ func (a *restServer) makeHandler(handler handlerFunc) http.HandlerFunc {
 
 	return gziphandler.GzipHandler(h).(http.HandlerFunc)
 }
+
+// maxRequestBodySize returns the maximum size of the body of the
+// given request, based on the identity targeted by its route.
+func (a *restServer) maxRequestBodySize(req *http.Request) int64 {
+
+	var identity elemental.Identity
+	if m, ok := a.cfg.model.modelManagers[0]; ok {
+		identity = m.IdentityFromCategory(bone.GetValue(req, "category"))
+	}
+
+	return requestBodyLimit(a.cfg, identity)
+}
+
+// mainListenerNetwork returns the network to use
+// for the main listener given its address.
+func mainListenerNetwork(address string) string {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "tcp4"
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "tcp6"
+	}
+
+	return "tcp4"
+}
+
+// makeListener returns a new net.Listener for the given network and address.
+func makeListener(network string, address string) (net.Listener, error) {
+
+	switch network {
+
+	case "tcp4", "tcp6":
+		return (&tcplisten.Config{
+			ReusePort:   true,
+			DeferAccept: true,
+			FastOpen:    true,
+		}).NewListener(network, address)
+
+	case "unix":
+		// We remove any leftover socket from a previous run.
+		if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("unable to remove existing socket: %s", err)
+			}
+		}
+		return net.Listen(network, address)
+
+	default:
+		return net.Listen(network, address)
+	}
+}
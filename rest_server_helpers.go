@@ -20,10 +20,18 @@ import (
 	"go.uber.org/zap"
 )
 
+// RequestTimeoutHeader is the header the clients can use to set
+// the timeout of their requests when OptClientRequestTimeout is set.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
 // Various common errors
 var (
-	ErrNotFound  = elemental.NewError("Not Found", "Unable to find the requested resource", "bahamut", http.StatusNotFound)
-	ErrRateLimit = elemental.NewError("Rate Limit", "You have exceeded your rate limit", "bahamut", http.StatusTooManyRequests)
+	ErrNotFound       = elemental.NewError("Not Found", "Unable to find the requested resource", "bahamut", http.StatusNotFound)
+	ErrRateLimit      = elemental.NewError("Rate Limit", "You have exceeded your rate limit", "bahamut", http.StatusTooManyRequests)
+	ErrDraining       = elemental.NewError("Service Unavailable", "The server is shutting down", "bahamut", http.StatusServiceUnavailable)
+	ErrTooLarge       = elemental.NewError("Request Entity Too Large", "The request body is too large", "bahamut", http.StatusRequestEntityTooLarge)
+	ErrRequestTimeout = elemental.NewError("Gateway Timeout", "The request took too long to be processed", "bahamut", http.StatusGatewayTimeout)
+	ErrOverloaded     = elemental.NewError("Service Unavailable", "The server is overloaded", "bahamut", http.StatusServiceUnavailable)
 )
 
 func setCommonHeader(w http.ResponseWriter, encoding elemental.EncodingType) {
@@ -17,19 +17,25 @@ import (
 	"crypto/x509"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-zoo/bone"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/quic-go/quic-go/http3"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.aporeto.io/elemental"
 	testmodel "go.aporeto.io/elemental/test/model"
+	"golang.org/x/net/http2"
 	"golang.org/x/time/rate"
 )
 
@@ -81,6 +87,28 @@ func TestServer_createSecureHTTPServer(t *testing.T) {
 			Convey("Then the server should be correctly initialized", func() {
 				So(srv, ShouldNotBeNil)
 			})
+
+			Convey("Then the default tls policy should be used", func() {
+				So(srv.TLSConfig.MinVersion, ShouldEqual, tls.VersionTLS12)
+				So(srv.TLSConfig.CurvePreferences, ShouldResemble, []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256})
+				So(len(srv.TLSConfig.CipherSuites), ShouldEqual, 5)
+			})
+		})
+
+		Convey("When I make a secure server with a tls policy", func() {
+
+			policy, _ := NewTLSPolicy(TLSProfileModern)
+			policy.NextProtos = []string{"h2"}
+			c.cfg.tls.policy = &policy
+
+			srv := c.createSecureHTTPServer(cfg.restServer.listenAddress)
+
+			Convey("Then the tls policy should be used", func() {
+				So(srv.TLSConfig.MinVersion, ShouldEqual, tls.VersionTLS13)
+				So(srv.TLSConfig.CurvePreferences, ShouldResemble, []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384})
+				So(srv.TLSConfig.CipherSuites, ShouldBeNil)
+				So(srv.TLSConfig.NextProtos, ShouldResemble, []string{"h2"})
+			})
 		})
 	})
 
@@ -155,7 +183,7 @@ func TestServer_RouteInstallation(t *testing.T) {
 
 		Convey("When I install the routes", func() {
 
-			c.installRoutes(routes)
+			c.installRoutes(func() map[int][]RouteInfo { return routes })
 
 			Convey("Then the bone Multiplexer should have correct number of handlers", func() {
 				So(len(c.multiplexer.Routes[http.MethodPost]), ShouldEqual, 5)
@@ -206,7 +234,7 @@ func TestServer_RouteInstallation(t *testing.T) {
 
 		Convey("When I install the routes", func() {
 
-			c.installRoutes(routes)
+			c.installRoutes(func() map[int][]RouteInfo { return routes })
 
 			Convey("Then the bone Multiplexer should have correct number of handlers", func() {
 				So(len(c.multiplexer.Routes[http.MethodPost]), ShouldEqual, 6)
@@ -245,7 +273,7 @@ func TestServer_Start(t *testing.T) {
 			cfg.restServer.listenAddress = "127.0.0.1:" + port1
 
 			c := newRestServer(cfg, bone.New(), nil, nil, nil)
-			defer c.stop()
+			defer c.stop(context.Background()) // nolint: errcheck
 
 			go c.start(context.TODO(), nil)
 			time.Sleep(30 * time.Millisecond)
@@ -259,6 +287,44 @@ func TestServer_Start(t *testing.T) {
 		})
 	})
 
+	Convey("Given I create an api without tls server and h2c enabled", t, func() {
+
+		Convey("When I start the server", func() {
+
+			port1 := strconv.Itoa(rand.Intn(10000) + 20000)
+
+			cfg := config{}
+			cfg.restServer.listenAddress = "127.0.0.1:" + port1
+			cfg.restServer.h2cEnabled = true
+
+			c := newRestServer(cfg, bone.New(), nil, nil, nil)
+			defer c.stop(context.Background()) // nolint: errcheck
+
+			go c.start(context.TODO(), nil)
+			time.Sleep(30 * time.Millisecond)
+
+			client := &http.Client{
+				Transport: &http2.Transport{
+					AllowHTTP: true,
+					DialTLS: func(network string, addr string, _ *tls.Config) (net.Conn, error) {
+						return net.Dial(network, addr)
+					},
+				},
+			}
+
+			resp, err := client.Get("http://127.0.0.1:" + port1)
+
+			Convey("Then the status code should be OK", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, 200)
+			})
+
+			Convey("Then the response should have been sent using http2", func() {
+				So(resp.ProtoMajor, ShouldEqual, 2)
+			})
+		})
+	})
+
 	Convey("Given I create an api with tls server", t, func() {
 
 		Convey("When I start the server", func() {
@@ -276,7 +342,7 @@ func TestServer_Start(t *testing.T) {
 			cfg.tls.authType = tls.RequireAndVerifyClientCert
 
 			c := newRestServer(cfg, bone.New(), nil, nil, nil)
-			defer c.stop()
+			defer c.stop(context.Background()) // nolint: errcheck
 
 			go c.start(context.TODO(), nil)
 			time.Sleep(30 * time.Millisecond)
@@ -303,6 +369,331 @@ func TestServer_Start(t *testing.T) {
 	})
 }
 
+type connCountingMetricsManager struct {
+	mockMetricsManager
+	connections int64
+}
+
+func (m *connCountingMetricsManager) RegisterTCPConnection()   { atomic.AddInt64(&m.connections, 1) }
+func (m *connCountingMetricsManager) UnregisterTCPConnection() { atomic.AddInt64(&m.connections, -1) }
+
+func TestServer_StartHTTP3(t *testing.T) {
+
+	Convey("Given I create an api with tls server and http3 enabled", t, func() {
+
+		port1 := strconv.Itoa(rand.Intn(10000) + 40000)
+		port2 := strconv.Itoa(rand.Intn(10000) + 50000)
+
+		_, _, servercerts := loadFixtureCertificates()
+
+		metrics := &connCountingMetricsManager{}
+
+		cfg := config{}
+		cfg.restServer.listenAddress = "127.0.0.1:" + port1
+		cfg.restServer.http3ListenAddress = "127.0.0.1:" + port2
+		cfg.tls.serverCertificates = servercerts
+		cfg.healthServer.metricsManager = metrics
+		cfg.shutdown.restTimeout = 3 * time.Second
+
+		mux := bone.New()
+		mux.Get("/slow", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		c := newRestServer(cfg, mux, nil, nil, nil)
+		defer c.stop(context.Background()) // nolint: errcheck
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go c.start(ctx, nil)
+		time.Sleep(30 * time.Millisecond)
+
+		transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}} // nolint: gosec
+		defer transport.Close()                                                               // nolint: errcheck
+
+		client := &http.Client{Transport: transport}
+
+		Convey("When I send a request over http3", func() {
+
+			resp, err := client.Get("https://127.0.0.1:" + port2)
+
+			Convey("Then the response should have been sent using http3", func() {
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, 200)
+				So(resp.ProtoMajor, ShouldEqual, 3)
+			})
+
+			Convey("Then the connection should be reported", func() {
+				So(atomic.LoadInt64(&metrics.connections), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When I stop the server while an http3 request is in flight", func() {
+
+			type result struct {
+				resp *http.Response
+				err  error
+			}
+
+			results := make(chan result, 1)
+			go func() {
+				resp, err := client.Get("https://127.0.0.1:" + port2 + "/slow")
+				results <- result{resp, err}
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			err := c.stop(context.Background())
+			r := <-results
+
+			_ = transport.Close()
+
+			wctx, wcancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer wcancel()
+
+			Convey("Then the request should complete", func() {
+				So(err, ShouldBeNil)
+				So(r.err, ShouldBeNil)
+				So(r.resp.StatusCode, ShouldEqual, 200)
+			})
+
+			Convey("Then the connection should be unregistered", func() {
+				So(waitUntil(wctx, func() bool { return atomic.LoadInt64(&metrics.connections) == 0 }), ShouldBeTrue)
+			})
+		})
+
+		Convey("When I send a request to the main server", func() {
+
+			resp, err := (&http.Client{
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, // nolint: gosec
+			}).Get("https://127.0.0.1:" + port1)
+
+			Convey("Then the response should advertise the http3 server", func() {
+				So(err, ShouldBeNil)
+				So(resp.Header.Get("Alt-Svc"), ShouldEqual, `h3=":`+port2+`"; ma=2592000`)
+			})
+		})
+	})
+}
+
+func TestServer_StartWithListeners(t *testing.T) {
+
+	Convey("Given I create an api server with an additional unix socket listener", t, func() {
+
+		Convey("When I start the server", func() {
+
+			port1 := strconv.Itoa(rand.Intn(10000) + 20000)
+
+			dir, err := ioutil.TempDir("", "bahamut")
+			if err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(dir) // nolint: errcheck
+
+			socketPath := filepath.Join(dir, "api.sock")
+
+			cfg := config{}
+			cfg.restServer.listenAddress = "127.0.0.1:" + port1
+			OptRestServerListener("unix", socketPath)(&cfg)
+
+			c := newRestServer(cfg, bone.New(), nil, nil, nil)
+			defer c.stop(context.Background()) // nolint: errcheck
+
+			go c.start(context.TODO(), nil)
+			time.Sleep(30 * time.Millisecond)
+
+			client := &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+						return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+					},
+				},
+			}
+
+			resp1, err1 := http.Get("http://127.0.0.1:" + port1)
+			resp2, err2 := client.Get("http://unix/")
+
+			Convey("Then the main listener should respond", func() {
+				So(err1, ShouldBeNil)
+				So(resp1.StatusCode, ShouldEqual, 200)
+			})
+
+			Convey("Then the unix socket listener should respond", func() {
+				So(err2, ShouldBeNil)
+				So(resp2.StatusCode, ShouldEqual, 200)
+			})
+		})
+	})
+}
+
+func TestServer_Drain(t *testing.T) {
+
+	Convey("Given I have a rest server and a drain handler", t, func() {
+
+		cfg := config{}
+		c := newRestServer(cfg, bone.New(), nil, nil, nil)
+
+		h := c.makeDrainHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		Convey("When I send a request while the server is not draining", func() {
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Convey("Then the request should be handled", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When I send a request while the server is draining", func() {
+
+			c.drain()
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Convey("Then the request should be rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(w.Header().Get("Retry-After"), ShouldEqual, "5")
+			})
+		})
+	})
+
+	Convey("Given I have a rest server with a custom retry after", t, func() {
+
+		cfg := config{}
+		cfg.shutdown.retryAfter = 30 * time.Second
+		c := newRestServer(cfg, bone.New(), nil, nil, nil)
+		c.drain()
+
+		h := c.makeDrainHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		Convey("When I send a request", func() {
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Convey("Then the retry after header should be correct", func() {
+				So(w.Header().Get("Retry-After"), ShouldEqual, "30")
+			})
+		})
+	})
+
+	Convey("Given I have a rest server with a sub-second retry after", t, func() {
+
+		cfg := config{}
+		cfg.shutdown.retryAfter = 500 * time.Millisecond
+		c := newRestServer(cfg, bone.New(), nil, nil, nil)
+		c.drain()
+
+		h := c.makeDrainHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		Convey("When I send a request", func() {
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			Convey("Then the retry after header should be rounded up", func() {
+				So(w.Header().Get("Retry-After"), ShouldEqual, "1")
+			})
+		})
+	})
+
+	Convey("Given I have a started rest server with a slow request in flight", t, func() {
+
+		port := strconv.Itoa(rand.Intn(10000) + 20000)
+
+		cfg := config{}
+		cfg.restServer.listenAddress = "127.0.0.1:" + port
+		cfg.restServer.customRootHandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(1 * time.Second)
+			w.WriteHeader(http.StatusOK)
+		}
+		cfg.shutdown.restTimeout = 300 * time.Millisecond
+
+		c := newRestServer(cfg, bone.New(), nil, nil, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go c.start(ctx, nil)
+		time.Sleep(30 * time.Millisecond)
+
+		go func() { _, _ = http.Get("http://127.0.0.1:" + port) }() // nolint
+		time.Sleep(30 * time.Millisecond)
+
+		Convey("When I stop it", func() {
+
+			err := c.stop(context.Background())
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "rest server: 1 request(s) did not complete")
+			})
+		})
+	})
+
+	Convey("Given I have a started rest server with a fast request in flight", t, func() {
+
+		port := strconv.Itoa(rand.Intn(10000) + 20000)
+
+		cfg := config{}
+		cfg.restServer.listenAddress = "127.0.0.1:" + port
+		cfg.restServer.customRootHandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}
+		cfg.shutdown.restTimeout = 3 * time.Second
+
+		c := newRestServer(cfg, bone.New(), nil, nil, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go c.start(ctx, nil)
+		time.Sleep(30 * time.Millisecond)
+
+		var code int
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if resp, err := http.Get("http://127.0.0.1:" + port); err == nil {
+				code = resp.StatusCode
+			}
+		}()
+		time.Sleep(30 * time.Millisecond)
+
+		Convey("When I stop it", func() {
+
+			err := c.stop(context.Background())
+			<-done
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the request should have completed", func() {
+				So(code, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+func TestServer_mainListenerNetwork(t *testing.T) {
+
+	Convey("Given I have various addresses", t, func() {
+		So(mainListenerNetwork("127.0.0.1:443"), ShouldEqual, "tcp4")
+		So(mainListenerNetwork(":443"), ShouldEqual, "tcp4")
+		So(mainListenerNetwork("localhost:443"), ShouldEqual, "tcp4")
+		So(mainListenerNetwork("[::1]:443"), ShouldEqual, "tcp6")
+		So(mainListenerNetwork("[::ffff:127.0.0.1]:443"), ShouldEqual, "tcp4")
+		So(mainListenerNetwork("not-an-address"), ShouldEqual, "tcp4")
+	})
+}
+
 type mockMetricsManager struct {
 	measureFunc FinishMeasurementFunc
 }
@@ -426,3 +817,153 @@ func TestServer_Handlers_RateLimiters(t *testing.T) {
 		})
 	})
 }
+
+type mockLimitsMetricsManager struct {
+	mockMetricsManager
+	violations []string
+}
+
+func (m *mockLimitsMetricsManager) RegisterLimitViolation(limit string) {
+	m.violations = append(m.violations, limit)
+}
+
+func TestServer_Handlers_BodyLimits(t *testing.T) {
+
+	Convey("Given I have some config", t, func() {
+
+		mm := map[int]elemental.ModelManager{
+			0: testmodel.Manager(),
+		}
+
+		var measuredCode int
+		metricsManager := &mockLimitsMetricsManager{
+			mockMetricsManager: mockMetricsManager{
+				measureFunc: func(code int, span opentracing.Span) time.Duration { measuredCode = code; return 0 },
+			},
+		}
+
+		cfg := config{}
+		cfg.model.modelManagers = mm
+		cfg.healthServer.metricsManager = metricsManager
+		cfg.limits.maxRequestBodySize = 10
+
+		body := `{"name":"a very long name that does not fit"}`
+
+		Convey("When I send a request with a known content length over the limit", func() {
+
+			c := newRestServer(cfg, bone.New(), nil, nil, nil)
+			h := c.makeHandler(handleCreate)
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "http://toto.com/lists", strings.NewReader(body))
+			h(w, r)
+
+			Convey("Then the request should be rejected", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+				So(measuredCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+				So(metricsManager.violations, ShouldResemble, []string{LimitRequestBodySize})
+			})
+		})
+
+		Convey("When I send a request with an unknown content length over the limit", func() {
+
+			c := newRestServer(cfg, bone.New(), nil, nil, nil)
+			h := c.makeHandler(handleCreate)
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "http://toto.com/lists", ioutil.NopCloser(strings.NewReader(body)))
+			h(w, r)
+
+			Convey("Then the request should be rejected", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+				So(metricsManager.violations, ShouldResemble, []string{LimitRequestBodySize})
+			})
+		})
+
+		Convey("When I send a request to an identity with a bigger limit", func() {
+
+			cfg.limits.identityMaxRequestBodySize = map[elemental.Identity]int64{
+				testmodel.ListIdentity: 1024,
+			}
+
+			c := newRestServer(cfg, bone.New(), nil, nil, nil)
+			mux := bone.New()
+			mux.Post("/:category", c.makeHandler(handleCreate))
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "http://toto.com/lists", strings.NewReader(body))
+			mux.ServeHTTP(w, r)
+
+			Convey("Then the request should not be rejected because of its size", func() {
+				So(w.Result().StatusCode, ShouldNotEqual, http.StatusRequestEntityTooLarge)
+				So(metricsManager.violations, ShouldBeEmpty)
+			})
+
+			Convey("When I send a request to another identity", func() {
+
+				w := httptest.NewRecorder()
+				r, _ := http.NewRequest(http.MethodPost, "http://toto.com/tasks", strings.NewReader(body))
+				mux.ServeHTTP(w, r)
+
+				Convey("Then the request should be rejected", func() {
+					So(w.Result().StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+				})
+			})
+		})
+	})
+}
+
+func TestServer_Handlers_RequestTimeout(t *testing.T) {
+
+	Convey("Given I have a rest server with a request timeout", t, func() {
+
+		cfg := config{}
+		cfg.model.modelManagers = map[int]elemental.ModelManager{0: testmodel.Manager()}
+		cfg.requestTimeouts.defaultTimeout = 10 * time.Millisecond
+
+		var hasDeadline bool
+		slowHandler := func(ctx *bcontext, cfg config, processorFinder processorFinderFunc, pusherFunc eventPusherFunc) *elemental.Response {
+			_, hasDeadline = ctx.Context().Deadline()
+			return runDispatcher(ctx, elemental.NewResponse(ctx.request), func() error {
+				<-ctx.Context().Done()
+				return ctx.Context().Err()
+			}, true, nil)
+		}
+
+		Convey("When I send a request that takes too long to process", func() {
+
+			c := newRestServer(cfg, bone.New(), nil, nil, nil)
+			h := c.makeHandler(slowHandler)
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "http://toto.com/lists", nil)
+			h(w, r)
+
+			Convey("Then the context should have had a deadline", func() {
+				So(hasDeadline, ShouldBeTrue)
+			})
+
+			Convey("Then I should get a 504", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusGatewayTimeout)
+			})
+		})
+
+		Convey("When the client cancels its request", func() {
+
+			cfg.requestTimeouts.defaultTimeout = time.Minute
+			c := newRestServer(cfg, bone.New(), nil, nil, nil)
+			h := c.makeHandler(slowHandler)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodGet, "http://toto.com/lists", nil)
+			h(w, r.WithContext(ctx))
+
+			Convey("Then no error should be written", func() {
+				So(w.Body.Len(), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -165,6 +165,24 @@ func (p *mockProcessor) ProcessInfo(ctx Context) error {
 	return p.err
 }
 
+// A mockAdapterProcessor is a mockProcessor that
+// only implements the given operations.
+type mockAdapterProcessor struct {
+	mockProcessor
+	operations []elemental.Operation
+}
+
+func (p *mockAdapterProcessor) ImplementsOperation(operation elemental.Operation) bool {
+
+	for _, op := range p.operations {
+		if op == operation {
+			return true
+		}
+	}
+
+	return false
+}
+
 // A mockPusher is a mockable implementation of a Pusher.
 type mockPusher struct {
 	events []*elemental.Event
@@ -0,0 +1,129 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// A TLSProfile is the name of a predefined TLSPolicy.
+type TLSProfile string
+
+// Various values for TLSProfile.
+const (
+	// TLSProfileDefault is the policy used when none is configured.
+	// It allows TLS 1.2 and above, with ECDHE AEAD cipher suites
+	// and the P-521, P-384 and P-256 curves.
+	TLSProfileDefault TLSProfile = "default"
+
+	// TLSProfileModern only allows TLS 1.3 and prefers X25519.
+	TLSProfileModern TLSProfile = "modern"
+
+	// TLSProfileIntermediate allows TLS 1.2 and above, with ECDHE AEAD
+	// cipher suites, and prefers X25519.
+	TLSProfileIntermediate TLSProfile = "intermediate"
+
+	// TLSProfileFIPS only allows TLS 1.2 with ECDHE AES-GCM cipher suites
+	// and NIST curves. TLS 1.3 is disabled because the Go standard library
+	// does not allow to restrict its cipher suites. It cannot be used with
+	// OptHTTP3, as QUIC requires TLS 1.3.
+	TLSProfileFIPS TLSProfile = "fips"
+)
+
+// A TLSPolicy describes the TLS parameters a server or a client
+// is allowed to negotiate.
+//
+// You can get a predefined policy using NewTLSPolicy and then
+// override any of its fields. A zero value field means the
+// Go standard library default will be used.
+type TLSPolicy struct {
+	MinVersion       uint16
+	MaxVersion       uint16
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+	NextProtos       []string
+}
+
+var tlsPolicies = map[TLSProfile]TLSPolicy{
+	TLSProfileDefault: {
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+	},
+	TLSProfileModern: {
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+	},
+	TLSProfileIntermediate: {
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	},
+	TLSProfileFIPS: {
+		MinVersion:       tls.VersionTLS12,
+		MaxVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+	},
+}
+
+// NewTLSPolicy returns a copy of the TLSPolicy for the given profile.
+// It returns an error if the profile is unknown.
+func NewTLSPolicy(profile TLSProfile) (TLSPolicy, error) {
+
+	p, ok := tlsPolicies[profile]
+	if !ok {
+		return TLSPolicy{}, fmt.Errorf("unknown tls profile '%s'", profile)
+	}
+
+	return TLSPolicy{
+		MinVersion:       p.MinVersion,
+		MaxVersion:       p.MaxVersion,
+		CipherSuites:     append([]uint16(nil), p.CipherSuites...),
+		CurvePreferences: append([]tls.CurveID(nil), p.CurvePreferences...),
+		NextProtos:       append([]string(nil), p.NextProtos...),
+	}, nil
+}
+
+// Apply sets the versions, cipher suites and curve preferences of
+// the policy to the given tls.Config. NextProtos are only set if
+// the policy defines some.
+func (p TLSPolicy) Apply(tlsConfig *tls.Config) {
+
+	tlsConfig.MinVersion = p.MinVersion
+	tlsConfig.MaxVersion = p.MaxVersion
+	tlsConfig.CipherSuites = p.CipherSuites
+	tlsConfig.CurvePreferences = p.CurvePreferences
+
+	if len(p.NextProtos) > 0 {
+		tlsConfig.NextProtos = p.NextProtos
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"crypto/tls"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTLSPolicy_NewTLSPolicy(t *testing.T) {
+
+	Convey("Given I retrieve the modern tls policy", t, func() {
+
+		p, err := NewTLSPolicy(TLSProfileModern)
+
+		Convey("Then err should be nil", func() {
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then the policy should be correct", func() {
+			So(p.MinVersion, ShouldEqual, tls.VersionTLS13)
+			So(p.CurvePreferences[0], ShouldEqual, tls.X25519)
+		})
+
+		Convey("When I modify the policy", func() {
+
+			p.CurvePreferences[0] = tls.CurveP521
+
+			Convey("Then the predefined policy should not be modified", func() {
+				So(tlsPolicies[TLSProfileModern].CurvePreferences[0], ShouldEqual, tls.X25519)
+			})
+		})
+	})
+
+	Convey("Given I retrieve the fips tls policy", t, func() {
+
+		p, err := NewTLSPolicy(TLSProfileFIPS)
+
+		Convey("Then the policy should be correct", func() {
+			So(err, ShouldBeNil)
+			So(p.MinVersion, ShouldEqual, tls.VersionTLS12)
+			So(p.MaxVersion, ShouldEqual, tls.VersionTLS12)
+			So(p.CipherSuites, ShouldNotContain, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305)
+		})
+	})
+
+	Convey("Given I retrieve an unknown tls policy", t, func() {
+
+		_, err := NewTLSPolicy("nope")
+
+		Convey("Then err should not be nil", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown tls profile 'nope'")
+		})
+	})
+}
+
+func TestTLSPolicy_Apply(t *testing.T) {
+
+	Convey("Given I have a tls policy and a tls config", t, func() {
+
+		p, _ := NewTLSPolicy(TLSProfileIntermediate)
+		p.MaxVersion = tls.VersionTLS12
+
+		tlsConfig := &tls.Config{
+			MinVersion: tls.VersionTLS10,
+			NextProtos: []string{"h2"},
+		}
+
+		Convey("When I apply the policy", func() {
+
+			p.Apply(tlsConfig)
+
+			Convey("Then the tls config should be updated", func() {
+				So(tlsConfig.MinVersion, ShouldEqual, tls.VersionTLS12)
+				So(tlsConfig.MaxVersion, ShouldEqual, tls.VersionTLS12)
+				So(tlsConfig.CipherSuites, ShouldResemble, p.CipherSuites)
+				So(tlsConfig.CurvePreferences, ShouldResemble, p.CurvePreferences)
+			})
+
+			Convey("Then the next protos should be kept", func() {
+				So(tlsConfig.NextProtos, ShouldResemble, []string{"h2"})
+			})
+		})
+
+		Convey("When I apply the policy with next protos", func() {
+
+			p.NextProtos = []string{"http/1.1"}
+			p.Apply(tlsConfig)
+
+			Convey("Then the next protos should be replaced", func() {
+				So(tlsConfig.NextProtos, ShouldResemble, []string{"http/1.1"})
+			})
+		})
+	})
+}
@@ -0,0 +1,307 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TLSMaterial contains the TLS server certificates
+// and the client CA pool used by the rest server.
+type TLSMaterial struct {
+	Certificates []tls.Certificate
+	ClientCAPool *x509.CertPool
+}
+
+// A TLSMaterialLoader is a function that returns the current
+// TLS material. It is called periodically by the server
+// when reloading is enabled using OptTLSReloader.
+type TLSMaterialLoader func() (*TLSMaterial, error)
+
+// NewTLSFilesLoader returns a TLSMaterialLoader that reads the
+// server certificate and key from the given PEM files, as well as
+// the client CA bundle if clientCAPath is not empty.
+//
+// The files are only parsed again when their modification time changed.
+func NewTLSFilesLoader(certPath string, keyPath string, clientCAPath string) TLSMaterialLoader {
+
+	l := &tlsFilesLoader{
+		certPath:     certPath,
+		keyPath:      keyPath,
+		clientCAPath: clientCAPath,
+	}
+
+	return l.load
+}
+
+type tlsFilesLoader struct {
+	certPath     string
+	keyPath      string
+	clientCAPath string
+	modTimes     []time.Time
+	material     *TLSMaterial
+}
+
+func (l *tlsFilesLoader) load() (*TLSMaterial, error) {
+
+	paths := []string{l.certPath, l.keyPath}
+	if l.clientCAPath != "" {
+		paths = append(paths, l.clientCAPath)
+	}
+
+	modTimes := make([]time.Time, len(paths))
+	for i, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to stat '%s': %s", p, err)
+		}
+		modTimes[i] = fi.ModTime()
+	}
+
+	if l.material != nil && sameTimes(modTimes, l.modTimes) {
+		return l.material, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certPath, l.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load server certificate: %s", err)
+	}
+
+	material := &TLSMaterial{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if l.clientCAPath != "" {
+
+		data, err := ioutil.ReadFile(l.clientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA: %s", err)
+		}
+
+		material.ClientCAPool = x509.NewCertPool()
+		if !material.ClientCAPool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("unable to read client CA: no valid certificate in '%s'", l.clientCAPath)
+		}
+	}
+
+	l.modTimes = modTimes
+	l.material = material
+
+	return material, nil
+}
+
+// A tlsReloader periodically reloads the TLS material
+// and serves it to the new TLS connections.
+type tlsReloader struct {
+	loader         TLSMaterialLoader
+	interval       time.Duration
+	expiryWarning  time.Duration
+	metricsManager MetricsManager
+
+	material    *TLSMaterial
+	fingerprint []byte
+	lock        sync.RWMutex
+}
+
+func newTLSReloader(loader TLSMaterialLoader, interval time.Duration, expiryWarning time.Duration) *tlsReloader {
+
+	return &tlsReloader{
+		loader:        loader,
+		interval:      interval,
+		expiryWarning: expiryWarning,
+	}
+}
+
+// reload loads the current TLS material and installs it if it changed.
+func (r *tlsReloader) reload() error {
+
+	material, err := r.loader()
+	if err == nil && (material == nil || len(material.Certificates) == 0) {
+		err = fmt.Errorf("no server certificate returned")
+	}
+
+	if err != nil {
+		if m, ok := r.metricsManager.(TLSMetricsManager); ok {
+			m.RegisterTLSReload(false)
+		}
+		return err
+	}
+
+	fingerprint := tlsMaterialFingerprint(material)
+
+	r.lock.Lock()
+	changed := !bytes.Equal(fingerprint, r.fingerprint)
+	r.material = material
+	r.fingerprint = fingerprint
+	r.lock.Unlock()
+
+	if changed {
+
+		if m, ok := r.metricsManager.(TLSMetricsManager); ok {
+			m.RegisterTLSReload(true)
+		}
+
+		zap.L().Info("TLS material reloaded", zap.Int("certificates", len(material.Certificates)))
+	}
+
+	r.checkExpiration(material)
+
+	return nil
+}
+
+// checkExpiration reports the expiration of the closest
+// certificate to expire and warns if needed.
+func (r *tlsReloader) checkExpiration(material *TLSMaterial) {
+
+	var notAfter time.Time
+	var subject string
+
+	for _, cert := range material.Certificates {
+
+		leaf := cert.Leaf
+		if leaf == nil {
+			if len(cert.Certificate) == 0 {
+				continue
+			}
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				continue
+			}
+		}
+
+		if notAfter.IsZero() || leaf.NotAfter.Before(notAfter) {
+			notAfter = leaf.NotAfter
+			subject = leaf.Subject.String()
+		}
+	}
+
+	if notAfter.IsZero() {
+		return
+	}
+
+	if m, ok := r.metricsManager.(TLSMetricsManager); ok {
+		m.SetTLSCertificateExpiration(notAfter)
+	}
+
+	if r.expiryWarning > 0 && time.Until(notAfter) < r.expiryWarning {
+		zap.L().Warn("Server certificate is about to expire",
+			zap.String("subject", subject),
+			zap.Time("expiration", notAfter),
+		)
+	}
+}
+
+func (r *tlsReloader) start(ctx context.Context) {
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				zap.L().Error("Unable to reload TLS material", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *tlsReloader) currentMaterial() *TLSMaterial {
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.material
+}
+
+// getCertificate can be used as tls.Config.GetCertificate.
+func (r *tlsReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	material := r.currentMaterial()
+	if material == nil || len(material.Certificates) == 0 {
+		return nil, fmt.Errorf("no server certificate available")
+	}
+
+	return &material.Certificates[0], nil
+}
+
+// makeGetConfigForClient returns a function that can be used as
+// tls.Config.GetConfigForClient. It returns a copy of the given base
+// configuration using the current TLS material.
+func (r *tlsReloader) makeGetConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+
+		material := r.currentMaterial()
+		if material == nil {
+			return nil, fmt.Errorf("no TLS material available")
+		}
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.GetCertificate = nil
+		cfg.Certificates = material.Certificates
+
+		if material.ClientCAPool != nil {
+			cfg.ClientCAs = material.ClientCAPool
+		}
+
+		return cfg, nil
+	}
+}
+
+func tlsMaterialFingerprint(material *TLSMaterial) []byte {
+
+	h := sha256.New()
+
+	for _, cert := range material.Certificates {
+		for _, der := range cert.Certificate {
+			_, _ = h.Write(der) // nolint: errcheck
+		}
+	}
+
+	if material.ClientCAPool != nil {
+		for _, subject := range material.ClientCAPool.Subjects() { // nolint: staticcheck
+			_, _ = h.Write(subject) // nolint: errcheck
+		}
+	}
+
+	return h.Sum(nil)
+}
+
+func sameTimes(a []time.Time, b []time.Time) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
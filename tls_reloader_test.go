@@ -0,0 +1,246 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type mockTLSMetricsManager struct {
+	mockMetricsManager
+	reloads    []bool
+	expiration time.Time
+
+	sync.Mutex
+}
+
+func (m *mockTLSMetricsManager) RegisterTLSReload(success bool) {
+	m.Lock()
+	m.reloads = append(m.reloads, success)
+	m.Unlock()
+}
+
+func (m *mockTLSMetricsManager) SetTLSCertificateExpiration(t time.Time) {
+	m.Lock()
+	m.expiration = t
+	m.Unlock()
+}
+
+func copyFixture(t *testing.T, name string, dest string) {
+
+	data, err := ioutil.ReadFile(filepath.Join("fixtures/certs", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(dest, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSReloader_FilesLoader(t *testing.T) {
+
+	Convey("Given I have certificates on disk", t, func() {
+
+		dir, err := ioutil.TempDir("", "bahamut")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir) // nolint: errcheck
+
+		certPath := filepath.Join(dir, "cert.pem")
+		keyPath := filepath.Join(dir, "key.pem")
+		caPath := filepath.Join(dir, "ca.pem")
+
+		copyFixture(t, "server-cert.pem", certPath)
+		copyFixture(t, "server-key.pem", keyPath)
+		copyFixture(t, "ca-cert.pem", caPath)
+
+		loader := NewTLSFilesLoader(certPath, keyPath, caPath)
+
+		Convey("When I load the material", func() {
+
+			m1, err := loader()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the material should be correct", func() {
+				So(len(m1.Certificates), ShouldEqual, 1)
+				So(m1.ClientCAPool, ShouldNotBeNil)
+			})
+
+			Convey("When I load it again without changes", func() {
+
+				m2, err := loader()
+
+				Convey("Then I should get the same material", func() {
+					So(err, ShouldBeNil)
+					So(m2, ShouldEqual, m1)
+				})
+			})
+
+			Convey("When I change the client CA and load it again", func() {
+
+				copyFixture(t, "client-cert.pem", caPath)
+				future := time.Now().Add(time.Hour)
+				if err := os.Chtimes(caPath, future, future); err != nil {
+					panic(err)
+				}
+
+				m2, err := loader()
+
+				Convey("Then I should get a new material", func() {
+					So(err, ShouldBeNil)
+					So(m2, ShouldNotEqual, m1)
+				})
+			})
+
+			Convey("When I remove a file and load it again", func() {
+
+				_ = os.Remove(keyPath)
+
+				_, err := loader()
+
+				Convey("Then err should not be nil", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When I load with an invalid client CA", func() {
+
+			if err := ioutil.WriteFile(caPath, []byte("not a cert"), 0600); err != nil {
+				panic(err)
+			}
+
+			_, err := loader()
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEndWith, "no valid certificate in '"+caPath+"'")
+			})
+		})
+	})
+}
+
+func TestTLSReloader_reload(t *testing.T) {
+
+	Convey("Given I have a tls reloader", t, func() {
+
+		_, clientcapool, servercerts := loadFixtureCertificates()
+
+		var material *TLSMaterial
+		var loaderErr error
+
+		mm := &mockTLSMetricsManager{}
+		r := newTLSReloader(
+			func() (*TLSMaterial, error) { return material, loaderErr },
+			time.Second,
+			100*365*24*time.Hour,
+		)
+		r.metricsManager = mm
+
+		Convey("When I reload valid material", func() {
+
+			material = &TLSMaterial{Certificates: servercerts, ClientCAPool: clientcapool}
+			err := r.reload()
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the material should be installed", func() {
+				So(r.currentMaterial(), ShouldEqual, material)
+			})
+
+			Convey("Then the metrics should be reported", func() {
+				So(mm.reloads, ShouldResemble, []bool{true})
+				So(mm.expiration.IsZero(), ShouldBeFalse)
+			})
+
+			Convey("Then getCertificate should return the certificate", func() {
+				cert, err := r.getCertificate(&tls.ClientHelloInfo{})
+				So(err, ShouldBeNil)
+				So(cert, ShouldEqual, &material.Certificates[0])
+			})
+
+			Convey("Then the config for client should use the material", func() {
+
+				base := &tls.Config{MinVersion: tls.VersionTLS12}
+				base.GetConfigForClient = r.makeGetConfigForClient(base)
+
+				cfg, err := base.GetConfigForClient(&tls.ClientHelloInfo{})
+				So(err, ShouldBeNil)
+				So(cfg.Certificates, ShouldResemble, servercerts)
+				So(cfg.ClientCAs, ShouldEqual, clientcapool)
+				So(cfg.MinVersion, ShouldEqual, tls.VersionTLS12)
+				So(cfg.GetConfigForClient, ShouldBeNil)
+			})
+
+			Convey("When I reload the same material", func() {
+
+				err := r.reload()
+
+				Convey("Then no new reload should be reported", func() {
+					So(err, ShouldBeNil)
+					So(mm.reloads, ShouldResemble, []bool{true})
+				})
+			})
+
+			Convey("When the loader fails", func() {
+
+				loaderErr = fmt.Errorf("boom")
+				err := r.reload()
+
+				Convey("Then err should not be nil", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "boom")
+				})
+
+				Convey("Then the previous material should be kept", func() {
+					So(r.currentMaterial(), ShouldEqual, material)
+				})
+
+				Convey("Then the failure should be reported", func() {
+					So(mm.reloads, ShouldResemble, []bool{true, false})
+				})
+			})
+		})
+
+		Convey("When I reload material without certificate", func() {
+
+			material = &TLSMaterial{}
+			err := r.reload()
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "no server certificate returned")
+			})
+
+			Convey("Then getCertificate should fail", func() {
+				_, err := r.getCertificate(&tls.ClientHelloInfo{})
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"os"
 	"runtime/debug"
 	"strings"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/log"
@@ -124,3 +125,23 @@ func splitPtr(tag string, key *string, value *string) (err error) {
 
 	return fmt.Errorf("invalid tag: missing equal symbol '%s'", tag)
 }
+
+// waitUntil checks the given condition every 10ms until it returns
+// true or the context is done. It returns the last result of the condition.
+func waitUntil(ctx context.Context, condition func() bool) bool {
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if condition() {
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return condition()
+		}
+	}
+}
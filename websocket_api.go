@@ -0,0 +1,269 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// wsMaxConcurrentAPIRequests is the maximum number of requests
+// a push session can run concurrently.
+const wsMaxConcurrentAPIRequests = 16
+
+// wsAPIHandlers contains the handlers used to run
+// the requests sent over the push websocket.
+var wsAPIHandlers = map[elemental.Operation]handlerFunc{
+	elemental.OperationRetrieveMany: handleRetrieveMany,
+	elemental.OperationRetrieve:     handleRetrieve,
+	elemental.OperationCreate:       handleCreate,
+	elemental.OperationUpdate:       handleUpdate,
+	elemental.OperationDelete:       handleDelete,
+	elemental.OperationPatch:        handlePatch,
+	elemental.OperationInfo:         handleInfo,
+}
+
+// A PushAPIResponse is the data of the message of type
+// PushMessageTypeResponse sent in reply to an elemental.Request
+// sent over the push websocket.
+//
+// Like PushMessage, the data is a raw JSON value when using JSON,
+// and a byte array containing the msgpack encoded value when using
+// msgpack.
+type PushAPIResponse struct {
+	StatusCode int             `msgpack:"status" json:"status"`
+	Total      int             `msgpack:"total,omitempty" json:"total,omitempty"`
+	Next       string          `msgpack:"next,omitempty" json:"next,omitempty"`
+	Messages   []string        `msgpack:"messages,omitempty" json:"messages,omitempty"`
+	RawData    []byte          `msgpack:"data,omitempty" json:"-"`
+	JSONData   json.RawMessage `msgpack:"-" json:"data,omitempty"`
+}
+
+func newPushAPIResponse(response *elemental.Response, encoding elemental.EncodingType) *PushAPIResponse {
+
+	out := &PushAPIResponse{
+		StatusCode: response.StatusCode,
+		Total:      response.Total,
+		Next:       response.Next,
+		Messages:   response.Messages,
+	}
+
+	if encoding == elemental.EncodingTypeJSON {
+		out.JSONData = response.Data
+	} else {
+		out.RawData = response.Data
+	}
+
+	return out
+}
+
+// handleAPIRequest decodes the elemental.Request contained in the given
+// message and runs it in the background. The response is sent back to
+// the client with the request ID of the message. If the session already
+// runs too many requests, the client receives a 429 error instead.
+func (s *wsPushSession) handleAPIRequest(msg *PushMessage) {
+
+	if !s.cfg.pushServer.apiEnabled {
+		s.sendMessageError(msg.RequestID, elemental.NewError("Not implemented", "Requests are not supported over this websocket", "bahamut", http.StatusNotImplemented))
+		return
+	}
+
+	request := elemental.NewRequest()
+	if err := msg.Decode(request); err != nil {
+		s.sendMessageError(msg.RequestID, elemental.NewError("Bad request", fmt.Sprintf("could not decode message into %T: %s", request, err), "bahamut", http.StatusBadRequest))
+		return
+	}
+
+	if err := s.prepareAPIRequest(request); err != nil {
+		s.sendMessageError(msg.RequestID, err.(elemental.Error))
+		return
+	}
+
+	select {
+	case s.apiRequests <- struct{}{}:
+	default:
+		s.sendMessageError(msg.RequestID, ErrRateLimit)
+		return
+	}
+
+	go func() {
+		defer func() { <-s.apiRequests }()
+		s.runAPIRequest(msg.RequestID, request)
+	}()
+}
+
+// prepareAPIRequest validates the given request and fills it
+// with the information of the session.
+func (s *wsPushSession) prepareAPIRequest(request *elemental.Request) error {
+
+	manager, ok := s.cfg.model.modelManagers[request.Version]
+	if !ok {
+		return elemental.NewError("Bad request", fmt.Sprintf("Unknown api version '%d'", request.Version), "bahamut", http.StatusBadRequest)
+	}
+
+	if _, ok := wsAPIHandlers[request.Operation]; !ok {
+		return elemental.NewError("Bad request", fmt.Sprintf("Unknown operation '%s'", request.Operation), "bahamut", http.StatusBadRequest)
+	}
+
+	identity := manager.IdentityFromName(request.Identity.Name)
+	if identity.IsEmpty() {
+		identity = manager.IdentityFromCategory(request.Identity.Category)
+	}
+	if identity.IsEmpty() {
+		return elemental.NewError("Bad request", "Unknown identity", "bahamut", http.StatusBadRequest)
+	}
+	request.Identity = identity
+
+	if request.ParentIdentity.IsEmpty() {
+		request.ParentIdentity = elemental.RootIdentity
+	} else if !request.ParentIdentity.IsEqual(elemental.RootIdentity) {
+		parent := manager.IdentityFromName(request.ParentIdentity.Name)
+		if parent.IsEmpty() {
+			parent = manager.IdentityFromCategory(request.ParentIdentity.Category)
+		}
+		if parent.IsEmpty() {
+			return elemental.NewError("Bad request", "Unknown parent identity", "bahamut", http.StatusBadRequest)
+		}
+		request.ParentIdentity = parent
+	}
+
+	request.ContentType = s.encodingRead
+	request.Accept = s.encodingWrite
+	request.ClientIP = s.remoteAddr
+	request.TLSConnectionState = s.tlsConnectionState
+
+	if request.Password == "" {
+		request.Password = s.Token()
+	}
+
+	if request.Headers == nil {
+		request.Headers = http.Header{}
+	}
+
+	return nil
+}
+
+// runAPIRequest runs the given request using the same checks and
+// handlers as the rest server and sends the response to the client.
+// As the session has already been authenticated, the request is not
+// authenticated again and gets the claims of the session.
+func (s *wsPushSession) runAPIRequest(requestID string, request *elemental.Request) {
+
+	var measure FinishMeasurementFunc
+	if s.cfg.healthServer.metricsManager != nil {
+		measure = s.cfg.healthServer.metricsManager.MeasureRequest(string(request.Operation), s.cfg.pushServer.endpoint)
+	}
+
+	ctx := traceRequest(s.ctx, request, s.cfg.opentracing.tracer, s.cfg.opentracing.excludedIdentities, s.cfg.opentracing.traceCleaner)
+	defer finishTracing(ctx)
+
+	ctx, done, err := admitRequest(ctx, s.cfg, s.admission, request, requestQuery(request))
+	if err != nil {
+		s.sendAPIResponse(ctx, requestID, makeErrorResponse(ctx, elemental.NewResponse(request), err, nil), measure)
+		return
+	}
+	defer done()
+
+	cfg := s.cfg
+	cfg.security.requestAuthenticators = nil
+
+	bctx := newContext(ctx, request)
+	bctx.SetClaims(s.Claims())
+
+	response := wsAPIHandlers[request.Operation](bctx, cfg, s.processorFinder, s.pusher)
+
+	// A response served from the cache is only written by the response
+	// writer of the context, so we build it from the cache entry.
+	if bctx.cacheEntry != nil && response != nil && response.StatusCode < http.StatusBadRequest {
+		response = bctx.cacheEntry.response(request)
+	}
+
+	s.sendAPIResponse(ctx, requestID, response, measure)
+}
+
+// requestQuery returns the parameters of the given request
+// as the query of the equivalent http request.
+func requestQuery(request *elemental.Request) url.Values {
+
+	query := url.Values{}
+	for k, p := range request.Parameters {
+		for _, v := range p.Values() {
+			query.Add(k, queryValue(v))
+		}
+	}
+
+	return query
+}
+
+// queryValue returns the given parameter value
+// formatted as it would be in an http query.
+func queryValue(v interface{}) string {
+
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sendAPIResponse sends the given response to the client in
+// a message of type PushMessageTypeResponse. Unlike the events,
+// the response is never dropped: it waits for the client to
+// read the previous messages, until the session is done.
+func (s *wsPushSession) sendAPIResponse(ctx context.Context, requestID string, response *elemental.Response, measure FinishMeasurementFunc) {
+
+	// The response is nil when the session context has been canceled.
+	if response == nil {
+		return
+	}
+
+	if measure != nil {
+		measure(response.StatusCode, opentracing.SpanFromContext(ctx))
+	}
+
+	msg, err := newPushMessage(PushMessageTypeResponse, requestID, newPushAPIResponse(response, s.encodingWrite), s.encodingWrite)
+	if err != nil {
+		zap.L().Error("Unable to encode api response", zap.String("sessionID", s.id), zap.Error(err))
+		return
+	}
+
+	data, err := elemental.Encode(s.encodingWrite, msg)
+	if err != nil {
+		zap.L().Error("Unable to encode push message", zap.String("sessionID", s.id), zap.Error(err))
+		return
+	}
+
+	select {
+	case s.dataCh <- &wsOutgoing{data: data}:
+	case <-s.ctx.Done():
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/wsc"
+	"golang.org/x/time/rate"
+)
+
+type mockClaimsAuthorizer struct {
+	claim string
+}
+
+func (a *mockClaimsAuthorizer) IsAuthorized(ctx Context) (AuthAction, error) {
+
+	for _, c := range ctx.Claims() {
+		if c == a.claim {
+			return AuthActionOK, nil
+		}
+	}
+
+	return AuthActionKO, nil
+}
+
+func TestPushSessionAPI(t *testing.T) {
+
+	makeSession := func(ctx context.Context, apiEnabled bool, processor Processor) (*wsPushSession, wsc.MockWebsocket) {
+
+		cfg := config{}
+		cfg.pushServer.apiEnabled = apiEnabled
+		cfg.model.modelManagers = map[int]elemental.ModelManager{0: testmodel.Manager(), 1: testmodel.Manager()}
+		cfg.security.requestAuthenticators = []RequestAuthenticator{&mockAuth{action: AuthActionKO}}
+		cfg.security.authorizers = []Authorizer{&mockClaimsAuthorizer{claim: "@auth:subject=alice"}}
+
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{}}).WithContext(ctx),
+			cfg,
+			func(*wsPushSession) {},
+			elemental.EncodingTypeJSON,
+			elemental.EncodingTypeJSON,
+		)
+		s.processorFinder = func(elemental.Identity) (Processor, error) { return processor, nil }
+		s.pusher = func(...*elemental.Event) {}
+
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+
+		go s.listen()
+
+		return s, conn
+	}
+
+	sendRequest := func(conn wsc.MockWebsocket, requestID string, request *elemental.Request) {
+		msg, err := newPushMessage(PushMessageTypeRequest, requestID, request, elemental.EncodingTypeJSON)
+		So(err, ShouldBeNil)
+		data, err := elemental.Encode(elemental.EncodingTypeJSON, msg)
+		So(err, ShouldBeNil)
+		conn.NextRead(data)
+	}
+
+	read := func(conn wsc.MockWebsocket) []byte {
+		select {
+		case data := <-conn.LastWrite():
+			return data
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	retrieveRequest := func() *elemental.Request {
+		request := elemental.NewRequest()
+		request.Operation = elemental.OperationRetrieve
+		request.Identity = testmodel.ListIdentity
+		request.ObjectID = "1"
+		request.Version = 1
+		return request
+	}
+
+	Convey("Given I have a session with the api enabled", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn := makeSession(ctx, true, &mockProcessor{output: &testmodel.List{ID: "1", Name: "hello"}})
+
+		Convey("When the client sends a request with the claims of the session", func() {
+
+			s.SetClaims([]string{"@auth:subject=alice"})
+			sendRequest(conn, "r1", retrieveRequest())
+
+			msg := &PushMessage{encoding: elemental.EncodingTypeJSON}
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), msg), ShouldBeNil)
+
+			resp := &PushAPIResponse{}
+			So(msg.Decode(resp), ShouldBeNil)
+
+			list := &testmodel.List{}
+			So(elemental.Decode(elemental.EncodingTypeJSON, resp.JSONData, list), ShouldBeNil)
+
+			Convey("Then the client should receive the response", func() {
+				So(msg.Type, ShouldEqual, PushMessageTypeResponse)
+				So(msg.RequestID, ShouldEqual, "r1")
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(list.ID, ShouldEqual, "1")
+				So(list.Name, ShouldEqual, "hello")
+			})
+		})
+
+		Convey("When the client sends a request without the expected claims", func() {
+
+			s.SetClaims([]string{"@auth:subject=bob"})
+			sendRequest(conn, "r2", retrieveRequest())
+
+			msg := &PushMessage{encoding: elemental.EncodingTypeJSON}
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), msg), ShouldBeNil)
+
+			resp := &PushAPIResponse{}
+			So(msg.Decode(resp), ShouldBeNil)
+
+			Convey("Then the client should receive a forbidden response", func() {
+				So(msg.RequestID, ShouldEqual, "r2")
+				So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("When the client sends a request with an unknown version", func() {
+
+			request := retrieveRequest()
+			request.Version = 42
+			sendRequest(conn, "r3", request)
+
+			var event elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &event), ShouldBeNil)
+
+			var ee elemental.Error
+			So(elemental.Decode(event.Encoding, event.JSONData, &ee), ShouldBeNil)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Code, ShouldEqual, http.StatusBadRequest)
+				So(ee.Description, ShouldEqual, "Unknown api version '42'")
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "r3"})
+			})
+		})
+
+		Convey("When the client sends a request while the rate limit of its api is reached", func() {
+
+			s.SetClaims([]string{"@auth:subject=alice"})
+			limiter := rate.NewLimiter(rate.Limit(1), 1)
+			limiter.Allow()
+			s.cfg.rateLimiting.apiRateLimiters = map[elemental.Identity]apiRateLimit{
+				testmodel.ListIdentity: {limiter: limiter},
+			}
+
+			sendRequest(conn, "r4", retrieveRequest())
+
+			msg := &PushMessage{encoding: elemental.EncodingTypeJSON}
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), msg), ShouldBeNil)
+
+			resp := &PushAPIResponse{}
+			So(msg.Decode(resp), ShouldBeNil)
+
+			Convey("Then the client should receive a rate limit response", func() {
+				So(msg.RequestID, ShouldEqual, "r4")
+				So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+			})
+		})
+
+		Convey("When the client sends a request with a timeout that is not valid", func() {
+
+			s.SetClaims([]string{"@auth:subject=alice"})
+			s.cfg.requestTimeouts.clientEnabled = true
+
+			request := retrieveRequest()
+			request.Headers = http.Header{RequestTimeoutHeader: []string{"nope"}}
+			sendRequest(conn, "r5", request)
+
+			msg := &PushMessage{encoding: elemental.EncodingTypeJSON}
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), msg), ShouldBeNil)
+
+			resp := &PushAPIResponse{}
+			So(msg.Decode(resp), ShouldBeNil)
+
+			Convey("Then the client should receive a bad request response", func() {
+				So(msg.RequestID, ShouldEqual, "r5")
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When the client sends the same request twice with the response cache enabled", func() {
+
+			s.SetClaims([]string{"@auth:subject=alice"})
+			s.cfg.responseCache = newResponseCache()
+			s.cfg.responseCache.ttls[testmodel.ListIdentity] = time.Minute
+
+			readList := func() (*PushAPIResponse, *testmodel.List) {
+				msg := &PushMessage{encoding: elemental.EncodingTypeJSON}
+				So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), msg), ShouldBeNil)
+
+				resp := &PushAPIResponse{}
+				So(msg.Decode(resp), ShouldBeNil)
+
+				list := &testmodel.List{}
+				So(elemental.Decode(elemental.EncodingTypeJSON, resp.JSONData, list), ShouldBeNil)
+
+				return resp, list
+			}
+
+			sendRequest(conn, "r7", retrieveRequest())
+			resp1, list1 := readList()
+
+			// The processor now returns something else, so
+			// the second response must come from the cache.
+			s.processorFinder = func(elemental.Identity) (Processor, error) {
+				return &mockProcessor{output: &testmodel.List{ID: "1", Name: "changed"}}, nil
+			}
+
+			sendRequest(conn, "r8", retrieveRequest())
+			resp2, list2 := readList()
+
+			Convey("Then both responses should contain the data", func() {
+				So(resp1.StatusCode, ShouldEqual, http.StatusOK)
+				So(list1.Name, ShouldEqual, "hello")
+				So(resp2.StatusCode, ShouldEqual, http.StatusOK)
+				So(list2.ID, ShouldEqual, "1")
+				So(list2.Name, ShouldEqual, "hello")
+			})
+		})
+
+		Convey("When the client sends a request while the session runs too many requests", func() {
+
+			for i := 0; i < wsMaxConcurrentAPIRequests; i++ {
+				s.apiRequests <- struct{}{}
+			}
+
+			sendRequest(conn, "r6", retrieveRequest())
+
+			var event elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &event), ShouldBeNil)
+
+			var ee elemental.Error
+			So(elemental.Decode(event.Encoding, event.JSONData, &ee), ShouldBeNil)
+
+			Convey("Then the client should receive a rate limit error", func() {
+				So(ee.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "r6"})
+			})
+		})
+	})
+
+	Convey("Given I have a session with the api disabled", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, conn := makeSession(ctx, false, &mockProcessor{})
+
+		Convey("When the client sends a request", func() {
+
+			sendRequest(conn, "r1", retrieveRequest())
+
+			var event elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &event), ShouldBeNil)
+
+			var ee elemental.Error
+			So(elemental.Decode(event.Encoding, event.JSONData, &ee), ShouldBeNil)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Code, ShouldEqual, http.StatusNotImplemented)
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "r1"})
+			})
+		})
+	})
+}
+
+func TestPushSessionAPI_requestQuery(t *testing.T) {
+
+	Convey("Given I have a request with typed parameters", t, func() {
+
+		date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		request := elemental.NewRequest()
+		request.Parameters = elemental.Parameters{
+			"s": elemental.NewParameter(elemental.ParameterTypeString, "a", "b"),
+			"i": elemental.NewParameter(elemental.ParameterTypeInt, 42),
+			"f": elemental.NewParameter(elemental.ParameterTypeFloat, 1000000.5),
+			"b": elemental.NewParameter(elemental.ParameterTypeBool, true),
+			"t": elemental.NewParameter(elemental.ParameterTypeTime, date),
+			"d": elemental.NewParameter(elemental.ParameterTypeDuration, 90*time.Second),
+		}
+
+		Convey("When I get its query", func() {
+
+			query := requestQuery(request)
+
+			Convey("Then the values should be formatted like in an http query", func() {
+				So(query["s"], ShouldResemble, []string{"a", "b"})
+				So(query.Get("i"), ShouldEqual, "42")
+				So(query.Get("f"), ShouldEqual, "1000000.5")
+				So(query.Get("b"), ShouldEqual, "true")
+				So(query.Get("t"), ShouldEqual, "2020-01-02T03:04:05Z")
+				So(query.Get("d"), ShouldEqual, "1m30s")
+			})
+
+			Convey("Then they should pass the validation of their type", func() {
+				So(checkParameterValue(elemental.ParameterDefinition{Type: elemental.ParameterTypeTime}, query.Get("t")), ShouldBeEmpty)
+				So(checkParameterValue(elemental.ParameterDefinition{Type: elemental.ParameterTypeDuration}, query.Get("d")), ShouldBeEmpty)
+				So(checkParameterValue(elemental.ParameterDefinition{Type: elemental.ParameterTypeFloat}, query.Get("f")), ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,269 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// Various push config parameters a client can
+// set to enable the batching of its events.
+const (
+	// PushConfigParamBatchWindow is the maximum duration the events are
+	// held to be sent in a single batch, like 50ms. Batching is only
+	// enabled when it is set.
+	PushConfigParamBatchWindow = "batchWindow"
+
+	// PushConfigParamBatchSize is the maximum number of events in a batch.
+	PushConfigParamBatchSize = "batchSize"
+
+	// PushConfigParamCoalesce, when set to true, only keeps the latest
+	// update event of an object in a batch.
+	PushConfigParamCoalesce = "coalesce"
+)
+
+// wsBatchSettings are the batching settings of a push session.
+type wsBatchSettings struct {
+	window   time.Duration
+	size     int
+	coalesce bool
+}
+
+// newWSBatchSettings returns the batching settings requested by the
+// client in the given push config parameters, capped by the server
+// configuration.
+func newWSBatchSettings(cfg config, params url.Values) wsBatchSettings {
+
+	if !cfg.pushServer.batchingEnabled {
+		return wsBatchSettings{}
+	}
+
+	window, err := time.ParseDuration(params.Get(PushConfigParamBatchWindow))
+	if err != nil || window <= 0 {
+		return wsBatchSettings{}
+	}
+
+	if window > cfg.pushServer.batchMaxWindow {
+		window = cfg.pushServer.batchMaxWindow
+	}
+
+	size := cfg.pushServer.batchMaxSize
+	if n, err := strconv.Atoi(params.Get(PushConfigParamBatchSize)); err == nil && n > 0 && n < size {
+		size = n
+	}
+
+	return wsBatchSettings{
+		window:   window,
+		size:     size,
+		coalesce: params.Get(PushConfigParamCoalesce) == "true",
+	}
+}
+
+// enabled returns true if the batching is enabled.
+func (b wsBatchSettings) enabled() bool {
+	return b.window > 0
+}
+
+// A wsOutgoing is an encoded message waiting to be written on the
+// websocket of a push session. If batch is set, it is an event to add
+// to the batch of the session, and the key identifies the object of
+// an update event when the session coalesces the events.
+type wsOutgoing struct {
+	key   string
+	data  []byte
+	batch bool
+}
+
+// A wsBatcher accumulates the events of a push session until the batch
+// window expires or the batch is full. It is only used from the listen
+// loop of the session.
+type wsBatcher struct {
+	events []*wsOutgoing
+	index  map[string]int
+	timer  *time.Timer
+}
+
+func newWSBatcher() *wsBatcher {
+	return &wsBatcher{
+		index: map[string]int{},
+	}
+}
+
+// timerC returns the channel receiving when the batch must
+// be flushed, or nil if there is no pending batch.
+func (b *wsBatcher) timerC() <-chan time.Time {
+
+	if b.timer == nil {
+		return nil
+	}
+
+	return b.timer.C
+}
+
+// add adds the given event to the batch, replacing the previous update
+// of the same object when coalescing. It returns true if the batch must
+// be flushed.
+func (b *wsBatcher) add(event *wsOutgoing, settings wsBatchSettings) bool {
+
+	if settings.coalesce && event.key != "" {
+		if i, ok := b.index[event.key]; ok {
+			b.events[i] = event
+			return false
+		}
+		b.index[event.key] = len(b.events)
+	}
+
+	b.events = append(b.events, event)
+
+	if len(b.events) == 1 && settings.window > 0 {
+		b.timer = time.NewTimer(settings.window)
+	}
+
+	return settings.window <= 0 || len(b.events) >= settings.size
+}
+
+// flush returns the pending events and resets the batch.
+func (b *wsBatcher) flush() []*wsOutgoing {
+
+	b.stop()
+
+	events := b.events
+	b.events = nil
+	b.index = map[string]int{}
+
+	return events
+}
+
+// stop stops the timer of the pending batch, if any.
+func (b *wsBatcher) stop() {
+
+	if b.timer == nil {
+		return
+	}
+
+	b.timer.Stop()
+	b.timer = nil
+}
+
+// encodeBatch encodes the given events as an array
+// in a single message using the given encoding.
+func encodeBatch(encoding elemental.EncodingType, events []*wsOutgoing) []byte {
+
+	buf := &bytes.Buffer{}
+
+	if encoding == elemental.EncodingTypeJSON {
+		buf.WriteByte('[')
+		for i, event := range events {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(event.data)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes()
+	}
+
+	// The events are already msgpack encoded,
+	// so we only need to write the array header.
+	n := len(events)
+	switch {
+	case n < 16:
+		buf.WriteByte(0x90 | byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xdc)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdd)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+
+	for _, event := range events {
+		buf.Write(event.data)
+	}
+
+	return buf.Bytes()
+}
+
+// eventObjectID returns the ID of the object of the given event,
+// or an empty string if it cannot be decoded.
+func eventObjectID(event *elemental.Event) string {
+
+	obj := &struct {
+		ID string `msgpack:"ID" json:"ID"`
+	}{}
+
+	if err := event.Decode(obj); err != nil {
+		return ""
+	}
+
+	return obj.ID
+}
+
+// batchSettings returns the current batching settings of the session.
+func (s *wsPushSession) batchSettings() wsBatchSettings {
+
+	s.currentPushConfigLock.RLock()
+	defer s.currentPushConfigLock.RUnlock()
+
+	return s.batch
+}
+
+// sendEvent sends the given encoded event, or queues it to be sent in
+// a batch if the session batches its events. The objectID function
+// returns the ID of the object of the event. It is only called to
+// coalesce the update events, and it can return an empty string.
+func (s *wsPushSession) sendEvent(event *elemental.Event, data []byte, objectID func() string) {
+
+	settings := s.batchSettings()
+	if !settings.enabled() {
+		s.send(data)
+		return
+	}
+
+	out := &wsOutgoing{data: data, batch: true}
+	if settings.coalesce && event.Type == elemental.EventUpdate {
+		if id := objectID(); id != "" {
+			out.key = event.Identity + "/" + id
+		}
+	}
+
+	select {
+	case s.dataCh <- out:
+	default:
+		atomic.AddUint64(&s.eventsDropped, 1)
+		zap.L().Warn("Slow consumer. event dropped",
+			zap.String("sessionID", s.id),
+			zap.Strings("claims", s.Claims()),
+		)
+	}
+}
+
+// flushBatch writes the pending events of the given batcher
+// in a single message.
+func (s *wsPushSession) flushBatch(batcher *wsBatcher) {
+
+	events := batcher.flush()
+	if len(events) == 0 {
+		return
+	}
+
+	s.conn.Write(encodeBatch(s.encodingWrite, events))
+	atomic.AddUint64(&s.eventsSent, uint64(len(events)))
+}
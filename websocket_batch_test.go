@@ -0,0 +1,361 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/wsc"
+)
+
+func TestBatch_newWSBatchSettings(t *testing.T) {
+
+	cfg := config{}
+	cfg.pushServer.batchingEnabled = true
+	cfg.pushServer.batchMaxWindow = 100 * time.Millisecond
+	cfg.pushServer.batchMaxSize = 10
+
+	Convey("Calling newWSBatchSettings with batching disabled on the server should disable it", t, func() {
+		s := newWSBatchSettings(config{}, url.Values{PushConfigParamBatchWindow: []string{"50ms"}})
+		So(s.enabled(), ShouldBeFalse)
+	})
+
+	Convey("Calling newWSBatchSettings with no window should disable it", t, func() {
+		So(newWSBatchSettings(cfg, url.Values{}).enabled(), ShouldBeFalse)
+		So(newWSBatchSettings(cfg, url.Values{PushConfigParamBatchWindow: []string{"nope"}}).enabled(), ShouldBeFalse)
+		So(newWSBatchSettings(cfg, url.Values{PushConfigParamBatchWindow: []string{"-1s"}}).enabled(), ShouldBeFalse)
+	})
+
+	Convey("Calling newWSBatchSettings with valid parameters should work", t, func() {
+		s := newWSBatchSettings(cfg, url.Values{
+			PushConfigParamBatchWindow: []string{"50ms"},
+			PushConfigParamBatchSize:   []string{"5"},
+			PushConfigParamCoalesce:    []string{"true"},
+		})
+		So(s, ShouldResemble, wsBatchSettings{window: 50 * time.Millisecond, size: 5, coalesce: true})
+	})
+
+	Convey("Calling newWSBatchSettings with too large parameters should cap them", t, func() {
+		s := newWSBatchSettings(cfg, url.Values{
+			PushConfigParamBatchWindow: []string{"1h"},
+			PushConfigParamBatchSize:   []string{"1000"},
+		})
+		So(s, ShouldResemble, wsBatchSettings{window: 100 * time.Millisecond, size: 10})
+	})
+}
+
+func TestBatch_wsBatcher(t *testing.T) {
+
+	Convey("Given I have a batcher", t, func() {
+
+		b := newWSBatcher()
+		defer b.stop()
+
+		settings := wsBatchSettings{window: time.Hour, size: 3}
+
+		Convey("Then it should have no timer", func() {
+			So(b.timerC(), ShouldBeNil)
+		})
+
+		Convey("When I add events until the batch is full", func() {
+
+			full1 := b.add(&wsOutgoing{data: []byte("1")}, settings)
+			timer := b.timerC()
+			full2 := b.add(&wsOutgoing{data: []byte("2")}, settings)
+			full3 := b.add(&wsOutgoing{data: []byte("3")}, settings)
+
+			Convey("Then only the last one should fill the batch", func() {
+				So(timer, ShouldNotBeNil)
+				So(full1, ShouldBeFalse)
+				So(full2, ShouldBeFalse)
+				So(full3, ShouldBeTrue)
+			})
+
+			Convey("When I flush it", func() {
+
+				events := b.flush()
+
+				Convey("Then I should get the events and the batch should be reset", func() {
+					So(len(events), ShouldEqual, 3)
+					So(string(events[2].data), ShouldEqual, "3")
+					So(b.timerC(), ShouldBeNil)
+					So(b.flush(), ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("When I add updates of the same object while coalescing", func() {
+
+			settings.coalesce = true
+			b.add(&wsOutgoing{key: "list/1", data: []byte("1")}, settings)
+			b.add(&wsOutgoing{data: []byte("2")}, settings)
+			b.add(&wsOutgoing{key: "list/1", data: []byte("3")}, settings)
+			b.add(&wsOutgoing{key: "list/2", data: []byte("4")}, settings)
+
+			events := b.flush()
+
+			Convey("Then only the latest update should be kept at the place of the first one", func() {
+				So(len(events), ShouldEqual, 3)
+				So(string(events[0].data), ShouldEqual, "3")
+				So(string(events[1].data), ShouldEqual, "2")
+				So(string(events[2].data), ShouldEqual, "4")
+			})
+		})
+
+		Convey("When I add updates of the same object without coalescing", func() {
+
+			b.add(&wsOutgoing{key: "list/1", data: []byte("1")}, settings)
+			b.add(&wsOutgoing{key: "list/1", data: []byte("2")}, settings)
+
+			Convey("Then all of them should be kept", func() {
+				So(len(b.flush()), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestBatch_encodeBatch(t *testing.T) {
+
+	makeEvents := func(encoding elemental.EncodingType, n int) []*wsOutgoing {
+		events := make([]*wsOutgoing, n)
+		for i := range events {
+			data, err := elemental.Encode(encoding, map[string]int{"i": i})
+			So(err, ShouldBeNil)
+			events[i] = &wsOutgoing{data: data}
+		}
+		return events
+	}
+
+	Convey("Calling encodeBatch with json should work", t, func() {
+		So(string(encodeBatch(elemental.EncodingTypeJSON, makeEvents(elemental.EncodingTypeJSON, 2))), ShouldEqual, `[{"i":0},{"i":1}]`)
+		So(string(encodeBatch(elemental.EncodingTypeJSON, nil)), ShouldEqual, `[]`)
+	})
+
+	Convey("Calling encodeBatch with msgpack should work", t, func() {
+
+		for _, n := range []int{1, 15, 16, 300} {
+
+			var out []map[string]int
+			So(elemental.Decode(elemental.EncodingTypeMSGPACK, encodeBatch(elemental.EncodingTypeMSGPACK, makeEvents(elemental.EncodingTypeMSGPACK, n)), &out), ShouldBeNil)
+
+			So(len(out), ShouldEqual, n)
+			So(out[n-1]["i"], ShouldEqual, n-1)
+		}
+	})
+}
+
+func TestBatch_eventObjectID(t *testing.T) {
+
+	Convey("Calling eventObjectID should work", t, func() {
+		So(eventObjectID(elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "1"})), ShouldEqual, "1")
+		So(eventObjectID(elemental.NewEvent(elemental.EventUpdate, &testmodel.List{})), ShouldEqual, "")
+	})
+	Convey("Calling objectID on a prepared event should only decode it when called", t, func() {
+		pe := &preparedEvent{event: elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "1"})}
+		So(pe.objectIDValue, ShouldBeEmpty)
+		So(pe.objectID(), ShouldEqual, "1")
+		So(pe.objectIDValue, ShouldEqual, "1")
+	})
+}
+
+func TestBatch_listen(t *testing.T) {
+
+	makeSession := func(ctx context.Context, params url.Values) (*wsPushSession, wsc.MockWebsocket) {
+
+		cfg := config{}
+		cfg.pushServer.batchingEnabled = true
+		cfg.pushServer.batchMaxWindow = time.Minute
+		cfg.pushServer.batchMaxSize = 10
+
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{}}).WithContext(ctx),
+			cfg,
+			func(*wsPushSession) {},
+			elemental.EncodingTypeJSON,
+			elemental.EncodingTypeJSON,
+		)
+
+		pc := elemental.NewPushConfig()
+		for k := range params {
+			pc.SetParameter(k, params.Get(k))
+		}
+		s.setCurrentPushConfig(pc)
+
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+
+		go s.listen()
+
+		return s, conn
+	}
+
+	read := func(conn wsc.MockWebsocket) []byte {
+		select {
+		case data := <-conn.LastWrite():
+			return data
+		case <-time.After(2 * time.Second):
+			return nil
+		}
+	}
+
+	Convey("Given I have a session that does not batch its events", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn := makeSession(ctx, nil)
+
+		Convey("When I push events", func() {
+
+			s.DirectPush(
+				elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "1"}),
+				elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "2"}),
+			)
+
+			event1 := &elemental.Event{}
+			event2 := &elemental.Event{}
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), event1), ShouldBeNil)
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), event2), ShouldBeNil)
+
+			Convey("Then it should receive them one by one", func() {
+				So(event1.Type, ShouldEqual, elemental.EventCreate)
+				So(event2.Type, ShouldEqual, elemental.EventCreate)
+			})
+		})
+	})
+
+	Convey("Given I have a session batching its events", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn := makeSession(ctx, url.Values{PushConfigParamBatchWindow: []string{"50ms"}})
+
+		Convey("When I push events", func() {
+
+			s.DirectPush(
+				elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "1"}),
+				elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "1"}),
+				elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "1"}),
+			)
+
+			var events []*elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &events), ShouldBeNil)
+
+			Convey("Then it should receive them in a single message", func() {
+				So(len(events), ShouldEqual, 3)
+				So(events[0].Type, ShouldEqual, elemental.EventCreate)
+				So(events[2].Type, ShouldEqual, elemental.EventUpdate)
+			})
+		})
+	})
+
+	Convey("Given I have a session batching and coalescing its events", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn := makeSession(ctx, url.Values{
+			PushConfigParamBatchWindow: []string{"50ms"},
+			PushConfigParamBatchSize:   []string{"2"},
+			PushConfigParamCoalesce:    []string{"true"},
+		})
+
+		Convey("When I push events", func() {
+
+			s.DirectPush(
+				elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "1", Name: "a"}),
+				elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "1", Name: "b"}),
+				elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "2", Name: "c"}),
+				elemental.NewEvent(elemental.EventUpdate, &testmodel.List{ID: "3", Name: "d"}),
+			)
+
+			var batch1, batch2 []*elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &batch1), ShouldBeNil)
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &batch2), ShouldBeNil)
+
+			list := &testmodel.List{}
+			So(batch1[0].Decode(list), ShouldBeNil)
+
+			Convey("Then it should only receive the latest update of each object, in full batches", func() {
+				So(len(batch1), ShouldEqual, 2)
+				So(len(batch2), ShouldEqual, 1)
+				So(list.Name, ShouldEqual, "b")
+			})
+		})
+	})
+
+	Convey("Given I have a session batching its events with a long window", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn := makeSession(ctx, url.Values{PushConfigParamBatchWindow: []string{"30s"}})
+
+		s.DirectPush(
+			elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "1"}),
+			elemental.NewEvent(elemental.EventCreate, &testmodel.List{ID: "2"}),
+		)
+
+		Convey("When a message that is not batched is sent", func() {
+
+			s.send([]byte(`{"type":"pong"}`))
+
+			var events []*elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &events), ShouldBeNil)
+			data := read(conn)
+
+			Convey("Then the pending batch should be sent first", func() {
+				So(len(events), ShouldEqual, 2)
+				So(string(data), ShouldEqual, `{"type":"pong"}`)
+			})
+		})
+
+		Convey("When the client changes its push config", func() {
+
+			conn.NextRead([]byte(`{"filters":{"list":{}}}`))
+
+			var events []*elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &events), ShouldBeNil)
+
+			Convey("Then the pending batch should be sent", func() {
+				So(len(events), ShouldEqual, 2)
+				So(s.batchSettings().enabled(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the session is terminated", func() {
+
+			s.terminate(PushSessionCloseUnauthorized, "")
+
+			var events []*elemental.Event
+			So(elemental.Decode(elemental.EncodingTypeJSON, read(conn), &events), ShouldBeNil)
+
+			Convey("Then the pending batch should be sent before the session is closed", func() {
+				So(len(events), ShouldEqual, 2)
+
+				select {
+				case <-conn.Done():
+				case <-time.After(2 * time.Second):
+					So("session should have been closed", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+}
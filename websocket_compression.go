@@ -0,0 +1,335 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.aporeto.io/elemental"
+)
+
+const (
+	// disableCompressionQueryParam contains the name of the query parameter that can be passed in by the client to
+	// disable the compression of its push session.
+	disableCompressionQueryParam = "disableCompression"
+
+	compressedWSWriteWait  = 10 * time.Second
+	compressedWSPongWait   = 30 * time.Second
+	compressedWSPingPeriod = 15 * time.Second
+)
+
+// compressionNegotiated returns true if the client
+// offered the permessage-deflate extension.
+func compressionNegotiated(r *http.Request) bool {
+
+	for _, ext := range r.Header["Sec-Websocket-Extensions"] {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// acceptCompressed returns the compressedWebsocket of the given session.
+func (n *pushServer) acceptCompressed(ctx context.Context, r *http.Request, ws *websocket.Conn, counter *wsCountingConn, session *wsPushSession) *compressedWebsocket {
+
+	_ = ws.SetCompressionLevel(n.cfg.pushServer.compressionLevel)
+
+	metrics, _ := n.cfg.healthServer.metricsManager.(PushCompressionMetricsManager)
+
+	return newCompressedWebsocket(
+		ctx,
+		ws,
+		counter,
+		session.encodingWrite,
+		compressionNegotiated(r) && !session.disablesCompression(),
+		n.cfg.pushServer.compressionThreshold,
+		metrics,
+	)
+}
+
+// disablesCompression returns true if the client
+// disabled the compression of its session.
+func (s *wsPushSession) disablesCompression() bool {
+
+	s.parametersLock.RLock()
+	defer s.parametersLock.RUnlock()
+
+	_, ok := s.parameters[disableCompressionQueryParam]
+	return ok
+}
+
+// A wsCountingConn is a net.Conn that counts the bytes written.
+type wsCountingConn struct {
+	net.Conn
+	written uint64
+}
+
+func (c *wsCountingConn) Write(b []byte) (int, error) {
+
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.written, uint64(n))
+
+	return n, err
+}
+
+func (c *wsCountingConn) bytesWritten() uint64 {
+	return atomic.LoadUint64(&c.written)
+}
+
+// A wsCountingHijacker is an http.ResponseWriter returning a
+// wsCountingConn when hijacked, so the size of the compressed
+// messages written by the websocket can be known.
+type wsCountingHijacker struct {
+	http.ResponseWriter
+	conn *wsCountingConn
+}
+
+func (h *wsCountingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.conn = &wsCountingConn{Conn: conn}
+
+	return h.conn, rw, nil
+}
+
+// A compressedWebsocket is the wsc.Websocket used by the push sessions
+// when the compression is enabled. Unlike the one returned by wsc.Accept,
+// it writes the messages itself so it can decide for every message if it
+// must be compressed, and measure the compression. Otherwise, it must
+// behave like the one returned by wsc.Accept, with the same timeouts.
+type compressedWebsocket struct {
+	conn        *websocket.Conn
+	counter     *wsCountingConn
+	encoding    elemental.EncodingType
+	compress    bool
+	threshold   int
+	metrics     PushCompressionMetricsManager
+	readChan    chan []byte
+	writeChan   chan []byte
+	errorChan   chan error
+	doneChan    chan error
+	closeChan   chan int
+	cancel      context.CancelFunc
+	messageType int
+	once        sync.Once
+}
+
+func newCompressedWebsocket(
+	ctx context.Context,
+	conn *websocket.Conn,
+	counter *wsCountingConn,
+	encoding elemental.EncodingType,
+	compress bool,
+	threshold int,
+	metrics PushCompressionMetricsManager,
+) *compressedWebsocket {
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	w := &compressedWebsocket{
+		conn:        conn,
+		counter:     counter,
+		encoding:    encoding,
+		compress:    compress,
+		threshold:   threshold,
+		metrics:     metrics,
+		readChan:    make(chan []byte, 16),
+		writeChan:   make(chan []byte, 64),
+		errorChan:   make(chan error, 64),
+		doneChan:    make(chan error, 1),
+		closeChan:   make(chan int, 1),
+		cancel:      cancel,
+		messageType: websocket.TextMessage,
+	}
+
+	if encoding == elemental.EncodingTypeMSGPACK {
+		w.messageType = websocket.BinaryMessage
+	}
+
+	go w.readPump()
+	go w.writePump(ctx)
+
+	return w
+}
+
+func (w *compressedWebsocket) Read() chan []byte { return w.readChan }
+func (w *compressedWebsocket) Done() chan error  { return w.doneChan }
+func (w *compressedWebsocket) Error() chan error { return w.errorChan }
+
+func (w *compressedWebsocket) Write(data []byte) {
+
+	select {
+	case w.writeChan <- data:
+	default:
+		w.reportError(fmt.Errorf("write chan full: message discarded"))
+	}
+}
+
+// Close closes the websocket with the given code once the
+// messages already written have been sent. Only the first
+// call has an effect.
+func (w *compressedWebsocket) Close(code int) {
+
+	select {
+	case w.closeChan <- code:
+	default:
+	}
+}
+
+func (w *compressedWebsocket) reportError(err error) {
+
+	select {
+	case w.errorChan <- err:
+	default:
+	}
+}
+
+// terminate closes the connection right away, with the given close code
+// if it is not 0, and publishes the given error in the done channel. Only
+// the first call has an effect.
+func (w *compressedWebsocket) terminate(code int, err error) {
+
+	w.once.Do(func() {
+
+		if code != 0 {
+			_ = w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(compressedWSWriteWait))
+		}
+
+		_ = w.conn.Close()
+		w.cancel()
+		w.doneChan <- err
+	})
+}
+
+func (w *compressedWebsocket) readPump() {
+
+	_ = w.conn.SetReadDeadline(time.Now().Add(compressedWSPongWait))
+	w.conn.SetPongHandler(func(string) error {
+		return w.conn.SetReadDeadline(time.Now().Add(compressedWSPongWait))
+	})
+
+	for {
+		_, data, err := w.conn.ReadMessage()
+		if err != nil {
+			w.terminate(0, err)
+			return
+		}
+
+		select {
+		case w.readChan <- data:
+		default:
+			w.reportError(fmt.Errorf("read chan full: message discarded"))
+		}
+	}
+}
+
+func (w *compressedWebsocket) writePump(ctx context.Context) {
+
+	ticker := time.NewTicker(compressedWSPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case data := <-w.writeChan:
+
+			if err := w.write(data, time.Now().Add(compressedWSWriteWait)); err != nil {
+				w.terminate(0, err)
+				return
+			}
+
+		case code := <-w.closeChan:
+
+			w.flush()
+			w.terminate(code, nil)
+			return
+
+		case <-ticker.C:
+
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(compressedWSWriteWait)); err != nil {
+				w.terminate(0, err)
+				return
+			}
+
+		case <-ctx.Done():
+
+			w.terminate(websocket.CloseGoingAway, nil)
+			return
+		}
+	}
+}
+
+// flush writes the messages waiting in the write channel,
+// until there is none left or compressedWSWriteWait is elapsed.
+func (w *compressedWebsocket) flush() {
+
+	deadline := time.Now().Add(compressedWSWriteWait)
+
+	for {
+		select {
+		case data := <-w.writeChan:
+			if err := w.write(data, deadline); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// write writes the given message before the given deadline, compressed
+// if the compression is enabled for the session and the message is not
+// smaller than the threshold.
+func (w *compressedWebsocket) write(data []byte, deadline time.Time) error {
+
+	compress := w.compress && len(data) >= w.threshold
+	w.conn.EnableWriteCompression(compress)
+
+	if err := w.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	if !compress || w.metrics == nil || w.counter == nil {
+		return w.conn.WriteMessage(w.messageType, data)
+	}
+
+	before := w.counter.bytesWritten()
+	start := time.Now()
+
+	if err := w.conn.WriteMessage(w.messageType, data); err != nil {
+		return err
+	}
+
+	w.metrics.ObservePushCompression(w.encoding, len(data), int(w.counter.bytesWritten()-before), time.Since(start))
+
+	return nil
+}
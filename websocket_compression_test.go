@@ -0,0 +1,333 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/wsc"
+)
+
+type mockPushCompressionMetricsManager struct {
+	uncompressed []int
+	compressed   []int
+
+	sync.Mutex
+}
+
+func (m *mockPushCompressionMetricsManager) ObservePushCompression(encoding elemental.EncodingType, uncompressed int, compressed int, d time.Duration) {
+	m.Lock()
+	m.uncompressed = append(m.uncompressed, uncompressed)
+	m.compressed = append(m.compressed, compressed)
+	m.Unlock()
+}
+
+func (m *mockPushCompressionMetricsManager) observed() ([]int, []int) {
+	m.Lock()
+	defer m.Unlock()
+	return append([]int{}, m.uncompressed...), append([]int{}, m.compressed...)
+}
+
+func TestCompressedWebsocket(t *testing.T) {
+
+	makeServer := func(ctx context.Context, compress bool, threshold int, metrics PushCompressionMetricsManager) (*httptest.Server, chan *compressedWebsocket) {
+
+		conns := make(chan *compressedWebsocket, 1)
+		upgrader := websocket.Upgrader{EnableCompression: true}
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hijacker := &wsCountingHijacker{ResponseWriter: w}
+			ws, err := upgrader.Upgrade(hijacker, r, nil)
+			if err != nil {
+				return
+			}
+			conns <- newCompressedWebsocket(ctx, ws, hijacker.conn, elemental.EncodingTypeJSON, compress && compressionNegotiated(r), threshold, metrics)
+		}))
+
+		return ts, conns
+	}
+
+	dial := func(ts *httptest.Server) *websocket.Conn {
+		dialer := websocket.Dialer{EnableCompression: true}
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		So(err, ShouldBeNil)
+		return conn
+	}
+
+	large := []byte(`{"data":"` + strings.Repeat("a", 4096) + `"}`)
+	small := []byte(`{"data":"a"}`)
+
+	Convey("Given I have a compressed websocket with a threshold", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		metrics := &mockPushCompressionMetricsManager{}
+		ts, conns := makeServer(ctx, true, 1024, metrics)
+		defer ts.Close()
+
+		client := dial(ts)
+		defer client.Close() // nolint
+
+		ws := <-conns
+
+		Convey("When I write a message smaller and a message larger than the threshold", func() {
+
+			ws.Write(small)
+			ws.Write(large)
+
+			_, d1, err1 := client.ReadMessage()
+			_, d2, err2 := client.ReadMessage()
+
+			Convey("Then the client should receive them and only the large one should be compressed", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(string(d1), ShouldEqual, string(small))
+				So(string(d2), ShouldEqual, string(large))
+
+				uncompressed, compressed := metrics.observed()
+				So(uncompressed, ShouldResemble, []int{len(large)})
+				So(compressed[0], ShouldBeLessThan, len(large)/10)
+			})
+		})
+	})
+
+	Convey("Given I have a compressed websocket", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ts, conns := makeServer(ctx, true, 0, nil)
+		defer ts.Close()
+
+		client := dial(ts)
+		defer client.Close() // nolint
+
+		ws := <-conns
+
+		Convey("When I write some messages and close it right away", func() {
+
+			ws.Write(small)
+			ws.Write(large)
+			ws.Close(websocket.ClosePolicyViolation)
+
+			_, d1, err1 := client.ReadMessage()
+			_, d2, err2 := client.ReadMessage()
+			_, _, err3 := client.ReadMessage()
+
+			Convey("Then the client should receive the messages before the close code", func() {
+				So(err1, ShouldBeNil)
+				So(string(d1), ShouldEqual, string(small))
+				So(err2, ShouldBeNil)
+				So(string(d2), ShouldEqual, string(large))
+				So(websocket.IsCloseError(err3, websocket.ClosePolicyViolation), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I have a websocket with the compression disabled for the session", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		metrics := &mockPushCompressionMetricsManager{}
+		ts, conns := makeServer(ctx, false, 0, metrics)
+		defer ts.Close()
+
+		client := dial(ts)
+		defer client.Close() // nolint
+
+		ws := <-conns
+
+		Convey("When I write a large message", func() {
+
+			ws.Write(large)
+
+			_, data, err := client.ReadMessage()
+
+			Convey("Then it should not be compressed", func() {
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, string(large))
+
+				uncompressed, _ := metrics.observed()
+				So(len(uncompressed), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestCompressedWebsocket_parity(t *testing.T) {
+
+	// The compressed websocket must behave like the one returned by
+	// wsc.Accept, which is used when the compression is disabled.
+	accepters := map[string]func(context.Context, *websocket.Conn, *wsCountingConn) wsc.Websocket{
+		"wsc": func(ctx context.Context, ws *websocket.Conn, counter *wsCountingConn) wsc.Websocket {
+			conn, err := wsc.Accept(ctx, ws, wsc.Config{WriteChanSize: 64, ReadChanSize: 16})
+			if err != nil {
+				panic(err)
+			}
+			return conn
+		},
+		"compressed": func(ctx context.Context, ws *websocket.Conn, counter *wsCountingConn) wsc.Websocket {
+			return newCompressedWebsocket(ctx, ws, counter, elemental.EncodingTypeJSON, true, 0, nil)
+		},
+	}
+
+	for name, accept := range accepters {
+
+		makeServer := func(ctx context.Context, readLimit int64) (*httptest.Server, chan wsc.Websocket) {
+
+			conns := make(chan wsc.Websocket, 1)
+			upgrader := websocket.Upgrader{EnableCompression: true}
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hijacker := &wsCountingHijacker{ResponseWriter: w}
+				ws, err := upgrader.Upgrade(hijacker, r, nil)
+				if err != nil {
+					return
+				}
+				if readLimit > 0 {
+					ws.SetReadLimit(readLimit)
+				}
+				conns <- accept(ctx, ws, hijacker.conn)
+			}))
+
+			return ts, conns
+		}
+
+		dial := func(ts *httptest.Server) *websocket.Conn {
+			dialer := websocket.Dialer{EnableCompression: true}
+			conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+			So(err, ShouldBeNil)
+			return conn
+		}
+
+		waitDone := func(ws wsc.Websocket) (bool, error) {
+			select {
+			case err := <-ws.Done():
+				return true, err
+			case <-time.After(time.Second):
+				return false, nil
+			}
+		}
+
+		Convey(fmt.Sprintf("Given I have a %s websocket", name), t, func() {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ts, conns := makeServer(ctx, 1024)
+			defer ts.Close()
+
+			client := dial(ts)
+			defer client.Close() // nolint
+
+			ws := <-conns
+
+			Convey("When I write a message", func() {
+
+				ws.Write([]byte(`{"data":"a"}`))
+
+				mt, data, err := client.ReadMessage()
+
+				Convey("Then the client should receive it as a text message", func() {
+					So(err, ShouldBeNil)
+					So(mt, ShouldEqual, websocket.TextMessage)
+					So(string(data), ShouldEqual, `{"data":"a"}`)
+				})
+			})
+
+			Convey("When the client sends a message", func() {
+
+				So(client.WriteMessage(websocket.TextMessage, []byte("hello")), ShouldBeNil)
+
+				var data []byte
+				select {
+				case data = <-ws.Read():
+				case <-time.After(time.Second):
+				}
+
+				Convey("Then it should be received", func() {
+					So(string(data), ShouldEqual, "hello")
+				})
+			})
+
+			Convey("When the client sends a message larger than the read limit", func() {
+
+				So(client.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 2048))), ShouldBeNil)
+
+				done, err := waitDone(ws)
+
+				Convey("Then done should be notified with the read limit error", func() {
+					So(done, ShouldBeTrue)
+					So(err, ShouldEqual, websocket.ErrReadLimit)
+				})
+			})
+
+			Convey("When I close the websocket", func() {
+
+				ws.Close(websocket.CloseNormalClosure)
+
+				_, _, err := client.ReadMessage()
+				done, _ := waitDone(ws)
+
+				Convey("Then the client should receive the close code and done should be notified", func() {
+					So(websocket.IsCloseError(err, websocket.CloseNormalClosure), ShouldBeTrue)
+					So(done, ShouldBeTrue)
+				})
+			})
+
+			Convey("When the context is canceled", func() {
+
+				cancel()
+
+				_, _, err := client.ReadMessage()
+
+				Convey("Then the client should receive a going away close code", func() {
+					So(websocket.IsCloseError(err, websocket.CloseGoingAway), ShouldBeTrue)
+				})
+			})
+
+			Convey("When the client closes the connection", func() {
+
+				_ = client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+
+				done, err := waitDone(ws)
+
+				Convey("Then done should be notified with the close error", func() {
+					So(done, ShouldBeTrue)
+					So(websocket.IsCloseError(err, websocket.CloseGoingAway), ShouldBeTrue)
+				})
+			})
+		})
+	}
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+
+	Convey("Calling compressionNegotiated should work", t, func() {
+		r := &http.Request{Header: http.Header{}}
+		So(compressionNegotiated(r), ShouldBeFalse)
+		r.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits")
+		So(compressionNegotiated(r), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,399 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// Default values of the push dispatch workers pool.
+const (
+	defaultPushDispatchQueueSize = 24000
+	pushDispatchWorkerQueueSize  = 64
+)
+
+// A queuedPublication is a publication waiting to be dispatched.
+type queuedPublication struct {
+	publication *Publication
+	received    time.Time
+}
+
+// A preparedEvent is an event ready to be dispatched to the push sessions.
+type preparedEvent struct {
+	event       *elemental.Event
+	dataMSGPACK []byte
+	dataJSON    []byte
+	summary     interface{}
+	routingKeys []string
+	received    time.Time
+	pending     int32
+
+	objectIDOnce  sync.Once
+	objectIDValue string
+}
+
+// objectID returns the ID of the object of the event. It is only
+// decoded once, when a session coalescing its events needs it.
+func (pe *preparedEvent) objectID() string {
+
+	pe.objectIDOnce.Do(func() {
+		pe.objectIDValue = eventObjectID(pe.event)
+	})
+
+	return pe.objectIDValue
+}
+
+// A sessionShard holds the push sessions handled by a dispatch
+// worker, indexed by their routing keys.
+type sessionShard struct {
+	unrouted map[string]*wsPushSession
+	routes   map[string]map[string]*wsPushSession
+	keys     map[string][]string
+}
+
+func newSessionShard() *sessionShard {
+	return &sessionShard{
+		unrouted: map[string]*wsPushSession{},
+		routes:   map[string]map[string]*wsPushSession{},
+		keys:     map[string][]string{},
+	}
+}
+
+// add adds the given session to the shard under the given routing keys.
+func (s *sessionShard) add(session *wsPushSession, keys []string) {
+
+	id := session.Identifier()
+
+	if len(keys) == 0 {
+		s.unrouted[id] = session
+		return
+	}
+
+	s.keys[id] = keys
+	for _, k := range keys {
+		if _, ok := s.routes[k]; !ok {
+			s.routes[k] = map[string]*wsPushSession{}
+		}
+		s.routes[k][id] = session
+	}
+}
+
+// remove removes the given session from the shard.
+func (s *sessionShard) remove(session *wsPushSession) {
+
+	id := session.Identifier()
+
+	delete(s.unrouted, id)
+
+	for _, k := range s.keys[id] {
+		delete(s.routes[k], id)
+		if len(s.routes[k]) == 0 {
+			delete(s.routes, k)
+		}
+	}
+	delete(s.keys, id)
+}
+
+// candidates returns the sessions that must be considered for an
+// event with the given routing keys. If there are no keys, all the
+// sessions of the shard are returned.
+func (s *sessionShard) candidates(keys []string) []*wsPushSession {
+
+	out := make([]*wsPushSession, 0, len(s.unrouted))
+	for _, session := range s.unrouted {
+		out = append(out, session)
+	}
+
+	// A session with several keys is listed under each of them.
+	seen := map[string]struct{}{}
+	collect := func(sessions map[string]*wsPushSession) {
+		for id, session := range sessions {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			out = append(out, session)
+		}
+	}
+
+	if len(keys) == 0 {
+		for _, sessions := range s.routes {
+			collect(sessions)
+		}
+		return out
+	}
+
+	for _, k := range keys {
+		collect(s.routes[k])
+	}
+
+	return out
+}
+
+// shardFor returns the index of the dispatch worker
+// handling the session with the given identifier.
+func shardFor(id string, shards int) int {
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+
+	return int(h.Sum32() % uint32(shards))
+}
+
+// enqueuePublication queues the given publication for dispatch.
+// If the queue is full, the publication is dropped.
+func (n *pushServer) enqueuePublication(publication *Publication) {
+
+	select {
+	case n.dispatchQueue <- queuedPublication{publication: publication, received: time.Now()}:
+		n.reportQueueDepth()
+	default:
+		zap.L().Warn("Push dispatch queue is full: dropping publication", zap.String("topic", publication.Topic))
+		if m, ok := n.cfg.healthServer.metricsManager.(PushMetricsManager); ok {
+			m.RegisterDroppedPublication(n.cfg.pushServer.topic)
+		}
+	}
+}
+
+// reportQueueDepth reports the depth of the dispatch
+// queue to the metrics manager, if it supports it.
+func (n *pushServer) reportQueueDepth() {
+
+	if m, ok := n.cfg.healthServer.metricsManager.(PushMetricsManager); ok {
+		m.SetPushDispatchQueueDepth(n.cfg.pushServer.topic, len(n.dispatchQueue))
+	}
+}
+
+// runDispatchQueue prepares the queued publications in order
+// and hands them over to all the dispatch workers, until
+// the given context is canceled. If the queue of a worker is
+// full, the event is dropped for the sessions of its shard,
+// so a slow shard does not hold back the others.
+func (n *pushServer) runDispatchQueue(ctx context.Context) {
+
+	for {
+		select {
+
+		case qp := <-n.dispatchQueue:
+
+			n.reportQueueDepth()
+
+			pe, err := n.prepareEvent(qp.publication)
+			if err != nil {
+				continue
+			}
+			pe.received = qp.received
+			pe.pending = int32(len(n.workerQueues))
+
+			for shard, q := range n.workerQueues {
+				select {
+				case q <- pe:
+				default:
+					n.dropDispatch(shard, pe)
+				}
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// prepareEvent decodes the event of the given publication and
+// prepares its encoded data and its summary once for all sessions.
+func (n *pushServer) prepareEvent(publication *Publication) (*preparedEvent, error) {
+
+	event := &elemental.Event{}
+	if err := publication.Decode(event); err != nil {
+		zap.L().Error("Unable to decode event",
+			zap.Stringer("event", event),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	n.cfg.responseCache.invalidate(event)
+
+	// We prepare the event data in both json and msgpack
+	// once for all.
+	dataMSGPACK, dataJSON, err := prepareEventData(event)
+	if err != nil {
+		zap.L().Error("Unable to prepare event encoding",
+			zap.Stringer("event", event),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	// We prepate the event summary if needed
+	var eventSummary interface{}
+	if n.cfg.pushServer.dispatchHandler != nil {
+		eventSummary, err = n.cfg.pushServer.dispatchHandler.SummarizeEvent(event)
+		if err != nil {
+			zap.L().Error("Unable to summary event",
+				zap.Stringer("event", event),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+	}
+
+	// We only route the event if the sessions are indexed.
+	var routingKeys []string
+	if _, ok := n.cfg.pushServer.dispatchHandler.(PushRoutingHandler); ok {
+		if r, ok := eventSummary.(RoutableEventSummary); ok {
+			routingKeys = r.RoutingKeys()
+		}
+	}
+
+	return &preparedEvent{
+		event:       event,
+		dataMSGPACK: dataMSGPACK,
+		dataJSON:    dataJSON,
+		summary:     eventSummary,
+		routingKeys: routingKeys,
+	}, nil
+}
+
+// runDispatchWorker dispatches the prepared events to the sessions of
+// the given shard, until the given context is canceled. As a session
+// always belongs to the same shard, it receives the events in order.
+func (n *pushServer) runDispatchWorker(ctx context.Context, shard int) {
+
+	for {
+		select {
+
+		case pe := <-n.workerQueues[shard]:
+
+			n.sessionsLock.RLock()
+			sessions := n.shards[shard].candidates(pe.routingKeys)
+			n.sessionsLock.RUnlock()
+
+			for _, session := range sessions {
+				n.dispatchEvent(session, pe)
+			}
+
+			n.eventDispatched(pe)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dropDispatch drops the given prepared event for the sessions of the
+// given shard. The event is counted as dropped for all the sessions
+// it could have been sent to, so they can be found in the push
+// sessions reported by the health server.
+func (n *pushServer) dropDispatch(shard int, pe *preparedEvent) {
+
+	zap.L().Warn("Push dispatch worker queue is full: dropping event",
+		zap.String("topic", n.cfg.pushServer.topic),
+		zap.Int("shard", shard),
+	)
+
+	if m, ok := n.cfg.healthServer.metricsManager.(PushMetricsManager); ok {
+		m.RegisterDroppedDispatch(n.cfg.pushServer.topic)
+	}
+
+	n.sessionsLock.RLock()
+	sessions := n.shards[shard].candidates(pe.routingKeys)
+	n.sessionsLock.RUnlock()
+
+	for _, session := range sessions {
+		atomic.AddUint64(&session.eventsDropped, 1)
+	}
+
+	n.eventDispatched(pe)
+}
+
+// eventDispatched marks the given prepared event as dispatched
+// to a shard. The last shard reports the dispatch duration.
+func (n *pushServer) eventDispatched(pe *preparedEvent) {
+
+	if atomic.AddInt32(&pe.pending, -1) != 0 {
+		return
+	}
+
+	if m, ok := n.cfg.healthServer.metricsManager.(PushMetricsManager); ok {
+		m.ObservePushDispatchDuration(n.cfg.pushServer.topic, time.Since(pe.received))
+	}
+}
+
+// dispatchEvent sends the given prepared event to the given session
+// if it is not filtered out and the dispatch handler allows it.
+func (n *pushServer) dispatchEvent(session *wsPushSession, pe *preparedEvent) {
+
+	// Client sent an invalid push config, this is a noop as it makes no sense to continue processing;
+	// wait until they send another message that is valid.
+	if session.inErrorState() {
+		return
+	}
+
+	// If event happened before session, we don't send it.
+	if pe.event.Timestamp.Before(session.startTime) {
+		return
+	}
+
+	// If the event identity (or related identities) are filtered out
+	// we don't send it.
+	if f := session.currentPushConfig(); f != nil {
+
+		identities := []string{pe.event.Identity}
+		if n.cfg.pushServer.dispatchHandler != nil {
+			identities = append(identities, n.cfg.pushServer.dispatchHandler.RelatedEventIdentities(pe.event.Identity)...)
+		}
+
+		var ok bool
+		for _, identity := range identities {
+			if !f.IsFilteredOut(identity, pe.event.Type) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return
+		}
+	}
+
+	if n.cfg.pushServer.dispatchHandler != nil {
+		dispatch, err := n.cfg.pushServer.dispatchHandler.ShouldDispatch(session, pe.event, pe.summary)
+		if err != nil {
+			// temp before we move to error wrapping
+			if err != context.Canceled && !strings.Contains(err.Error(), "context canceled") {
+				zap.L().Error("Error while calling dispatchHandler.ShouldDispatch", zap.Error(err))
+			}
+
+			return
+		}
+
+		if !dispatch {
+			return
+		}
+	}
+
+	switch session.encodingWrite {
+	case elemental.EncodingTypeMSGPACK:
+		session.sendEvent(pe.event, pe.dataMSGPACK, pe.objectID)
+	case elemental.EncodingTypeJSON:
+		session.sendEvent(pe.event, pe.dataJSON, pe.objectID)
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-zoo/bone"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	testmodel "go.aporeto.io/elemental/test/model"
+	"go.aporeto.io/wsc"
+)
+
+type mockPushMetricsManager struct {
+	mockMetricsManager
+	depth      int
+	durations  []time.Duration
+	dropped    int
+	undispatch int
+
+	sync.Mutex
+}
+
+func (m *mockPushMetricsManager) SetPushDispatchQueueDepth(topic string, depth int) {
+	m.Lock()
+	m.depth = depth
+	m.Unlock()
+}
+
+func (m *mockPushMetricsManager) ObservePushDispatchDuration(topic string, d time.Duration) {
+	m.Lock()
+	m.durations = append(m.durations, d)
+	m.Unlock()
+}
+
+func (m *mockPushMetricsManager) RegisterDroppedPublication(topic string) {
+	m.Lock()
+	m.dropped++
+	m.Unlock()
+}
+
+func (m *mockPushMetricsManager) RegisterDroppedDispatch(topic string) {
+	m.Lock()
+	m.undispatch++
+	m.Unlock()
+}
+
+func TestPushDispatch_shardFor(t *testing.T) {
+
+	Convey("Calling shardFor should be stable and within range", t, func() {
+		for i := 0; i < 100; i++ {
+			id := fmt.Sprintf("session-%d", i)
+			shard := shardFor(id, 8)
+			So(shard, ShouldBeBetweenOrEqual, 0, 7)
+			So(shardFor(id, 8), ShouldEqual, shard)
+		}
+		So(shardFor("a", 1), ShouldEqual, 0)
+	})
+}
+
+func TestPushDispatch_workers(t *testing.T) {
+
+	Convey("Given I create a push server with 4 workers", t, func() {
+
+		cfg := config{}
+		cfg.pushServer.dispatchWorkers = 4
+		cfg.pushServer.dispatchQueueSize = 10
+
+		wss := newPushServer(cfg, bone.New(), nil)
+
+		Convey("Then the workers should be configured", func() {
+			So(len(wss.workerQueues), ShouldEqual, 4)
+			So(len(wss.shards), ShouldEqual, 4)
+			So(cap(wss.dispatchQueue), ShouldEqual, 10)
+		})
+
+		Convey("When I register and unregister a session", func() {
+
+			s := newWSPushSession(&http.Request{URL: &url.URL{}}, cfg, nil, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
+			shard := wss.shards[shardFor(s.Identifier(), 4)]
+
+			wss.registerSession(s)
+			_, registered := shard.unrouted[s.Identifier()]
+
+			wss.unregisterSession(s)
+			_, unregistered := shard.unrouted[s.Identifier()]
+
+			Convey("Then the shard of the session should be updated", func() {
+				So(registered, ShouldBeTrue)
+				So(unregistered, ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given I create a push server with the default configuration", t, func() {
+
+		wss := newPushServer(config{}, bone.New(), nil)
+
+		Convey("Then the defaults should be used", func() {
+			So(len(wss.workerQueues), ShouldBeGreaterThan, 0)
+			So(cap(wss.dispatchQueue), ShouldEqual, defaultPushDispatchQueueSize)
+		})
+	})
+}
+
+func TestPushDispatch_enqueuePublication(t *testing.T) {
+
+	Convey("Given I have a push server with a full dispatch queue", t, func() {
+
+		mm := &mockPushMetricsManager{}
+
+		cfg := config{}
+		cfg.pushServer.topic = "topic"
+		cfg.pushServer.dispatchQueueSize = 1
+		cfg.healthServer.metricsManager = mm
+
+		wss := newPushServer(cfg, bone.New(), nil)
+		wss.enqueuePublication(NewPublication("topic"))
+
+		Convey("When I enqueue another publication", func() {
+
+			wss.enqueuePublication(NewPublication("topic"))
+
+			Convey("Then it should be dropped", func() {
+				So(len(wss.dispatchQueue), ShouldEqual, 1)
+				So(mm.depth, ShouldEqual, 1)
+				So(mm.dropped, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestPushDispatch_runDispatchQueue(t *testing.T) {
+
+	Convey("Given I have a push server with a full worker queue", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mm := &mockPushMetricsManager{}
+
+		cfg := config{}
+		cfg.pushServer.topic = "topic"
+		cfg.pushServer.dispatchWorkers = 2
+		cfg.healthServer.metricsManager = mm
+
+		wss := newPushServer(cfg, bone.New(), nil)
+		for i := 0; i < pushDispatchWorkerQueueSize; i++ {
+			wss.workerQueues[0] <- &preparedEvent{}
+		}
+
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{}}).WithContext(ctx),
+			config{},
+			nil,
+			elemental.EncodingTypeMSGPACK,
+			elemental.EncodingTypeMSGPACK,
+		)
+		wss.shards[0].add(s, nil)
+
+		go wss.runDispatchQueue(ctx)
+
+		Convey("When I enqueue a publication", func() {
+
+			pub := NewPublication("topic")
+			So(pub.Encode(elemental.NewEvent(elemental.EventCreate, testmodel.NewList())), ShouldBeNil)
+			wss.enqueuePublication(pub)
+
+			Convey("Then it should be dropped for the full shard only", func() {
+				waitCtx, waitCancel := context.WithTimeout(ctx, 2*time.Second)
+				defer waitCancel()
+
+				So(waitUntil(waitCtx, func() bool {
+					mm.Lock()
+					defer mm.Unlock()
+					return mm.undispatch == 1
+				}), ShouldBeTrue)
+				So(len(wss.workerQueues[0]), ShouldEqual, pushDispatchWorkerQueueSize)
+				So(len(wss.workerQueues[1]), ShouldEqual, 1)
+			})
+
+			Convey("Then it should be counted as dropped for the sessions of the full shard", func() {
+				waitCtx, waitCancel := context.WithTimeout(ctx, 2*time.Second)
+				defer waitCancel()
+
+				So(waitUntil(waitCtx, func() bool {
+					return atomic.LoadUint64(&s.eventsDropped) == 1
+				}), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestPushDispatch_ordering(t *testing.T) {
+
+	Convey("Given I have a started push server with many workers and a session", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mm := &mockPushMetricsManager{}
+		pushHandler := &mockSessionHandler{shouldDispatchOK: true}
+
+		cfg := config{}
+		cfg.pushServer.enabled = true
+		cfg.pushServer.dispatchEnabled = true
+		cfg.pushServer.dispatchHandler = pushHandler
+		cfg.pushServer.dispatchWorkers = 8
+		cfg.healthServer.metricsManager = mm
+
+		wss := newPushServer(cfg, bone.New(), nil)
+		go wss.start(ctx)
+
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{}}).WithContext(ctx),
+			config{},
+			wss.unregisterSession,
+			elemental.EncodingTypeMSGPACK,
+			elemental.EncodingTypeMSGPACK,
+		)
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+		go s.listen()
+
+		wss.registerSession(s)
+
+		Convey("When I push many events", func() {
+
+			for i := 0; i < 20; i++ {
+				l := testmodel.NewList()
+				l.Name = fmt.Sprintf("%d", i)
+				evt := elemental.NewEvent(elemental.EventCreate, l)
+				evt.Timestamp = time.Now().Add(time.Second)
+				pub := NewPublication("")
+				if err := pub.Encode(evt); err != nil {
+					panic(err)
+				}
+				wss.publications <- pub
+			}
+
+			var names []string
+		L:
+			for len(names) < 20 {
+				select {
+				case data := <-conn.LastWrite():
+					var event elemental.Event
+					So(elemental.Decode(elemental.EncodingTypeMSGPACK, data, &event), ShouldBeNil)
+					l := testmodel.NewList()
+					So(elemental.Decode(event.Encoding, event.RawData, l), ShouldBeNil)
+					names = append(names, l.Name)
+				case <-time.After(2 * time.Second):
+					break L
+				}
+			}
+
+			Convey("Then the session should receive them in order", func() {
+				So(len(names), ShouldEqual, 20)
+				for i, name := range names {
+					So(name, ShouldEqual, fmt.Sprintf("%d", i))
+				}
+			})
+
+			Convey("Then the dispatch duration should be reported", func() {
+				waitCtx, waitCancel := context.WithTimeout(ctx, 2*time.Second)
+				defer waitCancel()
+
+				So(waitUntil(waitCtx, func() bool {
+					mm.Lock()
+					defer mm.Unlock()
+					return len(mm.durations) == 20
+				}), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+type mockRoutingSessionHandler struct {
+	mockSessionHandler
+	keys map[string][]string
+}
+
+func (h *mockRoutingSessionHandler) SessionRoutingKeys(session PushSession) []string {
+	return h.keys[session.Identifier()]
+}
+
+func (h *mockRoutingSessionHandler) SummarizeEvent(event *elemental.Event) (interface{}, error) {
+	return mockRoutableEventSummary{"/a"}, nil
+}
+
+type mockRoutableEventSummary []string
+
+func (s mockRoutableEventSummary) RoutingKeys() []string { return s }
+
+func TestPushDispatch_sessionShard(t *testing.T) {
+
+	Convey("Given I have a shard with some sessions", t, func() {
+
+		makeSession := func(id string) *wsPushSession {
+			s := newWSPushSession(&http.Request{URL: &url.URL{}}, config{}, nil, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
+			s.id = id
+			return s
+		}
+
+		sa := makeSession("a")
+		sb := makeSession("b")
+		sab := makeSession("ab")
+		sx := makeSession("x")
+
+		shard := newSessionShard()
+		shard.add(sa, []string{"/a"})
+		shard.add(sb, []string{"/b"})
+		shard.add(sab, []string{"/a", "/b"})
+		shard.add(sx, nil)
+
+		Convey("When I get the candidates of an event routed to /a", func() {
+
+			candidates := shard.candidates([]string{"/a"})
+
+			Convey("Then I should get the matching and unrouted sessions", func() {
+				So(len(candidates), ShouldEqual, 3)
+				So(candidates, ShouldContain, sa)
+				So(candidates, ShouldContain, sab)
+				So(candidates, ShouldContain, sx)
+			})
+		})
+
+		Convey("When I get the candidates of an event routed to /a and /b", func() {
+
+			candidates := shard.candidates([]string{"/a", "/b"})
+
+			Convey("Then I should get every session once", func() {
+				So(len(candidates), ShouldEqual, 4)
+			})
+		})
+
+		Convey("When I get the candidates of an event without routing keys", func() {
+
+			candidates := shard.candidates(nil)
+
+			Convey("Then I should get every session once", func() {
+				So(len(candidates), ShouldEqual, 4)
+			})
+		})
+
+		Convey("When I remove some sessions", func() {
+
+			shard.remove(sab)
+			shard.remove(sx)
+
+			Convey("Then the index should be updated", func() {
+				So(shard.candidates([]string{"/a"}), ShouldResemble, []*wsPushSession{sa})
+				So(shard.routes, ShouldHaveLength, 2)
+				So(shard.keys, ShouldHaveLength, 2)
+				So(shard.unrouted, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I remove the last session of a key", func() {
+
+			shard.remove(sb)
+			shard.remove(sab)
+
+			Convey("Then the key should be removed", func() {
+				_, ok := shard.routes["/b"]
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestPushDispatch_routing(t *testing.T) {
+
+	Convey("Given I have a started push server with a routing handler and two sessions", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pushHandler := &mockRoutingSessionHandler{
+			mockSessionHandler: mockSessionHandler{shouldDispatchOK: true},
+			keys:               map[string][]string{"s1": {"/a"}, "s2": {"/b"}},
+		}
+
+		cfg := config{}
+		cfg.pushServer.enabled = true
+		cfg.pushServer.dispatchEnabled = true
+		cfg.pushServer.dispatchHandler = pushHandler
+
+		wss := newPushServer(cfg, bone.New(), nil)
+		go wss.start(ctx)
+
+		makeSession := func(id string) (*wsPushSession, wsc.MockWebsocket) {
+			s := newWSPushSession(
+				(&http.Request{URL: &url.URL{}}).WithContext(ctx),
+				config{},
+				wss.unregisterSession,
+				elemental.EncodingTypeMSGPACK,
+				elemental.EncodingTypeMSGPACK,
+			)
+			conn := wsc.NewMockWebsocket(ctx)
+			s.setConn(conn)
+			s.id = id
+			go s.listen()
+			wss.registerSession(s)
+			return s, conn
+		}
+
+		_, conn1 := makeSession("s1")
+		_, conn2 := makeSession("s2")
+
+		Convey("When I push an event routed to /a", func() {
+
+			evt := elemental.NewEvent(elemental.EventCreate, testmodel.NewList())
+			evt.Timestamp = time.Now().Add(time.Second)
+			pub := NewPublication("")
+			if err := pub.Encode(evt); err != nil {
+				panic(err)
+			}
+			wss.publications <- pub
+
+			var msg1, msg2 []byte
+			select {
+			case msg1 = <-conn1.LastWrite():
+			case msg2 = <-conn2.LastWrite():
+			case <-time.After(time.Second):
+			}
+
+			select {
+			case msg2 = <-conn2.LastWrite():
+			case <-time.After(300 * time.Millisecond):
+			}
+
+			Convey("Then only the session of /a should be considered", func() {
+				So(msg1, ShouldNotBeNil)
+				So(msg2, ShouldBeNil)
+				pushHandler.Lock()
+				So(pushHandler.shouldDispatchCalled, ShouldEqual, 1)
+				pushHandler.Unlock()
+			})
+		})
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"go.aporeto.io/elemental"
+)
+
+// Various values for the websocket subprotocols
+// a client can request to select the encoding of
+// its push session.
+const (
+	PushSubprotocolJSON    = "json"
+	PushSubprotocolMSGPACK = "msgpack"
+)
+
+// Various errors returned to the clients during the push handshake.
+var (
+	ErrPushOriginNotAllowed = elemental.NewError("Forbidden", "Origin is not allowed", "bahamut", http.StatusForbidden)
+	ErrTooManyPushSessions  = elemental.NewError("Too Many Sessions", "The maximum number of push sessions has been reached", "bahamut", http.StatusServiceUnavailable)
+	ErrTooManyClaimSessions = elemental.NewError("Too Many Sessions", "You have reached your maximum number of push sessions", "bahamut", http.StatusTooManyRequests)
+)
+
+var pushSubprotocols = map[string]elemental.EncodingType{
+	PushSubprotocolJSON:    elemental.EncodingTypeJSON,
+	PushSubprotocolMSGPACK: elemental.EncodingTypeMSGPACK,
+}
+
+// negotiateSubprotocol returns the first subprotocol requested by the
+// client that is supported, with the encoding it selects. It returns
+// an empty subprotocol if there is none.
+func negotiateSubprotocol(r *http.Request) (string, elemental.EncodingType) {
+
+	for _, p := range websocket.Subprotocols(r) {
+		if encoding, ok := pushSubprotocols[p]; ok {
+			return p, encoding
+		}
+	}
+
+	return "", ""
+}
+
+// originAllowed returns true if the origin of the given request is
+// allowed. Requests with no origin, which are not coming from a
+// browser, are always allowed. If allowedOrigins is empty, the CORS
+// origin is used and if it is not set either, only the same origin
+// is allowed.
+func originAllowed(r *http.Request, allowedOrigins []string, corsOrigin string) bool {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(allowedOrigins) == 0 && corsOrigin != "" {
+		allowedOrigins = []string{corsOrigin}
+	}
+
+	if len(allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range allowedOrigins {
+		if matchOrigin(origin, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchOrigin returns true if the given origin matches the given
+// allowed origin. The allowed origin can be *, to match any origin,
+// or contain a wildcard subdomain like https://*.example.com, which
+// matches the subdomains of example.com, but not example.com itself.
+func matchOrigin(origin string, allowed string) bool {
+
+	if allowed == "*" || strings.EqualFold(origin, allowed) {
+		return true
+	}
+
+	idx := strings.Index(allowed, "://*.")
+	if idx == -1 {
+		return false
+	}
+
+	scheme, domain := allowed[:idx+3], allowed[idx+4:]
+	if len(origin) <= len(scheme)+len(domain) {
+		return false
+	}
+
+	return strings.EqualFold(origin[:len(scheme)], scheme) &&
+		strings.HasSuffix(strings.ToLower(origin), strings.ToLower(domain)) &&
+		!strings.Contains(origin[len(scheme):len(origin)-len(domain)], "/")
+}
+
+// reserveSession reserves a slot for the given session, according to
+// the configured maximum number of sessions, globally and per claim.
+// The slot is released when the session is unregistered.
+func (n *pushServer) reserveSession(session *wsPushSession) error {
+
+	max := n.cfg.pushServer.maxSessions
+	maxPerClaim := n.cfg.pushServer.maxSessionsPerClaim
+
+	if max <= 0 && maxPerClaim <= 0 {
+		return nil
+	}
+
+	var claim string
+	if maxPerClaim > 0 {
+		if v, ok := session.ClaimsMap()[n.cfg.pushServer.sessionsClaimKey]; ok {
+			claim = n.cfg.pushServer.sessionsClaimKey + "=" + v
+		}
+	}
+
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	if max > 0 && n.reservedSessions >= max {
+		registerLimitViolation(n.cfg.healthServer.metricsManager, LimitPushSessions)
+		return ErrTooManyPushSessions
+	}
+
+	if claim != "" && n.claimSessions[claim] >= maxPerClaim {
+		registerLimitViolation(n.cfg.healthServer.metricsManager, LimitPushSessionsPerClaim)
+		return ErrTooManyClaimSessions
+	}
+
+	n.reservedSessions++
+	if claim != "" {
+		n.claimSessions[claim]++
+	}
+
+	session.reserved = true
+	session.reservedClaim = claim
+
+	return nil
+}
+
+// releaseSession releases the slot reserved for the given session, if any.
+func (n *pushServer) releaseSession(session *wsPushSession) {
+
+	n.limitsLock.Lock()
+	defer n.limitsLock.Unlock()
+
+	if !session.reserved {
+		return
+	}
+
+	n.reservedSessions--
+	if claim := session.reservedClaim; claim != "" {
+		n.claimSessions[claim]--
+		if n.claimSessions[claim] <= 0 {
+			delete(n.claimSessions, claim)
+		}
+	}
+
+	session.reserved = false
+}
@@ -0,0 +1,301 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoo/bone"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"golang.org/x/time/rate"
+)
+
+func TestHandshake_matchOrigin(t *testing.T) {
+
+	Convey("Calling matchOrigin should work", t, func() {
+		So(matchOrigin("https://example.com", "*"), ShouldBeTrue)
+		So(matchOrigin("https://example.com", "https://example.com"), ShouldBeTrue)
+		So(matchOrigin("https://EXAMPLE.com", "https://example.com"), ShouldBeTrue)
+		So(matchOrigin("http://example.com", "https://example.com"), ShouldBeFalse)
+		So(matchOrigin("https://a.example.com", "https://*.example.com"), ShouldBeTrue)
+		So(matchOrigin("https://a.b.example.com", "https://*.example.com"), ShouldBeTrue)
+		So(matchOrigin("https://example.com", "https://*.example.com"), ShouldBeFalse)
+		So(matchOrigin("https://.example.com", "https://*.example.com"), ShouldBeFalse)
+		So(matchOrigin("https://aexample.com", "https://*.example.com"), ShouldBeFalse)
+		So(matchOrigin("http://a.example.com", "https://*.example.com"), ShouldBeFalse)
+		So(matchOrigin("https://evil.com/.example.com", "https://*.example.com"), ShouldBeFalse)
+	})
+}
+
+func TestHandshake_originAllowed(t *testing.T) {
+
+	makeRequest := func(origin string) *http.Request {
+		r := &http.Request{Host: "api.example.com", Header: http.Header{}}
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	Convey("Calling originAllowed without origin should allow it", t, func() {
+		So(originAllowed(makeRequest(""), []string{"https://example.com"}, ""), ShouldBeTrue)
+	})
+
+	Convey("Calling originAllowed with no configuration should only allow the same origin", t, func() {
+		So(originAllowed(makeRequest("https://api.example.com"), nil, ""), ShouldBeTrue)
+		So(originAllowed(makeRequest("https://evil.com"), nil, ""), ShouldBeFalse)
+	})
+
+	Convey("Calling originAllowed with a CORS origin should use it", t, func() {
+		So(originAllowed(makeRequest("https://app.example.com"), nil, "https://app.example.com"), ShouldBeTrue)
+		So(originAllowed(makeRequest("https://evil.com"), nil, "https://app.example.com"), ShouldBeFalse)
+		So(originAllowed(makeRequest("https://evil.com"), nil, "*"), ShouldBeTrue)
+	})
+
+	Convey("Calling originAllowed with allowed origins should use them over the CORS origin", t, func() {
+		So(originAllowed(makeRequest("https://a.example.com"), []string{"https://*.example.com"}, "https://other.com"), ShouldBeTrue)
+		So(originAllowed(makeRequest("https://other.com"), []string{"https://*.example.com"}, "https://other.com"), ShouldBeFalse)
+	})
+}
+
+func TestHandshake_negotiateSubprotocol(t *testing.T) {
+
+	Convey("Calling negotiateSubprotocol should work", t, func() {
+
+		r := &http.Request{Header: http.Header{}}
+		p, e := negotiateSubprotocol(r)
+		So(p, ShouldEqual, "")
+		So(e, ShouldEqual, elemental.EncodingType(""))
+
+		r.Header.Set("Sec-Websocket-Protocol", "nope, msgpack, json")
+		p, e = negotiateSubprotocol(r)
+		So(p, ShouldEqual, PushSubprotocolMSGPACK)
+		So(e, ShouldEqual, elemental.EncodingTypeMSGPACK)
+	})
+}
+
+func TestHandshake_reserveSession(t *testing.T) {
+
+	makeSession := func(claims ...string) *wsPushSession {
+		s := newWSPushSession(&http.Request{URL: &url.URL{}}, config{}, nil, elemental.EncodingTypeJSON, elemental.EncodingTypeJSON)
+		s.SetClaims(claims)
+		return s
+	}
+
+	Convey("Given I have a push server with no limits", t, func() {
+
+		wss := newPushServer(config{}, bone.New(), nil)
+
+		Convey("When I reserve a session", func() {
+
+			s := makeSession()
+			err := wss.reserveSession(s)
+
+			Convey("Then nothing should be reserved", func() {
+				So(err, ShouldBeNil)
+				So(s.reserved, ShouldBeFalse)
+				So(wss.reservedSessions, ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given I have a push server with a global limit", t, func() {
+
+		cfg := config{}
+		cfg.pushServer.maxSessions = 2
+		wss := newPushServer(cfg, bone.New(), nil)
+
+		s1, s2, s3 := makeSession(), makeSession(), makeSession()
+
+		Convey("When I reserve more sessions than allowed", func() {
+
+			err1 := wss.reserveSession(s1)
+			err2 := wss.reserveSession(s2)
+			err3 := wss.reserveSession(s3)
+
+			Convey("Then the last one should be rejected", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(err3, ShouldResemble, ErrTooManyPushSessions)
+			})
+
+			Convey("When I release one and retry", func() {
+
+				wss.releaseSession(s1)
+				wss.releaseSession(s1)
+				err := wss.reserveSession(s3)
+
+				Convey("Then it should be accepted", func() {
+					So(err, ShouldBeNil)
+					So(wss.reservedSessions, ShouldEqual, 2)
+				})
+			})
+		})
+	})
+
+	Convey("Given I have a push server with a per claim limit", t, func() {
+
+		cfg := config{}
+		cfg.pushServer.maxSessionsPerClaim = 1
+		cfg.pushServer.sessionsClaimKey = "@auth:subject"
+		wss := newPushServer(cfg, bone.New(), nil)
+
+		Convey("When I reserve sessions for various claims", func() {
+
+			a1 := makeSession("@auth:subject=alice")
+			a2 := makeSession("@auth:subject=alice")
+			b1 := makeSession("@auth:subject=bob")
+			n1 := makeSession("@auth:realm=x")
+			n2 := makeSession("@auth:realm=x")
+
+			errA1 := wss.reserveSession(a1)
+			errA2 := wss.reserveSession(a2)
+			errB1 := wss.reserveSession(b1)
+			errN1 := wss.reserveSession(n1)
+			errN2 := wss.reserveSession(n2)
+
+			Convey("Then only the sessions over the limit of their claim should be rejected", func() {
+				So(errA1, ShouldBeNil)
+				So(errA2, ShouldResemble, ErrTooManyClaimSessions)
+				So(errB1, ShouldBeNil)
+				So(errN1, ShouldBeNil)
+				So(errN2, ShouldBeNil)
+			})
+
+			Convey("When I release a session", func() {
+
+				wss.releaseSession(a1)
+
+				Convey("Then its claim should be freed", func() {
+					So(wss.claimSessions, ShouldResemble, map[string]int{"@auth:subject=bob": 1})
+					So(wss.reserveSession(a2), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestHandshake_handleRequest(t *testing.T) {
+
+	makeServer := func(ctx context.Context, cfg config) *httptest.Server {
+
+		cfg.pushServer.enabled = true
+		cfg.pushServer.dispatchEnabled = true
+
+		wss := newPushServer(cfg, bone.New(), nil)
+		wss.mainContext = ctx
+
+		return httptest.NewServer(http.HandlerFunc(wss.handleRequest))
+	}
+
+	dial := func(ts *httptest.Server, header http.Header, protocols ...string) (*websocket.Conn, *http.Response, error) {
+		dialer := websocket.Dialer{Subprotocols: protocols}
+		return dialer.Dial(strings.Replace(ts.URL, "http://", "ws://", 1), header)
+	}
+
+	Convey("Given I have a push server", t, func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		ts := makeServer(ctx, config{})
+		defer ts.Close()
+
+		Convey("When I connect with an invalid encoding", func() {
+
+			_, resp, err := dial(ts, http.Header{"Content-Type": []string{"application/nope"}})
+
+			Convey("Then the handshake should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(resp.StatusCode, ShouldNotEqual, http.StatusSwitchingProtocols)
+			})
+		})
+
+		Convey("When I connect from another origin", func() {
+
+			_, resp, err := dial(ts, http.Header{"Origin": []string{"https://evil.com"}})
+
+			Convey("Then the handshake should be forbidden", func() {
+				So(err, ShouldNotBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("When I connect with a subprotocol", func() {
+
+			ws, resp, err := dial(ts, nil, "nope", PushSubprotocolMSGPACK)
+
+			Convey("Then the subprotocol should be selected", func() {
+				So(err, ShouldBeNil)
+				So(resp.Header.Get("Sec-Websocket-Protocol"), ShouldEqual, PushSubprotocolMSGPACK)
+				So(ws.Subprotocol(), ShouldEqual, PushSubprotocolMSGPACK)
+				_ = ws.Close()
+			})
+		})
+	})
+
+	Convey("Given I have a push server with a handshake rate limit", t, func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		cfg := config{}
+		cfg.pushServer.handshakeLimiter = rate.NewLimiter(rate.Limit(0.001), 1)
+		ts := makeServer(ctx, cfg)
+		defer ts.Close()
+
+		Convey("When I connect twice", func() {
+
+			ws, _, err1 := dial(ts, nil)
+			_, resp, err2 := dial(ts, nil)
+
+			Convey("Then the second handshake should be rate limited", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldNotBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+				_ = ws.Close()
+			})
+		})
+	})
+
+	Convey("Given I have a push server with a maximum number of sessions", t, func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		cfg := config{}
+		cfg.pushServer.maxSessions = 1
+		ts := makeServer(ctx, cfg)
+		defer ts.Close()
+
+		Convey("When I connect twice", func() {
+
+			ws, _, err1 := dial(ts, nil)
+			_, resp, err2 := dial(ts, nil)
+
+			Convey("Then the second handshake should be rejected", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldNotBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+				_ = ws.Close()
+			})
+		})
+	})
+}
@@ -0,0 +1,345 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"go.aporeto.io/elemental"
+	"go.uber.org/zap"
+)
+
+// A PushMessageType is the type of a PushMessage.
+type PushMessageType string
+
+// Various values for PushMessageType.
+const (
+	// PushMessageTypePushConfig is sent by clients to update their push config.
+	// The data contains an elemental.PushConfig.
+	PushMessageTypePushConfig PushMessageType = "pushconfig"
+
+	// PushMessageTypeToken is sent by clients to refresh their token.
+	// The data contains an object like {"token": "..."}.
+	PushMessageTypeToken PushMessageType = "token"
+
+	// PushMessageTypePing is sent by clients to check the session is alive.
+	// The server replies with a PushMessageTypePong.
+	PushMessageTypePing PushMessageType = "ping"
+
+	// PushMessageTypePong is sent by the server in reply to a PushMessageTypePing.
+	PushMessageTypePong PushMessageType = "pong"
+
+	// PushMessageTypeAck is sent by clients to acknowledge events.
+	// It is given to the PushMessageHandler.
+	PushMessageTypeAck PushMessageType = "ack"
+
+	// PushMessageTypeMessage is an application defined message sent by
+	// clients. It is given to the PushMessageHandler.
+	PushMessageTypeMessage PushMessageType = "message"
+
+	// PushMessageTypeResponse is sent by the server in reply to a message
+	// that has a request ID.
+	PushMessageTypeResponse PushMessageType = "response"
+
+	// PushMessageTypeRequest is sent by clients to run an elemental.Request
+	// when OptPushSessionAPI is set. The data contains the request, and the
+	// server replies with a PushAPIResponse.
+	PushMessageTypeRequest PushMessageType = "request"
+)
+
+// wsMessageQueueSize is the maximum number of messages of a push
+// session waiting to be given to the PushMessageHandler.
+const wsMessageQueueSize = 64
+
+// A PushMessage is a typed message exchanged over the push websocket.
+//
+// Like the entity of an elemental.Event, the data is a raw JSON value
+// when using JSON, and a byte array containing the msgpack encoded
+// value when using msgpack. Clients that send messages without a type
+// are considered as sending a bare elemental.PushConfig.
+type PushMessage struct {
+	Type      PushMessageType `msgpack:"type" json:"type"`
+	RequestID string          `msgpack:"requestID,omitempty" json:"requestID,omitempty"`
+	RawData   []byte          `msgpack:"data,omitempty" json:"-"`
+	JSONData  json.RawMessage `msgpack:"-" json:"data,omitempty"`
+
+	encoding elemental.EncodingType
+}
+
+// newPushMessage returns a new PushMessage of the given type with the
+// given data encoded using the given encoding.
+func newPushMessage(typ PushMessageType, requestID string, data interface{}, encoding elemental.EncodingType) (*PushMessage, error) {
+
+	msg := &PushMessage{
+		Type:      typ,
+		RequestID: requestID,
+		encoding:  encoding,
+	}
+
+	if data == nil {
+		return msg, nil
+	}
+
+	d, err := elemental.Encode(encoding, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if encoding == elemental.EncodingTypeJSON {
+		msg.JSONData = d
+	} else {
+		msg.RawData = d
+	}
+
+	return msg, nil
+}
+
+// Decode decodes the data of the message into the given destination.
+func (m *PushMessage) Decode(dest interface{}) error {
+
+	if m.encoding == elemental.EncodingTypeJSON {
+		return elemental.Decode(elemental.EncodingTypeJSON, m.JSONData, dest)
+	}
+
+	return elemental.Decode(elemental.EncodingTypeMSGPACK, m.RawData, dest)
+}
+
+// A PushMessageHandler handles the acknowledgements and the
+// application defined messages sent by the clients over
+// the push websocket.
+type PushMessageHandler interface {
+
+	// OnPushMessage is called when a client sends a message of type
+	// PushMessageTypeAck or PushMessageTypeMessage. If the message has a
+	// request ID, the returned response is sent back to the client in a
+	// message of type PushMessageTypeResponse with the same request ID.
+	// If it returns an error, it is sent to the client as an error event.
+	//
+	// The messages of a session are handled one at a time, in order, but
+	// not from the loop reading the websocket. If too many messages are
+	// waiting to be handled, the client receives a 429 error instead.
+	OnPushMessage(PushSession, *PushMessage) (interface{}, error)
+}
+
+// handleMessage handles the given message sent by the client. It
+// returns false if the session must be stopped.
+func (s *wsPushSession) handleMessage(data []byte, reauth *wsReauthenticator) bool {
+
+	msg := &PushMessage{}
+	if err := elemental.Decode(s.encodingRead, data, msg); err != nil || msg.Type == "" {
+		return s.handleLegacyMessage(data, reauth)
+	}
+	msg.encoding = s.encodingRead
+
+	switch msg.Type {
+
+	case PushMessageTypePushConfig:
+
+		pushConfig, ee := s.parsePushConfig(msg.Decode)
+		if ee != nil {
+			if !s.handlesErrorEvents() {
+				s.close(websocket.CloseUnsupportedData)
+				return false
+			}
+
+			s.setErrorState(true)
+			s.sendMessageError(msg.RequestID, *ee)
+			return true
+		}
+
+		s.setErrorState(false)
+		s.setCurrentPushConfig(pushConfig)
+		s.sendResponse(PushMessageTypeResponse, msg.RequestID, nil)
+
+	case PushMessageTypeToken:
+
+		refresh := &wsTokenRefresh{}
+		if err := msg.Decode(refresh); err != nil || refresh.Token == "" || !reauth.enabled() {
+			s.sendMessageError(msg.RequestID, elemental.NewError("Bad request", "Token refresh is not possible", "bahamut", http.StatusBadRequest))
+			return true
+		}
+
+		s.setToken(refresh.Token)
+		reauth.run()
+		s.sendResponse(PushMessageTypeResponse, msg.RequestID, nil)
+
+	case PushMessageTypePing:
+
+		s.sendResponse(PushMessageTypePong, msg.RequestID, nil)
+
+	case PushMessageTypeRequest:
+
+		s.handleAPIRequest(msg)
+
+	case PushMessageTypeAck, PushMessageTypeMessage:
+
+		if s.cfg.pushServer.messageHandler == nil {
+			s.sendMessageError(msg.RequestID, elemental.NewError("Not implemented", fmt.Sprintf("Messages of type '%s' are not handled", msg.Type), "bahamut", http.StatusNotImplemented))
+			return true
+		}
+
+		select {
+		case s.messageCh <- msg:
+		default:
+			s.sendMessageError(msg.RequestID, elemental.NewError("Too Many Requests", "Too many messages are waiting to be handled", "bahamut", http.StatusTooManyRequests))
+		}
+
+	default:
+
+		s.sendMessageError(msg.RequestID, elemental.NewError("Bad request", fmt.Sprintf("Unknown message type '%s'", msg.Type), "bahamut", http.StatusBadRequest))
+	}
+
+	return true
+}
+
+// runMessageHandler gives the queued messages to the given
+// PushMessageHandler, until the session is done.
+func (s *wsPushSession) runMessageHandler(handler PushMessageHandler) {
+
+	for {
+		select {
+
+		case msg := <-s.messageCh:
+
+			resp, err := handler.OnPushMessage(s, msg)
+			if err != nil {
+				ee, ok := err.(elemental.Error)
+				if !ok {
+					ee = elemental.NewError("Internal Server Error", err.Error(), "bahamut", http.StatusInternalServerError)
+				}
+				s.sendMessageError(msg.RequestID, ee)
+				continue
+			}
+
+			s.sendResponse(PushMessageTypeResponse, msg.RequestID, resp)
+
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleLegacyMessage handles the given message sent by a client
+// that does not use PushMessage. It returns false if the session
+// must be stopped.
+func (s *wsPushSession) handleLegacyMessage(data []byte, reauth *wsReauthenticator) bool {
+
+	if reauth.enabled() {
+		refresh := &wsTokenRefresh{}
+		if err := elemental.Decode(s.encodingRead, data, refresh); err == nil && refresh.Token != "" {
+			s.setToken(refresh.Token)
+			reauth.run()
+			return true
+		}
+	}
+
+	pushConfig, ee := s.parsePushConfig(func(dest interface{}) error { return elemental.Decode(s.encodingRead, data, dest) })
+	if ee != nil {
+		if !s.handlesErrorEvents() {
+			s.close(websocket.CloseUnsupportedData)
+			return false
+		}
+
+		s.sendWSError(*ee)
+		return true
+	}
+
+	s.setErrorState(false)
+	s.setCurrentPushConfig(pushConfig)
+
+	return true
+}
+
+// parsePushConfig decodes a push config using the given decode
+// function and parses its identity filters.
+func (s *wsPushSession) parsePushConfig(decode func(interface{}) error) (*elemental.PushConfig, *elemental.Error) {
+
+	pushConfig := elemental.NewPushConfig()
+	if err := decode(pushConfig); err != nil {
+		return nil, &elemental.Error{
+			Title:       "Bad request",
+			Subject:     "bahamut",
+			Description: fmt.Sprintf("could not decode message into %T: %s", pushConfig, err),
+		}
+	}
+
+	if err := pushConfig.ParseIdentityFilters(); err != nil {
+		zap.L().Debug("error parsing filter(s) in the received *elemental.PushConfig",
+			zap.Error(err),
+			zap.String("sessionID", s.id),
+			zap.String("pushConfig", pushConfig.String()),
+		)
+
+		return nil, &elemental.Error{
+			Title:       "Bad request",
+			Subject:     "bahamut",
+			Description: fmt.Sprintf("unable to parse identity filters: %s", err),
+			Data: map[string]interface{}{
+				"pushconfig": "filters",
+			},
+		}
+	}
+
+	return pushConfig, nil
+}
+
+// sendResponse sends a message of the given type with the given data to
+// the client. Responses are only sent to messages having a request ID,
+// except for the pongs.
+func (s *wsPushSession) sendResponse(typ PushMessageType, requestID string, data interface{}) {
+
+	if requestID == "" && typ != PushMessageTypePong {
+		return
+	}
+
+	msg, err := newPushMessage(typ, requestID, data, s.encodingWrite)
+	if err != nil {
+		s.sendMessageError(requestID, elemental.NewError("Internal Server Error", fmt.Sprintf("unable to encode response: %s", err), "bahamut", http.StatusInternalServerError))
+		return
+	}
+
+	encoded, err := elemental.Encode(s.encodingWrite, msg)
+	if err != nil {
+		zap.L().Error("Unable to encode push message", zap.String("sessionID", s.id), zap.Error(err))
+		return
+	}
+
+	s.send(encoded)
+}
+
+// sendMessageError sends the given error as an error event. The
+// request ID of the message is added in the data of the error,
+// along with the existing data, if any.
+func (s *wsPushSession) sendMessageError(requestID string, ee elemental.Error) {
+
+	if requestID != "" {
+		data := map[string]interface{}{}
+		if existing, ok := ee.Data.(map[string]interface{}); ok {
+			for k, v := range existing {
+				data[k] = v
+			}
+		}
+		data["requestID"] = requestID
+		ee.Data = data
+	}
+
+	data, err := s.errorEventData(ee)
+	if err != nil {
+		zap.L().Error("Unable to prepare error event", zap.String("sessionID", s.id), zap.Error(err))
+		return
+	}
+
+	s.send(data)
+}
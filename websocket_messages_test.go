@@ -0,0 +1,352 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/wsc"
+)
+
+type mockPushMessageHandler struct {
+	err     error
+	session PushSession
+	message *PushMessage
+}
+
+func (h *mockPushMessageHandler) OnPushMessage(session PushSession, msg *PushMessage) (interface{}, error) {
+
+	h.session = session
+	h.message = msg
+
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	data := map[string]interface{}{}
+	if err := msg.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"echo": data["value"]}, nil
+}
+
+type mockBlockingPushMessageHandler struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (h *mockBlockingPushMessageHandler) OnPushMessage(session PushSession, msg *PushMessage) (interface{}, error) {
+
+	select {
+	case h.called <- struct{}{}:
+	default:
+	}
+
+	<-h.release
+
+	return nil, nil
+}
+
+func TestPushMessage_encoding(t *testing.T) {
+
+	Convey("Given I have a msgpack message", t, func() {
+
+		msg, err := newPushMessage(PushMessageTypeMessage, "1", map[string]interface{}{"value": "hello"}, elemental.EncodingTypeMSGPACK)
+		So(err, ShouldBeNil)
+
+		Convey("When I encode and decode it", func() {
+
+			data, err := elemental.Encode(elemental.EncodingTypeMSGPACK, msg)
+			So(err, ShouldBeNil)
+
+			decoded := &PushMessage{encoding: elemental.EncodingTypeMSGPACK}
+			So(elemental.Decode(elemental.EncodingTypeMSGPACK, data, decoded), ShouldBeNil)
+
+			out := map[string]interface{}{}
+			err = decoded.Decode(&out)
+
+			Convey("Then it should be correct", func() {
+				So(err, ShouldBeNil)
+				So(decoded.Type, ShouldEqual, PushMessageTypeMessage)
+				So(decoded.RequestID, ShouldEqual, "1")
+				So(out["value"], ShouldEqual, "hello")
+			})
+		})
+	})
+
+	Convey("Given I have a json message without data", t, func() {
+
+		msg, err := newPushMessage(PushMessageTypePong, "", nil, elemental.EncodingTypeJSON)
+		So(err, ShouldBeNil)
+
+		Convey("When I encode it", func() {
+
+			data, err := elemental.Encode(elemental.EncodingTypeJSON, msg)
+
+			Convey("Then it should be correct", func() {
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, `{"type":"pong"}`)
+			})
+		})
+	})
+}
+
+func TestPushMessage_listen(t *testing.T) {
+
+	makeSession := func(ctx context.Context, handler PushMessageHandler, query string) (*wsPushSession, wsc.MockWebsocket) {
+
+		cfg := config{}
+		cfg.pushServer.messageHandler = handler
+
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{RawQuery: query}}).WithContext(ctx),
+			cfg,
+			func(*wsPushSession) {},
+			elemental.EncodingTypeJSON,
+			elemental.EncodingTypeJSON,
+		)
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+
+		go s.listen()
+
+		return s, conn
+	}
+
+	read := func(conn wsc.MockWebsocket) []byte {
+		select {
+		case data := <-conn.LastWrite():
+			return data
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	readError := func(conn wsc.MockWebsocket) elemental.Error {
+		var event elemental.Event
+		var ee elemental.Error
+		if err := elemental.Decode(elemental.EncodingTypeJSON, read(conn), &event); err == nil {
+			_ = elemental.Decode(event.Encoding, event.JSONData, &ee)
+		}
+		return ee
+	}
+
+	Convey("Given I have a listening session without message handler", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn := makeSession(ctx, nil, "enableErrors=true")
+
+		Convey("When the client sends a bare push config", func() {
+
+			conn.NextRead([]byte(`{"filters":{"list":{}}}`))
+
+			Convey("Then the push config should be set", func() {
+				So(waitUntil(ctx, func() bool { return s.currentPushConfig() != nil }), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the client sends a typed push config with a request ID", func() {
+
+			conn.NextRead([]byte(`{"type":"pushconfig","requestID":"1","data":{"filters":{"list":{}}}}`))
+			data := read(conn)
+
+			Convey("Then the push config should be set and the client should receive a response", func() {
+				So(string(data), ShouldEqual, `{"type":"response","requestID":"1"}`)
+				So(s.currentPushConfig(), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the client sends an invalid typed push config", func() {
+
+			conn.NextRead([]byte(`{"type":"pushconfig","requestID":"1","data":"nope"}`))
+			ee := readError(conn)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Title, ShouldEqual, "Bad request")
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "1"})
+				So(s.inErrorState(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the client sends a typed push config with invalid filters", func() {
+
+			conn.NextRead([]byte(`{"type":"pushconfig","requestID":"1","data":{"filters":{"list":{}},"identityFilters":{"list":"nope"}}}`))
+			ee := readError(conn)
+
+			Convey("Then the client should receive an error with both the request ID and the error data", func() {
+				So(ee.Title, ShouldEqual, "Bad request")
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "1", "pushconfig": "filters"})
+			})
+		})
+
+		Convey("When the client sends a ping", func() {
+
+			conn.NextRead([]byte(`{"type":"ping","requestID":"2"}`))
+			data := read(conn)
+
+			Convey("Then the client should receive a pong", func() {
+				So(string(data), ShouldEqual, `{"type":"pong","requestID":"2"}`)
+			})
+		})
+
+		Convey("When the client sends a message", func() {
+
+			conn.NextRead([]byte(`{"type":"message","requestID":"3","data":{"value":"hello"}}`))
+			ee := readError(conn)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Code, ShouldEqual, http.StatusNotImplemented)
+				So(ee.Description, ShouldEqual, "Messages of type 'message' are not handled")
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "3"})
+			})
+		})
+
+		Convey("When the client sends a message of an unknown type", func() {
+
+			conn.NextRead([]byte(`{"type":"nope","requestID":"4"}`))
+			ee := readError(conn)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Code, ShouldEqual, http.StatusBadRequest)
+				So(ee.Description, ShouldEqual, "Unknown message type 'nope'")
+			})
+		})
+
+		Convey("When the client sends a token refresh while re-authentication is disabled", func() {
+
+			conn.NextRead([]byte(`{"type":"token","requestID":"5","data":{"token":"abc"}}`))
+			ee := readError(conn)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Code, ShouldEqual, http.StatusBadRequest)
+				So(ee.Description, ShouldEqual, "Token refresh is not possible")
+			})
+		})
+	})
+
+	Convey("Given I have a listening session with a message handler", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		h := &mockPushMessageHandler{}
+		s, conn := makeSession(ctx, h, "enableErrors=true")
+
+		Convey("When the client sends a message", func() {
+
+			conn.NextRead([]byte(`{"type":"message","requestID":"1","data":{"value":"hello"}}`))
+			data := read(conn)
+
+			Convey("Then the handler should be called and the client should receive the response", func() {
+				So(string(data), ShouldEqual, `{"type":"response","requestID":"1","data":{"echo":"hello"}}`)
+				So(h.session, ShouldEqual, s)
+				So(h.message.Type, ShouldEqual, PushMessageTypeMessage)
+			})
+		})
+
+		Convey("When the client sends a message and the handler returns an error", func() {
+
+			h.err = fmt.Errorf("boom")
+			conn.NextRead([]byte(`{"type":"ack","requestID":"2","data":{}}`))
+			ee := readError(conn)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Code, ShouldEqual, http.StatusInternalServerError)
+				So(ee.Description, ShouldEqual, "boom")
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "2"})
+				So(s.inErrorState(), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given I have a listening session that does not handle error events", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn := makeSession(ctx, nil, "")
+
+		Convey("When the client sends an invalid typed push config", func() {
+
+			conn.NextRead([]byte(`{"type":"pushconfig","requestID":"1","data":"nope"}`))
+
+			var closeErr error
+			select {
+			case closeErr = <-conn.Done():
+			case <-time.After(time.Second):
+			}
+
+			Convey("Then the session should be closed", func() {
+				So(closeErr, ShouldNotBeNil)
+				So(closeErr.Error(), ShouldContainSubstring, fmt.Sprintf("%d", websocket.CloseUnsupportedData))
+				So(s.currentPushConfig(), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a listening session with a slow message handler", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		h := &mockBlockingPushMessageHandler{
+			called:  make(chan struct{}, 1),
+			release: make(chan struct{}),
+		}
+		defer close(h.release)
+
+		_, conn := makeSession(ctx, h, "enableErrors=true")
+
+		conn.NextRead([]byte(`{"type":"message","data":{}}`))
+
+		select {
+		case <-h.called:
+		case <-time.After(time.Second):
+			So("handler should have been called", ShouldBeEmpty)
+		}
+
+		Convey("When the client sends a ping", func() {
+
+			conn.NextRead([]byte(`{"type":"ping","requestID":"1"}`))
+			data := read(conn)
+
+			Convey("Then the client should receive a pong", func() {
+				So(string(data), ShouldEqual, `{"type":"pong","requestID":"1"}`)
+			})
+		})
+
+		Convey("When the client sends more messages than can be queued", func() {
+
+			for i := 0; i < wsMessageQueueSize; i++ {
+				conn.NextRead([]byte(`{"type":"message","data":{}}`))
+			}
+			conn.NextRead([]byte(`{"type":"message","requestID":"2","data":{}}`))
+			ee := readError(conn)
+
+			Convey("Then the client should receive an error", func() {
+				So(ee.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(ee.Data, ShouldResemble, map[string]interface{}{"requestID": "2"})
+			})
+		})
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -36,14 +37,24 @@ const (
 type unregisterFunc func(*wsPushSession)
 
 type wsPushSession struct {
-	dataCh                chan []byte
+	eventsSent            uint64
+	eventsDropped         uint64
+	dataCh                chan *wsOutgoing
+	messageCh             chan *PushMessage
+	apiRequests           chan struct{}
 	pushConfig            *elemental.PushConfig
+	batch                 wsBatchSettings
 	currentPushConfigLock sync.RWMutex
 	parametersLock        sync.RWMutex
 	errorStateActive      bool
 	errorStateLock        sync.RWMutex
 	claims                []string
 	claimsMap             map[string]string
+	claimsLock            sync.RWMutex
+	tags                  []string
+	tagsLock              sync.RWMutex
+	expiration            time.Time
+	expirationLock        sync.RWMutex
 	cfg                   config
 	headers               http.Header
 	id                    string
@@ -57,9 +68,21 @@ type wsPushSession struct {
 	ctx                   context.Context
 	cancel                context.CancelFunc
 	closeCh               chan struct{}
+	terminateCh           chan wsTermination
 	encodingRead          elemental.EncodingType
 	encodingWrite         elemental.EncodingType
 	cookies               []*http.Cookie
+	processorFinder       processorFinderFunc
+	pusher                eventPusherFunc
+	admission             *admissionController
+	reserved              bool
+	reservedClaim         string
+}
+
+// A wsTermination is a request to close a push session.
+type wsTermination struct {
+	code int
+	err  *elemental.Error
 }
 
 func newWSPushSession(
@@ -74,7 +97,9 @@ func newWSPushSession(
 	ctx, cancel := context.WithCancel(request.Context())
 
 	return &wsPushSession{
-		dataCh:             make(chan []byte, 64),
+		dataCh:             make(chan *wsOutgoing, 64),
+		messageCh:          make(chan *PushMessage, wsMessageQueueSize),
+		apiRequests:        make(chan struct{}, wsMaxConcurrentAPIRequests),
 		id:                 id,
 		claims:             []string{},
 		claimsMap:          map[string]string{},
@@ -83,6 +108,7 @@ func newWSPushSession(
 		parameters:         request.URL.Query(),
 		startTime:          time.Now(),
 		closeCh:            make(chan struct{}),
+		terminateCh:        make(chan wsTermination, 1),
 		unregister:         unregister,
 		ctx:                ctx,
 		cancel:             cancel,
@@ -125,7 +151,7 @@ func (s *wsPushSession) DirectPush(events ...*elemental.Event) {
 			continue
 		}
 
-		s.send(data)
+		s.sendEvent(event, data, func() string { return eventObjectID(event) })
 	}
 }
 
@@ -137,12 +163,18 @@ func (s *wsPushSession) String() string {
 // SetClaims implements elemental.ClaimsHolder.
 func (s *wsPushSession) SetClaims(claims []string) {
 
+	s.claimsLock.Lock()
+	defer s.claimsLock.Unlock()
+
 	s.claims = append([]string{}, claims...)
 	s.claimsMap = claimsToMap(s.claims)
 }
 
 func (s *wsPushSession) ClaimsMap() map[string]string {
 
+	s.claimsLock.RLock()
+	defer s.claimsLock.RUnlock()
+
 	copiedClaimsMap := map[string]string{}
 
 	for k, v := range s.claimsMap {
@@ -152,8 +184,15 @@ func (s *wsPushSession) ClaimsMap() map[string]string {
 	return copiedClaimsMap
 }
 
+func (s *wsPushSession) Claims() []string {
+
+	s.claimsLock.RLock()
+	defer s.claimsLock.RUnlock()
+
+	return append([]string{}, s.claims...)
+}
+
 func (s *wsPushSession) Identifier() string                            { return s.id }
-func (s *wsPushSession) Claims() []string                              { return append([]string{}, s.claims...) }
 func (s *wsPushSession) Token() string                                 { return s.Parameter("token") }
 func (s *wsPushSession) Context() context.Context                      { return s.ctx }
 func (s *wsPushSession) TLSConnectionState() *tls.ConnectionState      { return s.tlsConnectionState }
@@ -172,6 +211,48 @@ func (s *wsPushSession) Parameter(key string) string {
 	return s.parameters.Get(key)
 }
 
+// SetTags implements TaggableSession.
+func (s *wsPushSession) SetTags(tags []string) {
+	s.tagsLock.Lock()
+	s.tags = append([]string{}, tags...)
+	s.tagsLock.Unlock()
+}
+
+// Tags implements TaggableSession.
+func (s *wsPushSession) Tags() []string {
+	s.tagsLock.RLock()
+	defer s.tagsLock.RUnlock()
+	return append([]string{}, s.tags...)
+}
+
+// SetExpiration implements ExpirableSession.
+func (s *wsPushSession) SetExpiration(exp time.Time) {
+	s.expirationLock.Lock()
+	s.expiration = exp
+	s.expirationLock.Unlock()
+}
+
+// Expiration implements ExpirableSession.
+func (s *wsPushSession) Expiration() time.Time {
+	s.expirationLock.RLock()
+	defer s.expirationLock.RUnlock()
+	return s.expiration
+}
+
+// setToken replaces the token of the session.
+func (s *wsPushSession) setToken(token string) {
+	s.parametersLock.Lock()
+	defer s.parametersLock.Unlock()
+
+	// We copy the parameters as they may be shared with the request.
+	parameters := url.Values{}
+	for k, v := range s.parameters {
+		parameters[k] = v
+	}
+	parameters.Set("token", token)
+	s.parameters = parameters
+}
+
 func (s *wsPushSession) inErrorState() bool {
 	s.errorStateLock.RLock()
 	defer s.errorStateLock.RUnlock()
@@ -194,7 +275,7 @@ func (s *wsPushSession) handlesErrorEvents() bool {
 func (s *wsPushSession) sendWSError(ee elemental.Error) {
 
 	s.setErrorState(true)
-	msgpack, json, err := prepareEventData(elemental.NewErrorEvent(ee, s.encodingWrite))
+	data, err := s.errorEventData(ee)
 	if err != nil {
 		zap.L().Error("elemental: unable to prepare error event - closing socket",
 			zap.String("sessionID", s.id),
@@ -203,11 +284,47 @@ func (s *wsPushSession) sendWSError(ee elemental.Error) {
 		return
 	}
 
-	switch s.encodingWrite {
-	case elemental.EncodingTypeMSGPACK:
-		s.send(msgpack)
-	case elemental.EncodingTypeJSON:
-		s.send(json)
+	s.send(data)
+}
+
+// errorEventData returns the given error as an error
+// event encoded with the write encoding of the session.
+func (s *wsPushSession) errorEventData(ee elemental.Error) ([]byte, error) {
+
+	msgpack, json, err := prepareEventData(elemental.NewErrorEvent(ee, s.encodingWrite))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.encodingWrite == elemental.EncodingTypeJSON {
+		return json, nil
+	}
+
+	return msgpack, nil
+}
+
+// terminate asks the session to close itself with the given
+// code. If the client handles error events, it receives the
+// given reason before the socket is closed.
+func (s *wsPushSession) terminate(code int, reason string) {
+
+	var err *elemental.Error
+	if reason != "" {
+		e := elemental.NewError("Session terminated", reason, "bahamut", http.StatusForbidden)
+		err = &e
+	}
+
+	s.terminateWithError(code, err)
+}
+
+// terminateWithError asks the session to close itself with the
+// given code. If the given error is not nil and the client handles
+// error events, it receives it before the socket is closed.
+func (s *wsPushSession) terminateWithError(code int, err *elemental.Error) {
+
+	select {
+	case s.terminateCh <- wsTermination{code: code, err: err}:
+	default:
 	}
 }
 
@@ -228,10 +345,13 @@ func (s *wsPushSession) setCurrentPushConfig(f *elemental.PushConfig) {
 	defer s.currentPushConfigLock.Unlock()
 
 	s.pushConfig = f
+	s.batch = wsBatchSettings{}
 	if f == nil {
 		return
 	}
 
+	s.batch = newWSBatchSettings(s.cfg, f.Parameters())
+
 	s.parametersLock.Lock()
 	for k, v := range f.Parameters() {
 		s.parameters[k] = v
@@ -253,11 +373,12 @@ func (s *wsPushSession) Cookie(name string) (*http.Cookie, error) {
 func (s *wsPushSession) send(data []byte) {
 
 	select {
-	case s.dataCh <- data:
+	case s.dataCh <- &wsOutgoing{data: data}:
 	default:
+		atomic.AddUint64(&s.eventsDropped, 1)
 		zap.L().Warn("Slow consumer. event dropped",
 			zap.String("sessionID", s.id),
-			zap.Strings("claims", s.claims),
+			zap.Strings("claims", s.Claims()),
 		)
 	}
 }
@@ -265,65 +386,98 @@ func (s *wsPushSession) send(data []byte) {
 func (s *wsPushSession) listen() {
 
 	defer s.unregister(s)
+	defer s.cancel()
+
+	if handler := s.cfg.pushServer.messageHandler; handler != nil {
+		go s.runMessageHandler(handler)
+	}
+
+	reauth := newWSReauthenticator(s)
+	defer reauth.stop()
 
+	batcher := newWSBatcher()
+	defer batcher.stop()
+
+	// All the messages go through s.dataCh, so they are written in
+	// order. The pending batch is always written before a message
+	// that is not batched, and when the session stops.
 	for {
 		select {
-		case data := <-s.dataCh:
+		case out := <-s.dataCh:
 
-			s.conn.Write(data)
+			if out.batch {
+				if batcher.add(out, s.batchSettings()) {
+					s.flushBatch(batcher)
+				}
+				continue
+			}
 
-		case data := <-s.conn.Read():
+			s.flushBatch(batcher)
+			s.conn.Write(out.data)
+			atomic.AddUint64(&s.eventsSent, 1)
 
-			pushConfig := elemental.NewPushConfig()
-			if err := elemental.Decode(s.encodingRead, data, pushConfig); err != nil {
-				if !s.handlesErrorEvents() {
-					s.close(websocket.CloseUnsupportedData)
-					return
-				}
+		case <-batcher.timerC():
 
-				s.sendWSError(elemental.Error{
-					Title:       "Bad request",
-					Subject:     "bahamut",
-					Description: fmt.Sprintf("could not decode message into %T: %s", pushConfig, err),
-				})
+			s.flushBatch(batcher)
 
-				continue
+		case t := <-s.terminateCh:
+
+			zap.L().Info("Terminating push session",
+				zap.String("sessionID", s.id),
+				zap.Strings("claims", s.Claims()),
+				zap.Int("code", t.code),
+			)
+
+			s.flushBatch(batcher)
+
+			if t.err != nil && s.handlesErrorEvents() {
+				if data, err := s.errorEventData(*t.err); err == nil {
+					s.conn.Write(data)
+				}
 			}
 
-			if err := pushConfig.ParseIdentityFilters(); err != nil {
-				zap.L().Debug("error parsing filter(s) in the received *elemental.PushConfig",
-					zap.Error(err),
+			s.close(t.code)
+			return
+
+		case <-reauth.timerC():
+
+			reauth.run()
+
+		case err := <-reauth.results:
+
+			if err = reauth.done(err); err != nil {
+				zap.L().Debug("Push session re-authentication failed",
 					zap.String("sessionID", s.id),
-					zap.String("pushConfig", pushConfig.String()),
+					zap.Error(err),
 				)
 
-				if !s.handlesErrorEvents() {
-					s.close(websocket.CloseUnsupportedData)
-					return
-				}
+				s.terminateWithError(PushSessionCloseUnauthorized, reauthenticationError(err))
+			}
+
+		case data := <-s.conn.Read():
 
-				s.sendWSError(elemental.Error{
-					Title:       "Bad request",
-					Subject:     "bahamut",
-					Description: fmt.Sprintf("unable to parse identity filters: %s", err),
-					Data: map[string]interface{}{
-						"pushconfig": "filters",
-					},
-				})
+			settings := s.batchSettings()
 
-				continue
+			if !s.handleMessage(data, reauth) {
+				return
 			}
 
-			s.setErrorState(false)
-			s.setCurrentPushConfig(pushConfig)
+			// The pending batch was made with the previous settings.
+			if s.batchSettings() != settings {
+				s.flushBatch(batcher)
+			}
 
 		case err := <-s.conn.Error():
 			zap.L().Error("Error received from websocket", zap.String("session", s.id), zap.Error(err))
 
-		case <-s.conn.Done():
+		case err := <-s.conn.Done():
+			if err == websocket.ErrReadLimit || websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				registerLimitViolation(s.cfg.healthServer.metricsManager, LimitPushMessageSize)
+			}
 			return
 
 		case <-s.ctx.Done():
+			s.flushBatch(batcher)
 			s.close(websocket.CloseGoingAway)
 			return
 		}
@@ -45,7 +45,7 @@ func TestWSPushSession_newPushSession(t *testing.T) {
 		s := newWSPushSession(req, conf, unregister, elemental.EncodingTypeMSGPACK, elemental.EncodingTypeMSGPACK)
 
 		Convey("Then it should be correctly initialized", func() {
-			So(s.dataCh, ShouldHaveSameTypeAs, make(chan []byte))
+			So(s.dataCh, ShouldHaveSameTypeAs, make(chan *wsOutgoing))
 			So(s.Claims(), ShouldResemble, []string{})
 			So(s.claimsMap, ShouldResemble, map[string]string{})
 			So(s.cfg, ShouldResemble, conf)
@@ -82,8 +82,8 @@ func TestWSPushSession_DirectPush(t *testing.T) {
 		Convey("When I call directPush", func() {
 
 			go s.DirectPush(evt, evt)
-			data1 := <-s.dataCh
-			data2 := <-s.dataCh
+			data1 := (<-s.dataCh).data
+			data2 := (<-s.dataCh).data
 
 			Convey("Then data1 should be correct", func() {
 				So(string(data1), ShouldEqual, string(msgpack))
@@ -103,7 +103,8 @@ func TestWSPushSession_DirectPush(t *testing.T) {
 
 			var data []byte
 			select {
-			case data = <-s.dataCh:
+			case out := <-s.dataCh:
+				data = out.data
 			case <-time.After(1 * time.Second):
 			}
 
@@ -119,7 +120,8 @@ func TestWSPushSession_DirectPush(t *testing.T) {
 
 			var data []byte
 			select {
-			case data = <-s.dataCh:
+			case out := <-s.dataCh:
+				data = out.data
 			case <-time.After(1 * time.Second):
 			}
 
@@ -137,7 +139,8 @@ func TestWSPushSession_DirectPush(t *testing.T) {
 
 			var data []byte
 			select {
-			case data = <-s.dataCh:
+			case out := <-s.dataCh:
+				data = out.data
 			case <-time.After(1 * time.Second):
 			}
 
@@ -159,7 +162,7 @@ func TestWSPushSession_send(t *testing.T) {
 		Convey("When I call directPush and pull from the event channel", func() {
 
 			s.send([]byte("hello"))
-			data := <-s.dataCh
+			data := (<-s.dataCh).data
 
 			Convey("Then data should be correct", func() {
 				So(string(data), ShouldEqual, "hello")
@@ -0,0 +1,217 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"net/http"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+// PushSessionCloseUnauthorized is the websocket close code used
+// to close a push session that failed to re-authenticate.
+const PushSessionCloseUnauthorized = 4401
+
+// An ExpirableSession is a Session whose credentials can expire.
+// A SessionAuthenticator can type assert the Session it receives to
+// set the expiration of the credentials, like the expiration of a
+// token. When OptPushSessionReauthentication is set, the session
+// is re-authenticated at that time.
+type ExpirableSession interface {
+	Session
+
+	// SetExpiration sets the expiration of the credentials.
+	SetExpiration(time.Time)
+
+	// Expiration returns the expiration of the credentials.
+	Expiration() time.Time
+}
+
+// A wsTokenRefresh is a message a client sends to
+// refresh the token of its push session.
+type wsTokenRefresh struct {
+	Token string `msgpack:"token" json:"token"`
+}
+
+// authenticateSession runs the given session authenticators on the
+// given session. It returns an error if the session is not authorized.
+func authenticateSession(authenticators []SessionAuthenticator, session Session) error {
+
+	var action AuthAction
+	var err error
+
+	for _, authenticator := range authenticators {
+
+		action, err = authenticator.AuthenticateSession(session)
+		if err != nil {
+			return elemental.NewError("Unauthorized", err.Error(), "bahamut", http.StatusUnauthorized)
+		}
+
+		if action == AuthActionKO {
+			return elemental.NewError("Unauthorized", "You are not authorized to start a session", "bahamut", http.StatusUnauthorized)
+		}
+
+		if action == AuthActionOK {
+			break
+		}
+	}
+
+	return nil
+}
+
+// A wsReauthenticator periodically re-authenticates a push session.
+// It is only used from the listen loop of the session, and it runs
+// the authenticators in the background so the session keeps sending
+// events while they are running.
+type wsReauthenticator struct {
+	session *wsPushSession
+	timer   *time.Timer
+	results chan error
+	running bool
+	pending bool
+}
+
+func newWSReauthenticator(session *wsPushSession) *wsReauthenticator {
+
+	r := &wsReauthenticator{
+		session: session,
+		results: make(chan error, 1),
+	}
+
+	r.schedule()
+
+	return r
+}
+
+// enabled returns true if the re-authentication is enabled.
+func (r *wsReauthenticator) enabled() bool {
+	return r.session.cfg.pushServer.reauthEnabled
+}
+
+// timerC returns the channel receiving when the session must be
+// re-authenticated, or nil if there is nothing scheduled.
+func (r *wsReauthenticator) timerC() <-chan time.Time {
+
+	if r.timer == nil {
+		return nil
+	}
+
+	return r.timer.C
+}
+
+// schedule schedules the next re-authentication, according to
+// the configured interval and the expiration of the session.
+func (r *wsReauthenticator) schedule() {
+
+	r.stop()
+
+	if !r.enabled() {
+		return
+	}
+
+	var d time.Duration
+	var scheduled bool
+
+	if interval := r.session.cfg.pushServer.reauthInterval; interval > 0 {
+		d, scheduled = interval, true
+	}
+
+	if exp := r.session.Expiration(); !exp.IsZero() {
+		if untilExp := time.Until(exp); !scheduled || untilExp < d {
+			d, scheduled = untilExp, true
+		}
+	}
+
+	if !scheduled {
+		return
+	}
+
+	if d < 0 {
+		d = 0
+	}
+
+	r.timer = time.NewTimer(d)
+}
+
+// run re-authenticates the session in the background. If it is
+// already running, it runs again once done. The result is sent
+// to r.results.
+func (r *wsReauthenticator) run() {
+
+	if r.running {
+		r.pending = true
+		return
+	}
+
+	r.stop()
+	r.running = true
+
+	go func() {
+		r.results <- authenticateSession(r.session.cfg.security.sessionAuthenticators, r.session)
+	}()
+}
+
+// done must be called with the result received from r.results. It
+// returns the error the session must be terminated with, if any. As
+// the session is re-authenticated when its credentials expire, it is
+// terminated if they are still expired, instead of being
+// re-authenticated again right away.
+func (r *wsReauthenticator) done(err error) error {
+
+	r.running = false
+
+	if err == nil {
+		if exp := r.session.Expiration(); !exp.IsZero() && !time.Now().Before(exp) {
+			err = elemental.NewError("Unauthorized", "The session credentials have expired", "bahamut", http.StatusUnauthorized)
+		}
+	}
+
+	if err != nil {
+		r.pending = false
+		return err
+	}
+
+	if r.pending {
+		r.pending = false
+		r.run()
+		return nil
+	}
+
+	r.schedule()
+
+	return nil
+}
+
+// stop stops the scheduled re-authentication, if any.
+func (r *wsReauthenticator) stop() {
+
+	if r.timer == nil {
+		return
+	}
+
+	r.timer.Stop()
+	r.timer = nil
+}
+
+// reauthenticationError returns the error to send to
+// the client when the re-authentication failed.
+func reauthenticationError(err error) *elemental.Error {
+
+	if e, ok := err.(elemental.Error); ok {
+		e.Description = "Re-authentication failed: " + e.Description
+		return &e
+	}
+
+	e := elemental.NewError("Unauthorized", "Re-authentication failed: "+err.Error(), "bahamut", http.StatusUnauthorized)
+	return &e
+}
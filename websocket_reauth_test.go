@@ -0,0 +1,296 @@
+// Copyright 2019 Aporeto Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bahamut
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/wsc"
+)
+
+type mockTokenSessionAuthenticator struct {
+	token string
+}
+
+func (a *mockTokenSessionAuthenticator) AuthenticateSession(session Session) (AuthAction, error) {
+	if session.Token() != a.token {
+		return AuthActionKO, nil
+	}
+	return AuthActionOK, nil
+}
+
+func TestReauth_authenticateSession(t *testing.T) {
+
+	Convey("Given I have a session", t, func() {
+
+		s := newWSPushSession(&http.Request{URL: &url.URL{}}, config{}, nil, elemental.EncodingTypeJSON, elemental.EncodingTypeJSON)
+
+		Convey("When I authenticate it with no authenticators", func() {
+			Convey("Then err should be nil", func() {
+				So(authenticateSession(nil, s), ShouldBeNil)
+			})
+		})
+
+		Convey("When I authenticate it with authenticators returning continue then ok", func() {
+
+			err := authenticateSession([]SessionAuthenticator{
+				&mockSessionAuthenticator{action: AuthActionContinue},
+				&mockSessionAuthenticator{action: AuthActionOK},
+				&mockSessionAuthenticator{action: AuthActionKO},
+			}, s)
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I authenticate it with an authenticator returning ko", func() {
+
+			err := authenticateSession([]SessionAuthenticator{&mockSessionAuthenticator{action: AuthActionKO}}, s)
+
+			Convey("Then err should be correct", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "error 401 (bahamut): Unauthorized: You are not authorized to start a session")
+			})
+		})
+
+		Convey("When I authenticate it with an authenticator returning an error", func() {
+
+			err := authenticateSession([]SessionAuthenticator{&mockSessionAuthenticator{err: fmt.Errorf("boom")}}, s)
+
+			Convey("Then err should be correct", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "error 401 (bahamut): Unauthorized: boom")
+			})
+		})
+	})
+}
+
+func TestReauth_schedule(t *testing.T) {
+
+	Convey("Given I have a session", t, func() {
+
+		cfg := config{}
+		s := newWSPushSession(&http.Request{URL: &url.URL{}}, cfg, nil, elemental.EncodingTypeJSON, elemental.EncodingTypeJSON)
+
+		Convey("When the re-authentication is disabled", func() {
+
+			r := newWSReauthenticator(s)
+			defer r.stop()
+
+			Convey("Then nothing should be scheduled", func() {
+				So(r.timerC(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the re-authentication is enabled without interval nor expiration", func() {
+
+			s.cfg.pushServer.reauthEnabled = true
+
+			r := newWSReauthenticator(s)
+			defer r.stop()
+
+			Convey("Then nothing should be scheduled", func() {
+				So(r.timerC(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the re-authentication is enabled with an interval", func() {
+
+			s.cfg.pushServer.reauthEnabled = true
+			s.cfg.pushServer.reauthInterval = time.Hour
+
+			r := newWSReauthenticator(s)
+			defer r.stop()
+
+			Convey("Then it should be scheduled", func() {
+				So(r.timerC(), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the re-authentication is enabled and the session expires sooner than the interval", func() {
+
+			s.cfg.pushServer.reauthEnabled = true
+			s.cfg.pushServer.reauthInterval = time.Hour
+			s.SetExpiration(time.Now().Add(-time.Second))
+
+			r := newWSReauthenticator(s)
+			defer r.stop()
+
+			Convey("Then it should be scheduled at the expiration", func() {
+				select {
+				case <-r.timerC():
+				case <-time.After(time.Second):
+					So("timer should have fired", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+}
+
+func TestReauth_done(t *testing.T) {
+
+	Convey("Given I have a re-authenticator", t, func() {
+
+		cfg := config{}
+		cfg.pushServer.reauthEnabled = true
+		s := newWSPushSession(&http.Request{URL: &url.URL{}}, cfg, nil, elemental.EncodingTypeJSON, elemental.EncodingTypeJSON)
+
+		r := newWSReauthenticator(s)
+		defer r.stop()
+
+		Convey("When the re-authentication failed", func() {
+
+			r.running = true
+			err := r.done(fmt.Errorf("boom"))
+
+			Convey("Then the error should be returned and nothing should be scheduled", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "boom")
+				So(r.running, ShouldBeFalse)
+				So(r.timerC(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the re-authentication succeeded but did not extend the expiration", func() {
+
+			s.SetExpiration(time.Now().Add(-time.Second))
+
+			r.running = true
+			err := r.done(nil)
+
+			Convey("Then an error should be returned and nothing should be scheduled", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "error 401 (bahamut): Unauthorized: The session credentials have expired")
+				So(r.timerC(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the re-authentication succeeded and extended the expiration", func() {
+
+			s.SetExpiration(time.Now().Add(time.Hour))
+
+			r.running = true
+			err := r.done(nil)
+
+			Convey("Then it should be scheduled again", func() {
+				So(err, ShouldBeNil)
+				So(r.timerC(), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestReauth_listen(t *testing.T) {
+
+	makeSession := func(ctx context.Context, token string, interval time.Duration) (*wsPushSession, wsc.MockWebsocket, chan struct{}) {
+
+		cfg := config{}
+		cfg.pushServer.reauthEnabled = true
+		cfg.pushServer.reauthInterval = interval
+		cfg.security.sessionAuthenticators = []SessionAuthenticator{&mockTokenSessionAuthenticator{token: "good"}}
+
+		unregistered := make(chan struct{})
+		s := newWSPushSession(
+			(&http.Request{URL: &url.URL{RawQuery: "enableErrors=true&token=" + token}}).WithContext(ctx),
+			cfg,
+			func(*wsPushSession) { close(unregistered) },
+			elemental.EncodingTypeJSON,
+			elemental.EncodingTypeJSON,
+		)
+		conn := wsc.NewMockWebsocket(ctx)
+		s.setConn(conn)
+
+		go s.listen()
+
+		return s, conn, unregistered
+	}
+
+	Convey("Given I have a session whose token is no longer valid", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, conn, unregistered := makeSession(ctx, "revoked", 50*time.Millisecond)
+
+		Convey("When the session is re-authenticated", func() {
+
+			var data []byte
+			select {
+			case data = <-conn.LastWrite():
+			case <-time.After(time.Second):
+			}
+
+			Convey("Then the client should receive an error and the session should stop", func() {
+
+				var event elemental.Event
+				So(elemental.Decode(elemental.EncodingTypeJSON, data, &event), ShouldBeNil)
+				So(event.Type, ShouldEqual, elemental.EventError)
+
+				var ee elemental.Error
+				So(elemental.Decode(event.Encoding, event.JSONData, &ee), ShouldBeNil)
+				So(ee.Code, ShouldEqual, http.StatusUnauthorized)
+				So(ee.Description, ShouldEqual, "Re-authentication failed: You are not authorized to start a session")
+
+				select {
+				case <-unregistered:
+				case <-time.After(time.Second):
+					So("session should have been unregistered", ShouldBeEmpty)
+				}
+			})
+		})
+	})
+
+	Convey("Given I have a session with a valid token", t, func() {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s, conn, unregistered := makeSession(ctx, "good", 0)
+
+		Convey("When the client refreshes its token with a valid one", func() {
+
+			conn.NextRead([]byte(`{"token":"good"}`))
+
+			Convey("Then the session should continue", func() {
+				select {
+				case <-unregistered:
+					So("session should not have been unregistered", ShouldBeEmpty)
+				case <-time.After(300 * time.Millisecond):
+				}
+				So(s.Token(), ShouldEqual, "good")
+				So(s.currentPushConfig(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the client refreshes its token with an invalid one", func() {
+
+			conn.NextRead([]byte(`{"token":"bad"}`))
+
+			Convey("Then the session should stop", func() {
+				select {
+				case <-unregistered:
+				case <-time.After(time.Second):
+					So("session should have been unregistered", ShouldBeEmpty)
+				}
+				So(s.Token(), ShouldEqual, "bad")
+			})
+		})
+	})
+}
@@ -15,7 +15,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strings"
+	"runtime"
 	"sync"
 	"time"
 
@@ -31,9 +31,20 @@ type pushServer struct {
 	multiplexer     *bone.Mux
 	cfg             config
 	processorFinder processorFinderFunc
+	pusher          eventPusherFunc
+	admission       *admissionController
 	sessionsLock    sync.RWMutex
 	mainContext     context.Context
+	closeSessions   context.CancelFunc
 	publications    chan *Publication
+	targeted        chan *Publication
+	dispatchQueue   chan queuedPublication
+	workerQueues    []chan *preparedEvent
+	shards          []*sessionShard
+
+	limitsLock       sync.Mutex
+	reservedSessions int
+	claimSessions    map[string]int
 }
 
 func newPushServer(cfg config, multiplexer *bone.Mux, processorFinder processorFinderFunc) *pushServer {
@@ -45,6 +56,26 @@ func newPushServer(cfg config, multiplexer *bone.Mux, processorFinder processorF
 		sessionsLock:    sync.RWMutex{},
 		processorFinder: processorFinder,
 		publications:    make(chan *Publication, 24000),
+		targeted:        make(chan *Publication, 1024),
+		claimSessions:   map[string]int{},
+	}
+
+	workers := cfg.pushServer.dispatchWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	queueSize := cfg.pushServer.dispatchQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultPushDispatchQueueSize
+	}
+
+	srv.dispatchQueue = make(chan queuedPublication, queueSize)
+	srv.workerQueues = make([]chan *preparedEvent, workers)
+	srv.shards = make([]*sessionShard, workers)
+	for i := 0; i < workers; i++ {
+		srv.workerQueues[i] = make(chan *preparedEvent, pushDispatchWorkerQueueSize)
+		srv.shards[i] = newSessionShard()
 	}
 
 	endpoint := cfg.pushServer.endpoint
@@ -72,8 +103,14 @@ func (n *pushServer) registerSession(session *wsPushSession) {
 		panic("cannot register websocket session. empty identifier")
 	}
 
+	var keys []string
+	if router, ok := n.cfg.pushServer.dispatchHandler.(PushRoutingHandler); ok {
+		keys = router.SessionRoutingKeys(session)
+	}
+
 	n.sessionsLock.Lock()
 	n.sessions[session.Identifier()] = session
+	n.shards[shardFor(session.Identifier(), len(n.shards))].add(session, keys)
 	n.sessionsLock.Unlock()
 
 	if handler := n.cfg.pushServer.dispatchHandler; handler != nil {
@@ -95,8 +132,11 @@ func (n *pushServer) unregisterSession(session *wsPushSession) {
 
 	n.sessionsLock.Lock()
 	delete(n.sessions, session.Identifier())
+	n.shards[shardFor(session.Identifier(), len(n.shards))].remove(session)
 	n.sessionsLock.Unlock()
 
+	n.releaseSession(session)
+
 	if n.cfg.healthServer.metricsManager != nil {
 		n.cfg.healthServer.metricsManager.UnregisterWSConnection()
 	}
@@ -108,26 +148,7 @@ func (n *pushServer) authSession(session *wsPushSession) error {
 		return nil
 	}
 
-	var action AuthAction
-	var err error
-
-	for _, authenticator := range n.cfg.security.sessionAuthenticators {
-
-		action, err = authenticator.AuthenticateSession(session)
-		if err != nil {
-			return elemental.NewError("Unauthorized", err.Error(), "bahamut", http.StatusUnauthorized)
-		}
-
-		if action == AuthActionKO {
-			return elemental.NewError("Unauthorized", "You are not authorized to start a session", "bahamut", http.StatusUnauthorized)
-		}
-
-		if action == AuthActionOK {
-			break
-		}
-	}
-
-	return nil
+	return authenticateSession(n.cfg.security.sessionAuthenticators, session)
 }
 
 func (n *pushServer) initPushSession(session *wsPushSession) error {
@@ -150,6 +171,8 @@ func (n *pushServer) initPushSession(session *wsPushSession) error {
 
 func (n *pushServer) pushEvents(events ...*elemental.Event) {
 
+	n.cfg.responseCache.invalidate(events...)
+
 	// If we don't have a service or publication is explicitly disabled, we do nothing.
 	if n.cfg.pushServer.service == nil || !n.cfg.pushServer.enabled {
 		return
@@ -191,8 +214,21 @@ func (n *pushServer) pushEvents(events ...*elemental.Event) {
 
 func (n *pushServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 
+	if limiter := n.cfg.pushServer.handshakeLimiter; limiter != nil && !limiter.Allow() {
+		registerLimitViolation(n.cfg.healthServer.metricsManager, LimitPushHandshakeRate)
+		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), ErrRateLimit, nil))
+		return
+	}
+
+	if !originAllowed(r, n.cfg.pushServer.allowedOrigins, n.cfg.security.CORSOrigin) {
+		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), ErrPushOriginNotAllowed, nil))
+		return
+	}
+
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		// The origin has already been checked.
+		CheckOrigin:       func(r *http.Request) bool { return true },
+		EnableCompression: n.cfg.pushServer.compressionEnabled,
 	}
 
 	r = r.WithContext(n.mainContext)
@@ -200,6 +236,14 @@ func (n *pushServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 	readEncodingType, writeEncodingType, err := elemental.EncodingFromHeaders(r.Header)
 	if err != nil {
 		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err, nil))
+		return
+	}
+
+	// A subprotocol selecting the encoding takes precedence over the headers,
+	// as browsers cannot set the headers of websocket requests.
+	if protocol, encoding := negotiateSubprotocol(r); protocol != "" {
+		upgrader.Subprotocols = []string{protocol}
+		readEncodingType, writeEncodingType = encoding, encoding
 	}
 
 	session := newWSPushSession(r, n.cfg, n.unregisterSession, readEncodingType, writeEncodingType)
@@ -215,6 +259,9 @@ func (n *pushServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	session.setRemoteAddress(clientIP)
 	session.cookies = r.Cookies()
+	session.processorFinder = n.processorFinder
+	session.pusher = n.pusher
+	session.admission = n.admission
 
 	if err := n.authSession(session); err != nil {
 		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err, nil))
@@ -226,25 +273,53 @@ func (n *pushServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
+	if err := n.reserveSession(session); err != nil {
 		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err, nil))
 		return
 	}
 
-	conn, err := wsc.Accept(r.Context(), ws, wsc.Config{WriteChanSize: 64, ReadChanSize: 16})
+	// When the compression is enabled, we count the bytes written
+	// on the connection to measure the compression ratio.
+	var hijacker *wsCountingHijacker
+	var rw http.ResponseWriter = w
+	if n.cfg.pushServer.compressionEnabled {
+		hijacker = &wsCountingHijacker{ResponseWriter: w}
+		rw = hijacker
+	}
+
+	ws, err := upgrader.Upgrade(rw, r, nil)
 	if err != nil {
-		writeHTTPResponse(w, makeErrorResponse(r.Context(), elemental.NewResponse(elemental.NewRequest()), err, nil))
+		// The upgrader already replied with an error.
+		n.releaseSession(session)
 		return
 	}
 
-	session.setConn(conn)
+	if limit := n.cfg.limits.maxPushMessageSize; limit > 0 {
+		ws.SetReadLimit(limit)
+	}
+
+	if n.cfg.pushServer.compressionEnabled {
+		session.setConn(n.acceptCompressed(r.Context(), r, ws, hijacker.conn, session))
+	} else {
+		conn, err := wsc.Accept(r.Context(), ws, wsc.Config{WriteChanSize: 64, ReadChanSize: 16})
+		if err != nil {
+			// The connection has been hijacked, so we cannot reply anymore.
+			zap.L().Error("Unable to accept push websocket", zap.Error(err))
+			_ = ws.Close()
+			n.releaseSession(session)
+			return
+		}
+		session.setConn(conn)
+	}
 
 	n.registerSession(session)
 
 	session.listen()
 }
 
+// start starts the push server. If it is enabled, it runs until it is
+// stopped, rather than until ctx is canceled, so the events are still
+// dispatched to the sessions during the grace period.
 func (n *pushServer) start(ctx context.Context) {
 
 	// If dispatching of events is disabled, we sit here
@@ -254,11 +329,16 @@ func (n *pushServer) start(ctx context.Context) {
 		return
 	}
 
-	n.mainContext = ctx
+	// The sessions are not bound to ctx so they can be given
+	// a grace period when the server is stopped. The events
+	// keep being dispatched to them until then.
+	n.mainContext, n.closeSessions = context.WithCancel(context.Background())
+	lifetime := n.mainContext
 
 	if n.cfg.pushServer.service != nil {
 		errors := make(chan error, 24000)
 		defer n.cfg.pushServer.service.Subscribe(n.publications, errors, n.cfg.pushServer.topic)()
+		defer n.cfg.pushServer.service.Subscribe(n.targeted, errors, n.cfg.pushServer.topic+targetedPushTopicSuffix)()
 	}
 
 	zap.L().Debug("Websocket server started",
@@ -267,139 +347,65 @@ func (n *pushServer) start(ctx context.Context) {
 		zap.Bool("push-publish-enabled", n.cfg.pushServer.publishEnabled),
 	)
 
+	// The publications are prepared in order by a single goroutine,
+	// then dispatched by the workers, each one handling its own shard
+	// of sessions.
+	go n.runDispatchQueue(lifetime)
+	for i := range n.workerQueues {
+		go n.runDispatchWorker(lifetime, i)
+	}
+
 	for {
 		select {
 
 		case p := <-n.publications:
+			n.enqueuePublication(p)
+
+		case p := <-n.targeted:
+			n.handleTargetedPublication(p)
 
-			go func(publication *Publication) {
-
-				event := &elemental.Event{}
-				if err := publication.Decode(event); err != nil {
-					zap.L().Error("Unable to decode event",
-						zap.Stringer("event", event),
-						zap.Error(err),
-					)
-					return
-				}
-
-				// We prepare the event data in both json and msgpack
-				// once for all.
-				dataMSGPACK, dataJSON, err := prepareEventData(event)
-				if err != nil {
-					zap.L().Error("Unable to prepare event encoding",
-						zap.Stringer("event", event),
-						zap.Error(err),
-					)
-					return
-				}
-
-				// We prepate the event summary if needed
-				var eventSummary interface{}
-				if n.cfg.pushServer.dispatchHandler != nil {
-					eventSummary, err = n.cfg.pushServer.dispatchHandler.SummarizeEvent(event)
-					if err != nil {
-						zap.L().Error("Unable to summary event",
-							zap.Stringer("event", event),
-							zap.Error(err),
-						)
-						return
-					}
-				}
-
-				// Keep a references to all current ready push sessions as it may change at any time, we lost 8h on this one...
-				n.sessionsLock.RLock()
-				sessions := make([]*wsPushSession, len(n.sessions))
-				var i int
-				for _, s := range n.sessions {
-					sessions[i] = s
-					i++
-				}
-				n.sessionsLock.RUnlock()
-
-				// Dispatch the event to all sessions
-				for _, session := range sessions {
-
-					// Client sent an invalid push config, this is a noop as it makes no sense to continue processing;
-					// wait until they send another message that is valid.
-					if session.inErrorState() {
-						continue
-					}
-
-					// If event happened before session, we don't send it.
-					if event.Timestamp.Before(session.startTime) {
-						continue
-					}
-
-					// If the event identity (or related identities) are filtered out
-					// we don't send it.
-					if f := session.currentPushConfig(); f != nil {
-
-						identities := []string{event.Identity}
-						if n.cfg.pushServer.dispatchHandler != nil {
-							identities = append(identities, n.cfg.pushServer.dispatchHandler.RelatedEventIdentities(event.Identity)...)
-						}
-
-						var ok bool
-						for _, identity := range identities {
-							if !f.IsFilteredOut(identity, event.Type) {
-								ok = true
-								break
-							}
-						}
-
-						if !ok {
-							continue
-						}
-					}
-
-					if n.cfg.pushServer.dispatchHandler != nil {
-						dispatch, err := n.cfg.pushServer.dispatchHandler.ShouldDispatch(session, event, eventSummary)
-						if err != nil {
-							// temp before we move to error wrapping
-							if err != context.Canceled && !strings.Contains(err.Error(), "context canceled") {
-								zap.L().Error("Error while calling dispatchHandler.ShouldDispatch", zap.Error(err))
-							}
-
-							continue
-						}
-
-						if !dispatch {
-							continue
-						}
-					}
-
-					switch session.encodingWrite {
-					case elemental.EncodingTypeMSGPACK:
-						session.send(dataMSGPACK)
-					case elemental.EncodingTypeJSON:
-						session.send(dataJSON)
-					}
-				}
-			}(p)
-
-		case <-ctx.Done():
+		case <-lifetime.Done():
 			return
 		}
 	}
 }
 
-func (n *pushServer) stop() {
+// stop waits for the sessions to leave during the grace period,
+// then closes the remaining ones with a going-away code and waits
+// for them to terminate until the shutdown timeout is reached.
+func (n *pushServer) stop(ctx context.Context) error {
 
-	// we wait for all session to get cleanly terminated.
-	for {
+	sessionsCount := func() int {
 		n.sessionsLock.RLock()
-		leftOvers := len(n.sessions)
-		n.sessionsLock.RUnlock()
+		defer n.sessionsLock.RUnlock()
+		return len(n.sessions)
+	}
 
-		if leftOvers == 0 {
-			break
-		}
+	if grace := n.cfg.shutdown.pushGracePeriod; grace > 0 {
+		graceCtx, cancel := context.WithTimeout(ctx, grace)
+		waitUntil(graceCtx, func() bool { return sessionsCount() == 0 })
+		cancel()
+	}
 
-		time.Sleep(10 * time.Millisecond)
+	if n.closeSessions != nil {
+		n.closeSessions()
+	}
+
+	timeout := n.cfg.shutdown.pushTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if !waitUntil(ctx, func() bool { return sessionsCount() == 0 }) {
+		return fmt.Errorf("push server: %d session(s) did not terminate", sessionsCount())
 	}
 
 	zap.L().Info("Push server stopped")
+
+	return nil
 }
 
 func prepareEventData(event *elemental.Event) (msgpack []byte, json []byte, err error) {
@@ -1241,6 +1241,95 @@ func TestWebsocketServer_handleRequest(t *testing.T) {
 	})
 }
 
+func TestWebsocketServer_stop(t *testing.T) {
+
+	pf := func(identity elemental.Identity) (Processor, error) {
+		return struct{}{}, nil
+	}
+
+	Convey("Given I have a push server with no session", t, func() {
+
+		wss := newPushServer(config{}, bone.New(), pf)
+
+		Convey("When I stop it", func() {
+
+			err := wss.stop(context.Background())
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given I have a push server with a session that never leaves", t, func() {
+
+		cfg := config{}
+		cfg.shutdown.pushGracePeriod = 50 * time.Millisecond
+		cfg.shutdown.pushTimeout = 50 * time.Millisecond
+
+		wss := newPushServer(cfg, bone.New(), pf)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wss.mainContext, wss.closeSessions = ctx, cancel
+
+		wss.sessions["a"] = &wsPushSession{}
+
+		Convey("When I stop it", func() {
+
+			now := time.Now()
+			err := wss.stop(context.Background())
+
+			Convey("Then err should not be nil", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "push server: 1 session(s) did not terminate")
+			})
+
+			Convey("Then the sessions should have been asked to close", func() {
+				So(ctx.Err(), ShouldNotBeNil)
+			})
+
+			Convey("Then it should have waited for the grace period and the timeout", func() {
+				So(time.Since(now), ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+			})
+		})
+	})
+
+	Convey("Given I have a push server with a session that leaves during the grace period", t, func() {
+
+		cfg := config{}
+		cfg.shutdown.pushGracePeriod = 3 * time.Second
+
+		wss := newPushServer(cfg, bone.New(), pf)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		wss.mainContext, wss.closeSessions = ctx, cancel
+
+		wss.sessions["a"] = &wsPushSession{}
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			wss.sessionsLock.Lock()
+			delete(wss.sessions, "a")
+			wss.sessionsLock.Unlock()
+		}()
+
+		Convey("When I stop it", func() {
+
+			now := time.Now()
+			err := wss.stop(context.Background())
+
+			Convey("Then err should be nil", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then it should not have waited for the whole grace period", func() {
+				So(time.Since(now), ShouldBeLessThan, 3*time.Second)
+			})
+		})
+	})
+}
+
 func Test_prepareEventData(t *testing.T) {
 
 	pristineEvent := elemental.NewEvent(